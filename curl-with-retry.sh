@@ -1,8 +1,13 @@
 #!/usr/bin/env bash
 # Perform a curl request, retrying on transient failures. Connection errors and
 # HTTP 5xx are retried; HTTP 4xx (and any other non-2xx that is not a connection
-# failure or 5xx) are treated as fatal and returned without retrying. This mirrors
-# the retry policy of the Cloud Run service (3 attempts, backoff 30s then 60s).
+# failure or 5xx) are treated as fatal and returned without retrying (3 attempts,
+# backoff 30s then 60s). The service retries its own dependencies only briefly, so
+# these client-side retries cover the longer outages.
+# HTTP 429 (GitHub rate limit) is retried after the response's Retry-After
+# seconds, unless that is longer than CURL_RETRY_MAX_WAIT_SECONDS (default 120).
+# HTTP 5xx with a Retry-After header (open circuit breaker) waits that long
+# instead of the backoff if it is not longer than CURL_RETRY_MAX_WAIT_SECONDS.
 #
 # Usage: curl-with-retry.sh <output-file> <curl-arg>...
 #   <output-file>   file the response body is written to (passed to curl --output)
@@ -11,7 +16,8 @@
 # Prints the final HTTP status code to stdout ("000" when the connection failed).
 # Retry progress goes to stderr. Exits non-zero only on a usage error.
 #
-# Overridable via env (used by tests): CURL_RETRY_MAX_ATTEMPTS, CURL_RETRY_BACKOFF_SECONDS.
+# Overridable via env (used by tests): CURL_RETRY_MAX_ATTEMPTS, CURL_RETRY_BACKOFF_SECONDS,
+# CURL_RETRY_MAX_WAIT_SECONDS.
 set -euo pipefail
 
 if [[ $# -lt 2 ]]; then
@@ -24,11 +30,16 @@ shift
 
 max_attempts="${CURL_RETRY_MAX_ATTEMPTS:-3}"
 backoff="${CURL_RETRY_BACKOFF_SECONDS:-30}"
+max_wait="${CURL_RETRY_MAX_WAIT_SECONDS:-120}"
+
+header_file=$(mktemp)
+trap 'rm -f "$header_file"' EXIT
 
 http_code=000
 for (( attempt=1; attempt<=max_attempts; attempt++ )); do
+  : > "$header_file"
   http_code=$(curl --silent --show-error --write-out '%{http_code}' \
-    --output "$output_file" "$@") || http_code=000
+    --dump-header "$header_file" --output "$output_file" "$@") || http_code=000
   http_code="${http_code:-000}"
 
   # Success.
@@ -36,15 +47,26 @@ for (( attempt=1; attempt<=max_attempts; attempt++ )); do
     break
   fi
 
+  # Rate limited: retry after the time the server asks for, if it is not too long.
+  wait="$backoff"
+  retry_after=$(grep -i '^retry-after:' "$header_file" | tail -n 1 | tr -dc '0-9' || true)
+  if [[ "$http_code" == 429 ]]; then
+    wait="${retry_after:-$backoff}"
+    if (( wait > max_wait )); then
+      break
+    fi
   # Non-transient failure (not a connection error and not 5xx): do not retry.
-  if [[ "$http_code" != 000 && "$http_code" -lt 500 ]]; then
+  elif [[ "$http_code" != 000 && "$http_code" -lt 500 ]]; then
     break
+  # Dependency unavailable: the server knows when it will try it again.
+  elif [[ -n "$retry_after" ]] && (( retry_after <= max_wait )); then
+    wait="$retry_after"
   fi
 
-  # Transient failure (connection error or 5xx): retry with exponential backoff.
+  # Transient failure (connection error, 5xx, or 429): retry with exponential backoff.
   if (( attempt < max_attempts )); then
-    echo "Request failed (HTTP $http_code), retrying in ${backoff}s (attempt $attempt/$max_attempts)" >&2
-    sleep "$backoff"
+    echo "Request failed (HTTP $http_code), retrying in ${wait}s (attempt $attempt/$max_attempts)" >&2
+    sleep "$wait"
     backoff=$(( backoff * 2 ))
   fi
 done
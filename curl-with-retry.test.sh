@@ -12,15 +12,18 @@ trap 'rm -rf "$WORK"' EXIT
 # Fake curl. Reads MOCK_CODES (space-separated, one outcome per attempt) and a
 # MOCK_COUNTER file. Each outcome is either an HTTP code (writes a body, prints
 # the code, exit 0) or "conn" (simulates a connection reset: prints 000, exit 35,
-# just like real curl with --write-out '%{http_code}').
+# just like real curl with --write-out '%{http_code}'). If MOCK_RETRY_AFTER is
+# set, 429 and 503 responses carry that Retry-After header.
 mkdir -p "$WORK/bin"
 cat > "$WORK/bin/curl" <<'FAKE'
 #!/usr/bin/env bash
 set -u
 out=""
+headers=""
 prev=""
 for a in "$@"; do
   [[ "$prev" == "--output" ]] && out="$a"
+  [[ "$prev" == "--dump-header" ]] && headers="$a"
   prev="$a"
 done
 n=$(( $(cat "$MOCK_COUNTER" 2>/dev/null || echo 0) + 1 ))
@@ -35,6 +38,9 @@ if [[ "$code" == "conn" ]]; then
   exit 35
 fi
 [[ -n "$out" ]] && printf '{"value":"tok","token":"tok"}' > "$out"
+if [[ -n "$headers" && ( "$code" == 429 || "$code" == 503 ) && -n "${MOCK_RETRY_AFTER:-}" ]]; then
+  printf 'HTTP/2 %s\r\nretry-after: %s\r\n\r\n' "$code" "$MOCK_RETRY_AFTER" > "$headers"
+fi
 printf '%s' "$code"
 exit 0
 FAKE
@@ -53,6 +59,7 @@ run_case() {
     MOCK_CODES="$codes" \
     CURL_RETRY_MAX_ATTEMPTS="$max" \
     CURL_RETRY_BACKOFF_SECONDS=0 \
+    CURL_RETRY_MAX_WAIT_SECONDS=1 \
     bash "$HELPER" "$WORK/resp" --request GET "https://example/test"
   )"
   local attempts
@@ -71,6 +78,11 @@ run_case "5xx then success"                200 2 "503 200"
 run_case "4xx is fatal, no retry"          403 1 "403 200"
 run_case "persistent connection failure"   000 3 "conn conn conn conn"
 run_case "persistent 5xx exhausts retries" 503 3 "503 503 503 503"
+run_case "429 then success"                200 2 "429 200"
+MOCK_RETRY_AFTER=0 run_case "429 with Retry-After then success" 200 2 "429 200"
+MOCK_RETRY_AFTER=60 run_case "429 with too long Retry-After is fatal" 429 1 "429 200"
+MOCK_RETRY_AFTER=0 run_case "503 with Retry-After then success" 200 2 "503 200"
+MOCK_RETRY_AFTER=60 run_case "503 with too long Retry-After still retries" 200 2 "503 200"
 
 if [[ "$fail" -ne 0 ]]; then
   echo "SOME TESTS FAILED"
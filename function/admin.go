@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AdminRepository is a repository whose workflows may call the administrative endpoints.
+type AdminRepository struct {
+	// Issuer is the OIDC issuer of the GitHub host of the repository.
+	Issuer string
+	// ID is the numeric repository ID (repository_id claim), stable across renames.
+	ID int64
+}
+
+// ParseAdminRepositories parses the GITHUB_ADMIN_REPOSITORY_IDS environment variable.
+// Format: comma-separated list of numeric repository IDs, whitespace is trimmed. Repositories of a
+// GitHub Enterprise Server are prefixed with its host, e.g. "github.example.com/123": repository
+// IDs are only unique within a host. Empty disables the administrative endpoints.
+func ParseAdminRepositories() ([]AdminRepository, error) {
+	repositories := []AdminRepository{}
+	envValue := configValue("GITHUB_ADMIN_REPOSITORY_IDS")
+	for _, part := range strings.Split(envValue, ",") {
+		trimmed := strings.TrimSpace(part)
+		if trimmed == "" {
+			continue
+		}
+		baseURL, idStr := "", trimmed
+		if host, id, ok := strings.Cut(trimmed, "/"); ok {
+			if host == "" || strings.ContainsAny(host, ":/") {
+				return nil, fmt.Errorf("invalid repository %q in GITHUB_ADMIN_REPOSITORY_IDS: the host must be a plain host name", trimmed)
+			}
+			baseURL, idStr = "https://"+host, id
+		}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository ID %q in GITHUB_ADMIN_REPOSITORY_IDS: %w", trimmed, err)
+		}
+		repositories = append(repositories, AdminRepository{Issuer: OIDCIssuer(baseURL), ID: id})
+	}
+	return repositories, nil
+}
+
+// authorizeAdmin verifies the OIDC token of a request to an administrative endpoint (GET /keys
+// and the detailed GET /readyz report) and checks its repository against GITHUB_ADMIN_REPOSITORY_IDS.
+func authorizeAdmin(ctx context.Context, config *Config, r *http.Request) *Error {
+	// Reject before verifying the token: without admins, nobody gets further
+	if len(config.AdminRepositories) == 0 {
+		return NewError(CodeAdminNotAllowed, "administrative endpoints are disabled (GITHUB_ADMIN_REPOSITORY_IDS is not configured)")
+	}
+
+	identity, typedErr := verifyCaller(ctx, config, r)
+	if typedErr != nil {
+		return typedErr
+	}
+	for _, admin := range config.AdminRepositories {
+		if identity.RepositoryID != 0 && admin.ID == identity.RepositoryID && admin.Issuer == identity.Issuer {
+			return nil
+		}
+	}
+	return NewError(CodeAdminNotAllowed, "repository %s is not allowed to call administrative endpoints", identity.Repository).
+		WithDetails(map[string]interface{}{"repository_id": identity.RepositoryID, "rule": "GITHUB_ADMIN_REPOSITORY_IDS"})
+}
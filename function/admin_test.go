@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TestParseAdminRepositories tests parsing GITHUB_ADMIN_REPOSITORY_IDS.
+//
+// Test steps:
+//  1. Set GITHUB_ADMIN_REPOSITORY_IDS
+//  2. Parse it
+//  3. Verify the repositories, bound to the issuer of their host, or the error
+func TestParseAdminRepositories(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		want        []AdminRepository
+		errContains string
+	}{
+		{name: "unset", value: "", want: []AdminRepository{}},
+		{name: "github.com", value: " 7001, 7002 ", want: []AdminRepository{{Issuer: githubOIDCIssuer, ID: 7001}, {Issuer: githubOIDCIssuer, ID: 7002}}},
+		{
+			name:  "GitHub Enterprise Server",
+			value: "7001,github.example.com/7001",
+			want:  []AdminRepository{{Issuer: githubOIDCIssuer, ID: 7001}, {Issuer: "https://github.example.com/_services/token", ID: 7001}},
+		},
+		{name: "not a number", value: "owner/repo", errContains: `invalid repository ID "owner/repo"`},
+		{name: "URL", value: "https://github.example.com/7001", errContains: "must be a plain host name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Set the variable
+			t.Setenv("GITHUB_ADMIN_REPOSITORY_IDS", tt.value)
+
+			// Step 2: Parse it
+			got, err := ParseAdminRepositories()
+
+			// Step 3: Verify the result
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseAdminRepositories() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAdminRepositories() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAdminRepositories() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestKeyRotationHandler_Authorization tests that GET /keys only answers workflows of the admin
+// repositories, without the locations of the keys.
+//
+// Test steps:
+//  1. Configure a GitHub App and, except for the disabled case, admin repository 7001 of github.com
+//  2. Request GET /keys with the OIDC token of the case
+//  3. Verify the status, and that the report names no key source
+func TestKeyRotationHandler_Authorization(t *testing.T) {
+	key := generateTestRSAKey(t)
+	useTestJWKS(t, key)
+
+	tests := []struct {
+		name       string
+		admins     string
+		token      string
+		wantStatus int
+		wantCode   ErrorCode
+	}{
+		{name: "admin repository", admins: "7001", token: signTestOIDCToken(t, key, nil), wantStatus: http.StatusOK},
+		{name: "no admin repositories", admins: "", token: signTestOIDCToken(t, key, nil), wantStatus: http.StatusForbidden, wantCode: CodeAdminNotAllowed},
+		{name: "no token", admins: "7001", wantStatus: http.StatusUnauthorized, wantCode: CodeMissingAuthorization},
+		{name: "invalid token", admins: "7001", token: signTestOIDCToken(t, generateTestRSAKey(t), nil), wantStatus: http.StatusUnauthorized, wantCode: CodeInvalidOIDCToken},
+		{name: "other repository", admins: "7001", token: signTestOIDCToken(t, key, jwt.MapClaims{"repository_id": "7002"}), wantStatus: http.StatusForbidden, wantCode: CodeAdminNotAllowed},
+		{name: "same ID on another host", admins: "github.example.com/7001", token: signTestOIDCToken(t, key, nil), wantStatus: http.StatusForbidden, wantCode: CodeAdminNotAllowed},
+		{name: "no repository ID", admins: "7001", token: signTestOIDCToken(t, key, jwt.MapClaims{"repository_id": ""}), wantStatus: http.StatusForbidden, wantCode: CodeAdminNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Configure the app and the admins
+			clearConfigEnv(t)
+			t.Setenv("GITHUB_APP_ID", "123")
+			t.Setenv("GITHUB_ADMIN_REPOSITORY_IDS", tt.admins)
+			config := loadTestConfig(t)
+
+			// Step 2: Request the key rotation status
+			req := httptest.NewRequest(http.MethodGet, "/keys", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			NewTokenHandler(config)(rec, req)
+
+			// Step 3: Verify the response
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				var resp ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Code != tt.wantCode {
+					t.Errorf("response = %s, want code %s", rec.Body.String(), tt.wantCode)
+				}
+				return
+			}
+			var resp KeyRotationResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if _, ok := resp.Apps["default"]; !ok {
+				t.Errorf("response = %s, want the status of app 'default'", rec.Body.String())
+			}
+			if strings.Contains(rec.Body.String(), "source") || strings.Contains(rec.Body.String(), "github-app-private-key") {
+				t.Errorf("response = %s, want no key source", rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// defaultPrivateKeySecret is the Secret Manager secret holding the private key of a GitHub App
+// that doesn't name its own.
+const defaultPrivateKeySecret = "github-app-private-key"
+
+// GitHubApp is a GitHub App the service issues tokens with.
+type GitHubApp struct {
+	// Name identifies the app in routing decisions and logs.
+	Name  string `json:"name"`
+	AppID string `json:"app_id"`
+	// ClientID is the app's client ID (e.g. "Iv23li..."), used instead of the app ID as the issuer of
+	// the App JWT as GitHub recommends.
+	ClientID string `json:"client_id,omitempty"`
+	// PrivateKeySecret is the Secret Manager secret holding the app's private key.
+	PrivateKeySecret string `json:"private_key_secret,omitempty"`
+	// PrivateKey is the source of the app's private key or its remote signer (see ParseSigner).
+	// It replaces PrivateKeySecret.
+	PrivateKey string `json:"private_key,omitempty"`
+	// BaseURL is the URL of the GitHub host's REST API (e.g. "https://github.example.com/api/v3/"),
+	// or empty for github.com.
+	BaseURL string `json:"base_url,omitempty"`
+	// When restricts the requests the app is used for. An app without rules is used for any request.
+	When AppRoute `json:"when"`
+
+	signer JWTSigner
+}
+
+// Issuer returns the issuer (iss claim) of the app's JWTs: its client ID, or else its app ID.
+func (a GitHubApp) Issuer() string {
+	if a.ClientID != "" {
+		return a.ClientID
+	}
+	return a.AppID
+}
+
+// OIDCIssuer returns the issuer of the GitHub Actions OIDC tokens of the app's GitHub host. The app
+// only issues tokens to callers with tokens of this issuer.
+func (a GitHubApp) OIDCIssuer() string {
+	return OIDCIssuer(a.BaseURL)
+}
+
+// AppRoute is the routing rule of a GitHub App. All set conditions must match.
+type AppRoute struct {
+	// OwnerIDs matches requests of repositories owned by one of these account IDs.
+	OwnerIDs []int64 `json:"owner_ids,omitempty"`
+	// Scopes matches requests for at least one of these scopes.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// Matches reports whether the rule matches a request of the repository owner for the scopes.
+func (r AppRoute) Matches(ownerID int64, scopes map[string]string) bool {
+	if len(r.OwnerIDs) > 0 && !slices.Contains(r.OwnerIDs, ownerID) {
+		return false
+	}
+	if len(r.Scopes) > 0 && !slices.ContainsFunc(r.Scopes, func(scopeID string) bool {
+		_, requested := scopes[scopeID]
+		return requested
+	}) {
+		return false
+	}
+	return true
+}
+
+// ParseGitHubApps parses the GitHub Apps from the GITHUB_APPS environment variable.
+// Format: JSON array of apps in routing order, for example:
+//
+//	[{"name": "admin", "app_id": "456", "private_key_secret": "github-admin-app-private-key", "when": {"scopes": ["administration", "secrets"]}},
+//	 {"name": "default", "app_id": "123"}]
+//
+// Without GITHUB_APPS, the single app GITHUB_APP_ID is used, with the client ID GITHUB_APP_CLIENT_ID
+// (optional) and the private key from the GITHUB_APP_PRIVATE_KEY_SOURCE key source or else the
+// github-app-private-key secret. The key sources read their secret store with the settings.
+func ParseGitHubApps(settings KeySourceSettings) ([]GitHubApp, error) {
+	envValue := strings.TrimSpace(configValue("GITHUB_APPS"))
+	if envValue == "" {
+		appID := strings.TrimSpace(configValue("GITHUB_APP_ID"))
+		if appID == "" {
+			return nil, fmt.Errorf("GITHUB_APP_ID or GITHUB_APPS must be set")
+		}
+		app := GitHubApp{
+			Name:       "default",
+			AppID:      appID,
+			ClientID:   strings.TrimSpace(configValue("GITHUB_APP_CLIENT_ID")),
+			PrivateKey: strings.TrimSpace(configValue("GITHUB_APP_PRIVATE_KEY_SOURCE")),
+		}
+		if app.PrivateKey == "" {
+			app.PrivateKeySecret = defaultPrivateKeySecret
+			app.PrivateKey = "secretmanager://" + defaultPrivateKeySecret
+		}
+		signer, err := ParseSigner(app.PrivateKey, settings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_APP_PRIVATE_KEY_SOURCE: %w", err)
+		}
+		app.signer = signer
+		return []GitHubApp{app}, nil
+	}
+
+	var apps []GitHubApp
+	if err := json.Unmarshal([]byte(envValue), &apps); err != nil {
+		return nil, fmt.Errorf("invalid GITHUB_APPS: %w", err)
+	}
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("invalid GITHUB_APPS: no apps")
+	}
+
+	names := make(map[string]bool, len(apps))
+	for i := range apps {
+		app := &apps[i]
+		if app.Name == "" {
+			return nil, fmt.Errorf("invalid GITHUB_APPS: app %d has no name", i)
+		}
+		if names[app.Name] {
+			return nil, fmt.Errorf("invalid GITHUB_APPS: duplicate app name '%s'", app.Name)
+		}
+		names[app.Name] = true
+		if app.AppID == "" {
+			return nil, fmt.Errorf("invalid GITHUB_APPS: app '%s' has no app_id", app.Name)
+		}
+		if app.PrivateKey != "" && app.PrivateKeySecret != "" {
+			return nil, fmt.Errorf("invalid GITHUB_APPS: app '%s' sets both private_key and private_key_secret", app.Name)
+		}
+		if app.PrivateKey == "" {
+			if app.PrivateKeySecret == "" {
+				app.PrivateKeySecret = defaultPrivateKeySecret
+			}
+			app.PrivateKey = "secretmanager://" + app.PrivateKeySecret
+		}
+		signer, err := ParseSigner(app.PrivateKey, settings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_APPS: app '%s': %w", app.Name, err)
+		}
+		app.signer = signer
+		if app.BaseURL != "" {
+			baseURL, err := url.Parse(app.BaseURL)
+			if err != nil || (baseURL.Scheme != "https" && baseURL.Scheme != "http") || baseURL.Host == "" {
+				return nil, fmt.Errorf("invalid GITHUB_APPS: app '%s' has an invalid base_url %q", app.Name, app.BaseURL)
+			}
+		}
+		for _, scopeID := range app.When.Scopes {
+			if _, exists := AllowedScopes[scopeID]; !exists {
+				return nil, fmt.Errorf("invalid GITHUB_APPS: app '%s' routes unknown scope '%s'", app.Name, scopeID)
+			}
+		}
+	}
+
+	return apps, nil
+}
+
+// ResolveApp returns the first app whose routing rule matches a request of the caller for the scopes.
+// Only apps of the caller's GitHub host (the issuer of its OIDC token) are considered: repositories
+// and owner IDs of different hosts are unrelated, even if they are equal.
+func ResolveApp(apps []GitHubApp, identity Identity, scopes map[string]string) (GitHubApp, error) {
+	for _, app := range apps {
+		if app.OIDCIssuer() == identity.Issuer && app.When.Matches(identity.OwnerID, scopes) {
+			return app, nil
+		}
+	}
+	return GitHubApp{}, NewError(CodeNoMatchingApp, "no GitHub App is configured for requests of repository owner ID %d for these scopes", identity.OwnerID).
+		WithDetails(map[string]interface{}{"owner_id": identity.OwnerID})
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestParseGitHubApps tests parsing of the GITHUB_APPS environment variable and the fallback to GITHUB_APP_ID.
+func TestParseGitHubApps(t *testing.T) {
+	tests := []struct {
+		name        string
+		envValue    string
+		appID       string
+		wantNames   []string
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:      "single app from GITHUB_APP_ID",
+			appID:     "123",
+			wantNames: []string{"default"},
+		},
+		{
+			name:        "no app configured",
+			wantErr:     true,
+			errContains: "GITHUB_APP_ID or GITHUB_APPS must be set",
+		},
+		{
+			name: "multiple apps in routing order",
+			envValue: `[
+				{"name": "admin", "app_id": "456", "private_key_secret": "github-admin-app-private-key", "when": {"scopes": ["administration", "secrets"]}},
+				{"name": "ghes", "app_id": "789", "base_url": "https://github.example.com/api/v3/", "when": {"owner_ids": [42]}},
+				{"name": "default", "app_id": "123"}
+			]`,
+			appID:     "123",
+			wantNames: []string{"admin", "ghes", "default"},
+		},
+		{
+			name:      "private key from another source",
+			envValue:  `[{"name": "default", "app_id": "123", "private_key": "vault://secret/github-app"}]`,
+			wantNames: []string{"default"},
+		},
+		{
+			name:        "both private_key and private_key_secret",
+			envValue:    `[{"name": "default", "app_id": "123", "private_key": "env://KEY", "private_key_secret": "github-app-private-key"}]`,
+			wantErr:     true,
+			errContains: "sets both",
+		},
+		{
+			name:        "invalid private key source",
+			envValue:    `[{"name": "default", "app_id": "123", "private_key": "s3://bucket/key"}]`,
+			wantErr:     true,
+			errContains: "unsupported scheme",
+		},
+		{
+			name:        "invalid JSON",
+			envValue:    `[{"name":`,
+			wantErr:     true,
+			errContains: "invalid GITHUB_APPS",
+		},
+		{
+			name:        "no apps",
+			envValue:    `[]`,
+			wantErr:     true,
+			errContains: "no apps",
+		},
+		{
+			name:        "app without name",
+			envValue:    `[{"app_id": "123"}]`,
+			wantErr:     true,
+			errContains: "has no name",
+		},
+		{
+			name:        "duplicate app name",
+			envValue:    `[{"name": "default", "app_id": "123"}, {"name": "default", "app_id": "456"}]`,
+			wantErr:     true,
+			errContains: "duplicate app name",
+		},
+		{
+			name:        "app without app_id",
+			envValue:    `[{"name": "default"}]`,
+			wantErr:     true,
+			errContains: "has no app_id",
+		},
+		{
+			name:        "invalid base_url",
+			envValue:    `[{"name": "ghes", "app_id": "123", "base_url": "github.example.com"}]`,
+			wantErr:     true,
+			errContains: "invalid base_url",
+		},
+		{
+			name:        "routed scope not in allowlist",
+			envValue:    `[{"name": "admin", "app_id": "123", "when": {"scopes": ["unknown"]}}]`,
+			wantErr:     true,
+			errContains: "unknown scope",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_APPS", tt.envValue)
+			t.Setenv("GITHUB_APP_ID", tt.appID)
+			t.Setenv("GITHUB_APP_PRIVATE_KEY_SOURCE", "")
+
+			got, err := ParseGitHubApps(KeySourceSettings{})
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseGitHubApps() error = nil, wantErr = true")
+					return
+				}
+				if !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseGitHubApps() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("ParseGitHubApps() unexpected error = %v", err)
+				return
+			}
+			var names []string
+			for _, app := range got {
+				names = append(names, app.Name)
+				if app.signer == nil {
+					t.Errorf("app '%s' has no signer", app.Name)
+				}
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("ParseGitHubApps() names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+// TestResolveApp tests that requests are routed to the first app of the caller's GitHub host whose rule matches.
+func TestResolveApp(t *testing.T) {
+	const enterpriseIssuer = "https://github.example.com/_services/token"
+	apps := []GitHubApp{
+		{Name: "enterprise", AppID: "111", BaseURL: "https://github.example.com/api/v3/", When: AppRoute{OwnerIDs: []int64{42}}},
+		{Name: "admin", AppID: "456", When: AppRoute{Scopes: []string{"administration", "secrets"}}},
+		{Name: "org", AppID: "789", When: AppRoute{OwnerIDs: []int64{42}}},
+		{Name: "org-admin", AppID: "999", When: AppRoute{OwnerIDs: []int64{7}, Scopes: []string{"workflows"}}},
+	}
+
+	tests := []struct {
+		name    string
+		issuer  string
+		ownerID int64
+		scopes  map[string]string
+		want    string
+	}{
+		{name: "routed by scope", ownerID: 1, scopes: map[string]string{"contents": "read", "secrets": "write"}, want: "admin"},
+		{name: "scope rule wins by order", ownerID: 42, scopes: map[string]string{"administration": "read"}, want: "admin"},
+		{name: "routed by owner", ownerID: 42, scopes: map[string]string{"contents": "read"}, want: "org"},
+		{name: "owner and scope rule", ownerID: 7, scopes: map[string]string{"workflows": "write"}, want: "org-admin"},
+		{name: "owner matches but scope doesn't", ownerID: 7, scopes: map[string]string{"contents": "read"}},
+		{name: "no matching app", ownerID: 1, scopes: map[string]string{"contents": "read"}},
+		{name: "GitHub Enterprise Server caller", issuer: enterpriseIssuer, ownerID: 42, scopes: map[string]string{"contents": "read"}, want: "enterprise"},
+		{name: "no app of the caller's host", issuer: enterpriseIssuer, ownerID: 1, scopes: map[string]string{"secrets": "write"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := tt.issuer
+			if issuer == "" {
+				issuer = githubOIDCIssuer
+			}
+			got, err := ResolveApp(apps, Identity{Issuer: issuer, OwnerID: tt.ownerID}, tt.scopes)
+			if tt.want == "" {
+				var typed *Error
+				if !errors.As(err, &typed) || typed.Code != CodeNoMatchingApp {
+					t.Errorf("ResolveApp() error = %v, want code %s", err, CodeNoMatchingApp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveApp() unexpected error = %v", err)
+			}
+			if got.Name != tt.want {
+				t.Errorf("ResolveApp() = %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+// TestGitHubApp_Issuer tests that JWTs are issued by the client ID if set, else by the app ID.
+func TestGitHubApp_Issuer(t *testing.T) {
+	t.Setenv("GITHUB_APPS", "")
+	t.Setenv("GITHUB_APP_ID", "123")
+	t.Setenv("GITHUB_APP_CLIENT_ID", "Iv23liTest")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_SOURCE", "")
+
+	apps, err := ParseGitHubApps(KeySourceSettings{})
+	if err != nil {
+		t.Fatalf("ParseGitHubApps() unexpected error = %v", err)
+	}
+	if got := apps[0].Issuer(); got != "Iv23liTest" {
+		t.Errorf("Issuer() = %s, want the client ID", got)
+	}
+	if got := (GitHubApp{AppID: "123"}).Issuer(); got != "123" {
+		t.Errorf("Issuer() without client ID = %s, want the app ID", got)
+	}
+}
+
+// TestTokenHandler_AppOfOtherHost tests that a github.com workflow is refused a token of an app on a
+// GitHub Enterprise Server, even for a repository and owner ID that exist there too.
+//
+// Test steps:
+//  1. Configure an app on a GitHub Enterprise Server routed for the caller's owner ID, and a github.com
+//     app routed for other scopes only
+//  2. Request a token with a github.com OIDC token of that owner
+//  3. Verify no app matches and GitHub isn't called
+func TestTokenHandler_AppOfOtherHost(t *testing.T) {
+	// Step 1: Apps of both hosts
+	key := generateTestRSAKey(t)
+	useTestJWKS(t, key)
+	useEmptyInstallationCache(t)
+	t.Setenv("GITHUB_ALLOWED_OWNER_IDS", "")
+	t.Setenv("GITHUB_APPS", `[
+		{"name": "enterprise", "app_id": "111", "base_url": "https://github.example.com/api/v3/", "when": {"owner_ids": [42]}},
+		{"name": "admin", "app_id": "123", "when": {"scopes": ["administration"]}}]`)
+	config := loadTestConfig(t)
+	original := newJWTAppsService
+	t.Cleanup(func() { newJWTAppsService = original })
+	newJWTAppsService = func(ctx context.Context, app GitHubApp) (GitHubAppsService, error) {
+		t.Errorf("GitHub App '%s' used for a github.com caller", app.Name)
+		return nil, errors.New("unexpected call")
+	}
+
+	// Step 2: Request as owner/repo (owner ID 42) of github.com
+	req := httptest.NewRequest(http.MethodPost, "/token?contents=read", nil)
+	req.Header.Set("Authorization", "Bearer "+signTestOIDCToken(t, key, nil))
+	rec := httptest.NewRecorder()
+	NewTokenHandler(config)(rec, req)
+
+	// Step 3: No app of github.com matches
+	var resp ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Code != CodeNoMatchingApp {
+		t.Errorf("response = %d %s, want code %s", rec.Code, rec.Body.String(), CodeNoMatchingApp)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// auditQueueSize is the number of audit events buffered for delivery to a queued sink; further
+	// events are refused.
+	auditQueueSize = 1000
+	// auditDeliveryTimeout bounds the delivery of one audit event, including retries.
+	auditDeliveryTimeout = 10 * time.Second
+)
+
+// CloudEvents attributes of audit events delivered over HTTP.
+const (
+	auditEventType   = "github-token-issuer.token.decision"
+	auditEventSource = "/token"
+)
+
+// Decisions of audit events.
+const (
+	// auditIssued is a POST /token request that was handed a token (newly issued or reused).
+	auditIssued = "issued"
+	// auditAllowed is a dry run whose checks all passed.
+	auditAllowed = "allowed"
+	// auditDenied is a request rejected by a check or by GitHub (4xx).
+	auditDenied = "denied"
+	// auditFailed is a request that failed on an internal error or an unavailable dependency (5xx).
+	auditFailed = "failed"
+)
+
+// AuditEvent is the audit record of a POST /token decision. It never contains the token itself, only
+// its SHA-256 fingerprint, which matches TokenFingerprint of the token a workflow leaked.
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	Decision string    `json:"decision"`
+	DryRun   bool      `json:"dry_run,omitempty"`
+	// Code and Reason are the error code and detail of a denied or failed request.
+	Code   ErrorCode `json:"code,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+
+	// The claims of the verified OIDC token (empty if the caller wasn't authenticated)
+	Repository        string `json:"repository,omitempty"`
+	RepositoryOwnerID int64  `json:"repository_owner_id,omitempty"`
+	Ref               string `json:"ref,omitempty"`
+	Workflow          string `json:"workflow,omitempty"`
+	Actor             string `json:"actor,omitempty"`
+	RunID             int64  `json:"run_id,omitempty"`
+	RunAttempt        int64  `json:"run_attempt,omitempty"`
+
+	// RequestedScopes are the scopes of the query parameters; Profile adds the profile's scopes.
+	RequestedScopes map[string]string `json:"requested_scopes,omitempty"`
+	Profile         string            `json:"profile,omitempty"`
+	GrantedScopes   map[string]string `json:"granted_scopes,omitempty"`
+	DroppedScopes   []DroppedScope    `json:"dropped_scopes,omitempty"`
+
+	App              string `json:"app,omitempty"`
+	InstallationID   int64  `json:"installation_id,omitempty"`
+	TokenFingerprint string `json:"token_fingerprint,omitempty"`
+	ExpiresAt        string `json:"expires_at,omitempty"`
+	// Reused reports whether the token was reused from an identical request of the same workflow run.
+	Reused bool `json:"reused,omitempty"`
+}
+
+// newAuditEvent returns the audit event of a POST /token request with the requested scopes of its query.
+func newAuditEvent(query url.Values, now time.Time) *AuditEvent {
+	event := &AuditEvent{Time: now.UTC(), RequestedScopes: make(map[string]string)}
+	for param, values := range query {
+		if !reservedParams[param] && len(values) > 0 {
+			event.RequestedScopes[param] = values[0]
+		}
+	}
+	return event
+}
+
+// setIdentity records the claims of the verified OIDC token.
+func (e *AuditEvent) setIdentity(identity Identity) {
+	e.Repository = identity.Repository
+	e.RepositoryOwnerID = identity.OwnerID
+	e.Ref = identity.Ref
+	e.Workflow = identity.Workflow
+	e.Actor = identity.Actor
+	e.RunID = identity.RunID
+	e.RunAttempt = identity.RunAttempt
+}
+
+// fail records a denied (4xx) or failed (5xx) decision.
+func (e *AuditEvent) fail(err *Error) {
+	e.Decision = auditDenied
+	if err.Status() >= http.StatusInternalServerError {
+		e.Decision = auditFailed
+	}
+	e.Code = err.Code
+	e.Reason = err.Detail
+}
+
+// issue records the issued token.
+func (e *AuditEvent) issue(response TokenResponse, reused bool) {
+	e.Decision = auditIssued
+	e.GrantedScopes = response.Scopes
+	e.DroppedScopes = response.DroppedScopes
+	e.InstallationID = response.installationID
+	e.TokenFingerprint = TokenFingerprint(response.Token)
+	e.ExpiresAt = response.ExpiresAt
+	e.Reused = reused
+}
+
+// explain records the decision of a dry run.
+func (e *AuditEvent) explain(response DryRunResponse) {
+	e.DryRun = true
+	e.Decision = auditAllowed
+	e.GrantedScopes = response.Scopes
+	e.DroppedScopes = response.DroppedScopes
+	if response.Allowed {
+		return
+	}
+	e.Decision = auditDenied
+	for _, step := range response.Trace {
+		if step.Result == checkFailed {
+			e.Code = step.Code
+			e.Reason = step.Detail
+		}
+	}
+}
+
+// AuditSink delivers audit events.
+type AuditSink interface {
+	Write(ctx context.Context, event AuditEvent) error
+}
+
+// queuedAuditSink is implemented by sinks delivering over the network. Their events are queued and
+// delivered in the background, so a slow endpoint never delays token requests; other sinks are
+// written before the response is sent.
+type queuedAuditSink interface {
+	AuditSink
+	queued()
+}
+
+// ParseAuditSink parses the AUDIT_LOG environment variable: where audit events are delivered.
+// Returns nil (no audit log) if not set. Supported sinks:
+//
+//	stdout                 One JSON line per event on stdout, in Cloud Logging's structured format
+//	file://<path>          One JSON line per event appended to a local file
+//	https://<endpoint>     One CloudEvents (structured mode) HTTP POST per event; http:// for local endpoints
+func ParseAuditSink() (AuditSink, error) {
+	envValue := strings.TrimSpace(configValue("AUDIT_LOG"))
+	if envValue == "" {
+		return nil, nil
+	}
+	if envValue == "stdout" {
+		return &stdoutAuditSink{w: os.Stdout}, nil
+	}
+
+	u, err := url.Parse(envValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUDIT_LOG %q: %w", envValue, err)
+	}
+	switch u.Scheme {
+	case "file":
+		path := u.Host + u.Path
+		if path == "" {
+			return nil, fmt.Errorf("invalid AUDIT_LOG %q: expected file://<path>", envValue)
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUDIT_LOG %q: %w", envValue, err)
+		}
+		return &fileAuditSink{file: file}, nil
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid AUDIT_LOG %q: expected https://<endpoint>", envValue)
+		}
+		return &cloudEventsAuditSink{endpoint: envValue, client: &http.Client{}}, nil
+	default:
+		return nil, fmt.Errorf("invalid AUDIT_LOG %q: unsupported sink (use stdout, file, or https)", envValue)
+	}
+}
+
+// stdoutAuditSink writes audit events to stdout, where Cloud Run forwards them to Cloud Logging.
+type stdoutAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// auditLogEntry is an audit event in Cloud Logging's structured logging format.
+type auditLogEntry struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	AuditEvent
+}
+
+func (s *stdoutAuditSink) Write(ctx context.Context, event AuditEvent) error {
+	severity := "NOTICE"
+	if event.Decision == auditDenied || event.Decision == auditFailed {
+		severity = "WARNING"
+	}
+	message := "token request " + event.Decision
+	if event.Repository != "" {
+		message += " for " + event.Repository
+	}
+	line, err := json.Marshal(auditLogEntry{Severity: severity, Message: message, AuditEvent: event})
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// fileAuditSink appends audit events to a local file.
+type fileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (s *fileAuditSink) Write(ctx context.Context, event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+	return nil
+}
+
+// cloudEventsAuditSink posts audit events to an HTTP endpoint as CloudEvents in structured mode.
+type cloudEventsAuditSink struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (s *cloudEventsAuditSink) queued() {}
+
+// cloudEvent is a CloudEvents 1.0 event in the JSON event format.
+type cloudEvent struct {
+	SpecVersion     string     `json:"specversion"`
+	ID              string     `json:"id"`
+	Source          string     `json:"source"`
+	Type            string     `json:"type"`
+	Subject         string     `json:"subject,omitempty"`
+	Time            time.Time  `json:"time"`
+	DataContentType string     `json:"datacontenttype"`
+	Data            AuditEvent `json:"data"`
+}
+
+func (s *cloudEventsAuditSink) Write(ctx context.Context, event AuditEvent) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("failed to generate event ID: %w", err)
+	}
+	body, err := json.Marshal(cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              hex.EncodeToString(id),
+		Source:          auditEventSource,
+		Type:            auditEventType,
+		Subject:         event.Repository,
+		Time:            event.Time,
+		DataContentType: "application/json",
+		Data:            event,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	// The event ID stays the same across retries, so the endpoint can deduplicate them
+	return retryPolicy.Do(ctx, func() (bool, time.Duration, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to create audit request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/cloudevents+json")
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return true, 0, fmt.Errorf("failed to deliver audit event: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+			return retryable, 0, fmt.Errorf("audit endpoint responded with status %d", resp.StatusCode)
+		}
+		return false, 0, nil
+	})
+}
+
+// AuditLog records audit events. Events of local sinks (stdout, file) are written by Record, before the
+// response is sent. Events of queued sinks (CloudEvents) are delivered in the background at most once:
+// events the endpoint keeps rejecting, and events still queued when the process is killed, are lost
+// (reported on stderr if possible). Its methods do nothing on a nil log, so the handlers record events
+// unconditionally.
+type AuditLog struct {
+	sink AuditSink
+	// events queues the events of a queued sink; nil for sinks written synchronously.
+	events chan AuditEvent
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAuditLog returns the audit log of the sink, delivering queued sinks in the background. Returns nil if sink is nil.
+func NewAuditLog(sink AuditSink) *AuditLog {
+	if sink == nil {
+		return nil
+	}
+	log := &AuditLog{sink: sink}
+	if _, queued := sink.(queuedAuditSink); queued {
+		log.events = make(chan AuditEvent, auditQueueSize)
+		log.done = make(chan struct{})
+		go log.deliver()
+	}
+	return log
+}
+
+// deliver writes the queued events to the sink until the log is closed. Failures are written to stderr.
+func (l *AuditLog) deliver() {
+	defer close(l.done)
+	for event := range l.events {
+		ctx, cancel := context.WithTimeout(context.Background(), auditDeliveryTimeout)
+		if err := l.sink.Write(ctx, event); err != nil {
+			fmt.Fprintf(os.Stderr, "audit: failed to deliver the %s decision for %s: %v\n", event.Decision, event.Repository, err)
+		}
+		cancel()
+	}
+}
+
+// Record writes the event to a local sink, or queues it for delivery to a queued sink. It returns an
+// error, also written to stderr, if the event couldn't be written, or couldn't be queued because the
+// queue is full or the log is closed; callers must not hand out a token whose issuance wasn't recorded.
+func (l *AuditLog) Record(ctx context.Context, event *AuditEvent) error {
+	if l == nil || event == nil || event.Decision == "" {
+		return nil
+	}
+	err := l.record(ctx, *event)
+	if err != nil {
+		err = fmt.Errorf("failed to record the %s decision for %s in the audit log: %w", event.Decision, event.Repository, err)
+		fmt.Fprintf(os.Stderr, "audit: %v\n", err)
+	}
+	return err
+}
+
+// record writes or queues the event.
+func (l *AuditLog) record(ctx context.Context, event AuditEvent) error {
+	if l.events == nil {
+		return l.sink.Write(ctx, event)
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return errors.New("the log is closed")
+	}
+	select {
+	case l.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("the delivery queue is full (%d events)", auditQueueSize)
+	}
+}
+
+// Close stops accepting events and waits until the queued events are delivered or ctx is done.
+func (l *AuditLog) Close(ctx context.Context) error {
+	if l == nil || l.events == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.events)
+	}
+	l.mu.Unlock()
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("audit: %d events not delivered: %w", len(l.events), ctx.Err())
+	}
+}
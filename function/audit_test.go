@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// captureAuditSink records the audit events written to it.
+type captureAuditSink struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (s *captureAuditSink) Write(ctx context.Context, event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+// queuedCaptureAuditSink records the audit events delivered to it from the queue, like a CloudEvents sink.
+// Until release is closed, deliveries block.
+type queuedCaptureAuditSink struct {
+	captureAuditSink
+	release chan struct{}
+}
+
+func (s *queuedCaptureAuditSink) Write(ctx context.Context, event AuditEvent) error {
+	<-s.release
+	return s.captureAuditSink.Write(ctx, event)
+}
+
+func (s *queuedCaptureAuditSink) queued() {}
+
+// failingAuditSink fails every write, like a full disk.
+type failingAuditSink struct{}
+
+func (failingAuditSink) Write(ctx context.Context, event AuditEvent) error {
+	return errors.New("no space left on device")
+}
+
+// TestParseAuditSink tests parsing of the AUDIT_LOG environment variable.
+func TestParseAuditSink(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		envValue    string
+		wantType    string
+		errContains string
+	}{
+		{envValue: "", wantType: "<nil>"},
+		{envValue: "stdout", wantType: "*main.stdoutAuditSink"},
+		{envValue: "file://" + filepath.Join(dir, "audit.log"), wantType: "*main.fileAuditSink"},
+		{envValue: "https://audit.example.com/events", wantType: "*main.cloudEventsAuditSink"},
+		{envValue: "file://" + filepath.Join(dir, "missing", "audit.log"), errContains: "no such file or directory"},
+		{envValue: "https://", errContains: "expected https://<endpoint>"},
+		{envValue: "syslog://localhost", errContains: "unsupported sink"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.envValue, func(t *testing.T) {
+			t.Setenv("AUDIT_LOG", tt.envValue)
+
+			got, err := ParseAuditSink()
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseAuditSink() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAuditSink() unexpected error = %v", err)
+			}
+			if gotType := fmt.Sprintf("%T", got); gotType != tt.wantType {
+				t.Errorf("ParseAuditSink() = %s, want %s", gotType, tt.wantType)
+			}
+		})
+	}
+}
+
+// TestAuditSinks tests the stdout, file, and CloudEvents sinks.
+//
+// Test steps:
+//  1. Write an issued event to each sink
+//  2. Verify the stdout line has a severity and message, the file line is the event, and the
+//     CloudEvents request is a structured-mode event with the event as data
+func TestAuditSinks(t *testing.T) {
+	event := AuditEvent{Time: time.Now().UTC(), Decision: auditIssued, Repository: "owner/repo", TokenFingerprint: TokenFingerprint("ghs_secret")}
+
+	t.Run("stdout", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := (&stdoutAuditSink{w: &out}).Write(context.Background(), event); err != nil {
+			t.Fatalf("Write() unexpected error = %v", err)
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+			t.Fatalf("stdout line is not JSON: %v", err)
+		}
+		if entry["severity"] != "NOTICE" || entry["message"] != "token request issued for owner/repo" || entry["token_fingerprint"] != event.TokenFingerprint {
+			t.Errorf("stdout line = %s", out.String())
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		t.Setenv("AUDIT_LOG", "file://"+path)
+		sink, err := ParseAuditSink()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 2 {
+			if err := sink.Write(context.Background(), event); err != nil {
+				t.Fatalf("Write() unexpected error = %v", err)
+			}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		var got AuditEvent
+		if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &got) != nil || got.Repository != "owner/repo" {
+			t.Errorf("file = %q, want 2 event lines", data)
+		}
+	})
+
+	t.Run("CloudEvents", func(t *testing.T) {
+		useFastRetryPolicy(t)
+		var attempts int
+		var got cloudEvent
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if ct := r.Header.Get("Content-Type"); ct != "application/cloudevents+json" {
+				t.Errorf("Content-Type = %s, want application/cloudevents+json", ct)
+			}
+			body, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Errorf("body is not a CloudEvent: %v", err)
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		sink := &cloudEventsAuditSink{endpoint: server.URL, client: server.Client()}
+		if err := sink.Write(context.Background(), event); err != nil {
+			t.Fatalf("Write() unexpected error = %v", err)
+		}
+		if attempts != 2 {
+			t.Errorf("attempts = %d, want 2 (retried after 503)", attempts)
+		}
+		if got.SpecVersion != "1.0" || got.Type != auditEventType || got.Subject != "owner/repo" || got.ID == "" || got.Data.TokenFingerprint != event.TokenFingerprint {
+			t.Errorf("CloudEvent = %+v", got)
+		}
+	})
+}
+
+// TestAuditLog_Record tests that local sinks are written by Record, and that write failures are returned.
+func TestAuditLog_Record(t *testing.T) {
+	sink := &captureAuditSink{}
+	log := NewAuditLog(sink)
+	if err := log.Record(context.Background(), &AuditEvent{Decision: auditIssued}); err != nil {
+		t.Fatalf("Record() unexpected error = %v", err)
+	}
+	if err := log.Record(context.Background(), &AuditEvent{}); err != nil {
+		t.Fatalf("Record() of an undecided event unexpected error = %v", err)
+	}
+	if len(sink.events) != 1 {
+		t.Errorf("written %d events before Record() returned, want 1", len(sink.events))
+	}
+
+	failing := NewAuditLog(failingAuditSink{})
+	if err := failing.Record(context.Background(), &AuditEvent{Decision: auditIssued, Repository: "owner/repo"}); err == nil || !strings.Contains(err.Error(), "no space left on device") {
+		t.Errorf("Record() error = %v, want the write error", err)
+	}
+}
+
+// TestAuditLog_Close tests that closing a queued log delivers the queued events and refuses later ones,
+// and that events are refused while the queue is full.
+func TestAuditLog_Close(t *testing.T) {
+	sink := &queuedCaptureAuditSink{release: make(chan struct{})}
+	log := NewAuditLog(sink)
+	// The delivery goroutine holds one event while the queue fills up
+	var refused int
+	for range auditQueueSize + 2 {
+		if err := log.Record(context.Background(), &AuditEvent{Decision: auditIssued}); err != nil {
+			refused++
+		}
+	}
+	if refused == 0 {
+		t.Error("Record() accepted every event, want a full queue to refuse events")
+	}
+	close(sink.release)
+	if err := log.Close(context.Background()); err != nil {
+		t.Fatalf("Close() unexpected error = %v", err)
+	}
+	if err := log.Record(context.Background(), &AuditEvent{Decision: auditIssued}); err == nil {
+		t.Error("Record() after Close() accepted the event")
+	}
+
+	if want := auditQueueSize + 2 - refused; len(sink.events) != want {
+		t.Errorf("delivered %d events, want %d", len(sink.events), want)
+	}
+
+	// A nil log records nothing
+	var disabled *AuditLog
+	if err := disabled.Record(context.Background(), &AuditEvent{Decision: auditIssued}); err != nil {
+		t.Errorf("Record() of a nil log = %v", err)
+	}
+	if err := disabled.Close(context.Background()); err != nil {
+		t.Errorf("Close() of a nil log = %v", err)
+	}
+}
+
+// TestTokenHandler_Audit tests the audit events of POST /token decisions.
+//
+// Test steps:
+//  1. Load a config with an audit log, install a test JWKS and a GitHub mock issuing a token
+//  2. Send the request
+//  3. Verify the recorded event, and that it never contains the token
+func TestTokenHandler_Audit(t *testing.T) {
+	key := generateTestRSAKey(t)
+	t.Setenv("GITHUB_APPS", "")
+	t.Setenv("GITHUB_APP_ID", "123")
+	t.Setenv("GITHUB_ALLOWED_OWNER_IDS", "")
+
+	permissions := &github.InstallationPermissions{Contents: github.Ptr("read"), Issues: github.Ptr("write")}
+
+	tests := []struct {
+		name          string
+		query         string
+		noAuth        bool
+		githubStatus  int
+		wantDecision  string
+		wantCode      ErrorCode
+		wantDryRun    bool
+		wantToken     bool
+		wantRequested map[string]string
+	}{
+		{name: "issued", query: "contents=read&issues=write", wantDecision: auditIssued, wantToken: true, wantRequested: map[string]string{"contents": "read", "issues": "write"}},
+		{name: "denied by policy", query: "contents=admin", wantDecision: auditDenied, wantCode: CodeInvalidPermission},
+		{name: "unauthenticated", query: "contents=read", noAuth: true, wantDecision: auditDenied, wantCode: CodeMissingAuthorization},
+		{name: "GitHub unavailable", query: "contents=read", githubStatus: http.StatusBadGateway, wantDecision: auditFailed, wantCode: CodeGitHubUnavailable},
+		{name: "dry run", query: "contents=read&dry_run=true", wantDecision: auditAllowed, wantDryRun: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Config, JWKS, and GitHub mock
+			useFastRetryPolicy(t)
+			useEmptyInstallationCache(t)
+			useTestJWKS(t, key)
+			config := loadTestConfig(t)
+			sink := &captureAuditSink{}
+			config.AuditLog = NewAuditLog(sink)
+			original := newJWTAppsService
+			t.Cleanup(func() { newJWTAppsService = original })
+			newJWTAppsService = func(ctx context.Context, app GitHubApp) (GitHubAppsService, error) {
+				return &mockAppsService{
+					findRepoInstallation: func(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error) {
+						if tt.githubStatus != 0 {
+							resp := &http.Response{StatusCode: tt.githubStatus}
+							return nil, &github.Response{Response: resp}, &github.ErrorResponse{Response: resp}
+						}
+						return &github.Installation{ID: github.Ptr(int64(7)), Permissions: permissions}, &github.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+					},
+					createInstallationToken: func(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error) {
+						return &github.InstallationToken{
+							Token:       github.Ptr("ghs_secret"),
+							ExpiresAt:   &github.Timestamp{Time: time.Now().Add(time.Hour)},
+							Permissions: opts.Permissions,
+						}, &github.Response{Response: &http.Response{StatusCode: http.StatusCreated}}, nil
+					},
+				}, nil
+			}
+
+			// Step 2: POST /token
+			req := httptest.NewRequest(http.MethodPost, "/token?"+tt.query, nil)
+			if !tt.noAuth {
+				req.Header.Set("Authorization", "Bearer "+signTestOIDCToken(t, key, nil))
+			}
+			rec := httptest.NewRecorder()
+			NewTokenHandler(config)(rec, req)
+			if err := config.AuditLog.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			// Step 3: Verify the event
+			if len(sink.events) != 1 {
+				t.Fatalf("recorded %d events, want 1 (response: %s)", len(sink.events), rec.Body.String())
+			}
+			event := sink.events[0]
+			if event.Decision != tt.wantDecision || event.Code != tt.wantCode || event.DryRun != tt.wantDryRun {
+				t.Errorf("event = %+v, want decision %s, code %q, dry run %v", event, tt.wantDecision, tt.wantCode, tt.wantDryRun)
+			}
+			if tt.noAuth {
+				if event.Repository != "" {
+					t.Errorf("event repository = %q, want none for an unauthenticated caller", event.Repository)
+				}
+			} else if event.Repository != "owner/repo" || event.RepositoryOwnerID != 42 || event.RunID != 1001 ||
+				event.Ref != "refs/heads/main" || event.Workflow != "Release" || event.Actor != "octocat" {
+				t.Errorf("event claims = %+v", event)
+			}
+			if tt.wantRequested != nil && (len(event.RequestedScopes) != len(tt.wantRequested) || event.RequestedScopes["issues"] != "write") {
+				t.Errorf("event requested scopes = %v, want %v", event.RequestedScopes, tt.wantRequested)
+			}
+			if tt.wantToken {
+				if event.TokenFingerprint != TokenFingerprint("ghs_secret") || event.InstallationID != 7 || event.App != "default" || event.GrantedScopes["contents"] != "read" {
+					t.Errorf("issued event = %+v", event)
+				}
+			} else if event.TokenFingerprint != "" {
+				t.Errorf("event token fingerprint = %s, want none", event.TokenFingerprint)
+			}
+			encoded, _ := json.Marshal(event)
+			if strings.Contains(string(encoded), "ghs_secret") {
+				t.Errorf("event contains the token: %s", encoded)
+			}
+		})
+	}
+}
+
+// TestTokenHandler_AuditFailureWithdrawsToken tests that a token whose issuance can't be recorded is
+// neither handed out nor reused, and is revoked.
+//
+// Test steps:
+//  1. Enable token reuse, install an empty token store and token cache, and a GitHub mock issuing
+//     numbered tokens and recording revoked ones
+//  2. Request a token while the audit log fails
+//  3. Verify the request fails without the token, and the token is revoked and no longer stored
+//  4. Request the same token again with a working audit log and verify a new token is issued
+func TestTokenHandler_AuditFailureWithdrawsToken(t *testing.T) {
+	// Step 1: Reuse, token store and cache, and GitHub mock
+	key := generateTestRSAKey(t)
+	t.Setenv("GITHUB_APPS", "")
+	t.Setenv("GITHUB_APP_ID", "123")
+	t.Setenv("GITHUB_ALLOWED_OWNER_IDS", "")
+	t.Setenv("GITHUB_TOKEN_REUSE_MIN_VALIDITY", "10m")
+	useFastRetryPolicy(t)
+	useEmptyInstallationCache(t)
+	useTestJWKS(t, key)
+	config := loadTestConfig(t)
+	originalStore, originalCache := tokenStore, tokenCache
+	t.Cleanup(func() { tokenStore, tokenCache = originalStore, originalCache })
+	tokenStore, tokenCache = NewMemoryTokenStore(), NewTokenCache()
+
+	var issued int
+	original := newJWTAppsService
+	t.Cleanup(func() { newJWTAppsService = original })
+	newJWTAppsService = func(ctx context.Context, app GitHubApp) (GitHubAppsService, error) {
+		return &mockAppsService{
+			findRepoInstallation: func(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error) {
+				permissions := &github.InstallationPermissions{Contents: github.Ptr("read")}
+				return &github.Installation{ID: github.Ptr(int64(7)), Permissions: permissions}, &github.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+			},
+			createInstallationToken: func(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error) {
+				issued++
+				return &github.InstallationToken{
+					Token:       github.Ptr(fmt.Sprintf("ghs_%d", issued)),
+					ExpiresAt:   &github.Timestamp{Time: time.Now().Add(time.Hour)},
+					Permissions: opts.Permissions,
+				}, &github.Response{Response: &http.Response{StatusCode: http.StatusCreated}}, nil
+			},
+		}, nil
+	}
+	var revoked []string
+	mockTokenAppsService(t, func(token string) (*github.Response, error) {
+		revoked = append(revoked, token)
+		return &github.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+	})
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/token?contents=read", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestOIDCToken(t, key, nil))
+		rec := httptest.NewRecorder()
+		NewTokenHandler(config)(rec, req)
+		return rec
+	}
+
+	// Step 2: The audit log fails
+	config.AuditLog = NewAuditLog(failingAuditSink{})
+	rec := request()
+
+	// Step 3: The token is withheld, revoked, and forgotten
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "ghs_1") {
+		t.Fatalf("response = %d %s, want 500 without the token", rec.Code, rec.Body.String())
+	}
+	if len(revoked) != 1 || revoked[0] != "ghs_1" {
+		t.Errorf("revoked tokens = %v, want [ghs_1]", revoked)
+	}
+	if _, stored, _ := tokenStore.Get(context.Background(), TokenFingerprint("ghs_1")); stored {
+		t.Error("withheld token is still stored")
+	}
+
+	// Step 4: An identical request gets a new token
+	config.AuditLog = NewAuditLog(&captureAuditSink{})
+	rec = request()
+	var response TokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusOK || response.Token != "ghs_2" {
+		t.Errorf("response = %d %s, want a new token ghs_2", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// optionalScopesParam is the query parameter that lists requested scopes which may be dropped
+// instead of failing the request (best-effort mode).
+const optionalScopesParam = "optional"
+
+// DroppedScope describes an optional scope that was not included in the issued token.
+type DroppedScope struct {
+	Scope      string `json:"scope"`
+	Permission string `json:"permission"`
+	Reason     string `json:"reason"`
+}
+
+// ParseOptionalScopes parses the comma-separated list of optional scope IDs.
+// Every optional scope must also be requested with a permission level.
+func ParseOptionalScopes(value string, scopes map[string]string) (map[string]bool, error) {
+	optional := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		scopeID := strings.TrimSpace(part)
+		if scopeID == "" {
+			continue
+		}
+		if _, requested := scopes[scopeID]; !requested {
+			return nil, fmt.Errorf("optional scope '%s' is not requested (add '%s=read' or '%s=write')", scopeID, scopeID, scopeID)
+		}
+		optional[scopeID] = true
+	}
+	return optional, nil
+}
+
+// FilterScopesByPolicy validates scopes against allowlist and blacklist in best-effort mode.
+// Optional scopes that fail validation are dropped with the validation error as the reason;
+// a required scope that fails validation fails the whole request.
+func FilterScopesByPolicy(scopes map[string]string, optional map[string]bool) (map[string]string, []DroppedScope, error) {
+	kept := make(map[string]string, len(scopes))
+	var dropped []DroppedScope
+	for _, scopeID := range sortedScopeIDs(scopes) {
+		permission := scopes[scopeID]
+		if err := ValidateScope(scopeID, permission); err != nil {
+			if !optional[scopeID] {
+				return nil, nil, err
+			}
+			dropped = append(dropped, DroppedScope{Scope: scopeID, Permission: permission, Reason: err.Error()})
+			continue
+		}
+		kept[scopeID] = permission
+	}
+	return kept, dropped, nil
+}
+
+// FilterScopesByInstallation drops optional scopes that the GitHub App installation has not been granted,
+// so that GitHub doesn't reject the whole token request. Required scopes are left for GitHub to reject.
+func FilterScopesByInstallation(scopes map[string]string, optional map[string]bool, permissions *github.InstallationPermissions) (map[string]string, []DroppedScope) {
+	installationScopes := InstallationScopes(permissions)
+
+	kept := make(map[string]string, len(scopes))
+	var dropped []DroppedScope
+	for _, scopeID := range sortedScopeIDs(scopes) {
+		permission := scopes[scopeID]
+		if optional[scopeID] {
+			grantedPerm, exists := installationScopes[scopeID]
+			if !exists {
+				dropped = append(dropped, DroppedScope{
+					Scope:      scopeID,
+					Permission: permission,
+					Reason:     "GitHub App installation has no access to this scope",
+				})
+				continue
+			}
+			if !PermissionCovers(grantedPerm, permission) {
+				dropped = append(dropped, DroppedScope{
+					Scope:      scopeID,
+					Permission: permission,
+					Reason:     fmt.Sprintf("GitHub App installation only has '%s' access to this scope", grantedPerm),
+				})
+				continue
+			}
+		}
+		kept[scopeID] = permission
+	}
+	return kept, dropped
+}
+
+// sortedScopeIDs returns the scope IDs of scopes in lexical order, for deterministic processing.
+func sortedScopeIDs(scopes map[string]string) []string {
+	ids := make([]string, 0, len(scopes))
+	for scopeID := range scopes {
+		ids = append(ids, scopeID)
+	}
+	slices.Sort(ids)
+	return ids
+}
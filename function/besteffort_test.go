@@ -0,0 +1,237 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// TestParseOptionalScopes tests parsing of the comma-separated optional scope list.
+func TestParseOptionalScopes(t *testing.T) {
+	requested := map[string]string{"contents": "write", "issues": "write", "pull_requests": "read"}
+
+	tests := []struct {
+		name        string
+		value       string
+		want        []string
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:  "empty value",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "single optional scope",
+			value: "issues",
+			want:  []string{"issues"},
+		},
+		{
+			name:  "multiple optional scopes with whitespace",
+			value: " issues , pull_requests ",
+			want:  []string{"issues", "pull_requests"},
+		},
+		{
+			name:  "empty parts are skipped",
+			value: "issues,,",
+			want:  []string{"issues"},
+		},
+		{
+			name:        "optional scope not requested",
+			value:       "issues,workflows",
+			wantErr:     true,
+			errContains: "optional scope 'workflows' is not requested",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOptionalScopes(tt.value, requested)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseOptionalScopes() error = nil, wantErr = true")
+					return
+				}
+				if !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseOptionalScopes() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("ParseOptionalScopes() unexpected error = %v", err)
+				return
+			}
+
+			if len(got) != len(tt.want) {
+				t.Errorf("ParseOptionalScopes() = %v, want %v", got, tt.want)
+				return
+			}
+			for _, scopeID := range tt.want {
+				if !got[scopeID] {
+					t.Errorf("ParseOptionalScopes() missing %q, got %v", scopeID, got)
+				}
+			}
+		})
+	}
+}
+
+// TestFilterScopesByPolicy tests best-effort validation against allowlist and blacklist.
+// Optional scopes failing validation are dropped; required ones fail the request.
+func TestFilterScopesByPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		scopes      map[string]string
+		optional    map[string]bool
+		wantKept    map[string]string
+		wantDropped []string
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:     "all scopes valid",
+			scopes:   map[string]string{"contents": "write", "issues": "write"},
+			optional: map[string]bool{"issues": true},
+			wantKept: map[string]string{"contents": "write", "issues": "write"},
+		},
+		{
+			name:        "optional scope not in allowlist is dropped",
+			scopes:      map[string]string{"contents": "write", "invalid_scope": "read"},
+			optional:    map[string]bool{"invalid_scope": true},
+			wantKept:    map[string]string{"contents": "write"},
+			wantDropped: []string{"invalid_scope"},
+		},
+		{
+			name:        "optional scope with disallowed level is dropped",
+			scopes:      map[string]string{"contents": "write", "administration": "write"},
+			optional:    map[string]bool{"administration": true},
+			wantKept:    map[string]string{"contents": "write"},
+			wantDropped: []string{"administration"},
+		},
+		{
+			name:        "required scope not in allowlist fails",
+			scopes:      map[string]string{"contents": "write", "invalid_scope": "read"},
+			optional:    map[string]bool{"contents": true},
+			wantErr:     true,
+			errContains: "not in allowlist",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, dropped, err := FilterScopesByPolicy(tt.scopes, tt.optional)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("FilterScopesByPolicy() error = nil, wantErr = true")
+					return
+				}
+				if !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("FilterScopesByPolicy() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("FilterScopesByPolicy() unexpected error = %v", err)
+				return
+			}
+
+			assertScopesEqual(t, "FilterScopesByPolicy()", kept, tt.wantKept)
+			assertDroppedScopes(t, "FilterScopesByPolicy()", dropped, tt.wantDropped)
+		})
+	}
+}
+
+// TestFilterScopesByInstallation tests dropping optional scopes the installation can't grant.
+func TestFilterScopesByInstallation(t *testing.T) {
+	permissions := &github.InstallationPermissions{
+		Contents: github.Ptr("write"),
+		Issues:   github.Ptr("read"),
+	}
+
+	tests := []struct {
+		name        string
+		scopes      map[string]string
+		optional    map[string]bool
+		wantKept    map[string]string
+		wantDropped []string
+	}{
+		{
+			name:     "all scopes covered by installation",
+			scopes:   map[string]string{"contents": "write", "issues": "read"},
+			optional: map[string]bool{"issues": true},
+			wantKept: map[string]string{"contents": "write", "issues": "read"},
+		},
+		{
+			name:     "write grant covers read request",
+			scopes:   map[string]string{"contents": "read"},
+			optional: map[string]bool{"contents": true},
+			wantKept: map[string]string{"contents": "read"},
+		},
+		{
+			name:        "optional scope without installation access is dropped",
+			scopes:      map[string]string{"contents": "write", "workflows": "write"},
+			optional:    map[string]bool{"workflows": true},
+			wantKept:    map[string]string{"contents": "write"},
+			wantDropped: []string{"workflows"},
+		},
+		{
+			name:        "optional scope with insufficient level is dropped",
+			scopes:      map[string]string{"contents": "write", "issues": "write"},
+			optional:    map[string]bool{"issues": true},
+			wantKept:    map[string]string{"contents": "write"},
+			wantDropped: []string{"issues"},
+		},
+		{
+			name:     "required scope without installation access is kept",
+			scopes:   map[string]string{"workflows": "write", "issues": "read"},
+			optional: map[string]bool{"issues": true},
+			wantKept: map[string]string{"workflows": "write", "issues": "read"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, dropped := FilterScopesByInstallation(tt.scopes, tt.optional, permissions)
+
+			assertScopesEqual(t, "FilterScopesByInstallation()", kept, tt.wantKept)
+			assertDroppedScopes(t, "FilterScopesByInstallation()", dropped, tt.wantDropped)
+		})
+	}
+}
+
+// assertScopesEqual fails the test if the scope maps differ.
+func assertScopesEqual(t *testing.T, fn string, got, want map[string]string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s kept = %v, want %v", fn, got, want)
+		return
+	}
+	for scopeID, permission := range want {
+		if got[scopeID] != permission {
+			t.Errorf("%s kept = %v, want %v", fn, got, want)
+			return
+		}
+	}
+}
+
+// assertDroppedScopes fails the test if the dropped scope IDs differ or a reason is missing.
+func assertDroppedScopes(t *testing.T, fn string, got []DroppedScope, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s dropped = %v, want %v", fn, got, want)
+		return
+	}
+	for i, scopeID := range want {
+		if got[i].Scope != scopeID {
+			t.Errorf("%s dropped[%d] = %v, want %v", fn, i, got[i].Scope, scopeID)
+		}
+		if got[i].Reason == "" {
+			t.Errorf("%s dropped[%d] has empty reason", fn, i)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BreakerSettings controls when circuit breakers open and how long they stay open.
+type BreakerSettings struct {
+	// FailureRatio is the share of failed calls within a window that opens the breaker.
+	FailureRatio float64
+	// MinRequests is the number of calls within a window before the failure ratio is considered.
+	MinRequests int
+	// Window is the period over which calls are counted.
+	Window time.Duration
+	// OpenDuration is how long an open breaker fails fast before it lets a probe call through.
+	OpenDuration time.Duration
+}
+
+// defaultBreakerSettings open a breaker when at least half of 5 or more calls within 30 seconds fail.
+var defaultBreakerSettings = BreakerSettings{
+	FailureRatio: 0.5,
+	MinRequests:  5,
+	Window:       30 * time.Second,
+	OpenDuration: 30 * time.Second,
+}
+
+// Circuit breakers of the external dependencies. The GitHub APIs have breakers per host (githubBreakers),
+// and the JWKS per OIDC issuer (jwksIssuers).
+var (
+	githubBreakers       = NewGitHubBreakers(defaultBreakerSettings)
+	secretManagerBreaker = NewCircuitBreaker("secret_manager", defaultBreakerSettings)
+	vaultBreaker         = NewCircuitBreaker("vault", defaultBreakerSettings)
+	kmsBreaker           = NewCircuitBreaker("cloud_kms", defaultBreakerSettings)
+)
+
+// circuitBreakers lists the circuit breakers other than GitHub's and the JWKS', in the order they are reported.
+var circuitBreakers = []*CircuitBreaker{secretManagerBreaker, vaultBreaker, kmsBreaker}
+
+// ParseBreakerSettings parses the circuit breaker settings from the CIRCUIT_BREAKER_FAILURE_RATIO,
+// CIRCUIT_BREAKER_MIN_REQUESTS, CIRCUIT_BREAKER_WINDOW, and CIRCUIT_BREAKER_OPEN_DURATION environment
+// variables. Unset variables keep their defaults.
+func ParseBreakerSettings() (BreakerSettings, error) {
+	settings := defaultBreakerSettings
+
+	if envValue := strings.TrimSpace(configValue("CIRCUIT_BREAKER_FAILURE_RATIO")); envValue != "" {
+		ratio, err := strconv.ParseFloat(envValue, 64)
+		if err != nil || ratio <= 0 || ratio > 1 {
+			return BreakerSettings{}, fmt.Errorf("invalid CIRCUIT_BREAKER_FAILURE_RATIO %q: must be greater than 0 and at most 1", envValue)
+		}
+		settings.FailureRatio = ratio
+	}
+
+	if envValue := strings.TrimSpace(configValue("CIRCUIT_BREAKER_MIN_REQUESTS")); envValue != "" {
+		requests, err := strconv.Atoi(envValue)
+		if err != nil || requests < 1 {
+			return BreakerSettings{}, fmt.Errorf("invalid CIRCUIT_BREAKER_MIN_REQUESTS %q: must be a positive integer", envValue)
+		}
+		settings.MinRequests = requests
+	}
+
+	durations := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"CIRCUIT_BREAKER_WINDOW", &settings.Window},
+		{"CIRCUIT_BREAKER_OPEN_DURATION", &settings.OpenDuration},
+	}
+	for _, d := range durations {
+		envValue := strings.TrimSpace(configValue(d.name))
+		if envValue == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(envValue)
+		if err != nil || duration <= 0 {
+			return BreakerSettings{}, fmt.Errorf("invalid %s %q: must be a positive duration", d.name, envValue)
+		}
+		*d.value = duration
+	}
+
+	return settings, nil
+}
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all calls through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails all calls fast.
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe call through to test whether the dependency recovered.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitOpenError reports that a call was not made because the dependency's circuit breaker is open.
+type CircuitOpenError struct {
+	Dependency string
+	// RetryAfter is how long until the breaker lets a probe call through.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s is unavailable (circuit breaker open), retry after %s", e.Dependency, e.RetryAfter.Round(time.Second))
+}
+
+// CircuitBreaker fails calls to a dependency fast while too many of its recent calls failed.
+// Once open, it stays open for the configured duration, then lets a single probe call through:
+// the breaker closes if the probe succeeds and opens again if it fails. It is safe for concurrent use.
+type CircuitBreaker struct {
+	name string
+
+	mu       sync.Mutex
+	settings BreakerSettings
+	state    BreakerState
+	// requests and failures count the calls of the current window, which started at windowStart.
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probing     bool
+	// now returns the current time. It is a field so tests can control the clock.
+	now func() time.Time
+}
+
+// NewCircuitBreaker creates a closed circuit breaker for the named dependency.
+func NewCircuitBreaker(name string, settings BreakerSettings) *CircuitBreaker {
+	return &CircuitBreaker{name: name, settings: settings, now: time.Now}
+}
+
+// Configure replaces the breaker's settings and resets it to closed.
+func (b *CircuitBreaker) Configure(settings BreakerSettings) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.settings = settings
+	b.state = BreakerClosed
+	b.requests, b.failures = 0, 0
+	b.windowStart = time.Time{}
+	b.probing = false
+}
+
+// Allow reports whether a call may be made, returning a *CircuitOpenError if not.
+// Every allowed call must be followed by a call to Record with its outcome.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+
+	switch b.state {
+	case BreakerOpen:
+		reopenAt := b.openedAt.Add(b.settings.OpenDuration)
+		if now.Before(reopenAt) {
+			return &CircuitOpenError{Dependency: b.name, RetryAfter: reopenAt.Sub(now)}
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			// Callers retry once the probe had time to finish
+			return &CircuitOpenError{Dependency: b.name, RetryAfter: time.Second}
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record records the outcome of an allowed call.
+func (b *CircuitBreaker) Record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		if failed {
+			b.open(now)
+		} else {
+			b.state = BreakerClosed
+			b.requests, b.failures = 0, 0
+			b.windowStart = now
+		}
+		return
+	}
+	if b.state == BreakerOpen {
+		// A call allowed before the breaker opened; its outcome doesn't change anything
+		return
+	}
+
+	if now.Sub(b.windowStart) >= b.settings.Window {
+		b.requests, b.failures = 0, 0
+		b.windowStart = now
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.settings.MinRequests && float64(b.failures) >= b.settings.FailureRatio*float64(b.requests) {
+		b.open(now)
+	}
+}
+
+// open opens the breaker. b.mu must be held.
+func (b *CircuitBreaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.requests, b.failures = 0, 0
+}
+
+// Guard wraps an attempt function of RetryPolicy.Do with the breaker. Attempts are refused with a
+// non-retryable *CircuitOpenError while the breaker is open. Retryable errors count as failures,
+// except rate limits (attempts asking for a specific wait): the dependency did respond.
+// Each attempt is recorded in the metrics of the dependency named after the breaker.
+func (b *CircuitBreaker) Guard(fn func() (retryable bool, retryAfter time.Duration, err error)) func() (bool, time.Duration, error) {
+	attempt := 0
+	return func() (bool, time.Duration, error) {
+		attempt++
+		if err := b.Allow(); err != nil {
+			metrics.RecordDependencyAttempt(b.name, attempt, dependencyCircuitOpen, 0)
+			return false, 0, err
+		}
+		start := time.Now()
+		retryable, retryAfter, err := fn()
+		b.Record(err != nil && retryable && retryAfter <= 0)
+
+		outcome := dependencySuccess
+		if retryAfter > 0 {
+			outcome = dependencyRateLimited
+		} else if err != nil {
+			outcome = dependencyError
+		}
+		metrics.RecordDependencyAttempt(b.name, attempt, outcome, time.Since(start))
+		return retryable, retryAfter, err
+	}
+}
+
+// BreakerStatus is the reported status of a circuit breaker.
+type BreakerStatus struct {
+	State string `json:"state"`
+	// Requests and Failures count the calls of the current window.
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+	// RetryAfterSeconds is the time until an open breaker lets a probe call through.
+	RetryAfterSeconds int64 `json:"retry_after_seconds,omitempty"`
+}
+
+// Status returns the breaker's current status.
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{State: b.state.String(), Requests: b.requests, Failures: b.failures}
+	if b.state == BreakerOpen {
+		if wait := b.openedAt.Add(b.settings.OpenDuration).Sub(b.now()); wait > 0 {
+			status.RetryAfterSeconds = int64((wait + time.Second - 1) / time.Second)
+		} else {
+			// Due for a probe: the next call is let through
+			status.State = BreakerHalfOpen.String()
+		}
+	}
+	return status
+}
+
+// GitHubBreakers holds the circuit breakers of the GitHub installations and installation token APIs,
+// a pair per GitHub host, so an outage of one host (e.g. a GitHub Enterprise Server) doesn't fail the
+// requests to the others. The breakers of github.com are named github_installations and github_tokens;
+// those of other hosts carry the host, e.g. github_tokens:github.example.com. It is safe for concurrent use.
+type GitHubBreakers struct {
+	mu       sync.Mutex
+	settings BreakerSettings
+	// hosts are the breakers by host, "" for github.com.
+	hosts map[string]githubHostBreakers
+}
+
+// githubHostBreakers are the circuit breakers of a GitHub host.
+type githubHostBreakers struct {
+	installations *CircuitBreaker
+	tokens        *CircuitBreaker
+}
+
+// NewGitHubBreakers creates the GitHub circuit breakers; the breakers of a host are created closed on first use.
+func NewGitHubBreakers(settings BreakerSettings) *GitHubBreakers {
+	return &GitHubBreakers{settings: settings, hosts: make(map[string]githubHostBreakers)}
+}
+
+// Configure replaces the settings of the breakers of every host and resets them to closed.
+func (g *GitHubBreakers) Configure(settings BreakerSettings) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.settings = settings
+	for _, breakers := range g.hosts {
+		breakers.installations.Configure(settings)
+		breakers.tokens.Configure(settings)
+	}
+}
+
+// Installations returns the breaker of the installations API of the GitHub host of baseURL (empty for github.com).
+func (g *GitHubBreakers) Installations(baseURL string) *CircuitBreaker {
+	return g.host(baseURL).installations
+}
+
+// Tokens returns the breaker of the installation token API of the GitHub host of baseURL (empty for github.com).
+func (g *GitHubBreakers) Tokens(baseURL string) *CircuitBreaker {
+	return g.host(baseURL).tokens
+}
+
+// ForApps returns the breakers of the hosts of the GitHub Apps, sorted by name.
+func (g *GitHubBreakers) ForApps(apps []GitHubApp) []*CircuitBreaker {
+	var breakers []*CircuitBreaker
+	for _, app := range apps {
+		host := g.host(app.BaseURL)
+		if !slices.Contains(breakers, host.installations) {
+			breakers = append(breakers, host.installations, host.tokens)
+		}
+	}
+	slices.SortFunc(breakers, func(a, b *CircuitBreaker) int { return strings.Compare(a.name, b.name) })
+	return breakers
+}
+
+// host returns the breakers of the GitHub host of baseURL, creating them on first use.
+func (g *GitHubBreakers) host(baseURL string) githubHostBreakers {
+	host := ""
+	if baseURL != "" {
+		host = baseURL
+		if parsed, err := url.Parse(baseURL); err == nil && parsed.Host != "" {
+			host = strings.ToLower(parsed.Host)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	breakers, ok := g.hosts[host]
+	if !ok {
+		suffix := ""
+		if host != "" {
+			suffix = ":" + host
+		}
+		breakers = githubHostBreakers{
+			installations: NewCircuitBreaker("github_installations"+suffix, g.settings),
+			tokens:        NewCircuitBreaker("github_tokens"+suffix, g.settings),
+		}
+		g.hosts[host] = breakers
+	}
+	return breakers
+}
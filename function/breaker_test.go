@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// TestMain keeps the process-wide circuit breakers from opening: many tests make failing calls on
+// purpose, which would otherwise make unrelated tests fail fast. Breaker tests use their own breakers.
+func TestMain(m *testing.M) {
+	settings := defaultBreakerSettings
+	settings.MinRequests = math.MaxInt
+	githubBreakers.Configure(settings)
+	jwksIssuers.Configure(settings)
+	for _, breaker := range circuitBreakers {
+		breaker.Configure(settings)
+	}
+	os.Exit(m.Run())
+}
+
+// testClock is a manually advanced clock for circuit breakers.
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// newTestBreaker creates a breaker that opens when half of 4 calls fail and stays open for a minute.
+func newTestBreaker() (*CircuitBreaker, *testClock) {
+	clock := &testClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	breaker := NewCircuitBreaker("test", BreakerSettings{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		Window:       10 * time.Second,
+		OpenDuration: time.Minute,
+	})
+	breaker.now = clock.Now
+	return breaker, clock
+}
+
+// record makes an allowed call with the given outcome.
+func record(t *testing.T, breaker *CircuitBreaker, failed bool) {
+	t.Helper()
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("Allow() unexpected error = %v", err)
+	}
+	breaker.Record(failed)
+}
+
+// TestParseBreakerSettings tests parsing of the CIRCUIT_BREAKER_* environment variables.
+func TestParseBreakerSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    BreakerSettings
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{},
+			want: defaultBreakerSettings,
+		},
+		{
+			name: "all set",
+			env: map[string]string{
+				"CIRCUIT_BREAKER_FAILURE_RATIO": "0.25",
+				"CIRCUIT_BREAKER_MIN_REQUESTS":  "10",
+				"CIRCUIT_BREAKER_WINDOW":        "1m",
+				"CIRCUIT_BREAKER_OPEN_DURATION": "2m",
+			},
+			want: BreakerSettings{FailureRatio: 0.25, MinRequests: 10, Window: time.Minute, OpenDuration: 2 * time.Minute},
+		},
+		{name: "ratio zero", env: map[string]string{"CIRCUIT_BREAKER_FAILURE_RATIO": "0"}, wantErr: true},
+		{name: "ratio above one", env: map[string]string{"CIRCUIT_BREAKER_FAILURE_RATIO": "1.5"}, wantErr: true},
+		{name: "invalid min requests", env: map[string]string{"CIRCUIT_BREAKER_MIN_REQUESTS": "0"}, wantErr: true},
+		{name: "invalid window", env: map[string]string{"CIRCUIT_BREAKER_WINDOW": "soon"}, wantErr: true},
+		{name: "zero open duration", env: map[string]string{"CIRCUIT_BREAKER_OPEN_DURATION": "0s"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"CIRCUIT_BREAKER_FAILURE_RATIO", "CIRCUIT_BREAKER_MIN_REQUESTS", "CIRCUIT_BREAKER_WINDOW", "CIRCUIT_BREAKER_OPEN_DURATION"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			got, err := ParseBreakerSettings()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBreakerSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseBreakerSettings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCircuitBreaker_Opens tests that the breaker opens once the failure ratio is reached with enough calls.
+func TestCircuitBreaker_Opens(t *testing.T) {
+	breaker, clock := newTestBreaker()
+
+	record(t, breaker, true)
+	record(t, breaker, false)
+	record(t, breaker, true)
+	if state := breaker.Status().State; state != "closed" {
+		t.Fatalf("state after 3 calls = %s, want closed (below minimum requests)", state)
+	}
+	record(t, breaker, false)
+
+	status := breaker.Status()
+	if status.State != "open" {
+		t.Fatalf("state = %s, want open", status.State)
+	}
+	if status.RetryAfterSeconds != 60 {
+		t.Errorf("RetryAfterSeconds = %d, want 60", status.RetryAfterSeconds)
+	}
+
+	clock.now = clock.now.Add(20 * time.Second)
+	err := breaker.Allow()
+	var circuitOpen *CircuitOpenError
+	if !errors.As(err, &circuitOpen) {
+		t.Fatalf("Allow() error = %v, want *CircuitOpenError", err)
+	}
+	if circuitOpen.Dependency != "test" || circuitOpen.RetryAfter != 40*time.Second {
+		t.Errorf("CircuitOpenError = %+v, want dependency test and RetryAfter 40s", circuitOpen)
+	}
+}
+
+// TestCircuitBreaker_WindowReset tests that failures of past windows don't count.
+func TestCircuitBreaker_WindowReset(t *testing.T) {
+	breaker, clock := newTestBreaker()
+
+	record(t, breaker, true)
+	record(t, breaker, true)
+	record(t, breaker, true)
+	clock.now = clock.now.Add(10 * time.Second)
+	record(t, breaker, true)
+
+	status := breaker.Status()
+	if status.State != "closed" || status.Requests != 1 || status.Failures != 1 {
+		t.Errorf("Status() = %+v, want closed with 1 failed request in the new window", status)
+	}
+}
+
+// TestCircuitBreaker_HalfOpen tests that an open breaker lets a single probe through once the open
+// duration passed, closing on success and opening again on failure.
+func TestCircuitBreaker_HalfOpen(t *testing.T) {
+	tests := []struct {
+		name        string
+		probeFailed bool
+		wantState   string
+	}{
+		{name: "probe succeeds", probeFailed: false, wantState: "closed"},
+		{name: "probe fails", probeFailed: true, wantState: "open"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker, clock := newTestBreaker()
+			for range 4 {
+				record(t, breaker, true)
+			}
+
+			clock.now = clock.now.Add(time.Minute)
+			if state := breaker.Status().State; state != "half_open" {
+				t.Errorf("state after open duration = %s, want half_open", state)
+			}
+			if err := breaker.Allow(); err != nil {
+				t.Fatalf("Allow() probe error = %v", err)
+			}
+			if err := breaker.Allow(); err == nil {
+				t.Fatal("Allow() during probe succeeded, want *CircuitOpenError")
+			}
+
+			breaker.Record(tt.probeFailed)
+			if state := breaker.Status().State; state != tt.wantState {
+				t.Errorf("state after probe = %s, want %s", state, tt.wantState)
+			}
+		})
+	}
+}
+
+// TestCircuitBreaker_Guard tests which attempt outcomes count as failures.
+func TestCircuitBreaker_Guard(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryable  bool
+		retryAfter time.Duration
+		err        error
+		wantOpen   bool
+	}{
+		{name: "success", err: nil, wantOpen: false},
+		{name: "retryable error", retryable: true, err: errors.New("502"), wantOpen: true},
+		{name: "non-retryable error", retryable: false, err: errors.New("404"), wantOpen: false},
+		{name: "rate limit", retryable: true, retryAfter: time.Second, err: errors.New("429"), wantOpen: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker, _ := newTestBreaker()
+			attempt := breaker.Guard(func() (bool, time.Duration, error) {
+				return tt.retryable, tt.retryAfter, tt.err
+			})
+			for range 4 {
+				retryable, retryAfter, err := attempt()
+				if retryable != tt.retryable || retryAfter != tt.retryAfter || !errors.Is(err, tt.err) {
+					t.Fatalf("attempt() = %v, %v, %v, want the wrapped attempt's result", retryable, retryAfter, err)
+				}
+			}
+
+			if open := breaker.Status().State == "open"; open != tt.wantOpen {
+				t.Errorf("open = %v, want %v", open, tt.wantOpen)
+			}
+
+			if tt.wantOpen {
+				retryable, _, err := attempt()
+				var circuitOpen *CircuitOpenError
+				if retryable || !errors.As(err, &circuitOpen) {
+					t.Errorf("attempt() while open = %v, %v, want non-retryable *CircuitOpenError", retryable, err)
+				}
+			}
+		})
+	}
+}
+
+// TestRetryWithBackoff_CircuitOpen tests that GitHub calls fail fast with a 503 while their breaker is open.
+func TestRetryWithBackoff_CircuitOpen(t *testing.T) {
+	useFastRetryPolicy(t)
+	breaker, _ := newTestBreaker()
+	for range 4 {
+		record(t, breaker, true)
+	}
+
+	calls := 0
+	_, err := retryWithBackoff(context.Background(), breaker, "failed",
+		func() (struct{}, *github.Response, error) {
+			calls++
+			return struct{}{}, nil, nil
+		},
+		func(*github.Response, error) error { return nil },
+	)
+	if calls != 0 {
+		t.Errorf("calls = %d, want 0", calls)
+	}
+
+	typed := AsError(err, CodeGitHubUnavailable, "%w")
+	if typed.Code != CodeDependencyUnavailable || typed.Status() != http.StatusServiceUnavailable || typed.RetryAfter != time.Minute {
+		t.Errorf("AsError() = %s (%d) with RetryAfter %s, want %s (503) with 1m", typed.Code, typed.Status(), typed.RetryAfter, CodeDependencyUnavailable)
+	}
+	if typed.Details["dependency"] != "test" {
+		t.Errorf("Details = %v, want dependency test", typed.Details)
+	}
+}
+
+// TestGitHubBreakers tests that each GitHub host has breakers of its own.
+//
+// Test steps:
+//  1. Open the token breaker of a GitHub Enterprise Server host
+//  2. Verify github.com's token breaker is still closed and each host's breakers are shared by its base URLs
+//  3. Verify the breakers of the apps' hosts are listed once per host, sorted by name
+func TestGitHubBreakers(t *testing.T) {
+	// Step 1: Open the breaker of github.example.com
+	breakers := NewGitHubBreakers(BreakerSettings{FailureRatio: 0.5, MinRequests: 2, Window: time.Minute, OpenDuration: time.Minute})
+	enterprise := breakers.Tokens("https://github.example.com/api/v3/")
+	for range 2 {
+		record(t, enterprise, true)
+	}
+	if err := enterprise.Allow(); err == nil {
+		t.Fatal("Allow() = nil, want the breaker of github.example.com open")
+	}
+
+	// Step 2: Other hosts are unaffected
+	if err := breakers.Tokens("").Allow(); err != nil {
+		t.Errorf("Allow() of github.com = %v, want nil", err)
+	}
+	if got := breakers.Tokens("https://GITHUB.example.com/api/v3"); got != enterprise {
+		t.Errorf("Tokens() = %s, want the breaker of github.example.com", got.name)
+	}
+
+	// Step 3: Breakers of the apps' hosts
+	apps := []GitHubApp{{Name: "default"}, {Name: "ghes", BaseURL: "https://github.example.com/api/v3/"}, {Name: "ghes-2", BaseURL: "https://github.example.com/api/v3/"}}
+	var names []string
+	for _, breaker := range breakers.ForApps(apps) {
+		names = append(names, breaker.name)
+	}
+	want := []string{"github_installations", "github_installations:github.example.com", "github_tokens", "github_tokens:github.example.com"}
+	if !slices.Equal(names, want) {
+		t.Errorf("ForApps() = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// tokenCache is the process-wide cache of issued tokens for reuse.
+var tokenCache = NewTokenCache()
+
+// ParseTokenReuseMinValidity parses the GITHUB_TOKEN_REUSE_MIN_VALIDITY environment variable.
+// Token reuse is enabled when it is set: identical requests of the same workflow run attempt share
+// one token while it has at least this much lifetime left. Returns 0 (reuse disabled) if not set.
+func ParseTokenReuseMinValidity() (time.Duration, error) {
+	envValue := strings.TrimSpace(configValue("GITHUB_TOKEN_REUSE_MIN_VALIDITY"))
+	if envValue == "" {
+		return 0, nil
+	}
+	validity, err := time.ParseDuration(envValue)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GITHUB_TOKEN_REUSE_MIN_VALIDITY %q: %w", envValue, err)
+	}
+	if validity <= 0 || validity >= maxTokenTTL {
+		return 0, fmt.Errorf("GITHUB_TOKEN_REUSE_MIN_VALIDITY %s must be greater than 0 and less than %s", validity, maxTokenTTL)
+	}
+	return validity, nil
+}
+
+// cacheKey identifies requests that can share a token: same repository, workflow run attempt,
+// scopes, optional scopes, maximum lifetime, and GitHub App.
+func (req tokenRequest) cacheKey() string {
+	scopes := make([]string, 0, len(req.scopes))
+	for _, scopeID := range sortedScopeIDs(req.scopes) {
+		scopes = append(scopes, scopeID+"="+req.scopes[scopeID])
+	}
+	optional := make([]string, 0, len(req.optional))
+	for scopeID := range req.optional {
+		optional = append(optional, scopeID)
+	}
+	sort.Strings(optional)
+
+	return fmt.Sprintf("%s|%d|%d|%s|%s|%s|%s",
+		strings.ToLower(req.identity.Repository),
+		req.identity.RunID,
+		req.identity.RunAttempt,
+		strings.Join(scopes, ","),
+		strings.Join(optional, ","),
+		req.ttl,
+		req.app.Name)
+}
+
+// cachedToken is a token handed out for a cache key.
+type cachedToken struct {
+	response  TokenResponse
+	expiresAt time.Time
+	// holders is the number of requests the token was handed out to and that haven't released it.
+	holders int
+}
+
+// TokenCache reuses tokens across identical requests and coalesces concurrent identical requests
+// into a single GitHub call. It is safe for concurrent use.
+type TokenCache struct {
+	group singleflight.Group
+
+	mu     sync.Mutex
+	tokens map[string]*cachedToken
+}
+
+// NewTokenCache creates an empty token cache.
+func NewTokenCache() *TokenCache {
+	return &TokenCache{tokens: make(map[string]*cachedToken)}
+}
+
+// Issue returns the cached token for key if it is valid for at least minValidity, or issues a new
+// one with issue. Concurrent calls with the same key share a single issue call, which runs detached
+// from the cancellation of the individual callers.
+func (c *TokenCache) Issue(ctx context.Context, key string, minValidity time.Duration, issue func(ctx context.Context) (TokenResponse, error)) (TokenResponse, error) {
+	if response, ok := c.acquire(key, minValidity); ok {
+		return response, nil
+	}
+
+	result := c.group.DoChan(key, func() (interface{}, error) {
+		// Another flight may have finished between the lookup above and this one
+		if response, ok := c.lookup(key, minValidity); ok {
+			return response, nil
+		}
+
+		issueCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
+		defer cancel()
+		response, err := issue(issueCtx)
+		if err != nil {
+			return TokenResponse{}, err
+		}
+		expiresAt, err := time.Parse(time.RFC3339, response.ExpiresAt)
+		if err != nil {
+			return TokenResponse{}, fmt.Errorf("invalid token expiry %q: %w", response.ExpiresAt, err)
+		}
+
+		c.mu.Lock()
+		c.tokens[key] = &cachedToken{response: response, expiresAt: expiresAt}
+		c.mu.Unlock()
+		return response, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return TokenResponse{}, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return TokenResponse{}, res.Err
+		}
+		if response, ok := c.acquire(key, 0); ok {
+			return response, nil
+		}
+		// Released or pruned in the meantime; still a valid token for this request
+		return res.Val.(TokenResponse), nil
+	}
+}
+
+// lookup returns the cached token for key if it is valid for at least minValidity.
+func (c *TokenCache) lookup(key string, minValidity time.Duration) (TokenResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, exists := c.tokens[key]
+	if !exists || time.Until(cached.expiresAt) < minValidity {
+		return TokenResponse{}, false
+	}
+	return cached.response, true
+}
+
+// acquire returns the cached token for key if it is valid for at least minValidity and records the
+// caller as one of its holders. Expired entries are pruned.
+func (c *TokenCache) acquire(key string, minValidity time.Duration) (TokenResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for cachedKey, cached := range c.tokens {
+		if !cached.expiresAt.After(now) {
+			delete(c.tokens, cachedKey)
+		}
+	}
+	cached, exists := c.tokens[key]
+	if !exists || cached.expiresAt.Sub(now) < minValidity {
+		return TokenResponse{}, false
+	}
+	cached.holders++
+	return cached.response, true
+}
+
+// Evict stops handing out the token and reports whether other holders remain, besides the caller if it
+// is one. Tokens that aren't cached have no other holders.
+func (c *TokenCache) Evict(token string) (othersRemain bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, cached := range c.tokens {
+		if cached.response.Token != token {
+			continue
+		}
+		delete(c.tokens, key)
+		return cached.holders > 1
+	}
+	return false
+}
+
+// Release records that one holder of the token no longer needs it and reports whether other
+// holders remain. The token is no longer handed out once the last holder released it.
+// Tokens that aren't cached have no other holders.
+func (c *TokenCache) Release(token string) (othersRemain bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, cached := range c.tokens {
+		if cached.response.Token != token {
+			continue
+		}
+		cached.holders--
+		if cached.holders > 0 {
+			return true
+		}
+		delete(c.tokens, key)
+		return false
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestParseTokenReuseMinValidity tests parsing of the GITHUB_TOKEN_REUSE_MIN_VALIDITY environment variable.
+func TestParseTokenReuseMinValidity(t *testing.T) {
+	tests := []struct {
+		envValue string
+		want     time.Duration
+		wantErr  bool
+	}{
+		{envValue: "", want: 0},
+		{envValue: "10m", want: 10 * time.Minute},
+		{envValue: "0s", wantErr: true},
+		{envValue: "1h", wantErr: true},
+		{envValue: "ten", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.envValue, func(t *testing.T) {
+			t.Setenv("GITHUB_TOKEN_REUSE_MIN_VALIDITY", tt.envValue)
+
+			got, err := ParseTokenReuseMinValidity()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTokenReuseMinValidity() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseTokenReuseMinValidity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTokenRequestCacheKey tests that cache keys ignore scope order and repository case,
+// and differ for anything that changes the issued token.
+func TestTokenRequestCacheKey(t *testing.T) {
+	base := tokenRequest{
+		identity: Identity{Repository: "owner/repo", RunID: 100, RunAttempt: 1},
+		scopes:   map[string]string{"contents": "read", "issues": "write"},
+		optional: map[string]bool{"issues": true},
+	}
+
+	same := base
+	same.identity.Repository = "Owner/Repo"
+	same.scopes = map[string]string{"issues": "write", "contents": "read"}
+	if base.cacheKey() != same.cacheKey() {
+		t.Errorf("cacheKey() differs for equivalent requests: %q vs %q", base.cacheKey(), same.cacheKey())
+	}
+
+	variants := map[string]func(req *tokenRequest){
+		"run ID":      func(req *tokenRequest) { req.identity.RunID = 101 },
+		"run attempt": func(req *tokenRequest) { req.identity.RunAttempt = 2 },
+		"permission":  func(req *tokenRequest) { req.scopes = map[string]string{"contents": "write", "issues": "write"} },
+		"optional":    func(req *tokenRequest) { req.optional = nil },
+		"ttl":         func(req *tokenRequest) { req.ttl = 10 * time.Minute },
+	}
+	for name, modify := range variants {
+		t.Run(name, func(t *testing.T) {
+			variant := base
+			modify(&variant)
+			if variant.cacheKey() == base.cacheKey() {
+				t.Errorf("cacheKey() is the same after changing %s", name)
+			}
+		})
+	}
+}
+
+// testTokenResponse returns a token response expiring after validity.
+func testTokenResponse(token string, validity time.Duration) TokenResponse {
+	return TokenResponse{
+		Token:     token,
+		ExpiresAt: time.Now().Add(validity).Format(time.RFC3339),
+		Scopes:    map[string]string{"contents": "read"},
+	}
+}
+
+// TestTokenCache_Coalescing tests that concurrent identical requests share a single issue call.
+func TestTokenCache_Coalescing(t *testing.T) {
+	cache := NewTokenCache()
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	issue := func(ctx context.Context) (TokenResponse, error) {
+		calls.Add(1)
+		<-release
+		return testTokenResponse("ghs_shared", time.Hour), nil
+	}
+
+	const requests = 20
+	var wg sync.WaitGroup
+	tokens := make([]string, requests)
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := cache.Issue(context.Background(), "key", 10*time.Minute, issue)
+			if err != nil {
+				t.Errorf("Issue() unexpected error = %v", err)
+			}
+			tokens[i] = response.Token
+		}()
+	}
+
+	// Let the requests pile up on the in-flight call before it completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("issue called %d times, want 1", got)
+	}
+	for i, token := range tokens {
+		if token != "ghs_shared" {
+			t.Errorf("request %d got token %q, want ghs_shared", i, token)
+		}
+	}
+}
+
+// TestTokenCache_MinValidity tests that cached tokens are only reused while enough lifetime remains.
+func TestTokenCache_MinValidity(t *testing.T) {
+	ctx := context.Background()
+	cache := NewTokenCache()
+	var calls int
+	issue := func(ctx context.Context) (TokenResponse, error) {
+		calls++
+		return testTokenResponse(fmt.Sprintf("ghs_%d", calls), 15*time.Minute), nil
+	}
+
+	first, _ := cache.Issue(ctx, "key", 10*time.Minute, issue)
+	reused, _ := cache.Issue(ctx, "key", 10*time.Minute, issue)
+	if reused.Token != first.Token {
+		t.Errorf("Issue() = %q, want reused %q", reused.Token, first.Token)
+	}
+
+	fresh, _ := cache.Issue(ctx, "key", 20*time.Minute, issue)
+	if fresh.Token == first.Token {
+		t.Error("Issue() reused a token with less than the minimum remaining validity")
+	}
+
+	other, _ := cache.Issue(ctx, "other-key", 10*time.Minute, issue)
+	if other.Token == fresh.Token || other.Token == first.Token {
+		t.Error("Issue() reused a token across cache keys")
+	}
+}
+
+// TestTokenCache_Errors tests that failed issue calls are not cached.
+func TestTokenCache_Errors(t *testing.T) {
+	ctx := context.Background()
+	cache := NewTokenCache()
+
+	_, err := cache.Issue(ctx, "key", time.Minute, func(ctx context.Context) (TokenResponse, error) {
+		return TokenResponse{}, NewError(CodeGitHubUnavailable, "GitHub API error")
+	})
+	if err == nil {
+		t.Fatal("Issue() expected error")
+	}
+
+	response, err := cache.Issue(ctx, "key", time.Minute, func(ctx context.Context) (TokenResponse, error) {
+		return testTokenResponse("ghs_retry", time.Hour), nil
+	})
+	if err != nil || response.Token != "ghs_retry" {
+		t.Errorf("Issue() after error = %v, %v, want ghs_retry", response.Token, err)
+	}
+}
+
+// TestTokenCache_Release tests that a shared token is only released once every holder released it.
+func TestTokenCache_Release(t *testing.T) {
+	ctx := context.Background()
+	cache := NewTokenCache()
+	issue := func(ctx context.Context) (TokenResponse, error) {
+		return testTokenResponse("ghs_shared", time.Hour), nil
+	}
+
+	_, _ = cache.Issue(ctx, "key", time.Minute, issue)
+	_, _ = cache.Issue(ctx, "key", time.Minute, issue)
+
+	if !cache.Release("ghs_shared") {
+		t.Error("Release() by first holder = false, want true (another holder remains)")
+	}
+	if cache.Release("ghs_shared") {
+		t.Error("Release() by last holder = true, want false")
+	}
+	if cache.Release("ghs_unknown") {
+		t.Error("Release() of unknown token = true, want false")
+	}
+
+	// Released tokens are no longer handed out
+	response, _ := cache.Issue(ctx, "key", time.Minute, func(ctx context.Context) (TokenResponse, error) {
+		return testTokenResponse("ghs_new", time.Hour), nil
+	})
+	if response.Token != "ghs_new" {
+		t.Errorf("Issue() after release = %q, want ghs_new", response.Token)
+	}
+}
+
+// TestTokenCache_Evict tests that evicted tokens are no longer handed out, and whether other holders remain.
+func TestTokenCache_Evict(t *testing.T) {
+	ctx := context.Background()
+	cache := NewTokenCache()
+	issue := func(token string) func(ctx context.Context) (TokenResponse, error) {
+		return func(ctx context.Context) (TokenResponse, error) {
+			return testTokenResponse(token, time.Hour), nil
+		}
+	}
+
+	_, _ = cache.Issue(ctx, "single", time.Minute, issue("ghs_single"))
+	if cache.Evict("ghs_single") {
+		t.Error("Evict() of a token with a single holder = true, want false")
+	}
+	_, _ = cache.Issue(ctx, "shared", time.Minute, issue("ghs_shared"))
+	_, _ = cache.Issue(ctx, "shared", time.Minute, issue("ghs_shared"))
+	if !cache.Evict("ghs_shared") {
+		t.Error("Evict() of a shared token = false, want true (another holder remains)")
+	}
+	if cache.Evict("ghs_unknown") {
+		t.Error("Evict() of unknown token = true, want false")
+	}
+
+	// Evicted tokens are no longer handed out
+	if response, _ := cache.Issue(ctx, "shared", time.Minute, issue("ghs_new")); response.Token != "ghs_new" {
+		t.Errorf("Issue() after eviction = %q, want ghs_new", response.Token)
+	}
+}
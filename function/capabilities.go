@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// ScopeCapability describes what the calling repository can request for a scope.
+type ScopeCapability struct {
+	// Permissions are the permission levels the repository can request right now (empty if none).
+	Permissions []string `json:"permissions"`
+	// AllowedLevels are the permission levels the scope policy allows (empty if the scope is blacklisted).
+	AllowedLevels []string `json:"allowed_levels"`
+	// App is the GitHub App a request for the scope alone is routed to (empty if none).
+	App string `json:"app,omitempty"`
+	// Installation is the permission level granted to the app's installation (empty if none).
+	Installation string `json:"installation,omitempty"`
+	// Reason explains why an allowed permission level can't be requested.
+	Reason string `json:"reason,omitempty"`
+}
+
+// ProfileCapability describes a scope profile the calling repository may request.
+type ProfileCapability struct {
+	Scopes map[string]string `json:"scopes"`
+	// App is the GitHub App a request for the profile is routed to (empty if none).
+	App string `json:"app,omitempty"`
+	// Requestable reports whether every scope of the profile can be requested right now.
+	Requestable bool `json:"requestable"`
+	// UnavailableScopes are the profile's scopes that can't be requested (omitted if none).
+	UnavailableScopes []string `json:"unavailable_scopes,omitempty"`
+}
+
+// AppInstallation describes the installation of a GitHub App on the calling repository.
+type AppInstallation struct {
+	Installed      bool  `json:"installed"`
+	InstallationID int64 `json:"installation_id,omitempty"`
+}
+
+// CapabilitiesResponse is the response of GET /capabilities.
+type CapabilitiesResponse struct {
+	Repository string                       `json:"repository"`
+	Apps       map[string]AppInstallation   `json:"apps"`
+	Scopes     map[string]ScopeCapability   `json:"scopes"`
+	Profiles   map[string]ProfileCapability `json:"profiles"`
+}
+
+// handleCapabilities handles GET /capabilities requests: it reports which scopes the calling repository
+// can request right now, i.e. the scope catalog restricted by the scope policy and by the permissions
+// granted to the GitHub App installation, and which scope profiles it may request.
+func handleCapabilities(config *Config, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, NewError(CodeMethodNotAllowed, "method not allowed"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	identity, ok := authenticateCaller(ctx, config, w, r)
+	if !ok {
+		return
+	}
+
+	response, err := repositoryCapabilities(ctx, config, identity)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// repositoryCapabilities looks up the installations on the caller's repository of every GitHub App
+// its requests can be routed to, and returns the repository's capabilities.
+func repositoryCapabilities(ctx context.Context, config *Config, identity Identity) (CapabilitiesResponse, *Error) {
+	apps, profiles := config.Apps, config.ScopeProfiles
+
+	installations := make(map[string]*github.Installation)
+	for _, app := range routedApps(identity, apps, profiles) {
+		client, err := newJWTAppsService(ctx, app)
+		if err != nil {
+			return CapabilitiesResponse{}, AsError(err, CodeInternalError, "%w")
+		}
+		installation, err := GetInstallation(ctx, client, app.BaseURL, identity.Repository)
+		var typed *Error
+		if errors.As(err, &typed) && typed.Code == CodeAppNotInstalled {
+			installations[app.Name] = nil
+			continue
+		}
+		if err != nil {
+			return CapabilitiesResponse{}, AsError(err, CodeGitHubUnavailable, "GitHub API error: %w")
+		}
+		installations[app.Name] = installation
+	}
+
+	return BuildCapabilities(identity, apps, installations, profiles), nil
+}
+
+// routedApps returns the GitHub Apps that requests of the caller for a single scope or a scope profile are routed to.
+func routedApps(identity Identity, apps []GitHubApp, profiles map[string]ScopeProfile) []GitHubApp {
+	var routed []GitHubApp
+	add := func(scopes map[string]string) {
+		app, err := ResolveApp(apps, identity, scopes)
+		if err == nil && !slices.ContainsFunc(routed, func(other GitHubApp) bool { return other.Name == app.Name }) {
+			routed = append(routed, app)
+		}
+	}
+	for scopeID, levels := range AllowedScopes {
+		add(map[string]string{scopeID: levels[0]})
+	}
+	for name, profile := range profiles {
+		if ValidateProfileAllowed(name, profile, identity.Repository, identity.OwnerID) == nil {
+			add(profile.Scopes)
+		}
+	}
+	return routed
+}
+
+// BuildCapabilities computes the capabilities of the caller's repository from the scope catalog
+// (AllowedScopes and BlacklistedScopes), the GitHub App each request is routed to and the permissions
+// granted to its installation, and the scope profiles the repository and its owner may request.
+// installations maps app names to their installation on the repository (nil if not installed).
+func BuildCapabilities(identity Identity, apps []GitHubApp, installations map[string]*github.Installation, profiles map[string]ScopeProfile) CapabilitiesResponse {
+	appInstallations := make(map[string]AppInstallation, len(installations))
+	for name, installation := range installations {
+		appInstallations[name] = AppInstallation{Installed: installation != nil, InstallationID: installation.GetID()}
+	}
+
+	scopes := make(map[string]ScopeCapability, len(AllowedScopes))
+	for scopeID, levels := range AllowedScopes {
+		capability := ScopeCapability{
+			Permissions:   []string{},
+			AllowedLevels: []string{},
+		}
+		if BlacklistedScopes[scopeID] {
+			capability.Reason = "scope is blacklisted"
+			scopes[scopeID] = capability
+			continue
+		}
+		capability.AllowedLevels = slices.Clone(levels)
+
+		app, err := ResolveApp(apps, identity, map[string]string{scopeID: levels[0]})
+		if err != nil {
+			capability.Reason = "no GitHub App is configured for this scope"
+			scopes[scopeID] = capability
+			continue
+		}
+		capability.App = app.Name
+		installation := installations[app.Name]
+		capability.Installation = InstallationScopes(installation.GetPermissions())[scopeID]
+
+		switch {
+		case installation == nil:
+			capability.Reason = fmt.Sprintf("GitHub App '%s' is not installed on this repository", app.Name)
+		case capability.Installation == "":
+			capability.Reason = "GitHub App installation has no access to this scope"
+		default:
+			for _, level := range levels {
+				if PermissionCovers(capability.Installation, level) {
+					capability.Permissions = append(capability.Permissions, level)
+				}
+			}
+			if len(capability.Permissions) < len(levels) {
+				capability.Reason = fmt.Sprintf("GitHub App installation only has '%s' access to this scope", capability.Installation)
+			}
+		}
+		scopes[scopeID] = capability
+	}
+
+	allowedProfiles := make(map[string]ProfileCapability)
+	for name, profile := range profiles {
+		if ValidateProfileAllowed(name, profile, identity.Repository, identity.OwnerID) != nil {
+			continue
+		}
+		capability := ProfileCapability{Scopes: profile.Scopes}
+		app, err := ResolveApp(apps, identity, profile.Scopes)
+		var granted map[string]string
+		if err == nil {
+			capability.App = app.Name
+			granted = InstallationScopes(installations[app.Name].GetPermissions())
+		}
+		for _, scopeID := range sortedScopeIDs(profile.Scopes) {
+			if grantedPerm, exists := granted[scopeID]; !exists || !PermissionCovers(grantedPerm, profile.Scopes[scopeID]) {
+				capability.UnavailableScopes = append(capability.UnavailableScopes, scopeID)
+			}
+		}
+		capability.Requestable = len(capability.UnavailableScopes) == 0
+		allowedProfiles[name] = capability
+	}
+
+	return CapabilitiesResponse{
+		Repository: identity.Repository,
+		Apps:       appInstallations,
+		Scopes:     scopes,
+		Profiles:   allowedProfiles,
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// TestBuildCapabilities tests that scope capabilities combine the scope policy with the permissions
+// granted to the installation, and that only profiles allowed for the repository are listed.
+func TestBuildCapabilities(t *testing.T) {
+	original := BlacklistedScopes
+	t.Cleanup(func() { BlacklistedScopes = original })
+	BlacklistedScopes = map[string]bool{"secrets": true}
+
+	identity := Identity{Issuer: githubOIDCIssuer, Repository: "owner/repo", OwnerID: 12345}
+	installation := &github.Installation{
+		ID: github.Ptr(int64(42)),
+		Permissions: &github.InstallationPermissions{
+			Contents:       github.Ptr("write"),
+			Issues:         github.Ptr("read"),
+			Administration: github.Ptr("write"),
+			Secrets:        github.Ptr("write"),
+		},
+	}
+	profiles := map[string]ScopeProfile{
+		"release":    {Scopes: map[string]string{"contents": "write"}},
+		"triage":     {Scopes: map[string]string{"issues": "write", "contents": "read"}, Repositories: []string{"OWNER/REPO"}},
+		"other-repo": {Scopes: map[string]string{"contents": "read"}, Repositories: []string{"owner/other"}},
+		"other-org":  {Scopes: map[string]string{"contents": "read"}, OwnerIDs: []int64{999}},
+	}
+
+	apps := []GitHubApp{
+		{Name: "admin", AppID: "456", When: AppRoute{Scopes: []string{"administration"}}},
+		{Name: "default", AppID: "123"},
+	}
+	installations := map[string]*github.Installation{"default": installation, "admin": nil}
+
+	got := BuildCapabilities(identity, apps, installations, profiles)
+
+	if got.Repository != "owner/repo" {
+		t.Errorf("Repository = %s, want owner/repo", got.Repository)
+	}
+	if got.Apps["default"] != (AppInstallation{Installed: true, InstallationID: 42}) || got.Apps["admin"].Installed {
+		t.Errorf("Apps = %+v, want default installed as 42 and admin not installed", got.Apps)
+	}
+	if len(got.Scopes) != len(AllowedScopes) {
+		t.Errorf("len(Scopes) = %d, want %d (the whole catalog)", len(got.Scopes), len(AllowedScopes))
+	}
+
+	scopeTests := []struct {
+		scope           string
+		wantPermissions []string
+		wantReason      bool
+	}{
+		{scope: "contents", wantPermissions: []string{"read", "write"}},
+		{scope: "issues", wantPermissions: []string{"read"}, wantReason: true},
+		{scope: "workflows", wantPermissions: []string{}, wantReason: true},
+		// Routed to the admin app, which is not installed
+		{scope: "administration", wantPermissions: []string{}, wantReason: true},
+		{scope: "secrets", wantPermissions: []string{}, wantReason: true},
+	}
+	for _, tt := range scopeTests {
+		capability := got.Scopes[tt.scope]
+		if !slices.Equal(capability.Permissions, tt.wantPermissions) {
+			t.Errorf("Scopes[%s].Permissions = %v, want %v", tt.scope, capability.Permissions, tt.wantPermissions)
+		}
+		if (capability.Reason != "") != tt.wantReason {
+			t.Errorf("Scopes[%s].Reason = %q, want reason %v", tt.scope, capability.Reason, tt.wantReason)
+		}
+	}
+	if app := got.Scopes["administration"].App; app != "admin" {
+		t.Errorf("Scopes[administration].App = %s, want admin", app)
+	}
+	if levels := got.Scopes["secrets"].AllowedLevels; len(levels) != 0 {
+		t.Errorf("Scopes[secrets].AllowedLevels = %v, want none (blacklisted)", levels)
+	}
+
+	if len(got.Profiles) != 2 {
+		t.Errorf("Profiles = %v, want release and triage", got.Profiles)
+	}
+	if release := got.Profiles["release"]; !release.Requestable {
+		t.Errorf("Profiles[release] = %+v, want requestable", release)
+	}
+	if triage := got.Profiles["triage"]; triage.Requestable || !slices.Equal(triage.UnavailableScopes, []string{"issues"}) {
+		t.Errorf("Profiles[triage] = %+v, want not requestable with issues unavailable", triage)
+	}
+}
+
+// TestRepositoryCapabilities tests that apps not installed on the repository are reported, and other errors of the installation lookup fail the request.
+func TestRepositoryCapabilities(t *testing.T) {
+	t.Setenv("GITHUB_SCOPE_PROFILES", "")
+	t.Setenv("GITHUB_APPS", "")
+	t.Setenv("GITHUB_APP_ID", "123")
+	config := loadTestConfig(t)
+	mockJWTAppsService(t, nil, &github.Response{Response: &http.Response{StatusCode: http.StatusNotFound}})
+
+	got, err := repositoryCapabilities(context.Background(), config, Identity{Issuer: githubOIDCIssuer, Repository: "owner/repo", OwnerID: 1})
+	if err != nil {
+		t.Fatalf("repositoryCapabilities() unexpected error = %v", err)
+	}
+	if got.Apps["default"].Installed || got.Scopes["contents"].Reason != "GitHub App 'default' is not installed on this repository" {
+		t.Errorf("repositoryCapabilities() = %+v, want the default app reported as not installed", got)
+	}
+
+	mockJWTAppsService(t, nil, &github.Response{Response: &http.Response{StatusCode: http.StatusBadGateway}})
+	useFastRetryPolicy(t)
+	if _, err := repositoryCapabilities(context.Background(), config, Identity{Issuer: githubOIDCIssuer, Repository: "owner/repo", OwnerID: 1}); err == nil || err.Code != CodeGitHubUnavailable {
+		t.Errorf("repositoryCapabilities() error = %v, want code %s", err, CodeGitHubUnavailable)
+	}
+}
+
+// TestCapabilitiesHandler_RequiresOIDCToken tests that GET /capabilities requires the OIDC token.
+func TestCapabilitiesHandler_RequiresOIDCToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		wantStatus int
+	}{
+		{name: "missing token", method: http.MethodGet, wantStatus: http.StatusUnauthorized},
+		{name: "wrong method", method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			NewTokenHandler(&Config{})(w, httptest.NewRequest(tt.method, "/capabilities", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// configVariables are the environment variables a configuration file may set.
+var configVariables = []string{
+	"GOOGLE_CLOUD_PROJECT",
+	"GITHUB_APP_ID",
+	"GITHUB_APP_CLIENT_ID",
+	"GITHUB_APP_PRIVATE_KEY_SOURCE",
+	"GITHUB_APP_PRIVATE_KEY_CACHE_TTL",
+	"GITHUB_APPS",
+	"GITHUB_ALLOWED_OWNER_IDS",
+	"GITHUB_ADMIN_REPOSITORY_IDS",
+	"GITHUB_SCOPE_PROFILES",
+	"GITHUB_MAX_TOKEN_TTL",
+	"GITHUB_TOKEN_REUSE_MIN_VALIDITY",
+	"GITHUB_INSTALLATION_CACHE_TTL",
+	"GITHUB_REVOKE_ON_RUN_COMPLETION",
+	"GITHUB_WEBHOOK_ENABLED",
+	"DEBUG_STAGE_TIMINGS",
+	"SELF_CHECK_INTERVAL",
+	"AUDIT_LOG",
+	"METRICS_PROMETHEUS",
+	"METRICS_PROMETHEUS_PORT",
+	"METRICS_OTLP_ENDPOINT",
+	"METRICS_OTLP_INTERVAL",
+	"RETRY_MAX_ATTEMPTS",
+	"RETRY_BASE_DELAY",
+	"RETRY_MAX_DELAY",
+	"RETRY_BUDGET",
+	"CIRCUIT_BREAKER_FAILURE_RATIO",
+	"CIRCUIT_BREAKER_MIN_REQUESTS",
+	"CIRCUIT_BREAKER_WINDOW",
+	"CIRCUIT_BREAKER_OPEN_DURATION",
+	"SERVER_MODE",
+	"PORT",
+	"SERVER_READ_HEADER_TIMEOUT",
+	"SERVER_READ_TIMEOUT",
+	"SERVER_WRITE_TIMEOUT",
+	"SERVER_IDLE_TIMEOUT",
+	"SERVER_SHUTDOWN_TIMEOUT",
+	"SERVER_MAX_HEADER_BYTES",
+	"SERVER_MAX_BODY_BYTES",
+	"SERVER_TLS_CERT_FILE",
+	"SERVER_TLS_KEY_FILE",
+	"SERVER_TLS_CLIENT_CA_FILE",
+	"VAULT_ADDR",
+	"VAULT_NAMESPACE",
+	"VAULT_TOKEN",
+	"VAULT_TOKEN_FILE",
+}
+
+// Config is the service configuration. It is loaded and validated once at startup and passed to
+// the handlers, so a misconfigured service fails to start instead of failing requests.
+type Config struct {
+	// ProjectID is the GCP project of the Secret Manager secrets (GOOGLE_CLOUD_PROJECT).
+	ProjectID string
+	// Vault is the Vault server of the vault:// private key sources (the VAULT_* settings).
+	Vault VaultSettings
+	// Apps are the GitHub Apps in routing order (GITHUB_APPS, or GITHUB_APP_ID and related variables).
+	Apps []GitHubApp
+	// AllowedOwnerIDs are the repository owner account IDs allowed to request tokens (empty allows all).
+	AllowedOwnerIDs []int64
+	// AdminRepositories are the repositories allowed to call GET /keys and read the detailed
+	// GET /readyz report (GITHUB_ADMIN_REPOSITORY_IDS, empty disables them).
+	AdminRepositories []AdminRepository
+	// ScopeProfiles are the named scope profiles (GITHUB_SCOPE_PROFILES).
+	ScopeProfiles map[string]ScopeProfile
+	// MaxTokenTTL is the maximum token lifetime (0 for GitHub's default).
+	MaxTokenTTL time.Duration
+	// TokenReuseMinValidity is the lifetime a reused token must have left (0 disables token reuse).
+	TokenReuseMinValidity time.Duration
+	// RunRevocation binds tokens to their workflow run and enables the webhook.
+	RunRevocation bool
+	// Webhook enables POST /webhook (GITHUB_WEBHOOK_ENABLED or GITHUB_REVOKE_ON_RUN_COMPLETION).
+	Webhook bool
+	// StageTimings reports the stage durations of POST /token in a Server-Timing header.
+	StageTimings         bool
+	PrivateKeyCacheTTL   time.Duration
+	InstallationCacheTTL time.Duration
+	// SelfCheckInterval is how often the GitHub Apps are checked after startup (0 only checks on startup).
+	SelfCheckInterval time.Duration
+	RetryPolicy       RetryPolicy
+	BreakerSettings   BreakerSettings
+	// AuditLog records every POST /token decision (AUDIT_LOG); nil if disabled.
+	AuditLog *AuditLog
+	// Metrics controls the metrics exporters (METRICS_PROMETHEUS, METRICS_PROMETHEUS_PORT, and the METRICS_OTLP_* settings).
+	Metrics MetricsSettings
+	// Server controls the HTTP server (SERVER_MODE, PORT, and the SERVER_* settings).
+	Server ServerSettings
+}
+
+// LoadConfig loads the configuration from the environment variables and the optional JSON file
+// named by CONFIG_FILE, and validates it. The file is an object of environment variable names to
+// values, for example:
+//
+//	{"GITHUB_APP_ID": "123", "GITHUB_ALLOWED_OWNER_IDS": "1001,1002", "GITHUB_REVOKE_ON_RUN_COMPLETION": true,
+//	 "GITHUB_SCOPE_PROFILES": {"release": {"scopes": {"contents": "write"}}}}
+//
+// String values are used as is; other values (such as GITHUB_APPS and GITHUB_SCOPE_PROFILES) as
+// their JSON encoding. Environment variables take precedence over the file.
+//
+// All invalid settings are reported together, one per line.
+func LoadConfig() (*Config, error) {
+	if path := strings.TrimSpace(os.Getenv("CONFIG_FILE")); path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		configFileValues = values
+		defer func() { configFileValues = nil }()
+	}
+
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	config := &Config{
+		ProjectID: strings.TrimSpace(configValue("GOOGLE_CLOUD_PROJECT")),
+		Vault:     ParseVaultSettings(),
+	}
+	var err error
+	config.Apps, err = ParseGitHubApps(KeySourceSettings{ProjectID: config.ProjectID, Vault: config.Vault})
+	check(err)
+	config.AllowedOwnerIDs, err = ParseAllowedOwnerIDs()
+	check(err)
+	config.AdminRepositories, err = ParseAdminRepositories()
+	check(err)
+	config.ScopeProfiles, err = ParseScopeProfiles()
+	check(err)
+	config.MaxTokenTTL, err = ParseMaxTokenTTL()
+	check(err)
+	config.TokenReuseMinValidity, err = ParseTokenReuseMinValidity()
+	check(err)
+	config.RunRevocation, err = ParseRunRevocationEnabled()
+	check(err)
+	config.Webhook, err = ParseWebhookEnabled()
+	check(err)
+	config.Webhook = config.Webhook || config.RunRevocation
+	config.StageTimings, err = ParseStageTimingsEnabled()
+	check(err)
+	config.PrivateKeyCacheTTL, err = ParsePrivateKeyCacheTTL()
+	check(err)
+	config.InstallationCacheTTL, err = ParseInstallationCacheTTL()
+	check(err)
+	config.SelfCheckInterval, err = ParseSelfCheckInterval()
+	check(err)
+	config.RetryPolicy, err = ParseRetryPolicy()
+	check(err)
+	config.BreakerSettings, err = ParseBreakerSettings()
+	check(err)
+	config.Server, err = ParseServerSettings()
+	check(err)
+	config.Metrics, err = ParseMetricsSettings()
+	check(err)
+	auditSink, err := ParseAuditSink()
+	check(err)
+
+	if config.Metrics.Prometheus && config.Metrics.PrometheusPort == config.Server.Port {
+		check(fmt.Errorf("METRICS_PROMETHEUS_PORT %s must differ from PORT: the scrape endpoint isn't served with the service", config.Metrics.PrometheusPort))
+	}
+
+	// Vault secrets are read with the VAULT_* settings, which are otherwise only checked on the first key load
+	for _, app := range config.Apps {
+		if signer, ok := app.signer.(*privateKeySigner); ok {
+			if _, ok := signer.source.(*vaultKeySource); ok {
+				if config.Vault.Address == "" {
+					check(fmt.Errorf("VAULT_ADDR must be set: the private key of GitHub App '%s' is read from Vault", app.Name))
+				}
+				if config.Vault.Token == "" && config.Vault.TokenFile == "" {
+					check(fmt.Errorf("VAULT_TOKEN or VAULT_TOKEN_FILE must be set: the private key of GitHub App '%s' is read from Vault", app.Name))
+				}
+			}
+		}
+	}
+
+	// Secret Manager secrets are read from the GCP project
+	if config.ProjectID == "" {
+		for _, app := range config.Apps {
+			if signer, ok := app.signer.(*privateKeySigner); ok {
+				if _, ok := signer.source.(*secretManagerKeySource); ok {
+					check(fmt.Errorf("GOOGLE_CLOUD_PROJECT must be set: the private key of GitHub App '%s' is read from Secret Manager", app.Name))
+				}
+			}
+		}
+		if config.Webhook {
+			check(fmt.Errorf("GOOGLE_CLOUD_PROJECT must be set: GITHUB_WEBHOOK_ENABLED and GITHUB_REVOKE_ON_RUN_COMPLETION read the webhook secret from Secret Manager"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	config.AuditLog = NewAuditLog(auditSink)
+	return config, nil
+}
+
+// configFileValues are the settings of the CONFIG_FILE being loaded, nil outside of LoadConfig.
+var configFileValues map[string]string
+
+// configValue returns a setting from its environment variable, or else from the CONFIG_FILE being
+// loaded. Settings are only read while loading the configuration, so the file never has to be
+// applied to the process environment.
+func configValue(name string) string {
+	if value := os.Getenv(name); strings.TrimSpace(value) != "" {
+		return value
+	}
+	return configFileValues[name]
+}
+
+// readConfigFile reads the settings of the configuration file.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONFIG_FILE: %w", err)
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid CONFIG_FILE %s: %w", path, err)
+	}
+
+	settings := make(map[string]string, len(values))
+	for name, raw := range values {
+		if !slices.Contains(configVariables, name) {
+			return nil, fmt.Errorf("invalid CONFIG_FILE %s: unknown setting %s", path, name)
+		}
+		value := string(raw)
+		var text string
+		if json.Unmarshal(raw, &text) == nil {
+			value = text
+		}
+		settings[name] = value
+	}
+	return settings, nil
+}
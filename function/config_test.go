@@ -0,0 +1,220 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestConfig loads the configuration from the test's environment variables, in the test project.
+func loadTestConfig(t *testing.T) *Config {
+	t.Helper()
+	if os.Getenv("GOOGLE_CLOUD_PROJECT") == "" {
+		t.Setenv("GOOGLE_CLOUD_PROJECT", "test-project")
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() unexpected error = %v", err)
+	}
+	return config
+}
+
+// clearConfigEnv unsets all configuration environment variables for the test.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range append(configVariables, "CONFIG_FILE") {
+		t.Setenv(name, "")
+	}
+}
+
+// TestLoadConfig tests loading and validating the configuration from environment variables.
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		errContains []string
+	}{
+		{
+			name: "minimal",
+			env:  map[string]string{"GOOGLE_CLOUD_PROJECT": "project", "GITHUB_APP_ID": "123"},
+		},
+		{
+			name: "private key without Secret Manager",
+			env:  map[string]string{"GITHUB_APP_ID": "123", "GITHUB_APP_PRIVATE_KEY_SOURCE": "file:///var/secrets/key.pem"},
+		},
+		{
+			name:        "missing app",
+			env:         map[string]string{"GOOGLE_CLOUD_PROJECT": "project"},
+			errContains: []string{"GITHUB_APP_ID or GITHUB_APPS must be set"},
+		},
+		{
+			name:        "missing project for Secret Manager key",
+			env:         map[string]string{"GITHUB_APP_ID": "123"},
+			errContains: []string{"GOOGLE_CLOUD_PROJECT must be set: the private key of GitHub App 'default'"},
+		},
+		{
+			name:        "missing project for webhook secret",
+			env:         map[string]string{"GITHUB_APP_ID": "123", "GITHUB_APP_PRIVATE_KEY_SOURCE": "env://KEY", "GITHUB_REVOKE_ON_RUN_COMPLETION": "true"},
+			errContains: []string{"GOOGLE_CLOUD_PROJECT must be set: GITHUB_WEBHOOK_ENABLED and GITHUB_REVOKE_ON_RUN_COMPLETION"},
+		},
+		{
+			name:        "missing project for webhook",
+			env:         map[string]string{"GITHUB_APP_ID": "123", "GITHUB_APP_PRIVATE_KEY_SOURCE": "env://KEY", "GITHUB_WEBHOOK_ENABLED": "true"},
+			errContains: []string{"GOOGLE_CLOUD_PROJECT must be set: GITHUB_WEBHOOK_ENABLED"},
+		},
+		{
+			name: "private key from Vault",
+			env: map[string]string{"GITHUB_APP_ID": "123", "GITHUB_APP_PRIVATE_KEY_SOURCE": "vault://secret/github-app",
+				"VAULT_ADDR": "https://vault.example.com", "VAULT_TOKEN_FILE": "/var/run/vault/token"},
+		},
+		{
+			name: "missing Vault settings",
+			env:  map[string]string{"GITHUB_APP_ID": "123", "GITHUB_APP_PRIVATE_KEY_SOURCE": "vault://secret/github-app"},
+			errContains: []string{
+				"VAULT_ADDR must be set: the private key of GitHub App 'default' is read from Vault",
+				"VAULT_TOKEN or VAULT_TOKEN_FILE must be set",
+			},
+		},
+		{
+			name: "missing Vault settings of each app",
+			env: map[string]string{"GITHUB_APPS": `[{"name": "admin", "app_id": "456", "private_key": "vault://secret/admin-app", "when": {"owner_ids": [1]}},
+				{"name": "default", "app_id": "123", "private_key": "vault://secret/github-app"}]`},
+			errContains: []string{
+				"VAULT_ADDR must be set: the private key of GitHub App 'admin' is read from Vault",
+				"VAULT_ADDR must be set: the private key of GitHub App 'default' is read from Vault",
+			},
+		},
+		{
+			name:        "metrics on the service port",
+			env:         map[string]string{"GOOGLE_CLOUD_PROJECT": "project", "GITHUB_APP_ID": "123", "METRICS_PROMETHEUS": "true", "METRICS_PROMETHEUS_PORT": "8080"},
+			errContains: []string{"METRICS_PROMETHEUS_PORT 8080 must differ from PORT"},
+		},
+		{
+			name: "all errors reported",
+			env: map[string]string{
+				"GOOGLE_CLOUD_PROJECT":     "project",
+				"GITHUB_APP_ID":            "123",
+				"GITHUB_ALLOWED_OWNER_IDS": "1,abc",
+				"GITHUB_MAX_TOKEN_TTL":     "3h",
+				"RETRY_MAX_ATTEMPTS":       "0",
+			},
+			errContains: []string{"GITHUB_ALLOWED_OWNER_IDS", "GITHUB_MAX_TOKEN_TTL", "RETRY_MAX_ATTEMPTS"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			config, err := LoadConfig()
+			if len(tt.errContains) > 0 {
+				if err == nil {
+					t.Fatalf("LoadConfig() error = nil, want containing %q", tt.errContains)
+				}
+				for _, want := range tt.errContains {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("LoadConfig() error = %v, want containing %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() unexpected error = %v", err)
+			}
+			if len(config.Apps) != 1 || config.Apps[0].AppID != "123" {
+				t.Errorf("LoadConfig() apps = %+v, want the default app", config.Apps)
+			}
+		})
+	}
+}
+
+// TestLoadConfig_File tests loading settings from CONFIG_FILE.
+//
+// Test steps:
+//  1. Write a config file with string, boolean, and JSON object settings
+//  2. Load the config with one of the settings also set as environment variable
+//  3. Verify the file settings are applied and the environment variable takes precedence
+//  4. Verify the file settings are not applied to the process environment
+func TestLoadConfig_File(t *testing.T) {
+	clearConfigEnv(t)
+
+	// Step 1: Config file
+	path := filepath.Join(t.TempDir(), "config.json")
+	file := `{
+		"GOOGLE_CLOUD_PROJECT": "project",
+		"GITHUB_APP_ID": "123",
+		"GITHUB_MAX_TOKEN_TTL": "30m",
+		"GITHUB_REVOKE_ON_RUN_COMPLETION": true,
+		"GITHUB_SCOPE_PROFILES": {"release": {"scopes": {"contents": "write"}}},
+		"VAULT_ADDR": "https://vault.example.com/",
+		"VAULT_TOKEN": "file-token"
+	}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+
+	// Step 2: Load with GITHUB_MAX_TOKEN_TTL overridden
+	t.Setenv("GITHUB_MAX_TOKEN_TTL", "15m")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() unexpected error = %v", err)
+	}
+
+	// Step 3: Verify the settings
+	if config.ProjectID != "project" || config.Apps[0].AppID != "123" || !config.RunRevocation {
+		t.Errorf("LoadConfig() = %+v, want the file settings", config)
+	}
+	if _, ok := config.ScopeProfiles["release"]; !ok {
+		t.Errorf("LoadConfig() profiles = %v, want release", config.ScopeProfiles)
+	}
+	if config.MaxTokenTTL != 15*time.Minute {
+		t.Errorf("LoadConfig() max token TTL = %s, want the environment variable's 15m", config.MaxTokenTTL)
+	}
+	if want := (VaultSettings{Address: "https://vault.example.com", Token: "file-token"}); config.Vault != want {
+		t.Errorf("LoadConfig() Vault = %+v, want %+v", config.Vault, want)
+	}
+
+	// Step 4: Verify the environment is unchanged
+	for _, name := range []string{"GOOGLE_CLOUD_PROJECT", "GITHUB_APP_ID", "VAULT_TOKEN"} {
+		if value := os.Getenv(name); value != "" {
+			t.Errorf("LoadConfig() set %s = %q in the environment", name, value)
+		}
+	}
+}
+
+// TestLoadConfig_InvalidFile tests that unreadable, malformed, and unknown settings files are rejected.
+func TestLoadConfig_InvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name        string
+		content     string
+		errContains string
+	}{
+		{name: "missing file", errContains: "failed to read CONFIG_FILE"},
+		{name: "malformed", content: `{"GITHUB_APP_ID": `, errContains: "invalid CONFIG_FILE"},
+		{name: "unknown setting", content: `{"GITHUB_APP": "123"}`, errContains: "unknown setting GITHUB_APP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".json")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("CONFIG_FILE", path)
+
+			if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("LoadConfig() error = %v, want containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// dryRunParam is the query parameter that makes POST /token explain its decision instead of issuing a token.
+const dryRunParam = "dry_run"
+
+// Checks of the POST /token pipeline, as reported in the decision trace of a dry run.
+const (
+	checkOIDCToken               = "oidc_token"
+	checkOwnerAllowlist          = "owner_allowlist"
+	checkRequestParameters       = "request_parameters"
+	checkScopeProfile            = "scope_profile"
+	checkScopePolicy             = "scope_policy"
+	checkTokenLifetime           = "token_lifetime"
+	checkAppRouting              = "app_routing"
+	checkGitHubApp               = "github_app"
+	checkInstallation            = "installation"
+	checkInstallationPermissions = "installation_permissions"
+	checkCreateInstallationToken = "create_installation_token"
+)
+
+// Results of a check in the decision trace.
+const (
+	checkPassed  = "passed"
+	checkFailed  = "failed"
+	checkSkipped = "skipped"
+)
+
+// TraceStep is the result of one check of the decision trace.
+type TraceStep struct {
+	Check  string `json:"check"`
+	Result string `json:"result"`
+	Detail string `json:"detail"`
+	// Code and Details are the error code and details a failed check responds with without a dry run.
+	Code    ErrorCode              `json:"code,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// DecisionTrace records the checks of a token request in order. Its methods do nothing on a nil
+// trace, so the pipeline records its checks unconditionally and only dry runs pay for them.
+type DecisionTrace struct {
+	Steps []TraceStep
+}
+
+// Pass records a passed check.
+func (t *DecisionTrace) Pass(check, format string, args ...interface{}) {
+	if t != nil {
+		t.Steps = append(t.Steps, TraceStep{Check: check, Result: checkPassed, Detail: fmt.Sprintf(format, args...)})
+	}
+}
+
+// Skip records a check that didn't apply to the request.
+func (t *DecisionTrace) Skip(check, format string, args ...interface{}) {
+	if t != nil {
+		t.Steps = append(t.Steps, TraceStep{Check: check, Result: checkSkipped, Detail: fmt.Sprintf(format, args...)})
+	}
+}
+
+// Fail records a failed check and returns its error.
+func (t *DecisionTrace) Fail(check string, err *Error) *Error {
+	if t != nil {
+		t.Steps = append(t.Steps, TraceStep{Check: check, Result: checkFailed, Detail: err.Detail, Code: err.Code, Details: err.Details})
+	}
+	return err
+}
+
+// DryRunResponse is the response of a POST /token dry run.
+type DryRunResponse struct {
+	DryRun bool `json:"dry_run"`
+	// Allowed reports whether all checks passed, i.e. a token would be requested from GitHub.
+	Allowed    bool   `json:"allowed"`
+	Repository string `json:"repository"`
+	// Scopes are the scopes the token would be requested with.
+	Scopes        map[string]string `json:"scopes,omitempty"`
+	Profile       string            `json:"profile,omitempty"`
+	DroppedScopes []DroppedScope    `json:"dropped_scopes,omitempty"`
+	Trace         []TraceStep       `json:"trace"`
+}
+
+// ParseDryRun parses the dry_run query parameter.
+func ParseDryRun(query url.Values) (bool, *Error) {
+	values := query[dryRunParam]
+	if len(values) == 0 {
+		return false, nil
+	}
+	if len(values) > 1 {
+		return false, NewError(CodeDuplicateParameter, "duplicate parameter '%s' in request", dryRunParam)
+	}
+	dryRun, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return false, NewError(CodeInvalidRequest, "invalid %s value '%s' (must be 'true' or 'false')", dryRunParam, values[0])
+	}
+	return dryRun, nil
+}
+
+// explainTokenRequest runs the POST /token pipeline for an authenticated caller up to, but not including,
+// the creation of the installation token, and returns the decision trace. Instead of letting GitHub
+// reject the token request, the requested scopes are compared with the installation's permissions.
+//
+// A failed check is reported in the trace with a 200 response. Failures that don't decide the request
+// (rate limits, unavailable dependencies, and internal errors) are returned as errors,
+// as without a dry run.
+func explainTokenRequest(ctx context.Context, config *Config, query url.Values, identity Identity) (DryRunResponse, *Error) {
+	trace := &DecisionTrace{}
+	trace.Pass(checkOIDCToken, "valid OIDC token for repository %s", identity.Repository)
+
+	response := DryRunResponse{DryRun: true, Repository: identity.Repository}
+	req, err := parseTokenRequest(config, query, identity, trace)
+	if err == nil {
+		response.Profile = req.profile
+		response.Scopes, response.DroppedScopes, err = explainInstallation(ctx, req, trace)
+	}
+	if err != nil {
+		if status := err.Status(); status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+			return DryRunResponse{}, err
+		}
+	} else {
+		response.Allowed = true
+		trace.Skip(checkCreateInstallationToken, "dry run: no token is created")
+	}
+
+	response.Trace = trace.Steps
+	return response, nil
+}
+
+// explainInstallation looks up the GitHub App installation of the repository and compares the requested
+// scopes with its permissions. It returns the scopes the token would be requested with and all dropped
+// optional scopes. GitHub may still grant fewer scopes because of repository-level restrictions.
+func explainInstallation(ctx context.Context, req tokenRequest, trace *DecisionTrace) (map[string]string, []DroppedScope, *Error) {
+	apps, err := newJWTAppsService(ctx, req.app)
+	if err != nil {
+		return nil, nil, trace.Fail(checkGitHubApp, AsError(err, CodeInternalError, "%w"))
+	}
+	trace.Pass(checkGitHubApp, "authenticated as GitHub App '%s'", req.app.Name)
+
+	installation, err := GetInstallation(ctx, apps, req.app.BaseURL, req.identity.Repository)
+	if err != nil {
+		return nil, nil, trace.Fail(checkInstallation, AsError(err, CodeGitHubUnavailable, "GitHub API error: %w"))
+	}
+	trace.Pass(checkInstallation, "GitHub App installation %d has access to repository %s", installation.GetID(), req.identity.Repository)
+
+	scopes, dropped := req.scopes, req.dropped
+	if len(req.optional) > 0 {
+		var installationDropped []DroppedScope
+		scopes, installationDropped = FilterScopesByInstallation(scopes, req.optional, installation.GetPermissions())
+		dropped = append(dropped, installationDropped...)
+		if len(scopes) == 0 {
+			return nil, nil, trace.Fail(checkInstallationPermissions, NewError(CodeNoGrantableScopes, "none of the requested scopes can be granted").
+				WithDetails(map[string]interface{}{"dropped_scopes": dropped}))
+		}
+	}
+
+	// GitHub rejects the token request if a required scope exceeds the installation's permissions
+	installationScopes := InstallationScopes(installation.GetPermissions())
+	var missing []string
+	for _, scopeID := range sortedScopeIDs(scopes) {
+		if granted, exists := installationScopes[scopeID]; !exists || !PermissionCovers(granted, scopes[scopeID]) {
+			missing = append(missing, scopeID)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, trace.Fail(checkInstallationPermissions, NewError(CodeInsufficientPermissions, "GitHub App installation lacks permissions for scopes %v", missing).
+			WithDetails(map[string]interface{}{"missing_scopes": missing}))
+	}
+	trace.Pass(checkInstallationPermissions, "GitHub App installation has the permissions of all %d scopes", len(scopes))
+
+	return scopes, dropped, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// mockJWTAppsService replaces newJWTAppsService for the duration of the test with a client whose
+// GetRepositoryInstallation returns the given installation and response. Creating tokens fails the test.
+func mockJWTAppsService(t *testing.T, installation *github.Installation, resp *github.Response) {
+	t.Helper()
+	original := newJWTAppsService
+	t.Cleanup(func() { newJWTAppsService = original })
+	newJWTAppsService = func(ctx context.Context, app GitHubApp) (GitHubAppsService, error) {
+		return &mockAppsService{
+			findRepoInstallation: func(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error) {
+				if resp != nil {
+					return nil, resp, &github.ErrorResponse{Response: resp.Response}
+				}
+				return installation, &github.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+			},
+			createInstallationToken: func(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error) {
+				t.Error("CreateInstallationToken() called during a dry run")
+				return nil, nil, nil
+			},
+		}, nil
+	}
+}
+
+// TestParseDryRun tests parsing of the dry_run query parameter.
+func TestParseDryRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		want     bool
+		wantCode ErrorCode
+	}{
+		{name: "absent", query: "contents=read", want: false},
+		{name: "true", query: "dry_run=true", want: true},
+		{name: "one", query: "dry_run=1", want: true},
+		{name: "false", query: "dry_run=false", want: false},
+		{name: "invalid", query: "dry_run=maybe", wantCode: CodeInvalidRequest},
+		{name: "duplicate", query: "dry_run=true&dry_run=true", wantCode: CodeDuplicateParameter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			got, err := ParseDryRun(query)
+			if tt.wantCode != "" {
+				if err == nil || err.Code != tt.wantCode {
+					t.Fatalf("ParseDryRun() error = %v, want code %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDryRun() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDryRun() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExplainTokenRequest tests the decision trace of dry runs.
+//
+// Test steps:
+//  1. Configure the owner allowlist and scope profiles, and mock the GitHub App installation
+//  2. Call explainTokenRequest with the query of the request
+//  3. Verify the decision, the scopes the token would be requested with, and the result of each check
+func TestExplainTokenRequest(t *testing.T) {
+	identity := Identity{Issuer: githubOIDCIssuer, Repository: "owner/repo", OwnerID: 12345, RunID: 1, RunAttempt: 1}
+	installation := &github.Installation{
+		ID: github.Ptr(int64(42)),
+		Permissions: &github.InstallationPermissions{
+			Contents: github.Ptr("write"),
+			Issues:   github.Ptr("read"),
+		},
+	}
+
+	tests := []struct {
+		name         string
+		query        string
+		ownerIDs     string
+		apps         string
+		resp         *github.Response
+		wantAllowed  bool
+		wantScopes   map[string]string
+		wantDropped  []string
+		wantChecks   []string
+		wantFailCode ErrorCode
+	}{
+		{
+			name:        "allowed",
+			query:       "contents=read&issues=read",
+			wantAllowed: true,
+			wantScopes:  map[string]string{"contents": "read", "issues": "read"},
+			wantChecks: []string{
+				"oidc_token:passed", "owner_allowlist:passed", "request_parameters:passed", "scope_profile:skipped",
+				"scope_policy:passed", "token_lifetime:passed", "app_routing:passed", "github_app:passed", "installation:passed",
+				"installation_permissions:passed", "create_installation_token:skipped",
+			},
+		},
+		{
+			name:        "allowed with profile",
+			query:       "profile=release",
+			wantAllowed: true,
+			wantScopes:  map[string]string{"contents": "write"},
+			wantChecks: []string{
+				"oidc_token:passed", "owner_allowlist:passed", "request_parameters:passed", "scope_profile:passed",
+				"scope_policy:passed", "token_lifetime:passed", "app_routing:passed", "github_app:passed", "installation:passed",
+				"installation_permissions:passed", "create_installation_token:skipped",
+			},
+		},
+		{
+			name:        "optional scope dropped by installation",
+			query:       "contents=read&pull_requests=write&optional=pull_requests",
+			wantAllowed: true,
+			wantScopes:  map[string]string{"contents": "read"},
+			wantDropped: []string{"pull_requests"},
+			wantChecks: []string{
+				"oidc_token:passed", "owner_allowlist:passed", "request_parameters:passed", "scope_profile:skipped",
+				"scope_policy:passed", "token_lifetime:passed", "app_routing:passed", "github_app:passed", "installation:passed",
+				"installation_permissions:passed", "create_installation_token:skipped",
+			},
+		},
+		{
+			name:         "owner not allowed",
+			query:        "contents=read",
+			ownerIDs:     "999",
+			wantChecks:   []string{"oidc_token:passed", "owner_allowlist:failed"},
+			wantFailCode: CodeOwnerNotAllowed,
+		},
+		{
+			name:         "profile not allowed",
+			query:        "profile=restricted",
+			wantChecks:   []string{"oidc_token:passed", "owner_allowlist:passed", "request_parameters:passed", "scope_profile:failed"},
+			wantFailCode: CodeProfileNotAllowed,
+		},
+		{
+			name:  "read-only scope requested with write",
+			query: "contents=read&administration=write",
+			wantChecks: []string{
+				"oidc_token:passed", "owner_allowlist:passed", "request_parameters:passed", "scope_profile:skipped", "scope_policy:failed",
+			},
+			wantFailCode: CodePermissionNotAllowed,
+		},
+		{
+			name:  "no GitHub App for the scopes",
+			query: "contents=read",
+			apps:  `[{"name": "admin", "app_id": "456", "when": {"scopes": ["administration"]}}]`,
+			wantChecks: []string{
+				"oidc_token:passed", "owner_allowlist:passed", "request_parameters:passed", "scope_profile:skipped",
+				"scope_policy:passed", "token_lifetime:passed", "app_routing:failed",
+			},
+			wantFailCode: CodeNoMatchingApp,
+		},
+		{
+			name:  "app not installed",
+			query: "contents=read",
+			resp:  &github.Response{Response: &http.Response{StatusCode: http.StatusNotFound}},
+			wantChecks: []string{
+				"oidc_token:passed", "owner_allowlist:passed", "request_parameters:passed", "scope_profile:skipped",
+				"scope_policy:passed", "token_lifetime:passed", "app_routing:passed", "github_app:passed", "installation:failed",
+			},
+			wantFailCode: CodeAppNotInstalled,
+		},
+		{
+			name:  "installation lacks permission",
+			query: "contents=read&issues=write",
+			wantChecks: []string{
+				"oidc_token:passed", "owner_allowlist:passed", "request_parameters:passed", "scope_profile:skipped",
+				"scope_policy:passed", "token_lifetime:passed", "app_routing:passed", "github_app:passed", "installation:passed",
+				"installation_permissions:failed",
+			},
+			wantFailCode: CodeInsufficientPermissions,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Configure policy and mock the installation
+			t.Setenv("GITHUB_ALLOWED_OWNER_IDS", tt.ownerIDs)
+			t.Setenv("GITHUB_SCOPE_PROFILES", `{"release": {"scopes": {"contents": "write"}}, "restricted": {"scopes": {"contents": "write"}, "repositories": ["owner/other"]}}`)
+			t.Setenv("GITHUB_MAX_TOKEN_TTL", "")
+			t.Setenv("GITHUB_REVOKE_ON_RUN_COMPLETION", "")
+			t.Setenv("GITHUB_APPS", tt.apps)
+			t.Setenv("GITHUB_APP_ID", "123")
+			mockJWTAppsService(t, installation, tt.resp)
+
+			// Step 2: Explain the request
+			query, _ := url.ParseQuery(tt.query)
+			got, err := explainTokenRequest(context.Background(), loadTestConfig(t), query, identity)
+			if err != nil {
+				t.Fatalf("explainTokenRequest() unexpected error = %v", err)
+			}
+
+			// Step 3: Verify the decision and the trace
+			if !got.DryRun || got.Allowed != tt.wantAllowed {
+				t.Errorf("DryRun = %v, Allowed = %v, want true, %v", got.DryRun, got.Allowed, tt.wantAllowed)
+			}
+			if len(got.Scopes) != len(tt.wantScopes) {
+				t.Errorf("Scopes = %v, want %v", got.Scopes, tt.wantScopes)
+			}
+			for scopeID, permission := range tt.wantScopes {
+				if got.Scopes[scopeID] != permission {
+					t.Errorf("Scopes = %v, want %v", got.Scopes, tt.wantScopes)
+				}
+			}
+			var dropped []string
+			for _, scope := range got.DroppedScopes {
+				dropped = append(dropped, scope.Scope)
+			}
+			if !slices.Equal(dropped, tt.wantDropped) {
+				t.Errorf("DroppedScopes = %v, want %v", dropped, tt.wantDropped)
+			}
+
+			var checks []string
+			for _, step := range got.Trace {
+				checks = append(checks, step.Check+":"+step.Result)
+				if step.Result == checkFailed && step.Code != tt.wantFailCode {
+					t.Errorf("failed check %s code = %s, want %s", step.Check, step.Code, tt.wantFailCode)
+				}
+			}
+			if !slices.Equal(checks, tt.wantChecks) {
+				t.Errorf("trace = %v, want %v", checks, tt.wantChecks)
+			}
+		})
+	}
+}
+
+// TestExplainTokenRequest_UndecidedErrors tests that errors which don't decide the request are returned
+// as error responses instead of a failed check.
+func TestExplainTokenRequest_UndecidedErrors(t *testing.T) {
+	useFastRetryPolicy(t)
+	t.Setenv("GITHUB_ALLOWED_OWNER_IDS", "")
+	t.Setenv("GITHUB_SCOPE_PROFILES", "")
+	t.Setenv("GITHUB_APPS", "")
+	t.Setenv("GITHUB_APP_ID", "123")
+	mockJWTAppsService(t, nil, &github.Response{Response: &http.Response{StatusCode: http.StatusBadGateway}})
+
+	query, _ := url.ParseQuery("contents=read")
+	_, err := explainTokenRequest(context.Background(), loadTestConfig(t), query, Identity{Issuer: githubOIDCIssuer, Repository: "owner/repo", OwnerID: 1})
+	if err == nil || err.Code != CodeGitHubUnavailable {
+		t.Errorf("explainTokenRequest() error = %v, want code %s", err, CodeGitHubUnavailable)
+	}
+}
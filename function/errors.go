@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorCode is the stable, machine-readable code of an error response.
+// Codes are part of the API: clients branch on them, so they must not change.
+type ErrorCode string
+
+// Error codes of the error responses.
+const (
+	CodeNotFound                    ErrorCode = "not_found"
+	CodeMethodNotAllowed            ErrorCode = "method_not_allowed"
+	CodeInvalidRequest              ErrorCode = "invalid_request"
+	CodeDuplicateParameter          ErrorCode = "duplicate_parameter"
+	CodeDuplicateScope              ErrorCode = "duplicate_scope"
+	CodeInvalidPermission           ErrorCode = "invalid_permission"
+	CodeNoScopes                    ErrorCode = "no_scopes"
+	CodeInvalidOptionalScopes       ErrorCode = "invalid_optional_scopes"
+	CodeInvalidTTL                  ErrorCode = "invalid_ttl"
+	CodeScopeNotAllowed             ErrorCode = "scope_not_allowed"
+	CodeScopeNotInAllowlist         ErrorCode = "scope_not_in_allowlist"
+	CodePermissionNotAllowed        ErrorCode = "permission_not_allowed"
+	CodeUnknownProfile              ErrorCode = "unknown_profile"
+	CodeProfileNotAllowed           ErrorCode = "profile_not_allowed"
+	CodeMissingAuthorization        ErrorCode = "missing_authorization"
+	CodeInvalidOIDCToken            ErrorCode = "invalid_oidc_token"
+	CodeOwnerNotAllowed             ErrorCode = "owner_not_allowed"
+	CodeAdminNotAllowed             ErrorCode = "admin_not_allowed"
+	CodeNoMatchingApp               ErrorCode = "no_matching_app"
+	CodeAppNotInstalled             ErrorCode = "app_not_installed"
+	CodeInsufficientPermissions     ErrorCode = "insufficient_permissions"
+	CodeInstallationSuspended       ErrorCode = "installation_suspended"
+	CodeScopesNotGranted            ErrorCode = "scopes_not_granted"
+	CodeNoGrantableScopes           ErrorCode = "no_grantable_scopes"
+	CodeTokenInvalid                ErrorCode = "token_invalid"
+	CodeTokenNotIssuedForRepository ErrorCode = "token_not_issued_for_repository"
+	CodeWebhooksDisabled            ErrorCode = "webhooks_disabled"
+	CodeInvalidWebhookSignature     ErrorCode = "invalid_webhook_signature"
+	CodeRateLimited                 ErrorCode = "rate_limited"
+	CodeDependencyUnavailable       ErrorCode = "dependency_unavailable"
+	CodeGitHubUnavailable           ErrorCode = "github_unavailable"
+	CodeRevocationFailed            ErrorCode = "revocation_failed"
+	CodePrivateKeyUnavailable       ErrorCode = "private_key_unavailable"
+	CodeConfigurationError          ErrorCode = "configuration_error"
+	CodeInternalError               ErrorCode = "internal_error"
+)
+
+// errorCodes maps each error code to the HTTP status and the title of its error responses.
+var errorCodes = map[ErrorCode]struct {
+	status int
+	title  string
+}{
+	CodeNotFound:                    {http.StatusNotFound, "Not found"},
+	CodeMethodNotAllowed:            {http.StatusMethodNotAllowed, "Method not allowed"},
+	CodeInvalidRequest:              {http.StatusBadRequest, "Invalid request"},
+	CodeDuplicateParameter:          {http.StatusBadRequest, "Duplicate parameter"},
+	CodeDuplicateScope:              {http.StatusBadRequest, "Duplicate scope"},
+	CodeInvalidPermission:           {http.StatusBadRequest, "Invalid permission"},
+	CodeNoScopes:                    {http.StatusBadRequest, "No scopes requested"},
+	CodeInvalidOptionalScopes:       {http.StatusBadRequest, "Invalid optional scopes"},
+	CodeInvalidTTL:                  {http.StatusBadRequest, "Invalid token lifetime"},
+	CodeScopeNotAllowed:             {http.StatusBadRequest, "Scope not allowed"},
+	CodeScopeNotInAllowlist:         {http.StatusBadRequest, "Scope not in allowlist"},
+	CodePermissionNotAllowed:        {http.StatusBadRequest, "Permission not allowed"},
+	CodeUnknownProfile:              {http.StatusBadRequest, "Unknown scope profile"},
+	CodeProfileNotAllowed:           {http.StatusForbidden, "Scope profile not allowed"},
+	CodeMissingAuthorization:        {http.StatusUnauthorized, "Missing authorization"},
+	CodeInvalidOIDCToken:            {http.StatusUnauthorized, "Invalid OIDC token"},
+	CodeOwnerNotAllowed:             {http.StatusForbidden, "Repository owner not allowed"},
+	CodeAdminNotAllowed:             {http.StatusForbidden, "Repository not allowed to administer"},
+	CodeNoMatchingApp:               {http.StatusForbidden, "No matching GitHub App"},
+	CodeAppNotInstalled:             {http.StatusForbidden, "GitHub App not installed"},
+	CodeInsufficientPermissions:     {http.StatusForbidden, "Insufficient permissions"},
+	CodeInstallationSuspended:       {http.StatusForbidden, "GitHub App installation suspended"},
+	CodeScopesNotGranted:            {http.StatusForbidden, "Scopes not granted"},
+	CodeNoGrantableScopes:           {http.StatusForbidden, "No grantable scopes"},
+	CodeTokenInvalid:                {http.StatusBadRequest, "Installation token invalid"},
+	CodeTokenNotIssuedForRepository: {http.StatusForbidden, "Installation token not issued for repository"},
+	CodeWebhooksDisabled:            {http.StatusNotFound, "Webhooks not enabled"},
+	CodeInvalidWebhookSignature:     {http.StatusUnauthorized, "Invalid webhook delivery"},
+	CodeRateLimited:                 {http.StatusTooManyRequests, "GitHub rate limit exceeded"},
+	CodeDependencyUnavailable:       {http.StatusServiceUnavailable, "Dependency unavailable"},
+	CodeGitHubUnavailable:           {http.StatusServiceUnavailable, "GitHub API unavailable"},
+	CodeRevocationFailed:            {http.StatusServiceUnavailable, "Token revocation failed"},
+	CodePrivateKeyUnavailable:       {http.StatusInternalServerError, "Private key unavailable"},
+	CodeConfigurationError:          {http.StatusInternalServerError, "Configuration error"},
+	CodeInternalError:               {http.StatusInternalServerError, "Internal error"},
+}
+
+// problemTypeBase is the base of the problem type URIs, which end in the error code.
+// The README's error code catalog documents each code.
+const problemTypeBase = "https://github.com/remal/github-repository-token-issuer/blob/main/README.md#"
+
+// Error is an error with a stable code, which determines its HTTP status.
+type Error struct {
+	Code ErrorCode
+	// Detail is the human-readable description of this occurrence.
+	Detail string
+	// Details are machine-readable data about this occurrence (e.g. missing scopes).
+	Details map[string]interface{}
+	// RetryAfter is sent as the Retry-After header if set.
+	RetryAfter time.Duration
+	// Err is the wrapped cause, if any.
+	Err error
+}
+
+// NewError creates an error with the given code. The detail is formatted like fmt.Errorf,
+// and an operand of a %w verb becomes the wrapped cause.
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+	err := fmt.Errorf(format, args...)
+	return &Error{Code: code, Detail: err.Error(), Err: errors.Unwrap(err)}
+}
+
+// WithDetails sets the machine-readable details of the error and returns it.
+func (e *Error) WithDetails(details map[string]interface{}) *Error {
+	e.Details = details
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status of the error's code.
+func (e *Error) Status() int {
+	if code, exists := errorCodes[e.Code]; exists {
+		return code.status
+	}
+	return http.StatusInternalServerError
+}
+
+// AsError returns the *Error for err. Errors that carry their own code (an *Error in the chain,
+// GitHub rate limits, and open circuit breakers) keep it; any other error is wrapped with the
+// fallback code, formatting the detail with format, which should contain a single %w verb.
+func AsError(err error, fallback ErrorCode, format string) *Error {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+
+	var rateLimit *RateLimitError
+	if errors.As(err, &rateLimit) {
+		typed = NewError(CodeRateLimited, "%w", err).WithDetails(map[string]interface{}{"secondary": rateLimit.Secondary})
+		typed.RetryAfter = rateLimit.RetryAfter
+		return typed
+	}
+
+	var circuitOpen *CircuitOpenError
+	if errors.As(err, &circuitOpen) {
+		typed = NewError(CodeDependencyUnavailable, "%w", err).WithDetails(map[string]interface{}{"dependency": circuitOpen.Dependency})
+		typed.RetryAfter = circuitOpen.RetryAfter
+		return typed
+	}
+
+	return NewError(fallback, format, err)
+}
+
+// ErrorResponse is the error response format: an RFC 9457 problem details object with the
+// error code and details as extension members.
+type ErrorResponse struct {
+	Type   string    `json:"type"`
+	Title  string    `json:"title"`
+	Status int       `json:"status"`
+	Detail string    `json:"detail"`
+	Code   ErrorCode `json:"code"`
+	// Error repeats Detail for clients of the original error format.
+	Error   string                 `json:"error"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// writeError writes the application/problem+json error response of err, including its Retry-After header.
+func writeError(w http.ResponseWriter, err *Error) {
+	if err.RetryAfter > 0 {
+		// Retry-After is in whole seconds; round up so callers don't retry too early
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((err.RetryAfter+time.Second-1)/time.Second), 10))
+	}
+
+	status := err.Status()
+	title := http.StatusText(status)
+	if code, exists := errorCodes[err.Code]; exists {
+		title = code.title
+	}
+	writeJSONAs(w, "application/problem+json", status, ErrorResponse{
+		Type:    problemTypeBase + string(err.Code),
+		Title:   title,
+		Status:  status,
+		Detail:  err.Detail,
+		Code:    err.Code,
+		Error:   err.Detail,
+		Details: err.Details,
+	})
+}
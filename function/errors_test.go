@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestErrorCodes tests that every error code has an HTTP status and a title.
+func TestErrorCodes(t *testing.T) {
+	for code, mapping := range errorCodes {
+		if mapping.status < 400 || mapping.title == "" {
+			t.Errorf("error code %s = %+v, want an error status and a title", code, mapping)
+		}
+	}
+}
+
+// TestNewError tests that NewError formats the detail and wraps %w operands.
+func TestNewError(t *testing.T) {
+	cause := errors.New("connection reset")
+	err := NewError(CodeGitHubUnavailable, "GitHub API error: %w", cause)
+
+	if err.Error() != "GitHub API error: connection reset" {
+		t.Errorf("Error() = %q, want %q", err.Error(), "GitHub API error: connection reset")
+	}
+	if !errors.Is(err, cause) {
+		t.Error("errors.Is(err, cause) = false, want true")
+	}
+	if err.Status() != http.StatusServiceUnavailable {
+		t.Errorf("Status() = %d, want %d", err.Status(), http.StatusServiceUnavailable)
+	}
+
+	if status := (&Error{Code: "unknown"}).Status(); status != http.StatusInternalServerError {
+		t.Errorf("Status() of unknown code = %d, want %d", status, http.StatusInternalServerError)
+	}
+}
+
+// TestAsError tests which code errors are reported with.
+func TestAsError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantCode       ErrorCode
+		wantDetail     string
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:       "typed error",
+			err:        NewError(CodeAppNotInstalled, "GitHub App is not installed on repository owner/repo"),
+			wantCode:   CodeAppNotInstalled,
+			wantDetail: "GitHub App is not installed on repository owner/repo",
+		},
+		{
+			name:       "wrapped typed error",
+			err:        fmt.Errorf("profile: %w", ValidateScope("contents", "admin")),
+			wantCode:   CodePermissionNotAllowed,
+			wantDetail: "permission 'admin' not allowed for scope 'contents' (allowed: [read write])",
+		},
+		{
+			name:           "rate limit",
+			err:            fmt.Errorf("failed to create installation token: %w", &RateLimitError{RetryAfter: time.Minute, Secondary: true}),
+			wantCode:       CodeRateLimited,
+			wantDetail:     "failed to create installation token: GitHub API secondary rate limit exceeded, retry after 1m0s",
+			wantRetryAfter: time.Minute,
+		},
+		{
+			name:           "circuit breaker open",
+			err:            &CircuitOpenError{Dependency: "jwks", RetryAfter: 10 * time.Second},
+			wantCode:       CodeDependencyUnavailable,
+			wantDetail:     "jwks is unavailable (circuit breaker open), retry after 10s",
+			wantRetryAfter: 10 * time.Second,
+		},
+		{
+			name:       "other error",
+			err:        errors.New("connection reset"),
+			wantCode:   CodeGitHubUnavailable,
+			wantDetail: "GitHub API error: connection reset",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AsError(tt.err, CodeGitHubUnavailable, "GitHub API error: %w")
+			if got.Code != tt.wantCode || got.Detail != tt.wantDetail || got.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("AsError() = %s %q (RetryAfter %s), want %s %q (RetryAfter %s)",
+					got.Code, got.Detail, got.RetryAfter, tt.wantCode, tt.wantDetail, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+// TestWriteError tests that errors are written as application/problem+json responses.
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        *Error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "simple error",
+			err:        NewError(CodeNoScopes, "at least one scope is required"),
+			wantStatus: http.StatusBadRequest,
+			wantBody: `{"type":"https://github.com/remal/github-repository-token-issuer/blob/main/README.md#no_scopes",` +
+				`"title":"No scopes requested","status":400,"detail":"at least one scope is required","code":"no_scopes",` +
+				`"error":"at least one scope is required"}`,
+		},
+		{
+			name:       "error with details",
+			err:        NewError(CodeScopesNotGranted, "missing").WithDetails(map[string]interface{}{"missing_scopes": []string{"contents", "issues"}}),
+			wantStatus: http.StatusForbidden,
+			wantBody: `{"type":"https://github.com/remal/github-repository-token-issuer/blob/main/README.md#scopes_not_granted",` +
+				`"title":"Scopes not granted","status":403,"detail":"missing","code":"scopes_not_granted",` +
+				`"error":"missing","details":{"missing_scopes":["contents","issues"]}}`,
+		},
+		{
+			name:       "empty details map is omitted due to omitempty",
+			err:        NewError(CodeInternalError, "error").WithDetails(map[string]interface{}{}),
+			wantStatus: http.StatusInternalServerError,
+			wantBody: `{"type":"https://github.com/remal/github-repository-token-issuer/blob/main/README.md#internal_error",` +
+				`"title":"Internal error","status":500,"detail":"error","code":"internal_error","error":"error"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			writeError(w, tt.err)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("writeError() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/problem+json" {
+				t.Errorf("writeError() Content-Type = %v, want application/problem+json", contentType)
+			}
+			if strings.TrimSpace(w.Body.String()) != tt.wantBody {
+				t.Errorf("writeError() body = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+// TestWriteError_RetryAfter tests that rate limits and open circuit breakers set a Retry-After header.
+func TestWriteError_RetryAfter(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{
+			name:           "rate limit rounds Retry-After up to whole seconds",
+			err:            fmt.Errorf("failed to create installation token: %w", &RateLimitError{RetryAfter: 1500 * time.Millisecond, Secondary: true}),
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "2",
+		},
+		{
+			name:           "rate limit already reset",
+			err:            &RateLimitError{},
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "",
+		},
+		{
+			name:           "circuit breaker open",
+			err:            &CircuitOpenError{Dependency: "github_tokens", RetryAfter: 30 * time.Second},
+			wantStatus:     http.StatusServiceUnavailable,
+			wantRetryAfter: "30",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			writeError(w, AsError(tt.err, CodeInternalError, "%w"))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("writeError() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+// TestTokenHandler_ErrorCodes tests that error responses carry their error code.
+func TestTokenHandler_ErrorCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		header   string
+		wantCode ErrorCode
+	}{
+		{name: "unknown path", method: http.MethodPost, path: "/foo", wantCode: CodeNotFound},
+		{name: "wrong method", method: http.MethodGet, path: "/token", wantCode: CodeMethodNotAllowed},
+		{name: "missing authorization", method: http.MethodPost, path: "/token", wantCode: CodeMissingAuthorization},
+		{name: "wrong authorization scheme", method: http.MethodPost, path: "/token", header: "Basic abc", wantCode: CodeMissingAuthorization},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			NewTokenHandler(&Config{})(w, req)
+
+			var resp ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if resp.Code != tt.wantCode || resp.Status != w.Code {
+				t.Errorf("response code = %s with status %d (HTTP %d), want %s", resp.Code, resp.Status, w.Code, tt.wantCode)
+			}
+		})
+	}
+}
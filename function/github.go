@@ -3,10 +3,15 @@ package main
 import (
 	"context"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net/http"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,164 +19,347 @@ import (
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/go-github/v90/github"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// GetPrivateKey fetches the GitHub App private key from GCP Secret Manager.
-func GetPrivateKey(ctx context.Context, projectID string) (privateKey *rsa.PrivateKey, err error) {
-	client, err := secretmanager.NewClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Secret Manager client: %w", err)
-	}
-	defer func() {
-		if closeErr := client.Close(); closeErr != nil && err == nil {
-			err = fmt.Errorf("failed to close Secret Manager client: %w", closeErr)
-		}
-	}()
+// PrivateKeyVersion is a version of a GitHub App private key.
+type PrivateKeyVersion struct {
+	Version string
+	Key     *rsa.PrivateKey
+}
 
-	req := &secretmanagerpb.AccessSecretVersionRequest{
-		Name: fmt.Sprintf("projects/%s/secrets/github-app-private-key/versions/latest", projectID),
+// GetPrivateKeys loads the active GitHub App private keys from their key source, newest first.
+// A version of a versioned key source, or a payload of a single-version key source, may hold several
+// PEM blocks; without a version, keys are numbered by their position.
+// Only the newest version must be valid: older versions that can't be parsed are skipped.
+func GetPrivateKeys(ctx context.Context, source KeySource) ([]PrivateKeyVersion, error) {
+	var payloads []KeyVersion
+	if versioned, ok := source.(VersionedKeySource); ok {
+		var err error
+		if payloads, err = versioned.LoadKeyVersions(ctx); err != nil {
+			return nil, fmt.Errorf("failed to retrieve private key from %s: %w", source, err)
+		}
+	} else {
+		payload, err := source.LoadKey(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve private key from %s: %w", source, err)
+		}
+		payloads = []KeyVersion{{PEM: payload}}
 	}
 
-	result, err := client.AccessSecretVersion(ctx, req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve private key from Secret Manager: %w", err)
+	var versions []PrivateKeyVersion
+	for i, payload := range payloads {
+		keys, err := parsePrivateKeys(payload.PEM)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			continue
+		}
+		for j, key := range keys {
+			version := payload.Version
+			switch {
+			case version == "":
+				version = strconv.Itoa(len(versions) + 1)
+			case len(keys) > 1:
+				version = fmt.Sprintf("%s#%d", version, j+1)
+			}
+			versions = append(versions, PrivateKeyVersion{Version: version, Key: key})
+		}
 	}
+	return versions, nil
+}
 
-	// Parse PEM-encoded private key
-	block, _ := pem.Decode(result.Payload.Data)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode PEM block from private key")
-	}
+// parsePrivateKeys parses the PEM-encoded RSA private keys (PKCS1 or PKCS8) of a payload, in order.
+func parsePrivateKeys(payload []byte) ([]*rsa.PrivateKey, error) {
+	var keys []*rsa.PrivateKey
+	for {
+		var block *pem.Block
+		block, payload = pem.Decode(payload)
+		if block == nil {
+			break
+		}
 
-	// Try PKCS1 format first (RSA PRIVATE KEY)
-	privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		// Try PKCS8 format (PRIVATE KEY)
-		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		// Try PKCS1 format first (RSA PRIVATE KEY)
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
-		}
-		var ok bool
-		privateKey, ok = key.(*rsa.PrivateKey)
-		if !ok {
-			return nil, fmt.Errorf("key is not an RSA private key")
+			// Try PKCS8 format (PRIVATE KEY)
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse private key: %w", err)
+			}
+			var ok bool
+			privateKey, ok = key.(*rsa.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("key is not an RSA private key")
+			}
 		}
+		keys = append(keys, privateKey)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("failed to decode PEM block from private key")
 	}
+	return keys, nil
+}
 
-	return privateKey, nil
+// accessSecret fetches a version ("latest" or a version number) of a GCP Secret Manager secret.
+func accessSecret(ctx context.Context, projectID, secretID, version string) ([]byte, error) {
+	payload, _, err := accessSecretVersion(ctx, projectID, secretID, version)
+	return payload, err
 }
 
-// CreateJWT creates a JWT for authenticating as the GitHub App.
-// JWT expires in 10 minutes (GitHub's maximum allowed).
-func CreateJWT(privateKey *rsa.PrivateKey, appID string) (string, error) {
-	if privateKey == nil {
-		return "", fmt.Errorf("private key is nil")
+// accessSecretVersion fetches a version ("latest" or a version number) of a GCP Secret Manager secret
+// and returns its payload and version number.
+func accessSecretVersion(ctx context.Context, projectID, secretID, version string) (payload []byte, number string, err error) {
+	client, err := secretmanager.NewClient(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create Secret Manager client: %w", err)
 	}
+	defer func() {
+		if closeErr := client.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close Secret Manager client: %w", closeErr)
+		}
+	}()
 
-	now := time.Now()
+	req := &secretmanagerpb.AccessSecretVersionRequest{
+		Name: fmt.Sprintf("projects/%s/secrets/%s/versions/%s", projectID, secretID, version),
+	}
+
+	// Retries are left to retryPolicy instead of the client's built-in retry settings
+	var result *secretmanagerpb.AccessSecretVersionResponse
+	err = retryPolicy.Do(ctx, secretManagerBreaker.Guard(func() (bool, time.Duration, error) {
+		var err error
+		result, err = client.AccessSecretVersion(ctx, req, gax.WithRetry(func() gax.Retryer { return nil }))
+		switch status.Code(err) {
+		case codes.OK:
+			return false, 0, nil
+		case codes.Unavailable, codes.ResourceExhausted, codes.Internal, codes.Aborted, codes.DeadlineExceeded:
+			return true, 0, err
+		default:
+			return false, 0, err
+		}
+	}))
+	if err != nil {
+		return nil, "", err
+	}
+	return result.Payload.Data, path.Base(result.Name), nil
+}
+
+// signJWTAt creates a JWT for authenticating as the GitHub App at the given time, signed (RS256) by the
+// signer, and returns it with its expiry. issuer is the app's client ID or app ID. The JWT is valid for
+// jwtLifetime from its issue time, which is backdated by jwtClockSkew.
+func signJWTAt(ctx context.Context, signer JWTSigner, issuer string, now time.Time) (string, time.Time, error) {
+	issuedAt := now.Add(-jwtClockSkew)
+	expiresAt := issuedAt.Add(jwtLifetime)
 
 	claims := jwt.MapClaims{
-		"iat": now.Unix(),
-		"exp": now.Add(10 * time.Minute).Unix(),
-		"iss": appID,
+		"iat": issuedAt.Unix(),
+		"exp": expiresAt.Unix(),
+		"iss": issuer,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	signedToken, err := token.SignedString(privateKey)
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to encode JWT: %w", err)
+	}
+	digest := sha256.Sum256([]byte(signingString))
+	signature, err := signer.SignDigest(ctx, digest[:])
 	if err != nil {
-		return "", fmt.Errorf("failed to sign JWT: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to sign JWT: %w", err)
 	}
 
-	return signedToken, nil
+	return signingString + "." + token.EncodeSegment(signature), expiresAt, nil
 }
 
 // GitHubAppsService defines the GitHub Apps API methods used by this package.
+// GetRepositoryInstallation and CreateInstallationToken require a client authenticated with the App JWT;
+// RevokeInstallationToken requires a client authenticated with an installation token.
 type GitHubAppsService interface {
+	Get(ctx context.Context, appSlug string) (*github.App, *github.Response, error)
 	GetRepositoryInstallation(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error)
 	CreateInstallationToken(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error)
+	RevokeInstallationToken(ctx context.Context) (*github.Response, error)
+	ListRepos(ctx context.Context, opts *github.ListOptions) (*github.ListRepositories, *github.Response, error)
 }
 
-const maxRetries = 3
+// defaultSecondaryRateLimitWait is the wait after a secondary rate limit response without
+// Retry-After or X-RateLimit-Reset headers (GitHub asks to wait at least one minute).
+const defaultSecondaryRateLimitWait = time.Minute
+
+// RateLimitError reports that GitHub rate-limited a request and it couldn't be retried within the request deadline.
+type RateLimitError struct {
+	// RetryAfter is how long to wait before GitHub accepts requests again.
+	RetryAfter time.Duration
+	// Secondary reports a secondary rate limit (too many concurrent or too fast requests)
+	// rather than the primary hourly request quota.
+	Secondary bool
+	Err       error
+}
 
-var retryBackoffBase = 30 * time.Second
+func (e *RateLimitError) Error() string {
+	kind := "primary"
+	if e.Secondary {
+		kind = "secondary"
+	}
+	return fmt.Sprintf("GitHub API %s rate limit exceeded, retry after %s", kind, e.RetryAfter.Round(time.Second))
+}
 
-// retryWithBackoff retries fn up to maxRetries times with exponential backoff
-// on server errors (>= 500) or network errors (nil response). The isNonRetryable
-// callback handles caller-specific error conditions that should fail immediately.
-func retryWithBackoff[T any](ctx context.Context, errMsg string, fn func() (T, *github.Response, error), isNonRetryable func(*github.Response, error) error) (T, error) {
-	var zero T
-	var lastErr error
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
 
-	for attempt := range maxRetries {
-		var resp *github.Response
-		var result T
-		result, resp, lastErr = fn()
-		if lastErr == nil {
-			return result, nil
+// asRateLimitError converts GitHub primary and secondary rate limit errors to a RateLimitError.
+// The wait comes from the Retry-After or X-RateLimit-Reset response headers.
+func asRateLimitError(err error, now time.Time) (*RateLimitError, bool) {
+	var primary *github.RateLimitError
+	if errors.As(err, &primary) {
+		return &RateLimitError{RetryAfter: max(primary.Rate.Reset.Sub(now), 0), Err: err}, true
+	}
+	var secondary *github.AbuseRateLimitError
+	if errors.As(err, &secondary) {
+		wait := defaultSecondaryRateLimitWait
+		if secondary.RetryAfter != nil {
+			wait = max(*secondary.RetryAfter, 0)
 		}
+		return &RateLimitError{RetryAfter: wait, Secondary: true, Err: err}, true
+	}
+	return nil, false
+}
 
-		if err := isNonRetryable(resp, lastErr); err != nil {
-			return zero, err
+// retryWithBackoff retries fn according to retryPolicy on server errors (>= 500) or
+// network errors (nil response), which also count as failures for the breaker.
+// The isNonRetryable callback handles caller-specific error conditions that should fail
+// immediately. Rate-limited attempts are retried after the wait GitHub asks for if it fits
+// within the retry budget and the context deadline; otherwise the returned error wraps a
+// *RateLimitError. While the breaker is open, fn isn't called and the returned error is a
+// *CircuitOpenError.
+func retryWithBackoff[T any](ctx context.Context, breaker *CircuitBreaker, errMsg string, fn func() (T, *github.Response, error), isNonRetryable func(*github.Response, error) error) (T, error) {
+	var result T
+	err := retryPolicy.Do(ctx, breaker.Guard(func() (bool, time.Duration, error) {
+		var resp *github.Response
+		var err error
+		result, resp, err = fn()
+		if err == nil {
+			return false, 0, nil
 		}
 
-		retryable := resp == nil || resp.StatusCode >= http.StatusInternalServerError
-		if !retryable {
-			return zero, fmt.Errorf("%s: %w", errMsg, lastErr)
+		// Rate limits are checked first: GitHub reports them as 403, which callers treat as permission errors
+		if rateLimit, ok := asRateLimitError(err, time.Now()); ok {
+			return true, rateLimit.RetryAfter, fmt.Errorf("%s: %w", errMsg, rateLimit)
 		}
 
-		if attempt < maxRetries-1 {
-			backoff := time.Duration(1<<uint(attempt)) * retryBackoffBase
-			timer := time.NewTimer(backoff)
-			select {
-			case <-ctx.Done():
-				timer.Stop()
-				return zero, ctx.Err()
-			case <-timer.C:
-			}
+		if nonRetryableErr := isNonRetryable(resp, err); nonRetryableErr != nil {
+			return false, 0, nonRetryableErr
 		}
-	}
 
-	return zero, fmt.Errorf("%s: %w", errMsg, lastErr)
+		retryable := resp == nil || resp.StatusCode >= http.StatusInternalServerError
+		return retryable, 0, fmt.Errorf("%s: %w", errMsg, err)
+	}))
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return result, nil
 }
 
-// GetInstallationID finds the GitHub App installation ID for the given repository.
-func GetInstallationID(ctx context.Context, apps GitHubAppsService, repository string) (int64, error) {
+// GetInstallation finds the GitHub App installation for the given repository,
+// including the permissions granted to it. baseURL is the REST API URL of the GitHub host, or empty for github.com.
+func GetInstallation(ctx context.Context, apps GitHubAppsService, baseURL, repository string) (*github.Installation, error) {
 	parts := strings.Split(repository, "/")
 	if len(parts) != 2 {
-		return 0, fmt.Errorf("invalid repository format: %s", repository)
+		return nil, fmt.Errorf("invalid repository format: %s", repository)
 	}
 
 	owner, repo := parts[0], parts[1]
 
-	installation, err := retryWithBackoff(ctx, "failed to find installation",
+	installation, err := retryWithBackoff(ctx, githubBreakers.Installations(baseURL), "failed to find installation",
 		func() (*github.Installation, *github.Response, error) {
 			return apps.GetRepositoryInstallation(ctx, owner, repo)
 		},
 		func(resp *github.Response, _ error) error {
 			if resp != nil && resp.StatusCode == http.StatusNotFound {
-				return fmt.Errorf("GitHub App is not installed on repository %s", repository)
+				return NewError(CodeAppNotInstalled, "GitHub App is not installed on repository %s", repository)
 			}
 			return nil
 		},
 	)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	if installation == nil || installation.ID == nil {
-		return 0, fmt.Errorf("installation ID is nil for repository %s", repository)
+		return nil, fmt.Errorf("installation ID is nil for repository %s", repository)
 	}
 
-	return *installation.ID, nil
+	return installation, nil
 }
 
 // CreateInstallationToken requests an installation access token from GitHub with the specified permissions.
-func CreateInstallationToken(ctx context.Context, apps GitHubAppsService, installationID int64, scopes map[string]string) (*github.InstallationToken, error) {
+// baseURL is the REST API URL of the GitHub host, or empty for github.com.
+func CreateInstallationToken(ctx context.Context, apps GitHubAppsService, baseURL string, installationID int64, scopes map[string]string) (*github.InstallationToken, error) {
+	token, err := requestInstallationToken(ctx, apps, baseURL, installationID, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := VerifyRequestedScopes(scopes, token.GetPermissions()); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// CreateInstallationTokenBestEffort requests an installation access token like CreateInstallationToken,
+// but tolerates GitHub not granting optional scopes. Optional scopes missing from the granted permissions
+// are reported as dropped; a missing required scope still fails the request.
+func CreateInstallationTokenBestEffort(ctx context.Context, apps GitHubAppsService, baseURL string, installationID int64, scopes map[string]string, optional map[string]bool) (*github.InstallationToken, map[string]string, []DroppedScope, error) {
+	token, err := requestInstallationToken(ctx, apps, baseURL, installationID, scopes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if token.GetPermissions() == nil {
+		return nil, nil, nil, fmt.Errorf("GitHub API returned no permissions")
+	}
+
+	granted := make(map[string]string, len(scopes))
+	for scopeID, permission := range scopes {
+		granted[scopeID] = permission
+	}
+
+	var missingRequired []string
+	var dropped []DroppedScope
+	for _, scopeID := range MissingScopes(scopes, InstallationScopes(token.GetPermissions())) {
+		if !optional[scopeID] {
+			missingRequired = append(missingRequired, scopeID)
+			continue
+		}
+		dropped = append(dropped, DroppedScope{
+			Scope:      scopeID,
+			Permission: scopes[scopeID],
+			Reason:     "not granted by GitHub for this repository",
+		})
+		delete(granted, scopeID)
+	}
+	if len(missingRequired) > 0 {
+		return nil, nil, nil, NewError(CodeScopesNotGranted, "GitHub API returned fewer scopes than requested (missing: %v)", missingRequired).
+			WithDetails(map[string]interface{}{"missing_scopes": missingRequired})
+	}
+
+	return token, granted, dropped, nil
+}
+
+// requestInstallationToken calls GitHub to create an installation access token with the specified
+// permissions, without checking which of them were actually granted.
+func requestInstallationToken(ctx context.Context, apps GitHubAppsService, baseURL string, installationID int64, scopes map[string]string) (*github.InstallationToken, error) {
 	// Build permissions map
 	permissions := &github.InstallationPermissions{}
 
 	// IMPORTANT: When adding a new scope to AllowedScopes in scopes.go, you must also
-	// add a corresponding case in this switch statement and in VerifyRequestedScopes below.
+	// add a corresponding case in this switch statement and in InstallationScopes below.
 	// Failure to do so will cause runtime errors for the new scope.
 	for scopeID, permission := range scopes {
 		permValue := github.Ptr(permission)
@@ -230,16 +418,16 @@ func CreateInstallationToken(ctx context.Context, apps GitHubAppsService, instal
 		Permissions: permissions,
 	}
 
-	token, err := retryWithBackoff(ctx, "failed to create installation token",
+	token, err := retryWithBackoff(ctx, githubBreakers.Tokens(baseURL), "failed to create installation token",
 		func() (*github.InstallationToken, *github.Response, error) {
 			return apps.CreateInstallationToken(ctx, installationID, opts)
 		},
 		func(resp *github.Response, _ error) error {
 			if resp != nil && resp.StatusCode == http.StatusForbidden {
-				return fmt.Errorf("insufficient permissions for requested scopes")
+				return NewError(CodeInsufficientPermissions, "insufficient permissions for requested scopes")
 			}
 			if resp != nil && resp.StatusCode == http.StatusUnprocessableEntity {
-				return fmt.Errorf("GitHub App installation is suspended or has insufficient permissions")
+				return NewError(CodeInstallationSuspended, "GitHub App installation is suspended or has insufficient permissions")
 			}
 			return nil
 		},
@@ -248,10 +436,6 @@ func CreateInstallationToken(ctx context.Context, apps GitHubAppsService, instal
 		return nil, err
 	}
 
-	if err := VerifyRequestedScopes(scopes, token.GetPermissions()); err != nil {
-		return nil, err
-	}
-
 	return token, nil
 }
 
@@ -261,9 +445,50 @@ func VerifyRequestedScopes(requested map[string]string, granted *github.Installa
 		return fmt.Errorf("GitHub API returned no permissions")
 	}
 
+	// Check if all requested scopes were granted
+	missing := MissingScopes(requested, InstallationScopes(granted))
+	if len(missing) > 0 {
+		return NewError(CodeScopesNotGranted, "GitHub API returned fewer scopes than requested (missing: %v)", missing).
+			WithDetails(map[string]interface{}{"missing_scopes": missing})
+	}
+
+	return nil
+}
+
+// MissingScopes returns the sorted IDs of requested scopes that are absent from granted
+// or granted with a different permission level.
+func MissingScopes(requested, granted map[string]string) []string {
+	var missing []string
+	for scopeID, requestedPerm := range requested {
+		grantedPerm, exists := granted[scopeID]
+		if !exists || grantedPerm != requestedPerm {
+			missing = append(missing, scopeID)
+		}
+	}
+	slices.Sort(missing)
+	return missing
+}
+
+// PermissionCovers reports whether a granted permission level satisfies a requested one.
+// A "write" (or "admin") grant covers a "read" request.
+func PermissionCovers(granted, requested string) bool {
+	switch granted {
+	case requested, "admin":
+		return true
+	case "write":
+		return requested == "read"
+	default:
+		return false
+	}
+}
+
+// InstallationScopes converts GitHub installation permissions into a map of scope ID to permission level.
+func InstallationScopes(granted *github.InstallationPermissions) map[string]string {
 	grantedMap := make(map[string]string)
+	if granted == nil {
+		return grantedMap
+	}
 
-	// Convert granted permissions to map
 	if granted.Actions != nil {
 		grantedMap["actions"] = *granted.Actions
 	}
@@ -331,23 +556,82 @@ func VerifyRequestedScopes(requested map[string]string, granted *github.Installa
 		grantedMap["organization_actions_variables"] = *granted.OrganizationActionsVariables
 	}
 
-	// Check if all requested scopes were granted
-	var missing []string
-	for scopeID, requestedPerm := range requested {
-		grantedPerm, exists := grantedMap[scopeID]
-		if !exists || grantedPerm != requestedPerm {
-			missing = append(missing, scopeID)
-		}
-	}
+	return grantedMap
+}
 
-	if len(missing) > 0 {
-		return fmt.Errorf("GitHub API returned fewer scopes than requested (missing: %v)", missing)
+// ListTokenRepositories returns the first page of the repositories the installation token the apps
+// client is authenticated with can access (GET /installation/repositories), with their total count.
+// baseURL is the REST API URL of the GitHub host, or empty for github.com.
+func ListTokenRepositories(ctx context.Context, apps GitHubAppsService, baseURL string) (*github.ListRepositories, error) {
+	return retryWithBackoff(ctx, githubBreakers.Tokens(baseURL), "failed to list the repositories of the installation token",
+		func() (*github.ListRepositories, *github.Response, error) {
+			return apps.ListRepos(ctx, &github.ListOptions{PerPage: 2})
+		},
+		func(resp *github.Response, _ error) error {
+			if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+				return NewError(CodeTokenInvalid, "installation token is invalid, expired, or already revoked")
+			}
+			return nil
+		},
+	)
+}
+
+// RevokeInstallationToken revokes the installation token the apps client is authenticated with.
+// baseURL is the REST API URL of the GitHub host, or empty for github.com.
+func RevokeInstallationToken(ctx context.Context, apps GitHubAppsService, baseURL string) error {
+	_, err := retryWithBackoff(ctx, githubBreakers.Tokens(baseURL), "failed to revoke installation token",
+		func() (struct{}, *github.Response, error) {
+			resp, err := apps.RevokeInstallationToken(ctx)
+			return struct{}{}, resp, err
+		},
+		func(resp *github.Response, _ error) error {
+			if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+				return NewError(CodeTokenInvalid, "installation token is invalid, expired, or already revoked")
+			}
+			return nil
+		},
+	)
+	return err
+}
+
+// NewGitHubClientWithInstallationToken creates a GitHub client authenticated with an installation token.
+// baseURL is the REST API URL of the GitHub host, or empty for github.com.
+func NewGitHubClientWithInstallationToken(token, baseURL string) (*github.Client, error) {
+	return newGitHubClient(token, baseURL)
+}
+
+// newGitHubClient creates a GitHub client authenticated with the token, for github.com or the host of baseURL.
+// It uses the shared connection pool of githubTransport.
+func newGitHubClient(token, baseURL string) (*github.Client, error) {
+	opts := []github.ClientOptionsFunc{github.WithTransport(githubTransport), github.WithAuthToken(token)}
+	if baseURL != "" {
+		opts = append(opts, github.WithEnterpriseURLs(baseURL, baseURL))
 	}
+	return github.NewClient(opts...)
+}
 
-	return nil
+// newJWTAppsService returns the GitHub Apps API client authenticated as the GitHub App, shared by all
+// requests of the app (see AppTransport). It makes sure a JWT can be signed, so a private key that can't
+// be loaded fails the request before calling GitHub. Errors are returned as *Error.
+// It's a variable so tests can replace it with a mock.
+var newJWTAppsService = func(ctx context.Context, app GitHubApp) (GitHubAppsService, error) {
+	client, err := getAppClient(app)
+	if err != nil {
+		return nil, NewError(CodeInternalError, "failed to create GitHub client: %w", err)
+	}
+	if _, _, err := client.transport.Token(ctx); err != nil {
+		return nil, AsError(err, CodePrivateKeyUnavailable, "%w")
+	}
+	return client.client.Apps, nil
 }
 
-// NewGitHubClientWithJWT creates a GitHub client authenticated with a JWT.
-func NewGitHubClientWithJWT(jwtToken string) (*github.Client, error) {
-	return github.NewClient(github.WithAuthToken(jwtToken))
+// newTokenAppsService creates a GitHub Apps API client authenticated with an installation token
+// issued by the GitHub host of baseURL (empty for github.com).
+// It's a variable so tests can replace it with a mock.
+var newTokenAppsService = func(baseURL, token string) (GitHubAppsService, error) {
+	client, err := NewGitHubClientWithInstallationToken(token, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return client.Apps, nil
 }
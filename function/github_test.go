@@ -4,13 +4,13 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"testing"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/go-github/v90/github"
 )
 
@@ -24,198 +24,6 @@ func generateTestRSAKey(t *testing.T) *rsa.PrivateKey {
 	return key
 }
 
-// TestCreateJWT tests JWT creation for GitHub App authentication.
-// It verifies that valid keys produce valid JWTs and nil keys return errors.
-//
-// Test steps:
-//  1. Generate a test RSA key (or use nil for error cases)
-//  2. Call CreateJWT with the key and app ID
-//  3. Verify JWT is returned for valid keys (non-empty, 3-part structure)
-//  4. Verify error is returned for nil keys
-func TestCreateJWT(t *testing.T) {
-	tests := []struct {
-		name        string
-		appID       string
-		useNilKey   bool
-		wantErr     bool
-		errContains string
-	}{
-		{
-			name:      "valid private key and app ID",
-			appID:     "12345",
-			useNilKey: false,
-			wantErr:   false,
-		},
-		{
-			name:      "valid private key with different app ID",
-			appID:     "987654321",
-			useNilKey: false,
-			wantErr:   false,
-		},
-		{
-			name:      "valid private key with string app ID",
-			appID:     "my-app-id",
-			useNilKey: false,
-			wantErr:   false,
-		},
-		{
-			name:        "nil private key returns error",
-			appID:       "12345",
-			useNilKey:   true,
-			wantErr:     true,
-			errContains: "private key is nil",
-		},
-	}
-
-	// Step 1: Generate a shared test key for valid cases
-	validKey := generateTestRSAKey(t)
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Determine which key to use
-			var key *rsa.PrivateKey
-			if !tt.useNilKey {
-				key = validKey
-			}
-
-			// Step 2: Call CreateJWT
-			got, err := CreateJWT(key, tt.appID)
-
-			// Step 3 & 4: Verify results
-			if tt.wantErr {
-				// Verify error is returned
-				if err == nil {
-					t.Errorf("CreateJWT() error = nil, wantErr = true")
-					return
-				}
-				// Verify error message contains expected text
-				if tt.errContains != "" && !strings.Contains(err.Error(), tt.errContains) {
-					t.Errorf("CreateJWT() error = %v, want error containing %q", err, tt.errContains)
-				}
-				return
-			}
-
-			// Verify no error for valid keys
-			if err != nil {
-				t.Errorf("CreateJWT() unexpected error = %v", err)
-				return
-			}
-
-			// Verify JWT is not empty
-			if got == "" {
-				t.Error("CreateJWT() returned empty string")
-				return
-			}
-
-			// Verify JWT structure (3 parts separated by dots)
-			parts := strings.Split(got, ".")
-			if len(parts) != 3 {
-				t.Errorf("CreateJWT() returned invalid JWT format, got %d parts, want 3", len(parts))
-			}
-		})
-	}
-}
-
-// TestCreateJWT_Claims tests that JWT claims are correctly set.
-// It verifies iat (issued at), exp (expiration), and iss (issuer) claims.
-//
-// Test steps:
-//  1. Generate a test RSA key
-//  2. Record timestamp before/after JWT creation
-//  3. Call CreateJWT to generate a token
-//  4. Parse the JWT and extract claims
-//  5. Verify iss claim matches app ID
-//  6. Verify iat claim is within expected time range
-//  7. Verify exp claim is exactly 10 minutes after iat
-func TestCreateJWT_Claims(t *testing.T) {
-	// Step 1: Generate test key
-	key := generateTestRSAKey(t)
-	appID := "12345"
-
-	// Step 2: Record timestamps
-	beforeCreate := time.Now().Unix()
-	// Step 3: Create JWT
-	tokenString, err := CreateJWT(key, appID)
-	afterCreate := time.Now().Unix()
-
-	if err != nil {
-		t.Fatalf("CreateJWT() error = %v", err)
-	}
-
-	// Step 4: Parse the token to verify claims
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return &key.PublicKey, nil
-	})
-
-	if err != nil {
-		t.Fatalf("failed to parse JWT: %v", err)
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		t.Fatal("failed to get claims from token")
-	}
-
-	// Step 5: Verify issuer claim
-	if iss, ok := claims["iss"].(string); !ok || iss != appID {
-		t.Errorf("JWT iss claim = %v, want %v", claims["iss"], appID)
-	}
-
-	// Step 6: Verify iat claim (issued at)
-	if iat, ok := claims["iat"].(float64); !ok {
-		t.Error("JWT iat claim missing or invalid type")
-	} else {
-		iatInt := int64(iat)
-		if iatInt < beforeCreate || iatInt > afterCreate {
-			t.Errorf("JWT iat claim = %v, want between %v and %v", iatInt, beforeCreate, afterCreate)
-		}
-	}
-
-	// Step 7: Verify exp claim (expiration - should be ~10 minutes from iat)
-	if exp, ok := claims["exp"].(float64); !ok {
-		t.Error("JWT exp claim missing or invalid type")
-	} else {
-		iat := int64(claims["iat"].(float64))
-		expInt := int64(exp)
-		expectedExp := iat + 600 // 10 minutes in seconds
-
-		if expInt != expectedExp {
-			t.Errorf("JWT exp claim = %v, want %v (iat + 600 seconds)", expInt, expectedExp)
-		}
-	}
-}
-
-// TestCreateJWT_Algorithm tests that the JWT uses RS256 signing algorithm.
-// GitHub requires RS256 for App authentication.
-//
-// Test steps:
-//  1. Generate a test RSA key
-//  2. Call CreateJWT to generate a token
-//  3. Parse the JWT header without verification
-//  4. Verify the algorithm is RS256
-func TestCreateJWT_Algorithm(t *testing.T) {
-	// Step 1: Generate test key
-	key := generateTestRSAKey(t)
-	appID := "12345"
-
-	// Step 2: Create JWT
-	tokenString, err := CreateJWT(key, appID)
-	if err != nil {
-		t.Fatalf("CreateJWT() error = %v", err)
-	}
-
-	// Step 3: Parse without verification to check header
-	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
-	if err != nil {
-		t.Fatalf("failed to parse JWT: %v", err)
-	}
-
-	// Step 4: Verify RS256 algorithm
-	if token.Method.Alg() != "RS256" {
-		t.Errorf("JWT algorithm = %v, want RS256", token.Method.Alg())
-	}
-}
-
 // TestVerifyRequestedScopes tests verification of requested vs granted scopes.
 // It ensures the function correctly identifies missing or mismatched permissions.
 //
@@ -482,30 +290,17 @@ func TestVerifyRequestedScopes(t *testing.T) {
 	}
 }
 
-// TestNewGitHubClientWithJWT tests GitHub client creation with JWT authentication.
-// It verifies the function returns a non-nil client.
-//
-// Test steps:
-//  1. Call NewGitHubClientWithJWT with a test token
-//  2. Verify the returned client is not nil
-func TestNewGitHubClientWithJWT(t *testing.T) {
-	// Step 1: Create client with test token
-	token := "test-jwt-token"
-	client, err := NewGitHubClientWithJWT(token)
-
-	// Step 2: Verify no error and client is not nil
-	if err != nil {
-		t.Fatalf("NewGitHubClientWithJWT() returned error: %v", err)
-	}
-	if client == nil {
-		t.Error("NewGitHubClientWithJWT() returned nil")
-	}
-}
-
 // mockAppsService implements GitHubAppsService for testing.
 type mockAppsService struct {
+	getApp                  func(ctx context.Context, appSlug string) (*github.App, *github.Response, error)
 	findRepoInstallation    func(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error)
 	createInstallationToken func(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error)
+	revokeInstallationToken func(ctx context.Context) (*github.Response, error)
+	listRepos               func(ctx context.Context, opts *github.ListOptions) (*github.ListRepositories, *github.Response, error)
+}
+
+func (m *mockAppsService) Get(ctx context.Context, appSlug string) (*github.App, *github.Response, error) {
+	return m.getApp(ctx, appSlug)
 }
 
 func (m *mockAppsService) GetRepositoryInstallation(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error) {
@@ -516,15 +311,23 @@ func (m *mockAppsService) CreateInstallationToken(ctx context.Context, id int64,
 	return m.createInstallationToken(ctx, id, opts)
 }
 
-// TestGetInstallationID tests finding the GitHub App installation ID for a repository.
+func (m *mockAppsService) RevokeInstallationToken(ctx context.Context) (*github.Response, error) {
+	return m.revokeInstallationToken(ctx)
+}
+
+func (m *mockAppsService) ListRepos(ctx context.Context, opts *github.ListOptions) (*github.ListRepositories, *github.Response, error) {
+	return m.listRepos(ctx, opts)
+}
+
+// TestGetInstallation tests finding the GitHub App installation of a repository.
 // It verifies correct handling of valid repositories, invalid formats, and API errors.
 //
 // Test steps:
 //  1. Create mock GitHubAppsService with configured response
-//  2. Call GetInstallationID with test repository
+//  2. Call GetInstallation with test repository
 //  3. Verify returned installation ID matches expected value
 //  4. Verify error handling for various failure scenarios
-func TestGetInstallationID(t *testing.T) {
+func TestGetInstallation(t *testing.T) {
 	ctx := context.Background()
 
 	tests := []struct {
@@ -536,6 +339,7 @@ func TestGetInstallationID(t *testing.T) {
 		wantID       int64
 		wantErr      bool
 		errContains  string
+		wantCode     ErrorCode
 	}{
 		{
 			name:         "valid repository returns installation ID",
@@ -581,6 +385,7 @@ func TestGetInstallationID(t *testing.T) {
 			mockErr:      fmt.Errorf("not found"),
 			wantErr:      true,
 			errContains:  "not installed",
+			wantCode:     CodeAppNotInstalled,
 		},
 		{
 			name:         "API error - non-retryable",
@@ -611,36 +416,38 @@ func TestGetInstallationID(t *testing.T) {
 				},
 			}
 
-			// Step 2: Call GetInstallationID
-			gotID, err := GetInstallationID(ctx, mock, tt.repository)
+			// Step 2: Call GetInstallation
+			installation, err := GetInstallation(ctx, mock, "", tt.repository)
 
 			// Step 3 & 4: Verify results
 			if tt.wantErr {
 				if err == nil {
-					t.Errorf("GetInstallationID() error = nil, wantErr = true")
+					t.Errorf("GetInstallation() error = nil, wantErr = true")
 					return
 				}
 				if tt.errContains != "" && !strings.Contains(err.Error(), tt.errContains) {
-					t.Errorf("GetInstallationID() error = %v, want containing %q", err, tt.errContains)
+					t.Errorf("GetInstallation() error = %v, want containing %q", err, tt.errContains)
+				}
+				if code := AsError(err, CodeGitHubUnavailable, "%w").Code; tt.wantCode != "" && code != tt.wantCode {
+					t.Errorf("GetInstallation() error code = %s, want %s", code, tt.wantCode)
 				}
 				return
 			}
 
 			if err != nil {
-				t.Errorf("GetInstallationID() unexpected error = %v", err)
+				t.Errorf("GetInstallation() unexpected error = %v", err)
 				return
 			}
 
-			if gotID != tt.wantID {
-				t.Errorf("GetInstallationID() = %v, want %v", gotID, tt.wantID)
+			if installation.GetID() != tt.wantID {
+				t.Errorf("GetInstallation() ID = %v, want %v", installation.GetID(), tt.wantID)
 			}
 		})
 	}
 }
 
-func TestGetInstallationID_RetryOnNetworkError(t *testing.T) {
-	retryBackoffBase = time.Millisecond
-	t.Cleanup(func() { retryBackoffBase = 30 * time.Second })
+func TestGetInstallation_RetryOnNetworkError(t *testing.T) {
+	useFastRetryPolicy(t)
 	ctx := context.Background()
 	callCount := 0
 
@@ -655,21 +462,20 @@ func TestGetInstallationID_RetryOnNetworkError(t *testing.T) {
 		},
 	}
 
-	gotID, err := GetInstallationID(ctx, mock, "owner/repo")
+	installation, err := GetInstallation(ctx, mock, "", "owner/repo")
 	if err != nil {
-		t.Fatalf("GetInstallationID() unexpected error = %v", err)
+		t.Fatalf("GetInstallation() unexpected error = %v", err)
 	}
-	if gotID != 12345 {
-		t.Errorf("GetInstallationID() = %v, want 12345", gotID)
+	if installation.GetID() != 12345 {
+		t.Errorf("GetInstallation() ID = %v, want 12345", installation.GetID())
 	}
 	if callCount != 2 {
 		t.Errorf("expected 2 calls, got %d", callCount)
 	}
 }
 
-func TestGetInstallationID_RetryOn500(t *testing.T) {
-	retryBackoffBase = time.Millisecond
-	t.Cleanup(func() { retryBackoffBase = 30 * time.Second })
+func TestGetInstallation_RetryOn500(t *testing.T) {
+	useFastRetryPolicy(t)
 	ctx := context.Background()
 	callCount := 0
 
@@ -684,21 +490,20 @@ func TestGetInstallationID_RetryOn500(t *testing.T) {
 		},
 	}
 
-	gotID, err := GetInstallationID(ctx, mock, "owner/repo")
+	installation, err := GetInstallation(ctx, mock, "", "owner/repo")
 	if err != nil {
-		t.Fatalf("GetInstallationID() unexpected error = %v", err)
+		t.Fatalf("GetInstallation() unexpected error = %v", err)
 	}
-	if gotID != 12345 {
-		t.Errorf("GetInstallationID() = %v, want 12345", gotID)
+	if installation.GetID() != 12345 {
+		t.Errorf("GetInstallation() ID = %v, want 12345", installation.GetID())
 	}
 	if callCount != 2 {
 		t.Errorf("expected 2 calls, got %d", callCount)
 	}
 }
 
-func TestGetInstallationID_RetriesExhausted(t *testing.T) {
-	retryBackoffBase = time.Millisecond
-	t.Cleanup(func() { retryBackoffBase = 30 * time.Second })
+func TestGetInstallation_RetriesExhausted(t *testing.T) {
+	useFastRetryPolicy(t)
 	ctx := context.Background()
 	callCount := 0
 
@@ -709,19 +514,19 @@ func TestGetInstallationID_RetriesExhausted(t *testing.T) {
 		},
 	}
 
-	_, err := GetInstallationID(ctx, mock, "owner/repo")
+	_, err := GetInstallation(ctx, mock, "", "owner/repo")
 	if err == nil {
-		t.Fatal("GetInstallationID() expected error after retries exhausted")
+		t.Fatal("GetInstallation() expected error after retries exhausted")
 	}
 	if !strings.Contains(err.Error(), "failed to find installation") {
-		t.Errorf("GetInstallationID() error = %v, want containing 'failed to find installation'", err)
+		t.Errorf("GetInstallation() error = %v, want containing 'failed to find installation'", err)
 	}
-	if callCount != maxRetries {
-		t.Errorf("expected %d calls, got %d", maxRetries, callCount)
+	if callCount != retryPolicy.MaxAttempts {
+		t.Errorf("expected %d calls, got %d", retryPolicy.MaxAttempts, callCount)
 	}
 }
 
-func TestGetInstallationID_NoRetryOn404(t *testing.T) {
+func TestGetInstallation_NoRetryOn404(t *testing.T) {
 	ctx := context.Background()
 	callCount := 0
 
@@ -732,9 +537,9 @@ func TestGetInstallationID_NoRetryOn404(t *testing.T) {
 		},
 	}
 
-	_, err := GetInstallationID(ctx, mock, "owner/repo")
+	_, err := GetInstallation(ctx, mock, "", "owner/repo")
 	if err == nil {
-		t.Fatal("GetInstallationID() expected error on 404")
+		t.Fatal("GetInstallation() expected error on 404")
 	}
 	if callCount != 1 {
 		t.Errorf("expected exactly 1 call (no retry), got %d", callCount)
@@ -750,8 +555,7 @@ func TestGetInstallationID_NoRetryOn404(t *testing.T) {
 //  3. Verify returned token matches expected value
 //  4. Verify error handling for various failure scenarios
 func TestCreateInstallationToken(t *testing.T) {
-	retryBackoffBase = time.Millisecond
-	t.Cleanup(func() { retryBackoffBase = 30 * time.Second })
+	useFastRetryPolicy(t)
 	ctx := context.Background()
 	testTime := time.Now().Add(1 * time.Hour)
 
@@ -856,7 +660,7 @@ func TestCreateInstallationToken(t *testing.T) {
 				},
 			}
 
-			token, err := CreateInstallationToken(ctx, mock, tt.installID, tt.scopes)
+			token, err := CreateInstallationToken(ctx, mock, "", tt.installID, tt.scopes)
 
 			if tt.wantErr {
 				if err == nil {
@@ -887,8 +691,7 @@ func TestCreateInstallationToken(t *testing.T) {
 }
 
 func TestCreateInstallationToken_RetryOn500(t *testing.T) {
-	retryBackoffBase = time.Millisecond
-	t.Cleanup(func() { retryBackoffBase = 30 * time.Second })
+	useFastRetryPolicy(t)
 	ctx := context.Background()
 	testTime := time.Now().Add(1 * time.Hour)
 	callCount := 0
@@ -907,7 +710,7 @@ func TestCreateInstallationToken_RetryOn500(t *testing.T) {
 		},
 	}
 
-	token, err := CreateInstallationToken(ctx, mock, 12345, map[string]string{"contents": "write"})
+	token, err := CreateInstallationToken(ctx, mock, "", 12345, map[string]string{"contents": "write"})
 	if err != nil {
 		t.Fatalf("CreateInstallationToken() unexpected error = %v", err)
 	}
@@ -920,8 +723,7 @@ func TestCreateInstallationToken_RetryOn500(t *testing.T) {
 }
 
 func TestCreateInstallationToken_RetryOn504(t *testing.T) {
-	retryBackoffBase = time.Millisecond
-	t.Cleanup(func() { retryBackoffBase = 30 * time.Second })
+	useFastRetryPolicy(t)
 	ctx := context.Background()
 	testTime := time.Now().Add(1 * time.Hour)
 	callCount := 0
@@ -940,7 +742,7 @@ func TestCreateInstallationToken_RetryOn504(t *testing.T) {
 		},
 	}
 
-	token, err := CreateInstallationToken(ctx, mock, 12345, map[string]string{"contents": "write"})
+	token, err := CreateInstallationToken(ctx, mock, "", 12345, map[string]string{"contents": "write"})
 	if err != nil {
 		t.Fatalf("CreateInstallationToken() unexpected error = %v", err)
 	}
@@ -953,8 +755,7 @@ func TestCreateInstallationToken_RetryOn504(t *testing.T) {
 }
 
 func TestCreateInstallationToken_RetriesExhausted(t *testing.T) {
-	retryBackoffBase = time.Millisecond
-	t.Cleanup(func() { retryBackoffBase = 30 * time.Second })
+	useFastRetryPolicy(t)
 	ctx := context.Background()
 	callCount := 0
 
@@ -965,15 +766,15 @@ func TestCreateInstallationToken_RetriesExhausted(t *testing.T) {
 		},
 	}
 
-	_, err := CreateInstallationToken(ctx, mock, 12345, map[string]string{"contents": "write"})
+	_, err := CreateInstallationToken(ctx, mock, "", 12345, map[string]string{"contents": "write"})
 	if err == nil {
 		t.Fatal("CreateInstallationToken() expected error after retries exhausted")
 	}
 	if !strings.Contains(err.Error(), "failed to create installation token") {
 		t.Errorf("CreateInstallationToken() error = %v, want containing 'failed to create installation token'", err)
 	}
-	if callCount != maxRetries {
-		t.Errorf("expected %d calls, got %d", maxRetries, callCount)
+	if callCount != retryPolicy.MaxAttempts {
+		t.Errorf("expected %d calls, got %d", retryPolicy.MaxAttempts, callCount)
 	}
 }
 
@@ -988,7 +789,7 @@ func TestCreateInstallationToken_NoRetryOn403(t *testing.T) {
 		},
 	}
 
-	_, err := CreateInstallationToken(ctx, mock, 12345, map[string]string{"contents": "write"})
+	_, err := CreateInstallationToken(ctx, mock, "", 12345, map[string]string{"contents": "write"})
 	if err == nil {
 		t.Fatal("CreateInstallationToken() expected error on 403")
 	}
@@ -1008,7 +809,7 @@ func TestCreateInstallationToken_NoRetryOn422(t *testing.T) {
 		},
 	}
 
-	_, err := CreateInstallationToken(ctx, mock, 12345, map[string]string{"contents": "write"})
+	_, err := CreateInstallationToken(ctx, mock, "", 12345, map[string]string{"contents": "write"})
 	if err == nil {
 		t.Fatal("CreateInstallationToken() expected error on 422")
 	}
@@ -1016,3 +817,325 @@ func TestCreateInstallationToken_NoRetryOn422(t *testing.T) {
 		t.Errorf("expected exactly 1 call (no retry), got %d", callCount)
 	}
 }
+
+// secondaryRateLimitError returns a secondary rate limit response as returned by go-github for a 403 with Retry-After.
+func secondaryRateLimitError(retryAfter time.Duration) (*github.Response, error) {
+	resp := &http.Response{StatusCode: http.StatusForbidden}
+	return &github.Response{Response: resp}, &github.AbuseRateLimitError{Response: resp, Message: "secondary rate limit", RetryAfter: &retryAfter}
+}
+
+// TestCreateInstallationToken_RetryAfterSecondaryRateLimit tests that a rate-limited request is retried
+// after the wait GitHub asks for when it fits within the request deadline.
+func TestCreateInstallationToken_RetryAfterSecondaryRateLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	callCount := 0
+
+	mock := &mockAppsService{
+		createInstallationToken: func(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error) {
+			callCount++
+			if callCount == 1 {
+				resp, err := secondaryRateLimitError(time.Millisecond)
+				return nil, resp, err
+			}
+			return &github.InstallationToken{
+				Token:       github.Ptr("ghs_test"),
+				Permissions: &github.InstallationPermissions{Contents: github.Ptr("write")},
+			}, nil, nil
+		},
+	}
+
+	token, err := CreateInstallationToken(ctx, mock, "", 12345, map[string]string{"contents": "write"})
+	if err != nil {
+		t.Fatalf("CreateInstallationToken() unexpected error = %v", err)
+	}
+	if token.GetToken() != "ghs_test" {
+		t.Errorf("CreateInstallationToken() token = %q, want ghs_test", token.GetToken())
+	}
+	if callCount != 2 {
+		t.Errorf("expected 2 calls, got %d", callCount)
+	}
+}
+
+// TestCreateInstallationToken_RateLimitBeyondDeadline tests that rate limits whose wait would overrun
+// the request deadline fail immediately with a RateLimitError instead of "insufficient permissions".
+func TestCreateInstallationToken_RateLimitBeyondDeadline(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           func() (*github.Response, error)
+		wantSecondary bool
+		wantWait      time.Duration
+	}{
+		{
+			name: "secondary rate limit with Retry-After",
+			err: func() (*github.Response, error) {
+				return secondaryRateLimitError(2 * time.Minute)
+			},
+			wantSecondary: true,
+			wantWait:      2 * time.Minute,
+		},
+		{
+			name: "secondary rate limit without Retry-After",
+			err: func() (*github.Response, error) {
+				resp := &http.Response{StatusCode: http.StatusTooManyRequests}
+				return &github.Response{Response: resp}, &github.AbuseRateLimitError{Response: resp}
+			},
+			wantSecondary: true,
+			wantWait:      defaultSecondaryRateLimitWait,
+		},
+		{
+			name: "primary rate limit",
+			err: func() (*github.Response, error) {
+				resp := &http.Response{StatusCode: http.StatusForbidden}
+				reset := github.Timestamp{Time: time.Now().Add(30 * time.Minute)}
+				return &github.Response{Response: resp}, &github.RateLimitError{Response: resp, Rate: github.Rate{Reset: reset}}
+			},
+			wantWait: 30 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			callCount := 0
+
+			mock := &mockAppsService{
+				createInstallationToken: func(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error) {
+					callCount++
+					resp, err := tt.err()
+					return nil, resp, err
+				},
+			}
+
+			_, err := CreateInstallationToken(ctx, mock, "", 12345, map[string]string{"contents": "write"})
+
+			var rateLimit *RateLimitError
+			if !errors.As(err, &rateLimit) {
+				t.Fatalf("CreateInstallationToken() error = %v, want RateLimitError", err)
+			}
+			if strings.Contains(err.Error(), "insufficient permissions") {
+				t.Errorf("CreateInstallationToken() error = %v, must not report insufficient permissions", err)
+			}
+			if rateLimit.Secondary != tt.wantSecondary {
+				t.Errorf("RateLimitError.Secondary = %v, want %v", rateLimit.Secondary, tt.wantSecondary)
+			}
+			if diff := rateLimit.RetryAfter - tt.wantWait; diff > time.Second || diff < -time.Second {
+				t.Errorf("RateLimitError.RetryAfter = %v, want %v", rateLimit.RetryAfter, tt.wantWait)
+			}
+			if callCount != 1 {
+				t.Errorf("expected exactly 1 call (wait exceeds deadline), got %d", callCount)
+			}
+		})
+	}
+}
+
+// TestMissingScopes tests detection of requested scopes absent from or downgraded in granted scopes.
+func TestMissingScopes(t *testing.T) {
+	requested := map[string]string{"contents": "write", "issues": "read", "pull_requests": "write"}
+	granted := map[string]string{"contents": "write", "pull_requests": "read"}
+
+	got := MissingScopes(requested, granted)
+	want := []string{"issues", "pull_requests"}
+
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("MissingScopes() = %v, want %v", got, want)
+	}
+}
+
+// TestPermissionCovers tests permission level comparison.
+func TestPermissionCovers(t *testing.T) {
+	tests := []struct {
+		granted   string
+		requested string
+		want      bool
+	}{
+		{"read", "read", true},
+		{"write", "write", true},
+		{"write", "read", true},
+		{"admin", "write", true},
+		{"read", "write", false},
+		{"", "read", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.granted+"/"+tt.requested, func(t *testing.T) {
+			if got := PermissionCovers(tt.granted, tt.requested); got != tt.want {
+				t.Errorf("PermissionCovers(%q, %q) = %v, want %v", tt.granted, tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGetInstallation_Permissions tests that the installation returned includes its granted permissions.
+func TestGetInstallation_Permissions(t *testing.T) {
+	ctx := context.Background()
+
+	mock := &mockAppsService{
+		findRepoInstallation: func(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error) {
+			return &github.Installation{
+					ID:          github.Ptr(int64(12345)),
+					Permissions: &github.InstallationPermissions{Contents: github.Ptr("write")},
+				},
+				&github.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+		},
+	}
+
+	installation, err := GetInstallation(ctx, mock, "", "owner/repo")
+	if err != nil {
+		t.Fatalf("GetInstallation() unexpected error = %v", err)
+	}
+	if installation.GetID() != 12345 {
+		t.Errorf("GetInstallation() ID = %v, want 12345", installation.GetID())
+	}
+	if got := InstallationScopes(installation.GetPermissions())["contents"]; got != "write" {
+		t.Errorf("GetInstallation() contents permission = %q, want write", got)
+	}
+}
+
+// TestCreateInstallationTokenBestEffort tests that optional scopes GitHub didn't grant are dropped
+// while missing required scopes still fail.
+func TestCreateInstallationTokenBestEffort(t *testing.T) {
+	ctx := context.Background()
+	testTime := time.Now().Add(1 * time.Hour)
+
+	tests := []struct {
+		name        string
+		scopes      map[string]string
+		optional    map[string]bool
+		granted     *github.InstallationPermissions
+		wantScopes  map[string]string
+		wantDropped []string
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:       "all scopes granted",
+			scopes:     map[string]string{"contents": "write", "issues": "write"},
+			optional:   map[string]bool{"issues": true},
+			granted:    &github.InstallationPermissions{Contents: github.Ptr("write"), Issues: github.Ptr("write")},
+			wantScopes: map[string]string{"contents": "write", "issues": "write"},
+		},
+		{
+			name:        "optional scope not granted is dropped",
+			scopes:      map[string]string{"contents": "write", "issues": "write"},
+			optional:    map[string]bool{"issues": true},
+			granted:     &github.InstallationPermissions{Contents: github.Ptr("write")},
+			wantScopes:  map[string]string{"contents": "write"},
+			wantDropped: []string{"issues"},
+		},
+		{
+			name:        "required scope not granted fails",
+			scopes:      map[string]string{"contents": "write", "issues": "write"},
+			optional:    map[string]bool{"issues": true},
+			granted:     &github.InstallationPermissions{Issues: github.Ptr("write")},
+			wantErr:     true,
+			errContains: "fewer scopes",
+		},
+		{
+			name:        "nil granted permissions",
+			scopes:      map[string]string{"contents": "write"},
+			optional:    map[string]bool{"contents": true},
+			granted:     nil,
+			wantErr:     true,
+			errContains: "no permissions",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockAppsService{
+				createInstallationToken: func(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error) {
+					return &github.InstallationToken{
+						Token:       github.Ptr("ghs_best_effort"),
+						ExpiresAt:   &github.Timestamp{Time: testTime},
+						Permissions: tt.granted,
+					}, &github.Response{Response: &http.Response{StatusCode: http.StatusCreated}}, nil
+				},
+			}
+
+			token, scopes, dropped, err := CreateInstallationTokenBestEffort(ctx, mock, "", 12345, tt.scopes, tt.optional)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("CreateInstallationTokenBestEffort() error = nil, wantErr = true")
+					return
+				}
+				if !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("CreateInstallationTokenBestEffort() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("CreateInstallationTokenBestEffort() unexpected error = %v", err)
+			}
+			if token.GetToken() != "ghs_best_effort" {
+				t.Errorf("CreateInstallationTokenBestEffort() token = %v, want ghs_best_effort", token.GetToken())
+			}
+			assertScopesEqual(t, "CreateInstallationTokenBestEffort()", scopes, tt.wantScopes)
+			assertDroppedScopes(t, "CreateInstallationTokenBestEffort()", dropped, tt.wantDropped)
+		})
+	}
+}
+
+// TestRevokeInstallationToken tests revoking the installation token the client is authenticated with.
+func TestRevokeInstallationToken(t *testing.T) {
+	useFastRetryPolicy(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		responses   []int
+		wantCalls   int
+		errContains string
+	}{
+		{
+			name:      "revoked",
+			responses: []int{http.StatusNoContent},
+			wantCalls: 1,
+		},
+		{
+			name:      "retry on 502",
+			responses: []int{http.StatusBadGateway, http.StatusNoContent},
+			wantCalls: 2,
+		},
+		{
+			name:        "already revoked",
+			responses:   []int{http.StatusUnauthorized},
+			wantCalls:   1,
+			errContains: "invalid, expired, or already revoked",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callCount := 0
+			mock := &mockAppsService{
+				revokeInstallationToken: func(ctx context.Context) (*github.Response, error) {
+					status := tt.responses[callCount]
+					callCount++
+					resp := &github.Response{Response: &http.Response{StatusCode: status}}
+					if status >= http.StatusBadRequest {
+						return resp, fmt.Errorf("status %d", status)
+					}
+					return resp, nil
+				},
+			}
+
+			err := RevokeInstallationToken(ctx, mock, "")
+
+			if tt.errContains == "" {
+				if err != nil {
+					t.Errorf("RevokeInstallationToken() unexpected error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("RevokeInstallationToken() error = %v, want containing %q", err, tt.errContains)
+			}
+
+			if callCount != tt.wantCalls {
+				t.Errorf("expected %d calls, got %d", tt.wantCalls, callCount)
+			}
+		})
+	}
+}
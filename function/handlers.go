@@ -3,179 +3,489 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
+	"net/url"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/google/go-github/v90/github"
 )
 
 // TokenResponse is the successful response format.
 type TokenResponse struct {
-	Token     string            `json:"token"`
-	ExpiresAt string            `json:"expires_at"`
-	Scopes    map[string]string `json:"scopes"`
+	Token         string            `json:"token"`
+	ExpiresAt     string            `json:"expires_at"`
+	Scopes        map[string]string `json:"scopes"`
+	Profile       string            `json:"profile,omitempty"`
+	DroppedScopes []DroppedScope    `json:"dropped_scopes,omitempty"`
+	// installationID is the installation the token was issued for, recorded in the audit log.
+	installationID int64
 }
 
-// ErrorResponse is the error response format.
-type ErrorResponse struct {
-	Error   string                 `json:"error"`
-	Details map[string]interface{} `json:"details,omitempty"`
+// reservedParams are the POST /token query parameters that are options rather than scope IDs.
+var reservedParams = map[string]bool{
+	optionalScopesParam: true,
+	profileParam:        true,
+	maxTTLParam:         true,
+	dryRunParam:         true,
 }
 
-// TokenHandler handles POST /token requests.
-func TokenHandler(w http.ResponseWriter, r *http.Request) {
-	// Enforce /token path
-	if r.URL.Path != "/token" {
-		writeError(w, http.StatusNotFound, "not found", nil)
-		return
+// NewTokenHandler returns the HTTP entry point serving the configuration. It routes POST /token,
+// POST /token/revoke, GET /capabilities, POST /webhook, GET /healthz, GET /readyz, and GET /keys requests.
+func NewTokenHandler(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			handleIssueToken(config, w, r)
+		case "/token/revoke":
+			handleRevokeToken(config, w, r)
+		case "/capabilities":
+			handleCapabilities(config, w, r)
+		case "/webhook":
+			handleWebhook(config, w, r)
+		case "/healthz":
+			handleHealth(w, r)
+		case "/readyz":
+			handleReadiness(config, w, r)
+		case "/keys":
+			handleKeyRotation(config, w, r)
+		default:
+			writeError(w, NewError(CodeNotFound, "not found"))
+		}
 	}
+}
 
+// handleIssueToken handles POST /token requests.
+func handleIssueToken(config *Config, w http.ResponseWriter, r *http.Request) {
 	// Only allow POST method
 	if r.Method != http.MethodPost {
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		writeError(w, NewError(CodeMethodNotAllowed, "method not allowed"))
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
 	defer cancel()
 
-	// Extract GitHub OIDC token from Authorization header (Bearer token)
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		writeError(w, http.StatusUnauthorized, "missing Authorization header", nil)
+	// Report the stage durations if enabled
+	var timings *StageTimings
+	if config.StageTimings {
+		timings = NewStageTimings()
+		w = &timingResponseWriter{ResponseWriter: w, timings: timings}
+	}
+
+	// Record the decision in the audit log and the metrics, if enabled
+	query := r.URL.Query()
+	event := newAuditEvent(query, time.Now())
+	defer metrics.RecordTokenRequest(event)
+	fail := func(typedErr *Error) {
+		event.fail(typedErr)
+		_ = config.AuditLog.Record(ctx, event)
+		writeError(w, typedErr)
+	}
+
+	dryRun, typedErr := ParseDryRun(query)
+	if typedErr != nil {
+		fail(typedErr)
 		return
 	}
 
-	const bearerPrefix = "Bearer "
-	if !strings.HasPrefix(authHeader, bearerPrefix) {
-		writeError(w, http.StatusUnauthorized, "invalid Authorization header format (expected 'Bearer <token>')", nil)
+	if dryRun {
+		// Dry runs require a valid OIDC token too, so the policy is only explained to workflows
+		identity, typedErr := verifyCaller(ctx, config, r)
+		if typedErr != nil {
+			fail(typedErr)
+			return
+		}
+		event.setIdentity(identity)
+		response, typedErr := explainTokenRequest(ctx, config, query, identity)
+		if typedErr != nil {
+			fail(typedErr)
+			return
+		}
+		event.Profile = response.Profile
+		event.explain(response)
+		_ = config.AuditLog.Record(ctx, event)
+		writeJSON(w, http.StatusOK, response)
 		return
 	}
-	oidcToken := strings.TrimPrefix(authHeader, bearerPrefix)
-	if oidcToken == "" {
-		writeError(w, http.StatusUnauthorized, "empty token in Authorization header", nil)
+
+	// Authenticate the caller while the installation lookup runs ahead
+	identity, prefetched, typedErr := authenticateAndPrefetch(ctx, config, r, query, timings)
+	if typedErr != nil {
+		fail(typedErr)
 		return
 	}
+	event.setIdentity(identity)
 
-	// Validate OIDC token and extract repository and owner account ID
-	repository, ownerID, err := ValidateAndExtractIdentity(ctx, oidcToken)
-	if err != nil {
-		writeError(w, http.StatusUnauthorized, fmt.Sprintf("invalid OIDC token: %v", err), nil)
+	req, typedErr := parseTokenRequest(config, query, identity, nil)
+	if typedErr != nil {
+		fail(typedErr)
 		return
 	}
+	event.Profile = req.profile
+	event.App = req.app.Name
+	req.timings = timings
+	if prefetched.matches(req) {
+		req.lookup = &prefetched.lookup
+	}
 
-	// Validate repository owner is allowed (if GITHUB_ALLOWED_OWNER_IDS is configured)
-	allowedOwnerIDs, err := ParseAllowedOwnerIDs()
+	// Issue the token, reusing a still-valid token issued for an identical request of the same workflow run
+	var response TokenResponse
+	var err error
+	var issued atomic.Bool
+	if minReuseValidity := config.TokenReuseMinValidity; minReuseValidity > 0 && identity.RunID != 0 {
+		response, err = tokenCache.Issue(ctx, req.cacheKey(), minReuseValidity, func(ctx context.Context) (TokenResponse, error) {
+			issued.Store(true)
+			return issueToken(ctx, req)
+		})
+	} else {
+		issued.Store(true)
+		response, err = issueToken(ctx, req)
+	}
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		fail(AsError(err, CodeInternalError, "%w"))
 		return
 	}
-	if err := ValidateOwnerIDAllowed(ownerID, allowedOwnerIDs); err != nil {
-		writeError(w, http.StatusForbidden, err.Error(), nil)
+	response.Profile = req.profile
+	event.issue(response, !issued.Load())
+
+	// Never hand out a token whose issuance isn't in the audit log
+	if err := config.AuditLog.Record(ctx, event); err != nil {
+		withdrawToken(ctx, req.app.BaseURL, response.Token)
+		typedErr := NewError(CodeInternalError, "%w", err)
+		event.fail(typedErr)
+		writeError(w, typedErr)
 		return
 	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// parseTokenRequest checks the caller against the owner allowlist and parses and validates the
+// scopes and options of a POST /token request. Each check is recorded in trace, which may be nil.
+func parseTokenRequest(config *Config, query url.Values, identity Identity, trace *DecisionTrace) (tokenRequest, *Error) {
+	repository := identity.Repository
 
-	// Parse scopes from query parameters
+	// Validate repository owner is allowed (if GITHUB_ALLOWED_OWNER_IDS is configured)
+	if typedErr := checkOwnerAllowed(config, identity.OwnerID); typedErr != nil {
+		return tokenRequest{}, trace.Fail(checkOwnerAllowlist, typedErr)
+	}
+	if len(config.AllowedOwnerIDs) > 0 {
+		trace.Pass(checkOwnerAllowlist, "repository owner ID %d is allowed", identity.OwnerID)
+	} else {
+		trace.Pass(checkOwnerAllowlist, "no owner allowlist is configured")
+	}
+
+	// Parse scopes and reserved options from query parameters
 	scopes := make(map[string]string)
-	for param, values := range r.URL.Query() {
+	options := make(map[string]string)
+	for param, values := range query {
+		if reservedParams[param] {
+			if len(values) > 1 {
+				return tokenRequest{}, trace.Fail(checkRequestParameters, NewError(CodeDuplicateParameter, "duplicate parameter '%s' in request", param))
+			}
+			options[param] = values[0]
+			continue
+		}
+
 		if len(values) > 1 {
-			writeError(w, http.StatusBadRequest, fmt.Sprintf("duplicate scope '%s' in request", param), nil)
-			return
+			return tokenRequest{}, trace.Fail(checkRequestParameters, NewError(CodeDuplicateScope, "duplicate scope '%s' in request", param).WithDetails(map[string]interface{}{"scope": param}))
 		}
 		permission := values[0]
 
 		// Validate permission value
 		if permission != "read" && permission != "write" {
-			writeError(w,
-				http.StatusBadRequest,
-				fmt.Sprintf("invalid permission '%s' for scope '%s' (must be 'read' or 'write')", permission, param),
-				nil)
-			return
+			return tokenRequest{}, trace.Fail(checkRequestParameters, NewError(CodeInvalidPermission, "invalid permission '%s' for scope '%s' (must be 'read' or 'write')", permission, param))
 		}
 
 		scopes[param] = permission
 	}
 
+	// Parse requested maximum token lifetime
+	var requestedTTL time.Duration
+	if value, exists := options[maxTTLParam]; exists {
+		var err error
+		requestedTTL, err = ParseTokenTTL(value)
+		if err != nil {
+			return tokenRequest{}, trace.Fail(checkRequestParameters, NewError(CodeInvalidTTL, "%w", err))
+		}
+	}
+	trace.Pass(checkRequestParameters, "%d scopes requested explicitly", len(scopes))
+
+	// Add scopes from the requested scope profile
+	profileName := options[profileParam]
+	if profileName == "" {
+		trace.Skip(checkScopeProfile, "no scope profile requested")
+	} else {
+		profile, err := LookupProfile(config.ScopeProfiles, profileName)
+		if err != nil {
+			return tokenRequest{}, trace.Fail(checkScopeProfile, AsError(err, CodeInvalidRequest, "%w"))
+		}
+		if err := ValidateProfileAllowed(profileName, profile, repository, identity.OwnerID); err != nil {
+			return tokenRequest{}, trace.Fail(checkScopeProfile, AsError(err, CodeInvalidRequest, "%w"))
+		}
+		if err := MergeProfileScopes(scopes, profileName, profile); err != nil {
+			return tokenRequest{}, trace.Fail(checkScopeProfile, AsError(err, CodeInvalidRequest, "%w"))
+		}
+		trace.Pass(checkScopeProfile, "scope profile '%s' adds %d scopes", profileName, len(profile.Scopes))
+	}
+
 	// Require at least one scope
 	if len(scopes) == 0 {
-		writeError(w, http.StatusBadRequest, "at least one scope is required", nil)
-		return
+		return tokenRequest{}, trace.Fail(checkScopePolicy, NewError(CodeNoScopes, "at least one scope is required"))
 	}
 
-	// Validate scopes
-	if err := ValidateScopes(scopes); err != nil {
-		writeError(w, http.StatusBadRequest, err.Error(), nil)
-		return
+	// Parse optional scopes (best-effort mode)
+	optional, err := ParseOptionalScopes(options[optionalScopesParam], scopes)
+	if err != nil {
+		return tokenRequest{}, trace.Fail(checkScopePolicy, NewError(CodeInvalidOptionalScopes, "%w", err))
 	}
+	bestEffort := len(optional) > 0
 
-	// Get GitHub App ID from environment (validated at startup in main.go)
-	appID := os.Getenv("GITHUB_APP_ID")
+	// Validate scopes; in best-effort mode, optional scopes failing validation are dropped
+	var dropped []DroppedScope
+	if bestEffort {
+		scopes, dropped, err = FilterScopesByPolicy(scopes, optional)
+		if err != nil {
+			return tokenRequest{}, trace.Fail(checkScopePolicy, AsError(err, CodeInvalidRequest, "%w"))
+		}
+	} else if err := ValidateScopes(scopes); err != nil {
+		return tokenRequest{}, trace.Fail(checkScopePolicy, AsError(err, CodeInvalidRequest, "%w"))
+	}
+	if len(dropped) > 0 {
+		trace.Pass(checkScopePolicy, "%d scopes are allowed, %d optional scopes are dropped", len(scopes), len(dropped))
+	} else {
+		trace.Pass(checkScopePolicy, "all %d scopes are allowed", len(scopes))
+	}
 
-	// Get GCP project ID from environment
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	if projectID == "" {
-		writeError(w, http.StatusInternalServerError, "GCP project ID not configured", nil)
-		return
+	// Apply token lifetime policy
+	ttl := EffectiveTokenTTL(requestedTTL, config.MaxTokenTTL)
+	if ttl > 0 {
+		trace.Pass(checkTokenLifetime, "token is revoked after at most %s", ttl)
+	} else {
+		trace.Pass(checkTokenLifetime, "token expires after GitHub's default lifetime of 1 hour")
 	}
 
-	// Fetch private key from Secret Manager
-	privateKey, err := GetPrivateKey(ctx, projectID)
+	// Pick the GitHub App by the routing rules
+	app, err := ResolveApp(config.Apps, identity, scopes)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error(), nil)
-		return
+		return tokenRequest{}, trace.Fail(checkAppRouting, AsError(err, CodeNoMatchingApp, "%w"))
 	}
+	trace.Pass(checkAppRouting, "GitHub App '%s' (app ID %s) is used", app.Name, app.AppID)
 
-	// Create JWT for GitHub App authentication
-	jwtToken, err := CreateJWT(privateKey, appID)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create JWT: %v", err), nil)
-		return
+	return tokenRequest{
+		identity:      identity,
+		app:           app,
+		profile:       profileName,
+		scopes:        scopes,
+		optional:      optional,
+		dropped:       dropped,
+		ttl:           ttl,
+		runRevocation: config.RunRevocation,
+	}, nil
+}
+
+// tokenRequest is a validated POST /token request.
+type tokenRequest struct {
+	identity Identity
+	// app is the GitHub App picked by the routing rules.
+	app GitHubApp
+	// profile is the name of the requested scope profile, if any.
+	profile string
+	// scopes are the requested scopes that passed validation.
+	scopes map[string]string
+	// optional are the optional scope IDs (best-effort mode).
+	optional map[string]bool
+	// dropped are the optional scopes already dropped by validation.
+	dropped []DroppedScope
+	// ttl is the effective maximum token lifetime (0 for GitHub's default).
+	ttl           time.Duration
+	runRevocation bool
+	// lookup is the installation lookup made ahead of the caller's authentication, if any.
+	lookup *installationLookup
+	// timings records the stage durations, if enabled.
+	timings *StageTimings
+}
+
+// issueToken creates an installation token for a validated request and schedules its revocation.
+// Errors that map to a specific error response are returned as *Error.
+func issueToken(ctx context.Context, req tokenRequest) (TokenResponse, error) {
+	repository := req.identity.Repository
+
+	// Authenticate as the GitHub App and get its installation for the repository, unless done ahead
+	lookup := req.lookup
+	if lookup == nil {
+		result := lookupInstallation(ctx, req)
+		lookup = &result
 	}
 
-	// Create GitHub client with JWT
-	githubClient, err := NewGitHubClientWithJWT(jwtToken)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create GitHub client: %v", err), nil)
-		return
+	var token *github.InstallationToken
+	var scopes map[string]string
+	var dropped []DroppedScope
+	for {
+		if lookup.err != nil {
+			return TokenResponse{}, lookup.err
+		}
+		var err error
+		token, scopes, dropped, err = createToken(ctx, req, lookup)
+		if err == nil {
+			break
+		}
+		if !isStaleInstallationError(err) {
+			return TokenResponse{}, err
+		}
+		// The installation changed: forget it, and look it up again if it came from the cache
+		installationCache.Invalidate(req.app, repository)
+		if !lookup.cached {
+			return TokenResponse{}, err
+		}
+		result := lookupInstallation(ctx, req)
+		lookup = &result
 	}
 
-	// Get installation ID for repository
-	installationID, err := GetInstallationID(ctx, githubClient.Apps, repository)
+	// Record every issued token: revocation requests are checked against the repository it was issued for,
+	// and the maximum token lifetime and run binding are enforced by scheduling revocation before GitHub's expiry
+	issued := IssuedToken{
+		Fingerprint: TokenFingerprint(token.GetToken()),
+		Token:       token.GetToken(),
+		Repository:  repository,
+		BaseURL:     req.app.BaseURL,
+		RevokeAt:    token.GetExpiresAt().Time,
+		ExpiresAt:   token.GetExpiresAt().Time,
+	}
+	if req.ttl > 0 {
+		if revokeAt := time.Now().Add(req.ttl); revokeAt.Before(issued.ExpiresAt) {
+			issued.RevokeAt = revokeAt
+		}
+	}
+	if req.runRevocation && req.identity.RunID != 0 {
+		issued.RunID = req.identity.RunID
+		issued.RunAttempt = req.identity.RunAttempt
+	}
+	done := req.timings.Start(stageRevocation)
+	err := ScheduleRevocation(ctx, tokenStore, issued)
+	done()
 	if err != nil {
-		if strings.Contains(err.Error(), "not installed") {
-			writeError(w, http.StatusForbidden, err.Error(), nil)
-		} else {
-			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("GitHub API error: %v", err), nil)
+		// Never hand out a token whose lifetime or ownership can't be enforced
+		if tokenApps, clientErr := newTokenAppsService(req.app.BaseURL, token.GetToken()); clientErr == nil {
+			_ = RevokeInstallationToken(ctx, tokenApps, req.app.BaseURL)
+		}
+		return TokenResponse{}, NewError(CodeInternalError, "%w", err)
+	}
+
+	return TokenResponse{
+		Token:          token.GetToken(),
+		ExpiresAt:      issued.RevokeAt.Format(time.RFC3339),
+		Scopes:         scopes,
+		DroppedScopes:  dropped,
+		installationID: lookup.installation.GetID(),
+	}, nil
+}
+
+// createToken creates an installation token for a validated request on the looked up installation.
+// In best-effort mode, optional scopes the installation can't grant are dropped. It returns the token
+// with the granted and dropped scopes. Errors are returned as *Error.
+func createToken(ctx context.Context, req tokenRequest, lookup *installationLookup) (*github.InstallationToken, map[string]string, []DroppedScope, error) {
+	scopes := req.scopes
+	dropped := req.dropped
+	bestEffort := len(req.optional) > 0
+
+	// In best-effort mode, drop optional scopes the installation can't grant
+	if bestEffort {
+		var installationDropped []DroppedScope
+		scopes, installationDropped = FilterScopesByInstallation(scopes, req.optional, lookup.installation.GetPermissions())
+		dropped = append(slices.Clip(dropped), installationDropped...)
+		if len(scopes) == 0 {
+			return nil, nil, nil, NewError(CodeNoGrantableScopes, "none of the requested scopes can be granted").
+				WithDetails(map[string]interface{}{"dropped_scopes": dropped})
 		}
-		return
 	}
 
 	// Create installation token with requested scopes
-	token, err := CreateInstallationToken(ctx, githubClient.Apps, installationID, scopes)
+	defer req.timings.Start(stageToken)()
+	var token *github.InstallationToken
+	var err error
+	if bestEffort {
+		var grantDropped []DroppedScope
+		token, scopes, grantDropped, err = CreateInstallationTokenBestEffort(ctx, lookup.apps, req.app.BaseURL, lookup.installation.GetID(), scopes, req.optional)
+		dropped = append(dropped, grantDropped...)
+	} else {
+		token, err = CreateInstallationToken(ctx, lookup.apps, req.app.BaseURL, lookup.installation.GetID(), scopes)
+	}
 	if err != nil {
-		if strings.Contains(err.Error(), "insufficient permissions") ||
-			strings.Contains(err.Error(), "fewer scopes") ||
-			strings.Contains(err.Error(), "suspended") {
-			writeError(w, http.StatusForbidden, err.Error(), nil)
-		} else {
-			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("GitHub API error: %v", err), nil)
-		}
-		return
+		return nil, nil, nil, AsError(err, CodeGitHubUnavailable, "GitHub API error: %w")
 	}
+	return token, scopes, dropped, nil
+}
 
-	// Build response
-	response := TokenResponse{
-		Token:     token.GetToken(),
-		ExpiresAt: token.GetExpiresAt().Format(time.RFC3339),
-		Scopes:    scopes,
+// authenticateCaller validates the GitHub OIDC token from the Authorization header and checks
+// the repository owner against the allowlist. On failure it writes the error response and returns ok=false.
+func authenticateCaller(ctx context.Context, config *Config, w http.ResponseWriter, r *http.Request) (identity Identity, ok bool) {
+	identity, typedErr := verifyCaller(ctx, config, r)
+	if typedErr != nil {
+		writeError(w, typedErr)
+		return Identity{}, false
 	}
 
-	writeJSON(w, http.StatusOK, response)
+	// Validate repository owner is allowed (if GITHUB_ALLOWED_OWNER_IDS is configured)
+	if typedErr := checkOwnerAllowed(config, identity.OwnerID); typedErr != nil {
+		writeError(w, typedErr)
+		return Identity{}, false
+	}
+
+	return identity, true
+}
+
+// checkOwnerAllowed validates the repository owner account ID against GITHUB_ALLOWED_OWNER_IDS.
+func checkOwnerAllowed(config *Config, ownerID int64) *Error {
+	if err := ValidateOwnerIDAllowed(ownerID, config.AllowedOwnerIDs); err != nil {
+		return AsError(err, CodeOwnerNotAllowed, "%w")
+	}
+	return nil
+}
+
+// verifyCaller validates the GitHub OIDC token from the Authorization header and returns the caller identity.
+func verifyCaller(ctx context.Context, config *Config, r *http.Request) (Identity, *Error) {
+	oidcToken, typedErr := bearerToken(r)
+	if typedErr != nil {
+		return Identity{}, typedErr
+	}
+
+	// Validate OIDC token and extract repository, owner account ID, and workflow run
+	identity, err := ValidateAndExtractIdentity(ctx, oidcToken, TrustedIssuers(config.Apps))
+	if err != nil {
+		return Identity{}, AsError(err, CodeInvalidOIDCToken, "invalid OIDC token: %w")
+	}
+
+	return identity, nil
+}
+
+// bearerToken extracts the GitHub OIDC token from the Authorization header (Bearer token).
+func bearerToken(r *http.Request) (string, *Error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", NewError(CodeMissingAuthorization, "missing Authorization header")
+	}
+
+	const bearerPrefix = "Bearer "
+	if !strings.HasPrefix(authHeader, bearerPrefix) {
+		return "", NewError(CodeMissingAuthorization, "invalid Authorization header format (expected 'Bearer <token>')")
+	}
+	oidcToken := strings.TrimPrefix(authHeader, bearerPrefix)
+	if oidcToken == "" {
+		return "", NewError(CodeMissingAuthorization, "empty token in Authorization header")
+	}
+	return oidcToken, nil
 }
 
 // writeJSON writes a JSON response.
 func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	writeJSONAs(w, "application/json", statusCode, data)
+}
+
+// writeJSONAs writes a JSON response with the given content type.
+func writeJSONAs(w http.ResponseWriter, contentType string, statusCode int, data interface{}) {
 	jsonBytes, err := json.Marshal(data)
 	if err != nil {
 		w.Header().Set("Content-Type", "text/plain")
@@ -184,16 +494,7 @@ func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(statusCode)
 	_, _ = w.Write(jsonBytes)
 }
-
-// writeError writes a JSON error response.
-func writeError(w http.ResponseWriter, statusCode int, message string, details map[string]interface{}) {
-	response := ErrorResponse{
-		Error:   message,
-		Details: details,
-	}
-	writeJSON(w, statusCode, response)
-}
@@ -20,7 +20,7 @@ func TestTokenHandler_InvalidPath(t *testing.T) {
 			req := httptest.NewRequest(http.MethodPost, path, nil)
 			w := httptest.NewRecorder()
 
-			TokenHandler(w, req)
+			NewTokenHandler(&Config{})(w, req)
 
 			if w.Code != http.StatusNotFound {
 				t.Errorf("TokenHandler() status = %v, want %v", w.Code, http.StatusNotFound)
@@ -48,7 +48,7 @@ func TestTokenHandler_MethodNotAllowed(t *testing.T) {
 			req := httptest.NewRequest(method, "/token", nil)
 			w := httptest.NewRecorder()
 
-			TokenHandler(w, req)
+			NewTokenHandler(&Config{})(w, req)
 
 			if w.Code != http.StatusMethodNotAllowed {
 				t.Errorf("TokenHandler() status = %v, want %v", w.Code, http.StatusMethodNotAllowed)
@@ -71,7 +71,7 @@ func TestTokenHandler_MissingAuthorizationHeader(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/token?contents=read", nil)
 	w := httptest.NewRecorder()
 
-	TokenHandler(w, req)
+	NewTokenHandler(&Config{})(w, req)
 
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("TokenHandler() status = %v, want %v", w.Code, http.StatusUnauthorized)
@@ -103,7 +103,7 @@ func TestTokenHandler_InvalidOIDCToken(t *testing.T) {
 			req.Header.Set("Authorization", "Bearer "+tt.token)
 			w := httptest.NewRecorder()
 
-			TokenHandler(w, req)
+			NewTokenHandler(&Config{})(w, req)
 
 			if w.Code != http.StatusUnauthorized {
 				t.Errorf("TokenHandler() status = %v, want %v", w.Code, http.StatusUnauthorized)
@@ -121,16 +121,15 @@ func TestTokenHandler_InvalidOIDCToken(t *testing.T) {
 	}
 }
 
-// TestTokenHandler_ContentTypeHeader tests that all responses have JSON content type.
+// TestTokenHandler_ContentTypeHeader tests that error responses have the problem details content type.
 func TestTokenHandler_ContentTypeHeader(t *testing.T) {
-	// Test that even error responses have JSON content type
 	req := httptest.NewRequest(http.MethodGet, "/token", nil)
 	w := httptest.NewRecorder()
 
-	TokenHandler(w, req)
+	NewTokenHandler(&Config{})(w, req)
 
-	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
-		t.Errorf("TokenHandler() Content-Type = %v, want application/json", contentType)
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Errorf("TokenHandler() Content-Type = %v, want application/problem+json", contentType)
 	}
 }
 
@@ -151,18 +150,23 @@ func TestWriteJSON(t *testing.T) {
 			wantBody:       `{"token":"ghs_xxx","expires_at":"2024-01-01T00:00:00Z","scopes":{"contents":"read"}}`,
 		},
 		{
-			name:           "error response",
-			statusCode:     http.StatusBadRequest,
-			data:           ErrorResponse{Error: "bad request", Details: nil},
-			wantStatusCode: http.StatusBadRequest,
-			wantBody:       `{"error":"bad request"}`,
+			name:       "success response with dropped scopes",
+			statusCode: http.StatusOK,
+			data: TokenResponse{
+				Token:         "ghs_xxx",
+				ExpiresAt:     "2024-01-01T00:00:00Z",
+				Scopes:        map[string]string{"contents": "read"},
+				DroppedScopes: []DroppedScope{{Scope: "workflows", Permission: "write", Reason: "GitHub App installation has no access to this scope"}},
+			},
+			wantStatusCode: http.StatusOK,
+			wantBody:       `{"token":"ghs_xxx","expires_at":"2024-01-01T00:00:00Z","scopes":{"contents":"read"},"dropped_scopes":[{"scope":"workflows","permission":"write","reason":"GitHub App installation has no access to this scope"}]}`,
 		},
 		{
-			name:           "error response with details",
-			statusCode:     http.StatusForbidden,
-			data:           ErrorResponse{Error: "forbidden", Details: map[string]interface{}{"scope": "contents"}},
-			wantStatusCode: http.StatusForbidden,
-			wantBody:       `{"error":"forbidden","details":{"scope":"contents"}}`,
+			name:           "map",
+			statusCode:     http.StatusServiceUnavailable,
+			data:           map[string]interface{}{"status": "degraded"},
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantBody:       `{"status":"degraded"}`,
 		},
 		{
 			name:           "empty object",
@@ -225,83 +229,3 @@ func TestWriteJSON_UnmarshalableData(t *testing.T) {
 		t.Errorf("writeJSON() body = %v, want containing 'failed to encode response'", w.Body.String())
 	}
 }
-
-// TestWriteError tests error response writing with various status codes.
-// It verifies correct JSON error format with optional details.
-//
-// Test steps:
-//  1. Create response recorder
-//  2. Call writeError with message, status code, and optional details
-//  3. Verify response status matches expected
-//  4. Verify Content-Type header is application/json
-//  5. Verify response body matches expected JSON error format
-func TestWriteError(t *testing.T) {
-	tests := []struct {
-		name       string
-		statusCode int
-		message    string
-		details    map[string]interface{}
-		wantBody   string
-	}{
-		{
-			name:       "simple error",
-			statusCode: http.StatusBadRequest,
-			message:    "bad request",
-			details:    nil,
-			wantBody:   `{"error":"bad request"}`,
-		},
-		{
-			name:       "error with details",
-			statusCode: http.StatusForbidden,
-			message:    "insufficient permissions",
-			details:    map[string]interface{}{"missing": []string{"contents", "issues"}},
-			wantBody:   `{"error":"insufficient permissions","details":{"missing":["contents","issues"]}}`,
-		},
-		{
-			name:       "internal server error",
-			statusCode: http.StatusInternalServerError,
-			message:    "internal error",
-			details:    nil,
-			wantBody:   `{"error":"internal error"}`,
-		},
-		{
-			name:       "service unavailable",
-			statusCode: http.StatusServiceUnavailable,
-			message:    "GitHub API unavailable",
-			details:    nil,
-			wantBody:   `{"error":"GitHub API unavailable"}`,
-		},
-		{
-			name:       "empty details map is omitted due to omitempty",
-			statusCode: http.StatusBadRequest,
-			message:    "error",
-			details:    map[string]interface{}{},
-			wantBody:   `{"error":"error"}`,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Step 1: Create response recorder
-			w := httptest.NewRecorder()
-
-			// Step 2: Call writeError
-			writeError(w, tt.statusCode, tt.message, tt.details)
-
-			// Step 3: Verify status code
-			if w.Code != tt.statusCode {
-				t.Errorf("writeError() status = %v, want %v", w.Code, tt.statusCode)
-			}
-
-			// Step 4: Verify Content-Type header
-			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
-				t.Errorf("writeError() Content-Type = %v, want application/json", contentType)
-			}
-
-			// Step 5: Verify response body
-			if strings.TrimSpace(w.Body.String()) != tt.wantBody {
-				t.Errorf("writeError() body = %v, want %v", w.Body.String(), tt.wantBody)
-			}
-		})
-	}
-}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readinessTimeout bounds the dependency checks of GET /readyz.
+const readinessTimeout = 5 * time.Second
+
+// readinessCacheTTL is how long the report of GET /readyz is reused: the dependencies are checked at
+// most this often per instance, whatever the rate of probes and callers.
+const readinessCacheTTL = 10 * time.Second
+
+// Results of a dependency check of GET /readyz.
+const (
+	dependencyOK      = "ok"
+	dependencyPending = "pending"
+	dependencyFailed  = "failed"
+)
+
+// HealthResponse is the response of GET /healthz.
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// DependencyCheck is the result of a dependency check of GET /readyz.
+type DependencyCheck struct {
+	// Status is "ok", "pending" (not checked yet), or "failed".
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// UpdatedAt is when the checked data was last loaded, if it is cached.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// ReadinessResponse is the response of GET /readyz.
+type ReadinessResponse struct {
+	// Status is "ok", "degraded" while a circuit breaker is open, or "unavailable" while a dependency
+	// check is failed or pending.
+	Status string `json:"status"`
+	// Checks are the dependency checks: config, jwks, private_keys, and github.
+	Checks          map[string]DependencyCheck `json:"checks"`
+	CircuitBreakers map[string]BreakerStatus   `json:"circuit_breakers"`
+	// SelfCheck is the latest self-check of the GitHub Apps, once it finished.
+	SelfCheck *SelfCheckReport `json:"self_check,omitempty"`
+}
+
+// handleHealth handles GET /healthz requests: process liveness. It checks no dependencies, so a
+// liveness probe never restarts instances because of a dependency outage.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, NewError(CodeMethodNotAllowed, "method not allowed"))
+		return
+	}
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// handleReadiness handles GET /readyz requests: it checks that the instance can issue tokens (config,
+// GitHub's JWKS, the private keys of the GitHub Apps, and GitHub through the latest self-check) and
+// reports the state of the circuit breakers.
+//
+// It responds 503 while a check is failed or pending, so startup probes and uptime checks tell a broken
+// deployment from a healthy one. An open breaker only degrades it (200), so instances keep receiving the
+// traffic whose probe calls close the breaker again.
+//
+// Anonymous callers only get the outcome ("ok" or "unavailable"). The report, with the errors, the
+// breakers, and the GitHub Apps, is returned to workflows of the admin repositories. Reports are reused
+// for readinessCacheTTL, so callers can't make the instance load keys or call GitHub at their rate.
+func handleReadiness(config *Config, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, NewError(CodeMethodNotAllowed, "method not allowed"))
+		return
+	}
+
+	if r.Header.Get("Authorization") == "" {
+		statusCode, _ := cachedReadiness(config)
+		status := "ok"
+		if statusCode != http.StatusOK {
+			status = "unavailable"
+		}
+		writeJSON(w, statusCode, HealthResponse{Status: status})
+		return
+	}
+
+	if typedErr := authorizeAdmin(r.Context(), config, r); typedErr != nil {
+		writeError(w, typedErr)
+		return
+	}
+	statusCode, response := cachedReadiness(config)
+	writeJSON(w, statusCode, response)
+}
+
+// readinessCache holds the latest readiness report of the instance.
+var readinessCache struct {
+	mu         sync.Mutex
+	statusCode int
+	response   ReadinessResponse
+	checkedAt  time.Time
+}
+
+// cachedReadiness returns the readiness report, checking the dependencies again if it is older than
+// readinessCacheTTL. Concurrent callers wait for a single check, which isn't canceled with their requests.
+func cachedReadiness(config *Config) (int, ReadinessResponse) {
+	readinessCache.mu.Lock()
+	defer readinessCache.mu.Unlock()
+
+	if readinessCache.checkedAt.IsZero() || time.Since(readinessCache.checkedAt) >= readinessCacheTTL {
+		ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+		defer cancel()
+		readinessCache.statusCode, readinessCache.response = checkReadiness(ctx, config)
+		readinessCache.checkedAt = time.Now()
+	}
+	return readinessCache.statusCode, readinessCache.response
+}
+
+// checkReadiness checks the dependencies and the circuit breakers, and returns the status code and
+// report of GET /readyz.
+func checkReadiness(ctx context.Context, config *Config) (int, ReadinessResponse) {
+	response := ReadinessResponse{
+		Status: "ok",
+		Checks: map[string]DependencyCheck{
+			// The service doesn't start with an invalid configuration
+			"config":       {Status: dependencyOK, Detail: fmt.Sprintf("%d GitHub Apps", len(config.Apps))},
+			"jwks":         checkJWKS(ctx, config.Apps),
+			"private_keys": checkPrivateKeys(ctx, config.Apps),
+		},
+		CircuitBreakers: make(map[string]BreakerStatus),
+	}
+	response.SelfCheck = latestSelfCheck()
+	response.Checks["github"] = checkGitHub(response.SelfCheck)
+
+	// GitHub's and the JWKS' breakers are reported per host of the GitHub Apps
+	breakers := append(githubBreakers.ForApps(config.Apps), jwksIssuers.ForApps(config.Apps)...)
+	for _, breaker := range append(breakers, circuitBreakers...) {
+		status := breaker.Status()
+		if status.State != BreakerClosed.String() {
+			response.Status = "degraded"
+		}
+		response.CircuitBreakers[breaker.name] = status
+	}
+
+	statusCode := http.StatusOK
+	for _, check := range response.Checks {
+		if check.Status != dependencyOK {
+			response.Status = "unavailable"
+			statusCode = http.StatusServiceUnavailable
+		}
+	}
+	return statusCode, response
+}
+
+// checkJWKS checks that the JWKS of the OIDC issuer of every GitHub host of the apps is cached,
+// fetching them if they aren't (or are outdated). UpdatedAt is when the oldest was fetched.
+func checkJWKS(ctx context.Context, apps []GitHubApp) DependencyCheck {
+	var failures []string
+	var keys int
+	var fetchedAt time.Time
+	for _, issuer := range TrustedIssuers(apps) {
+		jwks, issuerFetchedAt, err := jwksIssuers.Fetch(ctx, issuer)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", issuer, err))
+			continue
+		}
+		keys += len(jwks.Keys)
+		if fetchedAt.IsZero() || issuerFetchedAt.Before(fetchedAt) {
+			fetchedAt = issuerFetchedAt
+		}
+	}
+	if len(failures) > 0 {
+		return DependencyCheck{Status: dependencyFailed, Error: strings.Join(failures, "; ")}
+	}
+	return DependencyCheck{Status: dependencyOK, Detail: fmt.Sprintf("%d keys", keys), UpdatedAt: &fetchedAt}
+}
+
+// checkPrivateKeys checks that every GitHub App can sign an App JWT. Keys and JWTs are cached, so
+// this only loads keys whose cache expired.
+func checkPrivateKeys(ctx context.Context, apps []GitHubApp) DependencyCheck {
+	var failures []string
+	for _, app := range apps {
+		if _, err := newJWTAppsService(ctx, app); err != nil {
+			failures = append(failures, fmt.Sprintf("GitHub App '%s': %v", app.Name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return DependencyCheck{Status: dependencyFailed, Error: strings.Join(failures, "; ")}
+	}
+	return DependencyCheck{Status: dependencyOK, Detail: fmt.Sprintf("%d GitHub Apps can sign App JWTs", len(apps))}
+}
+
+// checkGitHub reports whether GitHub accepted the App JWT of every GitHub App in the latest self-check.
+func checkGitHub(report *SelfCheckReport) DependencyCheck {
+	if report == nil {
+		return DependencyCheck{Status: dependencyPending, Detail: "the self-check hasn't finished yet"}
+	}
+	checkedAt := report.CheckedAt
+	var failures []string
+	for _, name := range slices.Sorted(maps.Keys(report.Apps)) {
+		if check := report.Apps[name]; check.Error != "" {
+			failures = append(failures, fmt.Sprintf("GitHub App '%s': %s", name, check.Error))
+		}
+	}
+	if len(failures) > 0 {
+		return DependencyCheck{Status: dependencyFailed, Error: strings.Join(failures, "; "), UpdatedAt: &checkedAt}
+	}
+	return DependencyCheck{Status: dependencyOK, Detail: "GET /app succeeded for every GitHub App", UpdatedAt: &checkedAt}
+}
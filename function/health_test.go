@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-github/v90/github"
+)
+
+// useReadinessState replaces the circuit breakers and the latest self-check, and empties the
+// readiness cache, for the duration of the test.
+func useReadinessState(t *testing.T, breakers []*CircuitBreaker, report *SelfCheckReport) {
+	t.Helper()
+	originalBreakers, originalReport := circuitBreakers, selfCheck.report
+	resetReadinessCache := func() {
+		readinessCache.mu.Lock()
+		readinessCache.checkedAt = time.Time{}
+		readinessCache.mu.Unlock()
+	}
+	t.Cleanup(func() {
+		circuitBreakers = originalBreakers
+		selfCheck.report = originalReport
+		resetReadinessCache()
+	})
+	circuitBreakers = breakers
+	selfCheck.report = report
+	resetReadinessCache()
+}
+
+// getReadiness checks the readiness and returns the status code and report of GET /readyz.
+func getReadiness(t *testing.T, config *Config) (int, ReadinessResponse) {
+	t.Helper()
+	return checkReadiness(t.Context(), config)
+}
+
+// sendReadiness sends GET /readyz with the OIDC token, if any, and returns the response.
+func sendReadiness(t *testing.T, config *Config, oidcToken string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	if oidcToken != "" {
+		req.Header.Set("Authorization", "Bearer "+oidcToken)
+	}
+	rec := httptest.NewRecorder()
+	NewTokenHandler(config)(rec, req)
+	return rec
+}
+
+// TestHealthHandler tests that GET /healthz reports liveness without checking dependencies.
+func TestHealthHandler(t *testing.T) {
+	useReadinessState(t, nil, nil)
+
+	rec := httptest.NewRecorder()
+	NewTokenHandler(&Config{})(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"ok"`) {
+		t.Errorf("GET /healthz = %d %s, want 200 ok", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	NewTokenHandler(&Config{})(rec, httptest.NewRequest(http.MethodPost, "/healthz", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /healthz status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// TestReadinessHandler tests that GET /readyz reports the state of the circuit breakers.
+func TestReadinessHandler(t *testing.T) {
+	useTestJWKS(t, generateTestRSAKey(t))
+	breaker, _ := newTestBreaker()
+	useReadinessState(t, []*CircuitBreaker{breaker}, &SelfCheckReport{CheckedAt: time.Now()})
+
+	if status, response := getReadiness(t, &Config{}); status != http.StatusOK || response.Status != "ok" || response.CircuitBreakers["test"].State != "closed" {
+		t.Errorf("response = %d %+v, want ok with closed breaker", status, response)
+	}
+
+	for range 4 {
+		record(t, breaker, true)
+	}
+	if status, response := getReadiness(t, &Config{}); status != http.StatusOK || response.Status != "degraded" || response.CircuitBreakers["test"].State != "open" {
+		t.Errorf("response = %d %+v, want degraded (200) with open breaker", status, response)
+	}
+
+	rec := httptest.NewRecorder()
+	NewTokenHandler(&Config{})(rec, httptest.NewRequest(http.MethodPost, "/readyz", strings.NewReader("")))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /readyz status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// TestReadinessHandler_Checks tests the dependency checks of GET /readyz.
+//
+// Test steps:
+//  1. Set up the JWKS, the private key mock, and the latest self-check of the case
+//  2. Send GET /readyz
+//  3. Verify the status code, the overall status, and the status of the failing check
+func TestReadinessHandler_Checks(t *testing.T) {
+	config := &Config{Apps: []GitHubApp{{Name: "default", AppID: "123"}}}
+	passed := &SelfCheckReport{CheckedAt: time.Now(), Apps: map[string]AppCheck{"default": {AppID: "123"}}}
+	failed := &SelfCheckReport{CheckedAt: time.Now(), Apps: map[string]AppCheck{"default": {AppID: "123", Error: "GET /app failed: 401 Bad credentials"}}}
+
+	tests := []struct {
+		name       string
+		jwks       bool
+		keyErr     error
+		report     *SelfCheckReport
+		wantStatus int
+		wantCheck  string
+	}{
+		{name: "ready", jwks: true, report: passed, wantStatus: http.StatusOK},
+		{name: "self-check pending", jwks: true, wantStatus: http.StatusServiceUnavailable, wantCheck: "github"},
+		{name: "self-check failed", jwks: true, report: failed, wantStatus: http.StatusServiceUnavailable, wantCheck: "github"},
+		{name: "private key unavailable", jwks: true, keyErr: errors.New("secret not found"), report: passed, wantStatus: http.StatusServiceUnavailable, wantCheck: "private_keys"},
+		{name: "JWKS unavailable", report: passed, wantStatus: http.StatusServiceUnavailable, wantCheck: "jwks"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Dependencies
+			useReadinessState(t, nil, tt.report)
+			if tt.jwks {
+				useTestJWKS(t, generateTestRSAKey(t))
+			} else {
+				useUnreachableJWKS(t)
+			}
+			mockGetApp(t, &github.App{ID: github.Ptr(int64(123))}, http.StatusOK, tt.keyErr)
+
+			// Step 2: GET /readyz
+			status, response := getReadiness(t, config)
+
+			// Step 3: Verify the result
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d (response: %+v)", status, tt.wantStatus, response)
+			}
+			for name, check := range response.Checks {
+				if wantOK := name != tt.wantCheck; (check.Status == dependencyOK) != wantOK {
+					t.Errorf("check %s = %+v, want ok: %v", name, check, wantOK)
+				}
+			}
+			if tt.wantCheck != "" && response.Status != "unavailable" {
+				t.Errorf("response status = %s, want unavailable", response.Status)
+			}
+		})
+	}
+}
+
+// TestReadinessHandler_Access tests that anonymous GET /readyz requests only get the outcome, and
+// that the report is returned to workflows of the admin repositories only.
+//
+// Test steps:
+//  1. Set up a ready instance with admin repository 7001
+//  2. Send GET /readyz anonymously, as the admin repository, and as another repository
+//  3. Verify the status code and body of each
+func TestReadinessHandler_Access(t *testing.T) {
+	// Step 1: A ready instance
+	key := generateTestRSAKey(t)
+	useTestJWKS(t, key)
+	useReadinessState(t, nil, &SelfCheckReport{CheckedAt: time.Now(), Apps: map[string]AppCheck{"default": {AppID: "123"}}})
+	mockGetApp(t, &github.App{ID: github.Ptr(int64(123))}, http.StatusOK, nil)
+	config := &Config{
+		Apps:              []GitHubApp{{Name: "default", AppID: "123"}},
+		AdminRepositories: []AdminRepository{{Issuer: githubOIDCIssuer, ID: 7001}},
+	}
+
+	// Step 2 and 3: Anonymous callers get the outcome only
+	rec := sendReadiness(t, config, "")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"status":"ok"}` {
+		t.Errorf("anonymous GET /readyz = %d %s, want 200 with the status only", rec.Code, rec.Body.String())
+	}
+
+	// The admin repository gets the report
+	rec = sendReadiness(t, config, signTestOIDCToken(t, key, nil))
+	var response ReadinessResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusOK || response.Checks["private_keys"].Status != dependencyOK {
+		t.Errorf("admin GET /readyz = %d %s, want 200 with the report", rec.Code, rec.Body.String())
+	}
+
+	// Other repositories get neither
+	rec = sendReadiness(t, config, signTestOIDCToken(t, key, jwt.MapClaims{"repository_id": "7002"}))
+	if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "checks") {
+		t.Errorf("non-admin GET /readyz = %d %s, want 403 without the report", rec.Code, rec.Body.String())
+	}
+}
+
+// TestReadinessHandler_Cache tests that GET /readyz checks the dependencies at most once per
+// readinessCacheTTL, and reports a failure to anonymous callers as unavailable.
+//
+// Test steps:
+//  1. Set up an instance whose private key can't be loaded, counting the loads
+//  2. Send GET /readyz several times
+//  3. Verify every response is 503 unavailable and the key was loaded once
+func TestReadinessHandler_Cache(t *testing.T) {
+	// Step 1: A failing private key
+	useTestJWKS(t, generateTestRSAKey(t))
+	useReadinessState(t, nil, &SelfCheckReport{CheckedAt: time.Now()})
+	var loads atomic.Int32
+	original := newJWTAppsService
+	t.Cleanup(func() { newJWTAppsService = original })
+	newJWTAppsService = func(ctx context.Context, _ GitHubApp) (GitHubAppsService, error) {
+		loads.Add(1)
+		return nil, errors.New("secret not found")
+	}
+	config := &Config{Apps: []GitHubApp{{Name: "default", AppID: "123"}}}
+
+	// Step 2: Probe repeatedly
+	for range 5 {
+		rec := sendReadiness(t, config, "")
+
+		// Step 3: Verify the responses and the loads
+		if rec.Code != http.StatusServiceUnavailable || strings.TrimSpace(rec.Body.String()) != `{"status":"unavailable"}` {
+			t.Errorf("GET /readyz = %d %s, want 503 unavailable without details", rec.Code, rec.Body.String())
+		}
+	}
+	if got := loads.Load(); got != 1 {
+		t.Errorf("private key loaded %d times, want 1", got)
+	}
+}
+
+// useUnreachableJWKS empties the JWKS cache and opens the JWKS circuit breaker for the duration of
+// the test, so fetching the JWKS fails without calling GitHub.
+func useUnreachableJWKS(t *testing.T) {
+	t.Helper()
+	breaker, _ := newTestBreaker()
+	for range 4 {
+		record(t, breaker, true)
+	}
+	issuer := jwksIssuers.issuer(githubOIDCIssuer)
+	issuer.mu.Lock()
+	originalJWKS, originalBreaker := issuer.jwks, issuer.breaker
+	issuer.jwks, issuer.breaker = nil, breaker
+	issuer.mu.Unlock()
+	t.Cleanup(func() {
+		issuer.mu.Lock()
+		issuer.jwks, issuer.breaker = originalJWKS, originalBreaker
+		issuer.mu.Unlock()
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// defaultInstallationCacheTTL is how long repository installations are cached by default.
+const defaultInstallationCacheTTL = 10 * time.Minute
+
+// installationCache is the process-wide cache of GitHub App installations by repository.
+var installationCache = NewInstallationCache(defaultInstallationCacheTTL)
+
+// ParseInstallationCacheTTL parses the GITHUB_INSTALLATION_CACHE_TTL environment variable: how long
+// the installation of a repository is cached before it is looked up again ("0" looks it up on every
+// request). Returns defaultInstallationCacheTTL if not set.
+func ParseInstallationCacheTTL() (time.Duration, error) {
+	envValue := strings.TrimSpace(configValue("GITHUB_INSTALLATION_CACHE_TTL"))
+	if envValue == "" {
+		return defaultInstallationCacheTTL, nil
+	}
+	ttl, err := time.ParseDuration(envValue)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GITHUB_INSTALLATION_CACHE_TTL %q: %w", envValue, err)
+	}
+	if ttl < 0 {
+		return 0, fmt.Errorf("GITHUB_INSTALLATION_CACHE_TTL %s must not be negative", ttl)
+	}
+	return ttl, nil
+}
+
+// installationKey identifies the installation of a GitHub App on a repository.
+type installationKey struct {
+	appID      string
+	baseURL    string
+	repository string
+}
+
+// cachedInstallation is a cached installation and when it was looked up.
+type cachedInstallation struct {
+	installation *github.Installation
+	cachedAt     time.Time
+}
+
+// InstallationCache caches the GitHub App installation (ID and granted permissions) of repositories.
+// Only found installations are cached. It is safe for concurrent use.
+type InstallationCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[installationKey]cachedInstallation
+	now     func() time.Time
+}
+
+// NewInstallationCache creates an empty installation cache.
+func NewInstallationCache(ttl time.Duration) *InstallationCache {
+	return &InstallationCache{ttl: ttl, entries: make(map[installationKey]cachedInstallation), now: time.Now}
+}
+
+// Configure sets the TTL of cached installations.
+func (c *InstallationCache) Configure(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// key returns the cache key of the app's installation on the repository.
+func (c *InstallationCache) key(app GitHubApp, repository string) installationKey {
+	return installationKey{appID: app.AppID, baseURL: app.BaseURL, repository: strings.ToLower(repository)}
+}
+
+// Get returns the cached installation of the app on the repository, if it is cached and its TTL hasn't passed.
+func (c *InstallationCache) Get(app GitHubApp, repository string) (*github.Installation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := c.key(app, repository)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if c.now().Sub(entry.cachedAt) >= c.ttl {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.installation, true
+}
+
+// Put caches the installation of the app on the repository.
+func (c *InstallationCache) Put(app GitHubApp, repository string, installation *github.Installation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	c.entries[c.key(app, repository)] = cachedInstallation{installation: installation, cachedAt: c.now()}
+}
+
+// Invalidate removes the cached installation of the app on the repository.
+func (c *InstallationCache) Invalidate(app GitHubApp, repository string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, c.key(app, repository))
+}
+
+// InvalidateInstallation removes all cached repositories of the installation.
+func (c *InstallationCache) InvalidateInstallation(installationID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.installation.GetID() == installationID {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// GetCachedInstallation finds the installation of the app for the repository like GetInstallation,
+// from installationCache if possible. cached reports whether it came from the cache.
+func GetCachedInstallation(ctx context.Context, app GitHubApp, apps GitHubAppsService, repository string) (installation *github.Installation, cached bool, err error) {
+	if installation, ok := installationCache.Get(app, repository); ok {
+		return installation, true, nil
+	}
+	installation, err = GetInstallation(ctx, apps, app.BaseURL, repository)
+	if err != nil {
+		return nil, false, err
+	}
+	installationCache.Put(app, repository, installation)
+	return installation, false, nil
+}
+
+// isStaleInstallationError reports whether creating an installation token failed in a way that
+// suggests the installation changed: it was deleted (404), its credentials were rejected (401), or it
+// was suspended or lost permissions (422).
+func isStaleInstallationError(err error) bool {
+	var typedErr *Error
+	if errors.As(err, &typedErr) && typedErr.Code == CodeInstallationSuspended {
+		return true
+	}
+	var errorResponse *github.ErrorResponse
+	if errors.As(err, &errorResponse) && errorResponse.Response != nil {
+		switch errorResponse.Response.StatusCode {
+		case http.StatusNotFound, http.StatusUnauthorized, http.StatusUnprocessableEntity:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// useEmptyInstallationCache replaces installationCache with an empty cache for the duration of the test.
+func useEmptyInstallationCache(t *testing.T) {
+	t.Helper()
+	original := installationCache
+	t.Cleanup(func() { installationCache = original })
+	installationCache = NewInstallationCache(defaultInstallationCacheTTL)
+}
+
+// TestParseInstallationCacheTTL tests parsing of the GITHUB_INSTALLATION_CACHE_TTL environment variable.
+func TestParseInstallationCacheTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		want     time.Duration
+		wantErr  bool
+	}{
+		{name: "default", envValue: "", want: defaultInstallationCacheTTL},
+		{name: "custom", envValue: "1h", want: time.Hour},
+		{name: "disabled", envValue: "0", want: 0},
+		{name: "negative", envValue: "-1m", wantErr: true},
+		{name: "invalid", envValue: "forever", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_INSTALLATION_CACHE_TTL", tt.envValue)
+			got, err := ParseInstallationCacheTTL()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseInstallationCacheTTL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseInstallationCacheTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestInstallationCache tests caching installations per app and repository, their TTL, and invalidation.
+//
+// Test steps:
+//  1. Cache an installation and verify it is found case-insensitively, but not for another app
+//  2. Advance past the TTL and verify it is gone
+//  3. Invalidate by repository and by installation ID
+//  4. Verify nothing is cached with a zero TTL
+func TestInstallationCache(t *testing.T) {
+	cache := NewInstallationCache(time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	app := GitHubApp{Name: "default", AppID: "123"}
+	installation := &github.Installation{ID: github.Ptr(int64(7))}
+
+	// Step 1: Cached per app and repository
+	cache.Put(app, "Owner/Repo", installation)
+	if got, ok := cache.Get(app, "owner/repo"); !ok || got.GetID() != 7 {
+		t.Errorf("Get() = %v, %v, want installation 7", got, ok)
+	}
+	if _, ok := cache.Get(GitHubApp{Name: "other", AppID: "456"}, "owner/repo"); ok {
+		t.Errorf("Get() of another app = true, want false")
+	}
+
+	// Step 2: Expired after the TTL
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get(app, "owner/repo"); ok {
+		t.Errorf("Get() after TTL = true, want false")
+	}
+
+	// Step 3: Invalidation
+	cache.Put(app, "owner/repo", installation)
+	cache.Invalidate(app, "OWNER/repo")
+	if _, ok := cache.Get(app, "owner/repo"); ok {
+		t.Errorf("Get() after Invalidate() = true, want false")
+	}
+	cache.Put(app, "owner/one", installation)
+	cache.Put(app, "owner/two", installation)
+	cache.Put(app, "other/repo", &github.Installation{ID: github.Ptr(int64(8))})
+	cache.InvalidateInstallation(7)
+	_, one := cache.Get(app, "owner/one")
+	_, two := cache.Get(app, "owner/two")
+	_, other := cache.Get(app, "other/repo")
+	if one || two || !other {
+		t.Errorf("after InvalidateInstallation(7): owner/one %v, owner/two %v, other/repo %v, want only other/repo", one, two, other)
+	}
+
+	// Step 4: Disabled
+	cache.Configure(0)
+	cache.Put(app, "owner/disabled", installation)
+	if _, ok := cache.Get(app, "owner/disabled"); ok {
+		t.Errorf("Get() with a zero TTL = true, want false")
+	}
+}
+
+// TestIsStaleInstallationError tests which token creation errors invalidate the cached installation.
+func TestIsStaleInstallationError(t *testing.T) {
+	githubError := func(status int) error {
+		return fmt.Errorf("failed to create installation token: %w", &github.ErrorResponse{Response: &http.Response{StatusCode: status}})
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "not found", err: githubError(http.StatusNotFound), want: true},
+		{name: "unauthorized", err: githubError(http.StatusUnauthorized), want: true},
+		{name: "suspended", err: NewError(CodeInstallationSuspended, "suspended"), want: true},
+		{name: "forbidden", err: NewError(CodeInsufficientPermissions, "insufficient permissions"), want: false},
+		{name: "server error", err: githubError(http.StatusBadGateway), want: false},
+		{name: "network error", err: errors.New("connection reset"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isStaleInstallationError(tt.err); got != tt.want {
+				t.Errorf("isStaleInstallationError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIssueToken_StaleCachedInstallation tests that a token request for a cached installation that
+// no longer exists looks the installation up again and succeeds.
+//
+// Test steps:
+//  1. Cache a deleted installation and mock GitHub with the new installation
+//  2. Issue a token
+//  3. Verify the token was created for the new installation, which is now cached
+func TestIssueToken_StaleCachedInstallation(t *testing.T) {
+	useFastRetryPolicy(t)
+	useEmptyInstallationCache(t)
+	app := GitHubApp{Name: "default", AppID: "123"}
+
+	// Step 1: Stale cache and GitHub mock
+	installationCache.Put(app, "owner/repo", &github.Installation{ID: github.Ptr(int64(1))})
+	var created []int64
+	original := newJWTAppsService
+	t.Cleanup(func() { newJWTAppsService = original })
+	newJWTAppsService = func(ctx context.Context, app GitHubApp) (GitHubAppsService, error) {
+		return &mockAppsService{
+			findRepoInstallation: func(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error) {
+				return &github.Installation{ID: github.Ptr(int64(2))}, &github.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+			},
+			createInstallationToken: func(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error) {
+				created = append(created, id)
+				if id != 2 {
+					resp := &github.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}
+					return nil, resp, &github.ErrorResponse{Response: resp.Response}
+				}
+				return &github.InstallationToken{
+					Token:       github.Ptr("ghs_test"),
+					ExpiresAt:   &github.Timestamp{Time: time.Now().Add(time.Hour)},
+					Permissions: &github.InstallationPermissions{Contents: github.Ptr("read")},
+				}, &github.Response{Response: &http.Response{StatusCode: http.StatusCreated}}, nil
+			},
+		}, nil
+	}
+
+	// Step 2: Issue a token
+	req := tokenRequest{identity: Identity{Repository: "owner/repo"}, app: app, scopes: map[string]string{"contents": "read"}}
+	response, err := issueToken(context.Background(), req)
+
+	// Step 3: Verify the new installation was used and cached
+	if err != nil {
+		t.Fatalf("issueToken() unexpected error = %v", err)
+	}
+	if response.Token != "ghs_test" || len(created) != 2 || created[0] != 1 || created[1] != 2 {
+		t.Errorf("token created for installations %v, want [1 2]", created)
+	}
+	if cached, ok := installationCache.Get(app, "owner/repo"); !ok || cached.GetID() != 2 {
+		t.Errorf("cached installation = %v, want 2", cached.GetID())
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultPrivateKeyCacheTTL is how long loaded private keys are cached by default.
+const defaultPrivateKeyCacheTTL = 5 * time.Minute
+
+// privateKeyCache is the process-wide cache of parsed GitHub App private keys.
+var privateKeyCache = NewKeyCache(defaultPrivateKeyCacheTTL)
+
+// ParsePrivateKeyCacheTTL parses the GITHUB_APP_PRIVATE_KEY_CACHE_TTL environment variable: how long
+// loaded private keys are cached before they are loaded again from their key source ("0" loads them
+// on every request). Returns defaultPrivateKeyCacheTTL if not set.
+func ParsePrivateKeyCacheTTL() (time.Duration, error) {
+	envValue := strings.TrimSpace(configValue("GITHUB_APP_PRIVATE_KEY_CACHE_TTL"))
+	if envValue == "" {
+		return defaultPrivateKeyCacheTTL, nil
+	}
+	ttl, err := time.ParseDuration(envValue)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GITHUB_APP_PRIVATE_KEY_CACHE_TTL %q: %w", envValue, err)
+	}
+	if ttl < 0 {
+		return 0, fmt.Errorf("GITHUB_APP_PRIVATE_KEY_CACHE_TTL %s must not be negative", ttl)
+	}
+	return ttl, nil
+}
+
+// cachedKeys are the private key versions loaded from a key source.
+type cachedKeys struct {
+	// versions are the active key versions, newest first.
+	versions []PrivateKeyVersion
+	// active is the index of the version in use: the newest version GitHub hasn't rejected.
+	active   int
+	loadedAt time.Time
+}
+
+// KeyCache caches the parsed private keys of key sources and tracks the key version in use.
+// Keys are loaded again once the TTL passes, which also retries versions GitHub rejected.
+// It is safe for concurrent use.
+type KeyCache struct {
+	group singleflight.Group
+
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*cachedKeys
+	now     func() time.Time
+}
+
+// NewKeyCache creates an empty key cache.
+func NewKeyCache(ttl time.Duration) *KeyCache {
+	return &KeyCache{ttl: ttl, entries: make(map[string]*cachedKeys), now: time.Now}
+}
+
+// Configure sets the TTL of cached keys.
+func (c *KeyCache) Configure(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// Active returns the key version in use for the key source, loading the keys if they aren't
+// cached or their TTL passed. If loading fails, previously loaded keys keep being used.
+func (c *KeyCache) Active(ctx context.Context, source KeySource) (PrivateKeyVersion, error) {
+	key := source.String()
+
+	c.mu.Lock()
+	if entry := c.entries[key]; entry != nil && c.now().Sub(entry.loadedAt) < c.ttl {
+		version := entry.versions[entry.active]
+		c.mu.Unlock()
+		return version, nil
+	}
+	c.mu.Unlock()
+
+	result, err, _ := c.group.Do(key, func() (interface{}, error) {
+		versions, err := GetPrivateKeys(ctx, source)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err != nil {
+			if entry := c.entries[key]; entry != nil {
+				return entry.versions[entry.active], nil
+			}
+			return nil, err
+		}
+		c.entries[key] = &cachedKeys{versions: versions, loadedAt: c.now()}
+		return versions[0], nil
+	})
+	if err != nil {
+		return PrivateKeyVersion{}, err
+	}
+	return result.(PrivateKeyVersion), nil
+}
+
+// Reject records that GitHub rejected a JWT signed with the key version, and returns the version
+// to use instead. It returns false if there is no older version to fall back to.
+func (c *KeyCache) Reject(source KeySource, version string) (PrivateKeyVersion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entries[source.String()]
+	if entry == nil {
+		return PrivateKeyVersion{}, false
+	}
+	for i, candidate := range entry.versions {
+		if candidate.Version != version {
+			continue
+		}
+		if i+1 >= len(entry.versions) {
+			return PrivateKeyVersion{}, false
+		}
+		entry.active = max(entry.active, i+1)
+		return entry.versions[entry.active], true
+	}
+	return PrivateKeyVersion{}, false
+}
+
+// KeyRotationStatus describes the private key versions of a GitHub App.
+type KeyRotationStatus struct {
+	// Remote reports a remote signer: the private key isn't loaded by the service.
+	Remote bool `json:"remote,omitempty"`
+	// Loaded reports whether the keys are cached. They are loaded by the first request using them.
+	Loaded bool `json:"loaded"`
+	// Versions are the active key versions, newest first.
+	Versions []string `json:"versions,omitempty"`
+	// Active is the version JWTs are signed with.
+	Active string `json:"active,omitempty"`
+	// Rejected are the newer versions GitHub rejected until the keys are loaded again.
+	Rejected  []string   `json:"rejected,omitempty"`
+	LoadedAt  *time.Time `json:"loaded_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Status returns the cached key versions of the key source.
+func (c *KeyCache) Status(source KeySource) KeyRotationStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var status KeyRotationStatus
+	entry := c.entries[source.String()]
+	if entry == nil {
+		return status
+	}
+	status.Loaded = true
+	for i, version := range entry.versions {
+		status.Versions = append(status.Versions, version.Version)
+		if i < entry.active {
+			status.Rejected = append(status.Rejected, version.Version)
+		}
+	}
+	status.Active = entry.versions[entry.active].Version
+	loadedAt, expiresAt := entry.loadedAt, entry.loadedAt.Add(c.ttl)
+	status.LoadedAt, status.ExpiresAt = &loadedAt, &expiresAt
+	return status
+}
+
+// KeyRotationResponse is the response of GET /keys.
+type KeyRotationResponse struct {
+	Apps map[string]KeyRotationStatus `json:"apps"`
+}
+
+// handleKeyRotation handles GET /keys requests of admin repositories: it reports, for every GitHub
+// App, the private key versions loaded by this instance and the version JWTs are signed with. Neither
+// key material nor key locations are returned, and no keys are loaded.
+func handleKeyRotation(config *Config, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, NewError(CodeMethodNotAllowed, "method not allowed"))
+		return
+	}
+	if typedErr := authorizeAdmin(r.Context(), config, r); typedErr != nil {
+		writeError(w, typedErr)
+		return
+	}
+
+	response := KeyRotationResponse{Apps: make(map[string]KeyRotationStatus, len(config.Apps))}
+	for _, app := range config.Apps {
+		signer, ok := app.signer.(*privateKeySigner)
+		if !ok {
+			response.Apps[app.Name] = KeyRotationStatus{Remote: true}
+			continue
+		}
+		response.Apps[app.Name] = privateKeyCache.Status(signer.source)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeKeySource is a KeySource returning a fixed payload or error, counting loads.
+type fakeKeySource struct {
+	name    string
+	payload []byte
+	err     error
+	loads   int
+}
+
+func (s *fakeKeySource) LoadKey(ctx context.Context) ([]byte, error) {
+	s.loads++
+	return s.payload, s.err
+}
+
+func (s *fakeKeySource) String() string {
+	return s.name
+}
+
+// TestParsePrivateKeyCacheTTL tests parsing of the GITHUB_APP_PRIVATE_KEY_CACHE_TTL environment variable.
+func TestParsePrivateKeyCacheTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		want     time.Duration
+		wantErr  bool
+	}{
+		{name: "default", envValue: "", want: defaultPrivateKeyCacheTTL},
+		{name: "custom", envValue: "1h", want: time.Hour},
+		{name: "disabled", envValue: "0", want: 0},
+		{name: "negative", envValue: "-1m", wantErr: true},
+		{name: "invalid", envValue: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_APP_PRIVATE_KEY_CACHE_TTL", tt.envValue)
+			got, err := ParsePrivateKeyCacheTTL()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePrivateKeyCacheTTL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePrivateKeyCacheTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestKeyCache tests caching of private keys, falling back to older versions, and reloading after the TTL.
+//
+// Test steps:
+//  1. Load a key source with two key versions and verify the newest is active and cached
+//  2. Reject the newest version and verify the older one becomes active, with no further fallback
+//  3. Advance past the TTL and verify the keys are reloaded with the newest version active again
+//  4. Fail the reload and verify the cached keys keep being used
+func TestKeyCache(t *testing.T) {
+	source := &fakeKeySource{name: "test keys", payload: []byte(encodeTestKey(t) + encodeTestKey(t))}
+	cache := NewKeyCache(time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Step 1: Newest version is active and cached
+	for range 2 {
+		key, err := cache.Active(ctx, source)
+		if err != nil || key.Version != "1" {
+			t.Fatalf("Active() = %v, %v, want version 1", key.Version, err)
+		}
+	}
+	if source.loads != 1 {
+		t.Errorf("loads = %d, want 1 (cached)", source.loads)
+	}
+
+	// Step 2: Fall back to the older version
+	if key, ok := cache.Reject(source, "1"); !ok || key.Version != "2" {
+		t.Fatalf("Reject(1) = %v, %v, want version 2", key.Version, ok)
+	}
+	if key, _ := cache.Active(ctx, source); key.Version != "2" {
+		t.Errorf("Active() = %v, want version 2", key.Version)
+	}
+	if _, ok := cache.Reject(source, "2"); ok {
+		t.Errorf("Reject(2) = true, want no older version")
+	}
+	status := cache.Status(source)
+	if !status.Loaded || status.Active != "2" || !slices.Equal(status.Versions, []string{"1", "2"}) || !slices.Equal(status.Rejected, []string{"1"}) {
+		t.Errorf("Status() = %+v, want versions [1 2], active 2, rejected [1]", status)
+	}
+
+	// Step 3: Reload after the TTL
+	now = now.Add(time.Minute)
+	if key, _ := cache.Active(ctx, source); key.Version != "1" || source.loads != 2 {
+		t.Errorf("Active() after TTL = %v (loads %d), want version 1 reloaded", key.Version, source.loads)
+	}
+
+	// Step 4: Keep the cached keys if the reload fails
+	now = now.Add(time.Minute)
+	source.err = errors.New("unavailable")
+	if key, err := cache.Active(ctx, source); err != nil || key.Version != "1" {
+		t.Errorf("Active() with failing source = %v, %v, want cached version 1", key.Version, err)
+	}
+	if _, err := cache.Active(ctx, &fakeKeySource{name: "other", err: errors.New("unavailable")}); err == nil {
+		t.Errorf("Active() of an uncached failing source error = nil, want error")
+	}
+}
+
+// TestNewJWTAppsService_KeyFallback tests that GitHub App calls rejected with 401 are retried with
+// the previous private key version, and that the service keeps signing with it.
+//
+// Test steps:
+//  1. Start a fake GitHub API accepting only JWTs signed with the registered (older) key
+//  2. Get the repository installation with the newest key not registered yet
+//  3. Verify the call succeeds with the older key, which stays active
+func TestNewJWTAppsService_KeyFallback(t *testing.T) {
+	registered := generateTestRSAKey(t)
+	newest := encodeTestKey(t)
+	// The registered key is the older version
+	source := &fakeKeySource{name: t.Name(), payload: []byte(newest + encodePrivateKey(registered))}
+
+	// Step 1: Fake GitHub API
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		signed := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return &registered.PublicKey, nil }); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/repos/owner/repo/installation") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": 42})
+	}))
+	t.Cleanup(server.Close)
+	app := GitHubApp{Name: "default", AppID: "123", BaseURL: server.URL + "/", signer: &privateKeySigner{source: source}}
+
+	// Step 2: Get the installation
+	apps, err := newJWTAppsService(context.Background(), app)
+	if err != nil {
+		t.Fatalf("newJWTAppsService() unexpected error = %v", err)
+	}
+	installation, _, err := apps.GetRepositoryInstallation(context.Background(), "owner", "repo")
+
+	// Step 3: Verify the fallback
+	if err != nil || installation.GetID() != 42 {
+		t.Fatalf("GetRepositoryInstallation() = %v, %v, want installation 42", installation, err)
+	}
+	if calls != 2 {
+		t.Errorf("GitHub calls = %d, want 2 (rejected, then the older key)", calls)
+	}
+	if status := privateKeyCache.Status(source); status.Active != "2" {
+		t.Errorf("active version = %s, want 2", status.Active)
+	}
+}
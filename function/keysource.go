@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultVaultField is the field of a Vault KV secret holding the private key if the source names none.
+const defaultVaultField = "private_key"
+
+// KeySource loads the PEM-encoded private key of a GitHub App.
+type KeySource interface {
+	// LoadKey returns the PEM-encoded private key.
+	LoadKey(ctx context.Context) ([]byte, error)
+	// String describes the source for error messages. It never contains key material or credentials.
+	String() string
+}
+
+// KeyVersion is the PEM-encoded private key of a version of a key source.
+type KeyVersion struct {
+	Version string
+	PEM     []byte
+}
+
+// VersionedKeySource is a KeySource with several active key versions, so that a new key can be
+// added before it is registered on the GitHub App.
+type VersionedKeySource interface {
+	KeySource
+	// LoadKeyVersions returns the active key versions, newest first.
+	LoadKeyVersions(ctx context.Context) ([]KeyVersion, error)
+}
+
+// VaultSettings are the Vault server and credentials of the vault:// private key sources.
+type VaultSettings struct {
+	// Address is the Vault server URL (VAULT_ADDR).
+	Address string
+	// Namespace is the Vault Enterprise namespace (VAULT_NAMESPACE, optional).
+	Namespace string
+	// Token is the Vault token (VAULT_TOKEN).
+	Token string
+	// TokenFile is the file holding the Vault token if Token is empty (VAULT_TOKEN_FILE), e.g.
+	// written by the Vault Agent. It is read on every key load so renewed tokens are picked up.
+	TokenFile string
+}
+
+// ParseVaultSettings parses the VAULT_ADDR, VAULT_NAMESPACE, VAULT_TOKEN and VAULT_TOKEN_FILE
+// environment variables. They are only required if a private key is read from Vault.
+func ParseVaultSettings() VaultSettings {
+	return VaultSettings{
+		Address:   strings.TrimRight(strings.TrimSpace(configValue("VAULT_ADDR")), "/"),
+		Namespace: strings.TrimSpace(configValue("VAULT_NAMESPACE")),
+		Token:     strings.TrimSpace(configValue("VAULT_TOKEN")),
+		TokenFile: strings.TrimSpace(configValue("VAULT_TOKEN_FILE")),
+	}
+}
+
+// KeySourceSettings are the settings of the private key sources that read a secret store.
+type KeySourceSettings struct {
+	// ProjectID is the GCP project of the Secret Manager secrets (GOOGLE_CLOUD_PROJECT).
+	ProjectID string
+	// Vault is the Vault server of the Vault secrets.
+	Vault VaultSettings
+}
+
+// ParseKeySource parses a private key source. Supported sources:
+//
+//	secretmanager://<secret>[?version=<version>]          GCP Secret Manager secret (version defaults to latest)
+//	file://<path>                                          Local file or mounted secret volume
+//	env://<variable>                                       Environment variable
+//	vault://<mount>/<path>[?field=<field>&version=<n>]     HashiCorp Vault KV version 2 secret
+//
+// Unless a version is pinned, Secret Manager and Vault sources have two active versions: the latest
+// and the one before it (if it is still enabled). File and environment variable sources may hold
+// several PEM blocks, newest first.
+//
+// Secret Manager secrets are read from the project and Vault secrets from the Vault server of the settings.
+func ParseKeySource(source string, settings KeySourceSettings) (KeySource, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key source %q: %w", source, err)
+	}
+	query := u.Query()
+	name := strings.Trim(u.Host+u.Path, "/")
+
+	switch u.Scheme {
+	case "secretmanager":
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid private key source %q: expected secretmanager://<secret>", source)
+		}
+		version := query.Get("version")
+		if version == "" {
+			version = "latest"
+		} else if version != "latest" {
+			if n, err := strconv.Atoi(version); err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid private key source %q: version must be 'latest' or a positive number", source)
+			}
+		}
+		return &secretManagerKeySource{projectID: settings.ProjectID, secretID: name, version: version}, nil
+	case "file":
+		path := u.Host + u.Path
+		if path == "" {
+			return nil, fmt.Errorf("invalid private key source %q: expected file://<path>", source)
+		}
+		return &fileKeySource{path: path}, nil
+	case "env":
+		if name == "" {
+			return nil, fmt.Errorf("invalid private key source %q: expected env://<variable>", source)
+		}
+		return &envKeySource{name: name}, nil
+	case "vault":
+		mount, path, ok := strings.Cut(name, "/")
+		if !ok || mount == "" || path == "" {
+			return nil, fmt.Errorf("invalid private key source %q: expected vault://<mount>/<path>", source)
+		}
+		keySource := &vaultKeySource{vault: settings.Vault, mount: mount, path: path, field: query.Get("field")}
+		if keySource.field == "" {
+			keySource.field = defaultVaultField
+		}
+		if version := query.Get("version"); version != "" {
+			if keySource.version, err = strconv.Atoi(version); err != nil || keySource.version < 1 {
+				return nil, fmt.Errorf("invalid private key source %q: version must be a positive number", source)
+			}
+		}
+		return keySource, nil
+	default:
+		return nil, fmt.Errorf("invalid private key source %q: unsupported scheme (use secretmanager, file, env, or vault)", source)
+	}
+}
+
+// secretManagerKeySource loads the private key from a GCP Secret Manager secret version
+// of the GOOGLE_CLOUD_PROJECT project.
+type secretManagerKeySource struct {
+	projectID string
+	secretID  string
+	version   string
+}
+
+func (s *secretManagerKeySource) LoadKey(ctx context.Context) ([]byte, error) {
+	if s.projectID == "" {
+		return nil, NewError(CodeConfigurationError, "GCP project ID not configured")
+	}
+	return accessSecret(ctx, s.projectID, s.secretID, s.version)
+}
+
+// LoadKeyVersions returns the pinned version, or the latest version and the one before it
+// unless that one is disabled, destroyed, or can't be fetched.
+func (s *secretManagerKeySource) LoadKeyVersions(ctx context.Context) ([]KeyVersion, error) {
+	if s.projectID == "" {
+		return nil, NewError(CodeConfigurationError, "GCP project ID not configured")
+	}
+	payload, number, err := accessSecretVersion(ctx, s.projectID, s.secretID, s.version)
+	if err != nil {
+		return nil, err
+	}
+	versions := []KeyVersion{{Version: number, PEM: payload}}
+
+	if previous, err := strconv.Atoi(number); s.version == "latest" && err == nil && previous > 1 {
+		previousNumber := strconv.Itoa(previous - 1)
+		if payload, _, err := accessSecretVersion(ctx, s.projectID, s.secretID, previousNumber); err == nil {
+			versions = append(versions, KeyVersion{Version: previousNumber, PEM: payload})
+		}
+	}
+	return versions, nil
+}
+
+func (s *secretManagerKeySource) String() string {
+	return fmt.Sprintf("Secret Manager secret '%s' (version %s)", s.secretID, s.version)
+}
+
+// fileKeySource loads the private key from a file, such as a mounted secret volume.
+// The file is read on every load, so rotated keys are picked up without a restart.
+type fileKeySource struct {
+	path string
+}
+
+func (s *fileKeySource) LoadKey(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(s.path)
+}
+
+func (s *fileKeySource) String() string {
+	return fmt.Sprintf("file '%s'", s.path)
+}
+
+// envKeySource loads the private key from an environment variable. Escaped newlines ("\n")
+// are accepted for platforms that don't support multi-line values.
+type envKeySource struct {
+	name string
+}
+
+func (s *envKeySource) LoadKey(ctx context.Context) ([]byte, error) {
+	value := os.Getenv(s.name)
+	if value == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", s.name)
+	}
+	if !strings.Contains(value, "\n") {
+		value = strings.ReplaceAll(value, `\n`, "\n")
+	}
+	return []byte(value), nil
+}
+
+func (s *envKeySource) String() string {
+	return fmt.Sprintf("environment variable %s", s.name)
+}
+
+// vaultKeySource loads the private key from a field of a HashiCorp Vault KV version 2 secret.
+type vaultKeySource struct {
+	vault VaultSettings
+	mount string
+	path  string
+	field string
+	// version is the secret version, or 0 for the latest.
+	version int
+}
+
+// vaultKVResponse is the response of reading a Vault KV version 2 secret.
+type vaultKVResponse struct {
+	Data struct {
+		Data     map[string]interface{} `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+func (s *vaultKeySource) LoadKey(ctx context.Context) ([]byte, error) {
+	payload, _, err := s.readVersion(ctx, s.version)
+	return payload, err
+}
+
+// LoadKeyVersions returns the pinned version, or the latest version and the one before it
+// unless that one is deleted, destroyed, or can't be read.
+func (s *vaultKeySource) LoadKeyVersions(ctx context.Context) ([]KeyVersion, error) {
+	payload, version, err := s.readVersion(ctx, s.version)
+	if err != nil {
+		return nil, err
+	}
+	versions := []KeyVersion{{Version: strconv.Itoa(version), PEM: payload}}
+
+	if s.version == 0 && version > 1 {
+		if payload, previous, err := s.readVersion(ctx, version-1); err == nil {
+			versions = append(versions, KeyVersion{Version: strconv.Itoa(previous), PEM: payload})
+		}
+	}
+	return versions, nil
+}
+
+// readVersion reads the private key field of a version (0 for the latest) of the secret and
+// returns it with its version number.
+func (s *vaultKeySource) readVersion(ctx context.Context, version int) ([]byte, int, error) {
+	if s.vault.Address == "" {
+		return nil, 0, NewError(CodeConfigurationError, "VAULT_ADDR not configured")
+	}
+	token, err := s.vault.token()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	secretURL := fmt.Sprintf("%s/v1/%s/data/%s", s.vault.Address, s.mount, s.path)
+	if version > 0 {
+		secretURL += "?version=" + strconv.Itoa(version)
+	}
+
+	var secret vaultKVResponse
+	err = retryPolicy.Do(ctx, vaultBreaker.Guard(func() (bool, time.Duration, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretURL, nil)
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to create Vault request: %w", err)
+		}
+		req.Header.Set("X-Vault-Token", token)
+		if s.vault.Namespace != "" {
+			req.Header.Set("X-Vault-Namespace", s.vault.Namespace)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return true, 0, fmt.Errorf("failed to read Vault secret: %w", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		if resp.StatusCode != http.StatusOK {
+			retryable := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+			return retryable, 0, fmt.Errorf("failed to read Vault secret: status %d", resp.StatusCode)
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+			return false, 0, fmt.Errorf("failed to decode Vault response: %w", err)
+		}
+		return false, 0, nil
+	}))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	value, ok := secret.Data.Data[s.field].(string)
+	if !ok || value == "" {
+		return nil, 0, fmt.Errorf("field '%s' not found", s.field)
+	}
+	return []byte(value), secret.Data.Metadata.Version, nil
+}
+
+func (s *vaultKeySource) String() string {
+	return fmt.Sprintf("Vault secret '%s/%s'", s.mount, s.path)
+}
+
+// token returns the Vault token, or else the content of the token file.
+func (v VaultSettings) token() (string, error) {
+	if v.Token != "" {
+		return v.Token, nil
+	}
+	if v.TokenFile == "" {
+		return "", NewError(CodeConfigurationError, "VAULT_TOKEN or VAULT_TOKEN_FILE not configured")
+	}
+	token, err := os.ReadFile(v.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Vault token: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// encodePrivateKey returns the PEM encoding (PKCS1) of the key.
+func encodePrivateKey(key *rsa.PrivateKey) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+// encodeTestKey returns the PEM encoding (PKCS1) of a generated test RSA key.
+func encodeTestKey(t *testing.T) string {
+	t.Helper()
+	return encodePrivateKey(generateTestRSAKey(t))
+}
+
+// TestParseKeySource tests parsing of private key sources.
+func TestParseKeySource(t *testing.T) {
+	vault := VaultSettings{Address: "https://vault.example.com", Token: "test-token"}
+	settings := KeySourceSettings{ProjectID: "test-project", Vault: vault}
+	tests := []struct {
+		name        string
+		source      string
+		want        KeySource
+		errContains string
+	}{
+		{name: "secret manager latest", source: "secretmanager://github-app-private-key", want: &secretManagerKeySource{projectID: "test-project", secretID: "github-app-private-key", version: "latest"}},
+		{name: "secret manager pinned version", source: "secretmanager://github-app-private-key?version=3", want: &secretManagerKeySource{projectID: "test-project", secretID: "github-app-private-key", version: "3"}},
+		{name: "secret manager invalid version", source: "secretmanager://github-app-private-key?version=0", errContains: "version must be"},
+		{name: "secret manager without secret", source: "secretmanager://", errContains: "expected secretmanager://"},
+		{name: "absolute file", source: "file:///var/secrets/key.pem", want: &fileKeySource{path: "/var/secrets/key.pem"}},
+		{name: "relative file", source: "file://key.pem", want: &fileKeySource{path: "key.pem"}},
+		{name: "env", source: "env://GITHUB_APP_PRIVATE_KEY", want: &envKeySource{name: "GITHUB_APP_PRIVATE_KEY"}},
+		{name: "vault", source: "vault://secret/github/app", want: &vaultKeySource{vault: vault, mount: "secret", path: "github/app", field: "private_key"}},
+		{name: "vault with field and version", source: "vault://kv/github-app?field=pem&version=2", want: &vaultKeySource{vault: vault, mount: "kv", path: "github-app", field: "pem", version: 2}},
+		{name: "vault without path", source: "vault://secret", errContains: "expected vault://"},
+		{name: "vault invalid version", source: "vault://secret/app?version=latest", errContains: "version must be"},
+		{name: "unsupported scheme", source: "s3://bucket/key.pem", errContains: "unsupported scheme"},
+		{name: "no scheme", source: "github-app-private-key", errContains: "unsupported scheme"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKeySource(tt.source, settings)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseKeySource() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeySource() unexpected error = %v", err)
+			}
+			if got.String() != tt.want.String() {
+				t.Errorf("ParseKeySource() = %s, want %s", got, tt.want)
+			}
+			switch want := tt.want.(type) {
+			case *secretManagerKeySource:
+				if *got.(*secretManagerKeySource) != *want {
+					t.Errorf("ParseKeySource() = %+v, want %+v", got, want)
+				}
+			case *vaultKeySource:
+				if *got.(*vaultKeySource) != *want {
+					t.Errorf("ParseKeySource() = %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestGetPrivateKeys_FileAndEnv tests loading private keys from files and environment variables.
+func TestGetPrivateKeys_FileAndEnv(t *testing.T) {
+	keyPEM := encodeTestKey(t)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, []byte(keyPEM), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_PRIVATE_KEY", keyPEM)
+	t.Setenv("TEST_PRIVATE_KEY_ESCAPED", strings.ReplaceAll(keyPEM, "\n", `\n`))
+
+	tests := []struct {
+		name    string
+		source  string
+		wantErr bool
+	}{
+		{name: "file", source: "file://" + path},
+		{name: "env", source: "env://TEST_PRIVATE_KEY"},
+		{name: "env with escaped newlines", source: "env://TEST_PRIVATE_KEY_ESCAPED"},
+		{name: "missing file", source: "file://" + filepath.Join(t.TempDir(), "missing.pem"), wantErr: true},
+		{name: "unset env", source: "env://TEST_PRIVATE_KEY_UNSET", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := ParseKeySource(tt.source, KeySourceSettings{})
+			if err != nil {
+				t.Fatalf("ParseKeySource() unexpected error = %v", err)
+			}
+			versions, err := GetPrivateKeys(context.Background(), source)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("GetPrivateKeys() error = nil, want error")
+				}
+				return
+			}
+			if err != nil || len(versions) != 1 || versions[0].Key == nil {
+				t.Errorf("GetPrivateKeys() = %v, %v, want one key", versions, err)
+			}
+		})
+	}
+}
+
+// TestVaultKeySource tests reading the private key from a Vault KV version 2 secret.
+//
+// Test steps:
+//  1. Start a fake Vault server checking the token, namespace, path, and version
+//  2. Load the key from the source
+//  3. Verify the key is returned, or the expected error
+func TestVaultKeySource(t *testing.T) {
+	keyPEM := encodeTestKey(t)
+
+	// Step 1: Fake Vault server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/github-app" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if version := r.URL.Query().Get("version"); version != "" && version != "2" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"data": map[string]string{"private_key": keyPEM}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("test-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		source      string
+		token       string
+		tokenFile   string
+		errContains string
+	}{
+		{name: "latest version", source: "vault://secret/github-app", token: "test-token"},
+		{name: "pinned version", source: "vault://secret/github-app?version=2", token: "test-token"},
+		{name: "token from file", source: "vault://secret/github-app", tokenFile: tokenFile},
+		{name: "missing token", source: "vault://secret/github-app", errContains: "VAULT_TOKEN or VAULT_TOKEN_FILE"},
+		{name: "wrong token", source: "vault://secret/github-app", token: "other", errContains: "status 403"},
+		{name: "missing version", source: "vault://secret/github-app?version=3", token: "test-token", errContains: "status 404"},
+		{name: "missing field", source: "vault://secret/github-app?field=pem", token: "test-token", errContains: "field 'pem' not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := VaultSettings{Address: server.URL, Namespace: "team", Token: tt.token, TokenFile: tt.tokenFile}
+			source, err := ParseKeySource(tt.source, KeySourceSettings{Vault: vault})
+			if err != nil {
+				t.Fatalf("ParseKeySource() unexpected error = %v", err)
+			}
+
+			// Step 2: Load the key
+			got, err := source.LoadKey(context.Background())
+
+			// Step 3: Verify the result
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("LoadKey() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadKey() unexpected error = %v", err)
+			}
+			if string(got) != keyPEM {
+				t.Errorf("LoadKey() returned a different key")
+			}
+		})
+	}
+}
+
+// TestGetPrivateKeys_VaultVersions tests that an unpinned Vault source has the latest and the previous
+// key version, and that a previous version that can't be read is skipped.
+func TestGetPrivateKeys_VaultVersions(t *testing.T) {
+	versions := map[string]string{"": encodeTestKey(t), "2": encodeTestKey(t)}
+	deleted := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := r.URL.Query().Get("version")
+		if version == "2" && deleted {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]string{"private_key": versions[version]},
+				"metadata": map[string]int{"version": map[string]int{"": 3, "2": 2}[version]},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	vault := VaultSettings{Address: server.URL, Token: "test-token"}
+	source, err := ParseKeySource("vault://secret/github-app", KeySourceSettings{Vault: vault})
+	if err != nil {
+		t.Fatalf("ParseKeySource() unexpected error = %v", err)
+	}
+	for _, tt := range []struct {
+		deleted bool
+		want    []string
+	}{
+		{deleted: false, want: []string{"3", "2"}},
+		{deleted: true, want: []string{"3"}},
+	} {
+		deleted = tt.deleted
+		got, err := GetPrivateKeys(context.Background(), source)
+		if err != nil {
+			t.Fatalf("GetPrivateKeys() unexpected error = %v", err)
+		}
+		var labels []string
+		for _, version := range got {
+			labels = append(labels, version.Version)
+		}
+		if strings.Join(labels, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("GetPrivateKeys() versions = %v, want %v (previous deleted: %v)", labels, tt.want, tt.deleted)
+		}
+	}
+}
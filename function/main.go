@@ -1,28 +1,137 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
 
 func main() {
-	// Validate required environment variables at startup
-	if os.Getenv("GITHUB_APP_ID") == "" {
+	// Load and validate the configuration at startup
+	config, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
 		os.Exit(1)
 	}
+	retryPolicy = config.RetryPolicy
+	privateKeyCache.Configure(config.PrivateKeyCacheTTL)
+	installationCache.Configure(config.InstallationCacheTTL)
+	githubBreakers.Configure(config.BreakerSettings)
+	jwksIssuers.Configure(config.BreakerSettings)
+	for _, breaker := range circuitBreakers {
+		breaker.Configure(config.BreakerSettings)
+	}
+	if metrics, err = NewMetrics(context.Background(), config.Metrics); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set up metrics: %v\n", err)
+		os.Exit(1)
+	}
+	if err := serveMetrics(config.Metrics); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to serve metrics: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Revoke tokens once their maximum lifetime passes
+	StartRevocationScheduler(context.Background(), tokenStore)
+
+	// Check the GitHub Apps on startup and every SELF_CHECK_INTERVAL
+	StartSelfCheck(context.Background(), config.Apps, config.SelfCheckInterval)
+
+	if config.Server.Mode == serverModeStandalone {
+		if err := serveStandalone(config); err != nil {
+			fmt.Fprintf(os.Stderr, "server failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	requests := &RequestTracker{}
+	go shutdownOnSignal(config, requests)
 
 	// Register HTTP function
-	functions.HTTP("TokenHandler", TokenHandler)
+	functions.HTTP("TokenHandler", requests.Track(NewTokenHandler(config)))
 
 	// Start the Functions Framework
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if err := funcframework.Start(config.Server.Port); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start server: %v\n", err)
+		os.Exit(1)
 	}
+}
 
-	if err := funcframework.Start(port); err != nil {
-		os.Exit(1)
+// serveMetrics serves the Prometheus scrape endpoint on its own port in the background, if enabled.
+func serveMetrics(settings MetricsSettings) error {
+	if !settings.Prometheus {
+		return nil
+	}
+	server := NewMetricsServer(settings.PrometheusPort)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", server.Addr, err)
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			fmt.Fprintf(os.Stderr, "metrics server failed: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// serveStandalone serves the handler with a net/http server until SIGTERM, then drains in-flight
+// requests, revokes the pending tokens with a maximum lifetime, and delivers the queued audit events
+// and the pending metrics within the shutdown timeout.
+func serveStandalone(config *Config) error {
+	server, err := NewServer(config.Server, NewTokenHandler(config))
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", server.Addr, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	shutdownCtx, cancel := shutdownContext(ctx, config.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Tokens issued by in-flight requests are revoked too, so drain them first
+	serveErr := Serve(ctx, shutdownCtx, server, listener)
+	_ = RevokeAllTokens(shutdownCtx, tokenStore, time.Now())
+	if err := config.AuditLog.Close(shutdownCtx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := metrics.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return serveErr
+}
+
+// shutdownOnSignal stops the Functions Framework server when the process is asked to stop. It waits for
+// in-flight requests, revokes the pending tokens with a maximum lifetime, and delivers the queued audit
+// events and the pending metrics within the shutdown timeout. The token store is lost with the process,
+// so a token left pending would stay valid past its reported expiry until GitHub's.
+func shutdownOnSignal(config *Config, requests *RequestTracker) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := requests.Wait(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	_ = RevokeAllTokens(ctx, tokenStore, time.Now())
+	if err := config.AuditLog.Close(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := metrics.Shutdown(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 	}
+	os.Exit(0)
 }
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// metricsServiceName is the service.name resource attribute of the metrics, unless OTEL_SERVICE_NAME is set.
+const metricsServiceName = "github-token-issuer"
+
+// defaultMetricsOTLPInterval is how often metrics are pushed to the OTLP endpoint by default.
+const defaultMetricsOTLPInterval = time.Minute
+
+// defaultMetricsPrometheusPort is the port of the Prometheus scrape endpoint by default, the
+// OpenTelemetry Prometheus exporter's registered port.
+const defaultMetricsPrometheusPort = "9464"
+
+// Outcomes of dependency call attempts.
+const (
+	dependencySuccess     = "success"
+	dependencyError       = "error"
+	dependencyRateLimited = "rate_limited"
+	// dependencyCircuitOpen is an attempt refused by the open circuit breaker.
+	dependencyCircuitOpen = "circuit_open"
+)
+
+// durationBuckets are the histogram bucket boundaries of durations, in seconds. The SDK's default
+// boundaries are meant for milliseconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// metrics records the metrics of the process. Nil if disabled; its methods then do nothing.
+var metrics *Metrics
+
+// MetricsSettings controls the metrics exporters. Both are disabled by default.
+type MetricsSettings struct {
+	// Prometheus serves the metrics on GET /metrics of a listener of its own on PrometheusPort, apart
+	// from the service port, so the scrape endpoint isn't exposed with the service.
+	Prometheus     bool
+	PrometheusPort string
+	// OTLPEndpoint is the OTLP/HTTP metrics URL metrics are pushed to every OTLPInterval (empty disables pushing).
+	OTLPEndpoint string
+	OTLPInterval time.Duration
+}
+
+// Enabled reports whether any metrics exporter is enabled.
+func (s MetricsSettings) Enabled() bool {
+	return s.Prometheus || s.OTLPEndpoint != ""
+}
+
+// ParseMetricsSettings parses the METRICS_PROMETHEUS, METRICS_PROMETHEUS_PORT, METRICS_OTLP_ENDPOINT, and
+// METRICS_OTLP_INTERVAL environment variables.
+func ParseMetricsSettings() (MetricsSettings, error) {
+	settings := MetricsSettings{PrometheusPort: defaultMetricsPrometheusPort, OTLPInterval: defaultMetricsOTLPInterval}
+
+	if envValue := strings.TrimSpace(configValue("METRICS_PROMETHEUS")); envValue != "" {
+		enabled, err := strconv.ParseBool(envValue)
+		if err != nil {
+			return MetricsSettings{}, fmt.Errorf("invalid METRICS_PROMETHEUS %q: %w", envValue, err)
+		}
+		settings.Prometheus = enabled
+	}
+
+	if envValue := strings.TrimSpace(configValue("METRICS_PROMETHEUS_PORT")); envValue != "" {
+		if port, err := strconv.Atoi(envValue); err != nil || port < 1 || port > 65535 {
+			return MetricsSettings{}, fmt.Errorf("invalid METRICS_PROMETHEUS_PORT %q: must be a port number", envValue)
+		}
+		if !settings.Prometheus {
+			return MetricsSettings{}, fmt.Errorf("METRICS_PROMETHEUS_PORT requires METRICS_PROMETHEUS=true")
+		}
+		settings.PrometheusPort = envValue
+	}
+
+	if envValue := strings.TrimSpace(configValue("METRICS_OTLP_ENDPOINT")); envValue != "" {
+		endpoint, err := url.Parse(envValue)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return MetricsSettings{}, fmt.Errorf("invalid METRICS_OTLP_ENDPOINT %q: expected http(s)://<host>[:<port>]/<path>", envValue)
+		}
+		settings.OTLPEndpoint = envValue
+	}
+
+	if envValue := strings.TrimSpace(configValue("METRICS_OTLP_INTERVAL")); envValue != "" {
+		interval, err := time.ParseDuration(envValue)
+		if err != nil || interval < time.Second {
+			return MetricsSettings{}, fmt.Errorf("invalid METRICS_OTLP_INTERVAL %q: must be a duration of at least 1s", envValue)
+		}
+		if settings.OTLPEndpoint == "" {
+			return MetricsSettings{}, fmt.Errorf("METRICS_OTLP_INTERVAL requires METRICS_OTLP_ENDPOINT")
+		}
+		settings.OTLPInterval = interval
+	}
+
+	return settings, nil
+}
+
+// Metrics records the OpenTelemetry metrics of token requests and of the calls to the dependencies,
+// and exports them to Prometheus (NewMetricsServer) and/or an OTLP endpoint. Its methods do nothing on
+// nil metrics, like AuditLog, so call sites record unconditionally. It is safe for concurrent use.
+type Metrics struct {
+	provider *sdkmetric.MeterProvider
+	// handler serves the Prometheus scrape endpoint; nil if Prometheus is disabled.
+	handler http.Handler
+
+	tokenRequests        metric.Int64Counter
+	tokenRequestDuration metric.Float64Histogram
+	tokensIssued         metric.Int64Counter
+	scopesGranted        metric.Int64Counter
+	dependencyAttempts   metric.Int64Counter
+	dependencyRetries    metric.Int64Counter
+	dependencyDuration   metric.Float64Histogram
+	jwksCacheLookups     metric.Int64Counter
+	rateLimitRemaining   metric.Int64Gauge
+	rateLimitLimit       metric.Int64Gauge
+}
+
+// NewMetrics creates the metrics with the exporters of the settings. Returns nil if no exporter is enabled.
+func NewMetrics(ctx context.Context, settings MetricsSettings) (*Metrics, error) {
+	if !settings.Enabled() {
+		return nil, nil
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the default service name
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attribute.String("service.name", metricsServiceName)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metrics resource: %w", err)
+	}
+	opts := []sdkmetric.Option{sdkmetric.WithResource(res)}
+
+	var handler http.Handler
+	if settings.Prometheus {
+		// A registry of its own keeps the scrape output to these metrics
+		registry := prometheus.NewRegistry()
+		exporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry), otelprometheus.WithoutScopeInfo())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Prometheus exporter: %w", err)
+		}
+		opts = append(opts, sdkmetric.WithReader(exporter))
+		handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	}
+
+	if settings.OTLPEndpoint != "" {
+		// Headers (such as authentication) are read from OTEL_EXPORTER_OTLP_HEADERS
+		exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(settings.OTLPEndpoint))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(settings.OTLPInterval))))
+	}
+
+	return newMetrics(sdkmetric.NewMeterProvider(opts...), handler)
+}
+
+// newMetrics creates the instruments of the metrics with the meter provider.
+func newMetrics(provider *sdkmetric.MeterProvider, handler http.Handler) (*Metrics, error) {
+	meter := provider.Meter("github.com/your-org/github-token-issuer/function")
+	m := &Metrics{provider: provider, handler: handler}
+
+	var err error
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	m.tokenRequests, err = meter.Int64Counter("token_issuer.token.requests",
+		metric.WithDescription("POST /token requests by decision and error code."),
+		metric.WithUnit("{request}"))
+	check(err)
+	m.tokenRequestDuration, err = meter.Float64Histogram("token_issuer.token.request.duration",
+		metric.WithDescription("Duration of POST /token requests by decision."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	check(err)
+	m.tokensIssued, err = meter.Int64Counter("token_issuer.tokens.issued",
+		metric.WithDescription("Tokens issued by repository owner and GitHub App, including reused tokens."),
+		metric.WithUnit("{token}"))
+	check(err)
+	m.scopesGranted, err = meter.Int64Counter("token_issuer.token.scopes.granted",
+		metric.WithDescription("Scopes granted to issued tokens by scope, permission, and repository owner."),
+		metric.WithUnit("{scope}"))
+	check(err)
+	m.dependencyAttempts, err = meter.Int64Counter("token_issuer.dependency.attempts",
+		metric.WithDescription("Call attempts to GitHub, Secret Manager, the JWKS endpoint, Vault, and Cloud KMS by outcome."),
+		metric.WithUnit("{attempt}"))
+	check(err)
+	m.dependencyRetries, err = meter.Int64Counter("token_issuer.dependency.retries",
+		metric.WithDescription("Retried call attempts to the dependencies."),
+		metric.WithUnit("{attempt}"))
+	check(err)
+	m.dependencyDuration, err = meter.Float64Histogram("token_issuer.dependency.duration",
+		metric.WithDescription("Duration of call attempts to the dependencies by outcome."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	check(err)
+	m.jwksCacheLookups, err = meter.Int64Counter("token_issuer.jwks.cache.lookups",
+		metric.WithDescription("Lookups of the GitHub Actions JWKS cache by result (hit or miss)."),
+		metric.WithUnit("{lookup}"))
+	check(err)
+	m.rateLimitRemaining, err = meter.Int64Gauge("token_issuer.github.rate_limit.remaining",
+		metric.WithDescription("Requests left in the GitHub App's current rate limit window, from the last GitHub response."),
+		metric.WithUnit("{request}"))
+	check(err)
+	m.rateLimitLimit, err = meter.Int64Gauge("token_issuer.github.rate_limit.limit",
+		metric.WithDescription("Requests allowed per rate limit window of the GitHub App, from the last GitHub response."),
+		metric.WithUnit("{request}"))
+	check(err)
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to create metrics: %w", errors.Join(errs...))
+	}
+	return m, nil
+}
+
+// Shutdown pushes the pending metrics to the OTLP endpoint and stops the exporters.
+func (m *Metrics) Shutdown(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	if err := m.provider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to flush metrics: %w", err)
+	}
+	return nil
+}
+
+// RecordTokenRequest records the decision of a POST /token request from its audit event.
+func (m *Metrics) RecordTokenRequest(event *AuditEvent) {
+	if m == nil {
+		return
+	}
+	ctx := context.Background()
+	decision := attribute.String("decision", event.Decision)
+	m.tokenRequests.Add(ctx, 1, metric.WithAttributes(decision, attribute.String("code", string(event.Code)), attribute.Bool("dry_run", event.DryRun)))
+	m.tokenRequestDuration.Record(ctx, time.Since(event.Time).Seconds(), metric.WithAttributes(decision, attribute.Bool("dry_run", event.DryRun)))
+	if event.Decision != auditIssued {
+		return
+	}
+
+	owner := attribute.String("owner", repositoryOwner(event.Repository))
+	m.tokensIssued.Add(ctx, 1, metric.WithAttributes(owner, attribute.String("app", event.App), attribute.Bool("reused", event.Reused)))
+	for scope, permission := range event.GrantedScopes {
+		m.scopesGranted.Add(ctx, 1, metric.WithAttributes(owner, attribute.String("scope", scope), attribute.String("permission", permission)))
+	}
+}
+
+// RecordDependencyAttempt records a call attempt to the dependency (named after its circuit breaker).
+// Attempts after the first of a call are counted as retries.
+func (m *Metrics) RecordDependencyAttempt(dependency string, attempt int, outcome string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	ctx := context.Background()
+	name := attribute.String("dependency", dependency)
+	attrs := metric.WithAttributes(name, attribute.String("outcome", outcome))
+	m.dependencyAttempts.Add(ctx, 1, attrs)
+	if attempt > 1 {
+		m.dependencyRetries.Add(ctx, 1, metric.WithAttributes(name))
+	}
+	if outcome != dependencyCircuitOpen {
+		m.dependencyDuration.Record(ctx, duration.Seconds(), attrs)
+	}
+}
+
+// RecordJWKSCacheLookup records a lookup of the JWKS cache.
+func (m *Metrics) RecordJWKSCacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.jwksCacheLookups.Add(context.Background(), 1, metric.WithAttributes(attribute.String("result", result)))
+}
+
+// RecordGitHubRateLimit records the rate limit headroom GitHub reports in the X-RateLimit-* headers
+// of a response to the GitHub App (identified by its client ID or app ID). Responses without the
+// headers are ignored.
+func (m *Metrics) RecordGitHubRateLimit(app string, resp *http.Response) {
+	if m == nil || resp == nil {
+		return
+	}
+	remaining, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Remaining"), 10, 64)
+	if err != nil {
+		return
+	}
+	resourceName := resp.Header.Get("X-RateLimit-Resource")
+	if resourceName == "" {
+		resourceName = "core"
+	}
+	attrs := metric.WithAttributes(attribute.String("app", app), attribute.String("resource", resourceName))
+	ctx := context.Background()
+	m.rateLimitRemaining.Record(ctx, remaining, attrs)
+	if limit, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Limit"), 10, 64); err == nil {
+		m.rateLimitLimit.Record(ctx, limit, attrs)
+	}
+}
+
+// repositoryOwner returns the owner of an "owner/repo" repository.
+func repositoryOwner(repository string) string {
+	owner, _, _ := strings.Cut(repository, "/")
+	return owner
+}
+
+// NewMetricsServer returns the server of the Prometheus scrape endpoint (GET /metrics) on the port.
+// It only serves the metrics, never the token endpoints.
+func NewMetricsServer(port string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	return &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// handleMetrics handles GET /metrics requests of the metrics server: the Prometheus scrape endpoint, if enabled.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if metrics == nil || metrics.handler == nil {
+		writeError(w, NewError(CodeNotFound, "not found"))
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, NewError(CodeMethodNotAllowed, "method not allowed"))
+		return
+	}
+	metrics.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// useTestMetrics enables the metrics with a manual reader for the duration of the test.
+func useTestMetrics(t *testing.T) *sdkmetric.ManualReader {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	m, err := newMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := metrics
+	t.Cleanup(func() { metrics = original })
+	metrics = m
+	return reader
+}
+
+// metricValues returns the data points of the named counter or gauge, keyed by their attributes
+// (encoded as "key=value,..." sorted by key).
+func metricValues(t *testing.T, reader *sdkmetric.ManualReader, name string) map[string]int64 {
+	t.Helper()
+	var collected metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &collected); err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]int64)
+	for _, scope := range collected.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name != name {
+				continue
+			}
+			var points []metricdata.DataPoint[int64]
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				points = data.DataPoints
+			case metricdata.Gauge[int64]:
+				points = data.DataPoints
+			case metricdata.Histogram[float64]:
+				for _, point := range data.DataPoints {
+					values[point.Attributes.Encoded(attribute.DefaultEncoder())] = int64(point.Count)
+				}
+			}
+			for _, point := range points {
+				values[point.Attributes.Encoded(attribute.DefaultEncoder())] = point.Value
+			}
+		}
+	}
+	return values
+}
+
+// TestParseMetricsSettings tests parsing of the METRICS_* environment variables.
+func TestParseMetricsSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		want        MetricsSettings
+		errContains string
+	}{
+		{name: "disabled by default", want: MetricsSettings{PrometheusPort: "9464", OTLPInterval: time.Minute}},
+		{name: "Prometheus", env: map[string]string{"METRICS_PROMETHEUS": "true"}, want: MetricsSettings{Prometheus: true, PrometheusPort: "9464", OTLPInterval: time.Minute}},
+		{
+			name: "Prometheus port",
+			env:  map[string]string{"METRICS_PROMETHEUS": "true", "METRICS_PROMETHEUS_PORT": "9090"},
+			want: MetricsSettings{Prometheus: true, PrometheusPort: "9090", OTLPInterval: time.Minute},
+		},
+		{
+			name: "OTLP",
+			env:  map[string]string{"METRICS_OTLP_ENDPOINT": "https://collector.example.com/v1/metrics", "METRICS_OTLP_INTERVAL": "15s"},
+			want: MetricsSettings{PrometheusPort: "9464", OTLPEndpoint: "https://collector.example.com/v1/metrics", OTLPInterval: 15 * time.Second},
+		},
+		{name: "invalid Prometheus", env: map[string]string{"METRICS_PROMETHEUS": "yes please"}, errContains: "invalid METRICS_PROMETHEUS"},
+		{name: "invalid Prometheus port", env: map[string]string{"METRICS_PROMETHEUS": "true", "METRICS_PROMETHEUS_PORT": "metrics"}, errContains: "invalid METRICS_PROMETHEUS_PORT"},
+		{name: "Prometheus port without Prometheus", env: map[string]string{"METRICS_PROMETHEUS_PORT": "9090"}, errContains: "requires METRICS_PROMETHEUS=true"},
+		{name: "OTLP endpoint without scheme", env: map[string]string{"METRICS_OTLP_ENDPOINT": "collector:4318"}, errContains: "invalid METRICS_OTLP_ENDPOINT"},
+		{name: "OTLP interval too short", env: map[string]string{"METRICS_OTLP_ENDPOINT": "http://localhost:4318/v1/metrics", "METRICS_OTLP_INTERVAL": "100ms"}, errContains: "at least 1s"},
+		{name: "OTLP interval without endpoint", env: map[string]string{"METRICS_OTLP_INTERVAL": "15s"}, errContains: "requires METRICS_OTLP_ENDPOINT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"METRICS_PROMETHEUS", "METRICS_PROMETHEUS_PORT", "METRICS_OTLP_ENDPOINT", "METRICS_OTLP_INTERVAL"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			got, err := ParseMetricsSettings()
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseMetricsSettings() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMetricsSettings() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMetricsSettings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMetrics_TokenRequests tests the metrics of POST /token decisions.
+//
+// Test steps:
+//  1. Send an unauthenticated request and record an issued and a reused token
+//  2. Verify the requests by decision and code, and the issued tokens and scopes by owner
+func TestMetrics_TokenRequests(t *testing.T) {
+	reader := useTestMetrics(t)
+
+	// Step 1: A denied request and two issued tokens
+	rec := httptest.NewRecorder()
+	NewTokenHandler(&Config{})(rec, httptest.NewRequest(http.MethodPost, "/token?contents=read", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	for _, reused := range []bool{false, true} {
+		metrics.RecordTokenRequest(&AuditEvent{
+			Time:          time.Now(),
+			Decision:      auditIssued,
+			Repository:    "owner/repo",
+			App:           "default",
+			GrantedScopes: map[string]string{"contents": "read", "issues": "write"},
+			Reused:        reused,
+		})
+	}
+
+	// Step 2: Verify the recorded metrics
+	requests := metricValues(t, reader, "token_issuer.token.requests")
+	if requests["code=missing_authorization,decision=denied,dry_run=false"] != 1 || requests["code=,decision=issued,dry_run=false"] != 2 {
+		t.Errorf("token requests = %v", requests)
+	}
+	if durations := metricValues(t, reader, "token_issuer.token.request.duration"); durations["decision=issued,dry_run=false"] != 2 {
+		t.Errorf("token request durations = %v", durations)
+	}
+	issued := metricValues(t, reader, "token_issuer.tokens.issued")
+	if issued["app=default,owner=owner,reused=false"] != 1 || issued["app=default,owner=owner,reused=true"] != 1 {
+		t.Errorf("issued tokens = %v", issued)
+	}
+	scopes := metricValues(t, reader, "token_issuer.token.scopes.granted")
+	if len(scopes) != 2 || scopes["owner=owner,permission=write,scope=issues"] != 2 {
+		t.Errorf("granted scopes = %v", scopes)
+	}
+}
+
+// TestMetrics_Dependencies tests the attempt, retry, and duration metrics of dependency calls.
+//
+// Test steps:
+//  1. Make a call failing once before it succeeds, and a rate-limited call
+//  2. Open the breaker and make a call it refuses
+//  3. Verify the attempts by outcome and the retries
+func TestMetrics_Dependencies(t *testing.T) {
+	reader := useTestMetrics(t)
+	useFastRetryPolicy(t)
+	breaker, _ := newTestBreaker()
+
+	// Step 1: A retried call and a rate-limited one
+	attempts := 0
+	err := retryPolicy.Do(context.Background(), breaker.Guard(func() (bool, time.Duration, error) {
+		attempts++
+		if attempts == 1 {
+			return true, 0, errors.New("unavailable")
+		}
+		return false, 0, nil
+	}))
+	if err != nil {
+		t.Fatalf("Do() unexpected error = %v", err)
+	}
+	limited := breaker.Guard(func() (bool, time.Duration, error) {
+		return true, time.Millisecond, errors.New("rate limited")
+	})
+	_, _, _ = limited()
+
+	// Step 2: A call refused by the open breaker
+	for breaker.Allow() == nil {
+		breaker.Record(true)
+	}
+	if err := retryPolicy.Do(context.Background(), breaker.Guard(func() (bool, time.Duration, error) { return false, 0, nil })); err == nil {
+		t.Fatal("Do() with an open breaker succeeded")
+	}
+
+	// Step 3: Verify the metrics
+	got := metricValues(t, reader, "token_issuer.dependency.attempts")
+	want := map[string]int64{
+		"dependency=test,outcome=error":        1,
+		"dependency=test,outcome=success":      1,
+		"dependency=test,outcome=rate_limited": 1,
+		"dependency=test,outcome=circuit_open": 1,
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("attempts[%s] = %d, want %d (all: %v)", key, got[key], value, got)
+		}
+	}
+	if retries := metricValues(t, reader, "token_issuer.dependency.retries"); retries["dependency=test"] != 1 {
+		t.Errorf("retries = %v, want 1", retries)
+	}
+	durations := metricValues(t, reader, "token_issuer.dependency.duration")
+	if _, ok := durations["dependency=test,outcome=circuit_open"]; ok || durations["dependency=test,outcome=success"] != 1 {
+		t.Errorf("durations = %v, want none for refused attempts", durations)
+	}
+}
+
+// TestMetrics_JWKSCache tests the hit and miss metrics of the JWKS cache.
+func TestMetrics_JWKSCache(t *testing.T) {
+	reader := useTestMetrics(t)
+
+	useTestJWKS(t, generateTestRSAKey(t))
+	if _, err := fetchJWKS(context.Background(), githubOIDCIssuer); err != nil {
+		t.Fatalf("fetchJWKS() unexpected error = %v", err)
+	}
+	useUnreachableJWKS(t)
+	if _, err := fetchJWKS(context.Background(), githubOIDCIssuer); err == nil {
+		t.Fatal("fetchJWKS() with an open breaker succeeded")
+	}
+
+	if got := metricValues(t, reader, "token_issuer.jwks.cache.lookups"); got["result=hit"] != 1 || got["result=miss"] != 1 {
+		t.Errorf("JWKS cache lookups = %v, want 1 hit and 1 miss", got)
+	}
+}
+
+// TestMetrics_GitHubRateLimit tests that the rate limit headers of GitHub responses to the App are recorded.
+func TestMetrics_GitHubRateLimit(t *testing.T) {
+	reader := useTestMetrics(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/search" {
+			w.Header().Set("X-RateLimit-Resource", "search")
+		}
+		w.Header().Set("X-RateLimit-Limit", "15000")
+		w.Header().Set("X-RateLimit-Remaining", "14250")
+	}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: NewAppTransport(githubTransport, &keySigner{key: generateTestRSAKey(t)}, "Iv23liTest")}
+	for _, path := range []string{"/app/installations/1/access_tokens", "/search"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Get() unexpected error = %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	remaining := metricValues(t, reader, "token_issuer.github.rate_limit.remaining")
+	if remaining["app=Iv23liTest,resource=core"] != 14250 || remaining["app=Iv23liTest,resource=search"] != 14250 {
+		t.Errorf("rate limit remaining = %v", remaining)
+	}
+	if limit := metricValues(t, reader, "token_issuer.github.rate_limit.limit"); limit["app=Iv23liTest,resource=core"] != 15000 {
+		t.Errorf("rate limit = %v", limit)
+	}
+}
+
+// TestHandleMetrics tests the Prometheus scrape endpoint of the metrics server.
+//
+// Test steps:
+//  1. Verify GET /metrics is not found with metrics disabled
+//  2. Enable Prometheus, record a request, and verify it is scraped
+//  3. Verify other methods are rejected, and that the service port doesn't serve the metrics
+func TestHandleMetrics(t *testing.T) {
+	original := metrics
+	t.Cleanup(func() { metrics = original })
+	handler := NewMetricsServer("9464").Handler
+	get := func(method string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/metrics", nil))
+		return rec
+	}
+
+	// Step 1: Disabled
+	metrics = nil
+	if rec := get(http.MethodGet); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled GET /metrics status = %d, want 404", rec.Code)
+	}
+
+	// Step 2: Prometheus enabled
+	m, err := NewMetrics(context.Background(), MetricsSettings{Prometheus: true})
+	if err != nil {
+		t.Fatalf("NewMetrics() unexpected error = %v", err)
+	}
+	t.Cleanup(func() { _ = m.Shutdown(context.Background()) })
+	metrics = m
+	metrics.RecordTokenRequest(&AuditEvent{Time: time.Now(), Decision: auditDenied, Code: CodeInvalidPermission})
+
+	rec := get(http.MethodGet)
+	body, _ := io.ReadAll(rec.Body)
+	if rec.Code != http.StatusOK || !strings.Contains(string(body), `token_issuer_token_requests_total{code="invalid_permission",decision="denied",dry_run="false"} 1`) {
+		t.Errorf("GET /metrics = %d:\n%s", rec.Code, body)
+	}
+
+	// Step 3: Only GET, only on the metrics server
+	if rec := get(http.MethodPost); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /metrics status = %d, want 405", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	NewTokenHandler(&Config{})(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /metrics on the service port status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+	"golang.org/x/sync/errgroup"
+)
+
+// Stages of the POST /token pipeline, as reported in the Server-Timing header.
+const (
+	stageOIDC         = "oidc"
+	stageAppJWT       = "app_jwt"
+	stageInstallation = "installation"
+	stageToken        = "token"
+	stageRevocation   = "revocation"
+)
+
+// ParseStageTimingsEnabled parses the DEBUG_STAGE_TIMINGS environment variable. When enabled,
+// POST /token responses report the duration of each pipeline stage in a Server-Timing header.
+func ParseStageTimingsEnabled() (bool, error) {
+	envValue := strings.TrimSpace(configValue("DEBUG_STAGE_TIMINGS"))
+	if envValue == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(envValue)
+	if err != nil {
+		return false, fmt.Errorf("invalid DEBUG_STAGE_TIMINGS %q: %w", envValue, err)
+	}
+	return enabled, nil
+}
+
+// StageTiming is the duration of one pipeline stage.
+type StageTiming struct {
+	Stage    string
+	Duration time.Duration
+}
+
+// StageTimings records the durations of the pipeline stages of a request, including stages running
+// concurrently. Its methods do nothing on nil timings, like DecisionTrace, so stages are timed
+// unconditionally and only requests with timings enabled pay for it. It is safe for concurrent use.
+type StageTimings struct {
+	start time.Time
+
+	mu     sync.Mutex
+	stages []StageTiming
+}
+
+// NewStageTimings starts timing a request.
+func NewStageTimings() *StageTimings {
+	return &StageTimings{start: time.Now()}
+}
+
+// Start starts timing a stage and returns the function that records its duration.
+func (t *StageTimings) Start(stage string) (done func()) {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.stages = append(t.stages, StageTiming{Stage: stage, Duration: time.Since(start)})
+	}
+}
+
+// ServerTiming returns the Server-Timing header value of the recorded stages, in the order they
+// finished, followed by the total duration of the request so far.
+func (t *StageTimings) ServerTiming() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	metrics := make([]string, 0, len(t.stages)+1)
+	for _, stage := range t.stages {
+		metrics = append(metrics, fmt.Sprintf("%s;dur=%.1f", stage.Stage, float64(stage.Duration.Microseconds())/1000))
+	}
+	metrics = append(metrics, fmt.Sprintf("total;dur=%.1f", float64(time.Since(t.start).Microseconds())/1000))
+	return strings.Join(metrics, ", ")
+}
+
+// timingResponseWriter adds the Server-Timing header of the request's stage timings to the response.
+type timingResponseWriter struct {
+	http.ResponseWriter
+	timings *StageTimings
+}
+
+func (w *timingResponseWriter) WriteHeader(statusCode int) {
+	w.Header().Set("Server-Timing", w.timings.ServerTiming())
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// installationLookup is the result of the app JWT and installation lookup stages of a token request.
+type installationLookup struct {
+	apps         GitHubAppsService
+	installation *github.Installation
+	// cached reports whether the installation came from installationCache.
+	cached bool
+	// err is returned as *Error.
+	err error
+}
+
+// lookupInstallation authenticates as the request's GitHub App and looks up its installation on
+// the repository, from installationCache if possible.
+func lookupInstallation(ctx context.Context, req tokenRequest) installationLookup {
+	apps, err := authenticateApp(ctx, req)
+	if err != nil {
+		return installationLookup{err: err}
+	}
+	return lookupRepositoryInstallation(ctx, req, apps)
+}
+
+// authenticateApp returns the GitHub Apps API client of the request's GitHub App, signing the App JWT
+// if needed. It doesn't call GitHub.
+func authenticateApp(ctx context.Context, req tokenRequest) (GitHubAppsService, error) {
+	defer req.timings.Start(stageAppJWT)()
+	return newJWTAppsService(ctx, req.app)
+}
+
+// lookupRepositoryInstallation looks up the installation of the request's GitHub App on the
+// repository with the app's client, from installationCache if possible.
+func lookupRepositoryInstallation(ctx context.Context, req tokenRequest, apps GitHubAppsService) installationLookup {
+	defer req.timings.Start(stageInstallation)()
+	installation, cached, err := GetCachedInstallation(ctx, req.app, apps, req.identity.Repository)
+	if err != nil {
+		return installationLookup{err: AsError(err, CodeGitHubUnavailable, "GitHub API error: %w")}
+	}
+	return installationLookup{apps: apps, installation: installation, cached: cached}
+}
+
+// prefetchedLookup is an installation lookup prepared before the caller was authenticated.
+type prefetchedLookup struct {
+	// req is the request parsed from the claimed identity.
+	req    tokenRequest
+	lookup installationLookup
+	// verified reports whether the lookup was made for the verified identity.
+	verified bool
+}
+
+// authenticateAndPrefetch validates the caller's OIDC token and, concurrently, loads the App private
+// key and signs the App JWT for the identity the token claims. Cold, these are slow stages: the JWKS
+// and key source calls now overlap instead of adding up.
+//
+// The installation lookup calls GitHub, so it waits until the OIDC token's signature is verified and
+// only runs if the verified identity is the claimed one: unauthenticated callers can't make the service
+// query GitHub for repositories of their choosing. Only the OIDC validation fails the request: it
+// cancels the prefetch, which only starts if the token is well-formed and the claimed request passes
+// all checks. Errors of the prefetch are kept with its result and reported once the caller is
+// authenticated. A prefetched lookup is only used for a request parsed from the same verified identity.
+func authenticateAndPrefetch(ctx context.Context, config *Config, r *http.Request, query url.Values, timings *StageTimings) (Identity, *prefetchedLookup, *Error) {
+	group, groupCtx := errgroup.WithContext(ctx)
+
+	var identity Identity
+	// verified is closed once identity is verified
+	verified := make(chan struct{})
+	group.Go(func() error {
+		defer timings.Start(stageOIDC)()
+		var typedErr *Error
+		if identity, typedErr = verifyCaller(groupCtx, config, r); typedErr != nil {
+			return typedErr
+		}
+		close(verified)
+		return nil
+	})
+
+	var prefetched *prefetchedLookup
+	if oidcToken, typedErr := bearerToken(r); typedErr == nil {
+		if claimed, ok := ClaimedIdentity(oidcToken, TrustedIssuers(config.Apps)); ok {
+			req, typedErr := parseTokenRequest(config, query, claimed, nil)
+			// A token reused from the cache needs no lookup
+			reused := false
+			if reuseMinValidity := config.TokenReuseMinValidity; typedErr == nil && reuseMinValidity > 0 && claimed.RunID != 0 {
+				_, reused = tokenCache.lookup(req.cacheKey(), reuseMinValidity)
+			}
+			if typedErr == nil && !reused {
+				req.timings = timings
+				prefetched = &prefetchedLookup{req: req}
+				group.Go(func() error {
+					apps, err := authenticateApp(groupCtx, req)
+					select {
+					case <-verified:
+					case <-groupCtx.Done():
+						return nil
+					}
+					if identity != claimed {
+						return nil
+					}
+					prefetched.verified = true
+					if err != nil {
+						prefetched.lookup = installationLookup{err: err}
+						return nil
+					}
+					prefetched.lookup = lookupRepositoryInstallation(groupCtx, req, apps)
+					return nil
+				})
+			}
+		}
+	}
+
+	if err := group.Wait(); err != nil {
+		return Identity{}, nil, err.(*Error)
+	}
+	return identity, prefetched, nil
+}
+
+// matches reports whether the prefetched lookup was made for the request.
+func (p *prefetchedLookup) matches(req tokenRequest) bool {
+	return p != nil && p.verified && p.req.identity == req.identity && p.req.app.Name == req.app.Name
+}
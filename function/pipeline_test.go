@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// TestParseStageTimingsEnabled tests parsing of the DEBUG_STAGE_TIMINGS environment variable.
+func TestParseStageTimingsEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		want     bool
+		wantErr  bool
+	}{
+		{name: "unset", envValue: "", want: false},
+		{name: "enabled", envValue: "true", want: true},
+		{name: "disabled", envValue: "false", want: false},
+		{name: "invalid", envValue: "verbose", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEBUG_STAGE_TIMINGS", tt.envValue)
+			got, err := ParseStageTimingsEnabled()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseStageTimingsEnabled() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseStageTimingsEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStageTimings tests that stage durations are reported in the Server-Timing header, and that
+// nil timings record nothing.
+func TestStageTimings(t *testing.T) {
+	var disabled *StageTimings
+	disabled.Start(stageOIDC)()
+
+	timings := NewStageTimings()
+	timings.Start(stageOIDC)()
+	timings.Start(stageAppJWT)()
+
+	w := httptest.NewRecorder()
+	writeJSON(&timingResponseWriter{ResponseWriter: w, timings: timings}, http.StatusOK, map[string]string{})
+
+	pattern := regexp.MustCompile(`^oidc;dur=\d+\.\d, app_jwt;dur=\d+\.\d, total;dur=\d+\.\d$`)
+	if got := w.Header().Get("Server-Timing"); !pattern.MatchString(got) {
+		t.Errorf("Server-Timing = %q, want oidc, app_jwt, and total durations", got)
+	}
+}
+
+// TestAuthenticateAndPrefetch tests that the installation is looked up once the OIDC token is verified,
+// and never for a caller whose token isn't.
+//
+// Test steps:
+//  1. Install a test JWKS and a GitHub mock where the app is installed or not, counting lookups
+//  2. Authenticate a caller with a valid or forged OIDC token
+//  3. Verify the identity and prefetched lookup, or the OIDC error and that GitHub wasn't called
+func TestAuthenticateAndPrefetch(t *testing.T) {
+	key := generateTestRSAKey(t)
+	forger := generateTestRSAKey(t)
+	t.Setenv("GITHUB_APPS", "")
+	t.Setenv("GITHUB_APP_ID", "123")
+	t.Setenv("GITHUB_ALLOWED_OWNER_IDS", "")
+	config := loadTestConfig(t)
+
+	tests := []struct {
+		name          string
+		token         string
+		installed     bool
+		wantCode      ErrorCode
+		wantLookup    int64
+		wantLookupErr ErrorCode
+	}{
+		{name: "prefetched installation", token: signTestOIDCToken(t, key, nil), installed: true, wantLookup: 7},
+		{name: "lookup error kept for later", token: signTestOIDCToken(t, key, nil), wantLookupErr: CodeAppNotInstalled},
+		{name: "forged token is not looked up", token: signTestOIDCToken(t, forger, nil), wantCode: CodeInvalidOIDCToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFastRetryPolicy(t)
+			useEmptyInstallationCache(t)
+
+			// Step 1: Test JWKS and GitHub mock
+			useTestJWKS(t, key)
+			if tt.installed {
+				mockJWTAppsService(t, &github.Installation{ID: github.Ptr(int64(7))}, nil)
+			} else {
+				mockJWTAppsService(t, nil, &github.Response{Response: &http.Response{StatusCode: http.StatusNotFound}})
+			}
+			var lookups atomic.Int32
+			mocked := newJWTAppsService
+			newJWTAppsService = func(ctx context.Context, app GitHubApp) (GitHubAppsService, error) {
+				apps, err := mocked(ctx, app)
+				mock := apps.(*mockAppsService)
+				find := mock.findRepoInstallation
+				mock.findRepoInstallation = func(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error) {
+					lookups.Add(1)
+					return find(ctx, owner, repo)
+				}
+				return mock, err
+			}
+
+			// Step 2: Authenticate the caller
+			r := httptest.NewRequest(http.MethodPost, "/token?contents=read", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			identity, prefetched, typedErr := authenticateAndPrefetch(context.Background(), config, r, r.URL.Query(), NewStageTimings())
+
+			// Step 3: Verify the result
+			if tt.wantCode != "" {
+				if typedErr == nil || typedErr.Code != tt.wantCode {
+					t.Fatalf("authenticateAndPrefetch() error = %v, want code %s", typedErr, tt.wantCode)
+				}
+				if got := lookups.Load(); got != 0 {
+					t.Errorf("installation lookups = %d, want none before the token is verified", got)
+				}
+				return
+			}
+			if typedErr != nil {
+				t.Fatalf("authenticateAndPrefetch() unexpected error = %v", typedErr)
+			}
+			req, _ := parseTokenRequest(config, r.URL.Query(), identity, nil)
+			if !prefetched.matches(req) {
+				t.Fatalf("prefetched lookup doesn't match the verified request")
+			}
+			if tt.wantLookupErr != "" {
+				if typed, ok := prefetched.lookup.err.(*Error); !ok || typed.Code != tt.wantLookupErr {
+					t.Errorf("lookup error = %v, want code %s", prefetched.lookup.err, tt.wantLookupErr)
+				}
+				return
+			}
+			if got := prefetched.lookup.installation.GetID(); got != tt.wantLookup {
+				t.Errorf("prefetched installation = %d, want %d", got, tt.wantLookup)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// profileParam is the query parameter that selects a server-side scope profile.
+const profileParam = "profile"
+
+// ScopeProfile is a named bundle of scopes defined by the operator.
+// OwnerIDs and Repositories optionally restrict which callers may request the profile;
+// empty means no restriction.
+type ScopeProfile struct {
+	Scopes       map[string]string `json:"scopes"`
+	OwnerIDs     []int64           `json:"owner_ids,omitempty"`
+	Repositories []string          `json:"repositories,omitempty"`
+}
+
+// ParseScopeProfiles parses the GITHUB_SCOPE_PROFILES environment variable.
+// Format: JSON object mapping profile name to profile, for example:
+//
+//	{"release": {"scopes": {"contents": "write", "deployments": "write"}, "owner_ids": [12345]}}
+//
+// Returns an empty map if the variable is not set. Every profile scope must pass ValidateScopes.
+func ParseScopeProfiles() (map[string]ScopeProfile, error) {
+	profiles := map[string]ScopeProfile{}
+	envValue := strings.TrimSpace(configValue("GITHUB_SCOPE_PROFILES"))
+	if envValue == "" {
+		return profiles, nil
+	}
+
+	if err := json.Unmarshal([]byte(envValue), &profiles); err != nil {
+		return nil, fmt.Errorf("invalid GITHUB_SCOPE_PROFILES: %w", err)
+	}
+
+	for name, profile := range profiles {
+		if len(profile.Scopes) == 0 {
+			return nil, fmt.Errorf("invalid GITHUB_SCOPE_PROFILES: profile '%s' has no scopes", name)
+		}
+		if err := ValidateScopes(profile.Scopes); err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_SCOPE_PROFILES: profile '%s': %w", name, err)
+		}
+	}
+
+	return profiles, nil
+}
+
+// LookupProfile returns the profile with the given name.
+func LookupProfile(profiles map[string]ScopeProfile, name string) (ScopeProfile, error) {
+	profile, exists := profiles[name]
+	if !exists {
+		return ScopeProfile{}, NewError(CodeUnknownProfile, "unknown scope profile '%s'", name).
+			WithDetails(map[string]interface{}{"profile": name})
+	}
+	return profile, nil
+}
+
+// ValidateProfileAllowed validates that the repository and its owner may request the profile.
+// Repository names are compared case-insensitively, as GitHub does.
+func ValidateProfileAllowed(name string, profile ScopeProfile, repository string, ownerID int64) error {
+	if len(profile.OwnerIDs) > 0 && !slices.Contains(profile.OwnerIDs, ownerID) {
+		return NewError(CodeProfileNotAllowed, "scope profile '%s' is not allowed for repository owner ID %d", name, ownerID).
+			WithDetails(map[string]interface{}{"profile": name, "rule": "owner_ids"})
+	}
+
+	if len(profile.Repositories) > 0 && !slices.ContainsFunc(profile.Repositories, func(allowed string) bool {
+		return strings.EqualFold(allowed, repository)
+	}) {
+		return NewError(CodeProfileNotAllowed, "scope profile '%s' is not allowed for repository %s", name, repository).
+			WithDetails(map[string]interface{}{"profile": name, "rule": "repositories"})
+	}
+
+	return nil
+}
+
+// MergeProfileScopes adds the profile's scopes to the explicitly requested scopes.
+// A scope requested both explicitly and through the profile is rejected, like a duplicate scope parameter.
+func MergeProfileScopes(scopes map[string]string, name string, profile ScopeProfile) error {
+	for scopeID, permission := range profile.Scopes {
+		if _, exists := scopes[scopeID]; exists {
+			return NewError(CodeDuplicateScope, "scope '%s' is requested both explicitly and by scope profile '%s'", scopeID, name).
+				WithDetails(map[string]interface{}{"scope": scopeID, "profile": name})
+		}
+		scopes[scopeID] = permission
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParseScopeProfiles tests parsing of the GITHUB_SCOPE_PROFILES environment variable.
+func TestParseScopeProfiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		envValue    string
+		wantNames   []string
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:      "empty env var",
+			envValue:  "",
+			wantNames: nil,
+		},
+		{
+			name:      "single profile",
+			envValue:  `{"release": {"scopes": {"contents": "write", "deployments": "write", "statuses": "write"}}}`,
+			wantNames: []string{"release"},
+		},
+		{
+			name: "multiple profiles with restrictions",
+			envValue: `{
+				"release": {"scopes": {"contents": "write"}, "owner_ids": [231188]},
+				"pr-bot": {"scopes": {"pull_requests": "write", "issues": "write"}, "repositories": ["remal/repo"]}
+			}`,
+			wantNames: []string{"pr-bot", "release"},
+		},
+		{
+			name:        "invalid JSON",
+			envValue:    `{"release":`,
+			wantErr:     true,
+			errContains: "invalid GITHUB_SCOPE_PROFILES",
+		},
+		{
+			name:        "profile without scopes",
+			envValue:    `{"empty": {"scopes": {}}}`,
+			wantErr:     true,
+			errContains: "has no scopes",
+		},
+		{
+			name:        "profile with scope not in allowlist",
+			envValue:    `{"bad": {"scopes": {"unknown": "read"}}}`,
+			wantErr:     true,
+			errContains: "not in allowlist",
+		},
+		{
+			name:        "profile with disallowed permission level",
+			envValue:    `{"bad": {"scopes": {"administration": "write"}}}`,
+			wantErr:     true,
+			errContains: "not allowed for scope",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_SCOPE_PROFILES", tt.envValue)
+
+			got, err := ParseScopeProfiles()
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseScopeProfiles() error = nil, wantErr = true")
+					return
+				}
+				if !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseScopeProfiles() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("ParseScopeProfiles() unexpected error = %v", err)
+				return
+			}
+
+			if len(got) != len(tt.wantNames) {
+				t.Errorf("ParseScopeProfiles() = %v, want profiles %v", got, tt.wantNames)
+				return
+			}
+			for _, name := range tt.wantNames {
+				if _, exists := got[name]; !exists {
+					t.Errorf("ParseScopeProfiles() missing profile %q", name)
+				}
+			}
+		})
+	}
+}
+
+// TestLookupProfile tests profile lookup by name.
+func TestLookupProfile(t *testing.T) {
+	profiles := map[string]ScopeProfile{
+		"release": {Scopes: map[string]string{"contents": "write"}},
+	}
+
+	if _, err := LookupProfile(profiles, "release"); err != nil {
+		t.Errorf("LookupProfile() unexpected error = %v", err)
+	}
+
+	_, err := LookupProfile(profiles, "unknown")
+	if err == nil || !strings.Contains(err.Error(), "unknown scope profile 'unknown'") {
+		t.Errorf("LookupProfile() error = %v, want containing 'unknown scope profile'", err)
+	}
+}
+
+// TestValidateProfileAllowed tests owner and repository restrictions of a profile.
+func TestValidateProfileAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		profile     ScopeProfile
+		repository  string
+		ownerID     int64
+		errContains string
+	}{
+		{
+			name:       "no restrictions",
+			profile:    ScopeProfile{},
+			repository: "remal/repo",
+			ownerID:    231188,
+		},
+		{
+			name:       "owner allowed",
+			profile:    ScopeProfile{OwnerIDs: []int64{231188, 77341723}},
+			repository: "remal/repo",
+			ownerID:    77341723,
+		},
+		{
+			name:        "owner not allowed",
+			profile:     ScopeProfile{OwnerIDs: []int64{231188}},
+			repository:  "other/repo",
+			ownerID:     99999,
+			errContains: "not allowed for repository owner ID 99999",
+		},
+		{
+			name:       "repository allowed case-insensitively",
+			profile:    ScopeProfile{Repositories: []string{"Remal/Repo"}},
+			repository: "remal/repo",
+			ownerID:    231188,
+		},
+		{
+			name:        "repository not allowed",
+			profile:     ScopeProfile{Repositories: []string{"remal/repo"}},
+			repository:  "remal/other",
+			ownerID:     231188,
+			errContains: "not allowed for repository remal/other",
+		},
+		{
+			name:        "owner allowed but repository not allowed",
+			profile:     ScopeProfile{OwnerIDs: []int64{231188}, Repositories: []string{"remal/repo"}},
+			repository:  "remal/other",
+			ownerID:     231188,
+			errContains: "not allowed for repository remal/other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateProfileAllowed("release", tt.profile, tt.repository, tt.ownerID)
+
+			if tt.errContains == "" {
+				if err != nil {
+					t.Errorf("ValidateProfileAllowed() unexpected error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("ValidateProfileAllowed() error = %v, want containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+// TestMergeProfileScopes tests combining explicit scopes with profile scopes.
+func TestMergeProfileScopes(t *testing.T) {
+	profile := ScopeProfile{Scopes: map[string]string{"contents": "write", "deployments": "write"}}
+
+	scopes := map[string]string{"issues": "read"}
+	if err := MergeProfileScopes(scopes, "release", profile); err != nil {
+		t.Fatalf("MergeProfileScopes() unexpected error = %v", err)
+	}
+	assertScopesEqual(t, "MergeProfileScopes()", scopes, map[string]string{"issues": "read", "contents": "write", "deployments": "write"})
+
+	conflicting := map[string]string{"contents": "read"}
+	err := MergeProfileScopes(conflicting, "release", profile)
+	if err == nil || !strings.Contains(err.Error(), "both explicitly and by scope profile 'release'") {
+		t.Errorf("MergeProfileScopes() error = %v, want containing 'both explicitly and by scope profile'", err)
+	}
+}
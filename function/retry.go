@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how calls to external dependencies (GitHub, JWKS, Secret Manager) are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// BaseDelay is the backoff cap before the first retry; it doubles for every further retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff between two attempts.
+	MaxDelay time.Duration
+	// Budget caps the total time spent waiting between attempts of one call.
+	Budget time.Duration
+}
+
+// defaultRetryPolicy keeps retries well within the request timeout and short compared to the
+// composite action's own client-side retries.
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+	Budget:      15 * time.Second,
+}
+
+// retryPolicy is the process-wide retry policy, set from the environment at startup.
+var retryPolicy = defaultRetryPolicy
+
+// ParseRetryPolicy parses the retry policy from the RETRY_MAX_ATTEMPTS, RETRY_BASE_DELAY,
+// RETRY_MAX_DELAY, and RETRY_BUDGET environment variables. Unset variables keep their defaults.
+func ParseRetryPolicy() (RetryPolicy, error) {
+	policy := defaultRetryPolicy
+
+	if envValue := strings.TrimSpace(configValue("RETRY_MAX_ATTEMPTS")); envValue != "" {
+		attempts, err := strconv.Atoi(envValue)
+		if err != nil || attempts < 1 {
+			return RetryPolicy{}, fmt.Errorf("invalid RETRY_MAX_ATTEMPTS %q: must be a positive integer", envValue)
+		}
+		policy.MaxAttempts = attempts
+	}
+
+	durations := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"RETRY_BASE_DELAY", &policy.BaseDelay},
+		{"RETRY_MAX_DELAY", &policy.MaxDelay},
+		{"RETRY_BUDGET", &policy.Budget},
+	}
+	for _, d := range durations {
+		envValue := strings.TrimSpace(configValue(d.name))
+		if envValue == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(envValue)
+		if err != nil || duration < 0 {
+			return RetryPolicy{}, fmt.Errorf("invalid %s %q: must be a non-negative duration", d.name, envValue)
+		}
+		*d.value = duration
+	}
+
+	if policy.MaxDelay < policy.BaseDelay {
+		return RetryPolicy{}, fmt.Errorf("RETRY_MAX_DELAY %s must not be less than RETRY_BASE_DELAY %s", policy.MaxDelay, policy.BaseDelay)
+	}
+
+	return policy, nil
+}
+
+// Backoff returns the delay before the retry following the given attempt (0-based), using full
+// jitter: a random duration between 0 and min(MaxDelay, BaseDelay * 2^attempt).
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if attempt < 63 && p.BaseDelay > 0 {
+		if exp := p.BaseDelay << uint(attempt); exp > 0 && exp < ceiling {
+			ceiling = exp
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// Do calls fn until it succeeds or returns a non-retryable error, the attempts or the retry
+// budget are exhausted, or the next wait would overrun the context deadline; in those cases the
+// last error is returned. fn can ask for a specific wait with retryAfter (e.g. a rate limit's
+// Retry-After) instead of the jittered backoff.
+func (p RetryPolicy) Do(ctx context.Context, fn func() (retryable bool, retryAfter time.Duration, err error)) error {
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		retryable, retryAfter, err := fn()
+		if err == nil || !retryable || attempt+1 >= p.MaxAttempts {
+			return err
+		}
+
+		wait := retryAfter
+		if wait <= 0 {
+			wait = p.Backoff(attempt)
+		}
+		if waited+wait > p.Budget || !fitsDeadline(ctx, wait) {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		waited += wait
+	}
+}
+
+// fitsDeadline reports whether waiting for d still leaves the context's deadline ahead.
+func fitsDeadline(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Now().Add(d).Before(deadline)
+}
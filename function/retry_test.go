@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// useFastRetryPolicy replaces the retry policy with one that waits at most a millisecond for the duration of the test.
+func useFastRetryPolicy(t *testing.T) {
+	t.Helper()
+	original := retryPolicy
+	t.Cleanup(func() { retryPolicy = original })
+	retryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: time.Second}
+}
+
+// TestParseRetryPolicy tests parsing of the RETRY_* environment variables.
+func TestParseRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    RetryPolicy
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{},
+			want: defaultRetryPolicy,
+		},
+		{
+			name: "all set",
+			env: map[string]string{
+				"RETRY_MAX_ATTEMPTS": "5",
+				"RETRY_BASE_DELAY":   "100ms",
+				"RETRY_MAX_DELAY":    "2s",
+				"RETRY_BUDGET":       "10s",
+			},
+			want: RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second, Budget: 10 * time.Second},
+		},
+		{
+			name: "retries disabled",
+			env:  map[string]string{"RETRY_MAX_ATTEMPTS": "1"},
+			want: RetryPolicy{MaxAttempts: 1, BaseDelay: defaultRetryPolicy.BaseDelay, MaxDelay: defaultRetryPolicy.MaxDelay, Budget: defaultRetryPolicy.Budget},
+		},
+		{name: "zero attempts", env: map[string]string{"RETRY_MAX_ATTEMPTS": "0"}, wantErr: true},
+		{name: "invalid attempts", env: map[string]string{"RETRY_MAX_ATTEMPTS": "three"}, wantErr: true},
+		{name: "invalid duration", env: map[string]string{"RETRY_BUDGET": "forever"}, wantErr: true},
+		{name: "negative duration", env: map[string]string{"RETRY_BASE_DELAY": "-1s"}, wantErr: true},
+		{name: "max delay below base delay", env: map[string]string{"RETRY_BASE_DELAY": "10s", "RETRY_MAX_DELAY": "1s"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"RETRY_MAX_ATTEMPTS", "RETRY_BASE_DELAY", "RETRY_MAX_DELAY", "RETRY_BUDGET"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			got, err := ParseRetryPolicy()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRetryPolicy() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseRetryPolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRetryPolicyBackoff tests that backoff uses full jitter capped by the exponential delay and MaxDelay.
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt, ceiling := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		for range 100 {
+			if got := policy.Backoff(attempt); got < 0 || got > ceiling {
+				t.Fatalf("Backoff(%d) = %v, want between 0 and %v", attempt, got, ceiling)
+			}
+		}
+	}
+
+	if got := policy.Backoff(100); got < 0 || got > time.Second {
+		t.Errorf("Backoff(100) = %v, want between 0 and MaxDelay", got)
+	}
+	if got := (RetryPolicy{}).Backoff(0); got != 0 {
+		t.Errorf("Backoff() without delays = %v, want 0", got)
+	}
+}
+
+// TestRetryPolicyDo tests attempts, non-retryable errors, the retry budget, and the context deadline.
+func TestRetryPolicyDo(t *testing.T) {
+	errTransient := errors.New("transient")
+	fast := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: time.Second}
+
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		timeout    time.Duration
+		retryable  bool
+		retryAfter time.Duration
+		wantCalls  int
+	}{
+		{name: "retryable error exhausts attempts", policy: fast, retryable: true, wantCalls: 3},
+		{name: "non-retryable error fails immediately", policy: fast, retryable: false, wantCalls: 1},
+		{name: "single attempt", policy: RetryPolicy{MaxAttempts: 1, Budget: time.Second}, retryable: true, wantCalls: 1},
+		{name: "retry-after beyond budget", policy: fast, retryable: true, retryAfter: 2 * time.Second, wantCalls: 1},
+		{name: "retry-after beyond deadline", policy: RetryPolicy{MaxAttempts: 3, Budget: time.Hour}, timeout: 50 * time.Millisecond, retryable: true, retryAfter: time.Second, wantCalls: 1},
+		{name: "retry-after within budget", policy: fast, retryable: true, retryAfter: time.Millisecond, wantCalls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			calls := 0
+
+			err := tt.policy.Do(ctx, func() (bool, time.Duration, error) {
+				calls++
+				return tt.retryable, tt.retryAfter, errTransient
+			})
+
+			if !errors.Is(err, errTransient) {
+				t.Errorf("Do() error = %v, want %v", err, errTransient)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Do() called fn %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// TestRetryPolicyDo_Success tests that Do stops at the first successful attempt.
+func TestRetryPolicyDo_Success(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: time.Second}
+	calls := 0
+
+	err := policy.Do(context.Background(), func() (bool, time.Duration, error) {
+		calls++
+		if calls < 2 {
+			return true, 0, errors.New("transient")
+		}
+		return false, 0, nil
+	})
+
+	if err != nil {
+		t.Errorf("Do() unexpected error = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Do() called fn %d times, want 2", calls)
+	}
+}
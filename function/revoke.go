@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxRevokeRequestBytes limits the size of the revoke request body.
+const maxRevokeRequestBytes = 64 * 1024
+
+// RevokeRequest is the request body of POST /token/revoke.
+type RevokeRequest struct {
+	Token string `json:"token"`
+}
+
+// RevokeResponse is the response of POST /token/revoke for a token that was released but not revoked.
+// Revoked tokens are answered with 204 No Content.
+type RevokeResponse struct {
+	Revoked bool `json:"revoked"`
+	// Shared reports that other requests of the workflow run hold the token (token reuse): it stays
+	// valid until the last of them revokes it.
+	Shared bool `json:"shared"`
+}
+
+// handleRevokeToken handles POST /token/revoke requests.
+// The caller authenticates with its GitHub OIDC token, like for POST /token, and can only revoke
+// installation tokens the service issued for its own repository.
+func handleRevokeToken(config *Config, w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		writeError(w, NewError(CodeMethodNotAllowed, "method not allowed"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	identity, ok := authenticateCaller(ctx, config, w, r)
+	if !ok {
+		return
+	}
+	repository := identity.Repository
+
+	// Parse installation token from request body
+	var req RevokeRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRevokeRequestBytes))
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, NewError(CodeInvalidRequest, "invalid request body: %w", err))
+		return
+	}
+	token := strings.TrimSpace(req.Token)
+	if token == "" {
+		writeError(w, NewError(CodeInvalidRequest, "token is required"))
+		return
+	}
+
+	// Only tokens the service issued for the caller's repository (on the caller's GitHub host) can be revoked. Installation tokens
+	// cover the whole installation, so GitHub can't tell which repository a token was issued for.
+	fingerprint := TokenFingerprint(token)
+	issued, tracked, err := tokenStore.Get(ctx, fingerprint)
+	if err != nil {
+		writeError(w, NewError(CodeInternalError, "failed to look up token: %w", err))
+		return
+	}
+	if !tracked {
+		// Issued by another instance, or before a restart: ask GitHub what the token can access
+		baseURL, typedErr := verifyUntrackedToken(ctx, config, identity, token)
+		if typedErr != nil {
+			writeError(w, typedErr)
+			return
+		}
+		issued = IssuedToken{Repository: repository, BaseURL: baseURL}
+	}
+	if !strings.EqualFold(issued.Repository, repository) || OIDCIssuer(issued.BaseURL) != identity.Issuer {
+		writeError(w, NewError(CodeTokenNotIssuedForRepository, "installation token was not issued for repository %s", repository))
+		return
+	}
+
+	// Tokens shared by other requests of the workflow run (token reuse) stay valid until the last holder releases them
+	if tokenCache.Release(token) {
+		writeJSON(w, http.StatusOK, RevokeResponse{Revoked: false, Shared: true})
+		return
+	}
+
+	// Revoke the token
+	if err := revokeToken(ctx, issued.BaseURL, token); err != nil {
+		writeError(w, AsError(err, CodeGitHubUnavailable, "GitHub API error: %w"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyUntrackedToken checks, through the token itself, that an installation token the token store
+// doesn't know can only access the caller's repository, and returns the REST API URL of its GitHub host.
+// Such a token is of no use for any other repository, so the caller may revoke it. A token that can access
+// other repositories too can't be attributed to the caller's repository and is rejected.
+func verifyUntrackedToken(ctx context.Context, config *Config, identity Identity, token string) (string, *Error) {
+	notIssued := NewError(CodeTokenNotIssuedForRepository, "installation token was not issued for repository %s", identity.Repository)
+
+	// The token was issued on the caller's GitHub host, if at all
+	i := slices.IndexFunc(config.Apps, func(app GitHubApp) bool { return app.OIDCIssuer() == identity.Issuer })
+	if i < 0 {
+		return "", notIssued
+	}
+	baseURL := config.Apps[i].BaseURL
+
+	tokenApps, err := newTokenAppsService(baseURL, token)
+	if err != nil {
+		return "", NewError(CodeInternalError, "failed to create GitHub client: %w", err)
+	}
+	repositories, err := ListTokenRepositories(ctx, tokenApps, baseURL)
+	if err != nil {
+		return "", AsError(err, CodeGitHubUnavailable, "GitHub API error: %w")
+	}
+	if repositories.GetTotalCount() != 1 || len(repositories.Repositories) != 1 {
+		return "", notIssued
+	}
+	only := repositories.Repositories[0]
+	if !strings.EqualFold(only.GetFullName(), identity.Repository) || (identity.RepositoryID != 0 && only.GetID() != identity.RepositoryID) {
+		return "", notIssued
+	}
+	return baseURL, nil
+}
+
+// revokeToken revokes the installation token on the GitHub host of baseURL (empty for github.com) and
+// removes it from the token store, as nothing is left to revoke on schedule.
+func revokeToken(ctx context.Context, baseURL, token string) error {
+	// Create GitHub client authenticated with the token being revoked
+	tokenApps, err := newTokenAppsService(baseURL, token)
+	if err != nil {
+		return NewError(CodeInternalError, "failed to create GitHub client: %w", err)
+	}
+	if err := RevokeInstallationToken(ctx, tokenApps, baseURL); err != nil {
+		return err
+	}
+	_ = tokenStore.Delete(ctx, TokenFingerprint(token))
+	return nil
+}
+
+// withdrawToken takes back an issued token that isn't handed out to the request after all: it is no
+// longer reused for identical requests, and revoked unless other requests of the workflow run hold it.
+// If revoking fails, the token stays stored and is revoked on schedule.
+func withdrawToken(ctx context.Context, baseURL, token string) {
+	if tokenCache.Evict(token) {
+		return
+	}
+	_ = revokeToken(ctx, baseURL, token)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// Note: Revocation of a real installation token is covered by CI/CD integration. Unit tests below cover
+// request validation and the ownership check, with a test JWKS and a GitHub mock.
+
+// TestRevokeHandler_MethodNotAllowed tests that non-POST methods are rejected.
+func TestRevokeHandler_MethodNotAllowed(t *testing.T) {
+	methods := []string{http.MethodGet, http.MethodPut, http.MethodDelete, http.MethodPatch}
+
+	for _, method := range methods {
+		t.Run(method, func(t *testing.T) {
+			req := httptest.NewRequest(method, "/token/revoke", nil)
+			w := httptest.NewRecorder()
+
+			NewTokenHandler(&Config{})(w, req)
+
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("TokenHandler() status = %v, want %v", w.Code, http.StatusMethodNotAllowed)
+			}
+		})
+	}
+}
+
+// TestRevokeHandler_RequiresOIDCToken tests that revocation requires the caller's OIDC token.
+func TestRevokeHandler_RequiresOIDCToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		wantError     string
+	}{
+		{"missing Authorization header", "", "missing Authorization header"},
+		{"invalid OIDC token", "Bearer not-a-jwt", "invalid OIDC token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/token/revoke", strings.NewReader(`{"token":"ghs_xxx"}`))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			NewTokenHandler(&Config{})(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("TokenHandler() status = %v, want %v", w.Code, http.StatusUnauthorized)
+			}
+
+			var resp ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if !strings.Contains(resp.Error, tt.wantError) {
+				t.Errorf("TokenHandler() error = %v, want containing %q", resp.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+// TestRevokeHandler_Ownership tests that only tokens the service issued for the caller's repository are revoked.
+//
+// Test steps:
+//  1. Install a test JWKS, an empty token store, and a GitHub mock recording revoked tokens
+//  2. Store tokens issued for the caller's repository and for another repository of the same owner
+//  3. Verify the caller's token is revoked, while the other repository's token is rejected without
+//     calling GitHub
+func TestRevokeHandler_Ownership(t *testing.T) {
+	// Step 1: JWKS, token store, and GitHub mock
+	key := generateTestRSAKey(t)
+	useTestJWKS(t, key)
+	useFastRetryPolicy(t)
+	originalStore := tokenStore
+	t.Cleanup(func() { tokenStore = originalStore })
+	store := NewMemoryTokenStore()
+	tokenStore = store
+	var revoked []string
+	mockTokenAppsService(t, func(token string) (*github.Response, error) {
+		revoked = append(revoked, token)
+		return &github.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+	})
+
+	// Step 2: Tokens of the caller's and another repository
+	expiresAt := time.Now().Add(time.Hour)
+	for token, repository := range map[string]string{"ghs_own": "owner/repo", "ghs_other": "owner/other"} {
+		if err := store.Put(context.Background(), IssuedToken{Fingerprint: TokenFingerprint(token), Token: token, Repository: repository, RevokeAt: expiresAt, ExpiresAt: expiresAt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Step 3: Revoke each token as owner/repo
+	tests := []struct {
+		token      string
+		wantStatus int
+		wantCode   ErrorCode
+	}{
+		{token: "ghs_other", wantStatus: http.StatusForbidden, wantCode: CodeTokenNotIssuedForRepository},
+		{token: "ghs_own", wantStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/token/revoke", strings.NewReader(`{"token":"`+tt.token+`"}`))
+			req.Header.Set("Authorization", "Bearer "+signTestOIDCToken(t, key, nil))
+			w := httptest.NewRecorder()
+
+			NewTokenHandler(&Config{Apps: []GitHubApp{{Name: "default", AppID: "123"}}})(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("TokenHandler() status = %v, want %v (body: %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantCode != "" {
+				var resp ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != tt.wantCode {
+					t.Errorf("TokenHandler() code = %q (%v), want %q", resp.Code, err, tt.wantCode)
+				}
+			}
+		})
+	}
+
+	if len(revoked) != 1 || revoked[0] != "ghs_own" {
+		t.Errorf("revoked tokens = %v, want only ghs_own", revoked)
+	}
+	if _, tracked, _ := store.Get(context.Background(), TokenFingerprint("ghs_own")); tracked {
+		t.Error("revoked token is still stored")
+	}
+}
+
+// TestRevokeHandler_SharedToken tests that revoking a token held by several requests (token reuse)
+// reports that it was only released, and that the last holder's call revokes it.
+//
+// Test steps:
+//  1. Install a test JWKS, a GitHub mock recording revoked tokens, and a token issued to two requests
+//  2. Revoke it as the first holder: 200 with {"revoked":false,"shared":true}, GitHub isn't called
+//  3. Revoke it as the last holder: 204, and GitHub revokes it
+func TestRevokeHandler_SharedToken(t *testing.T) {
+	// Step 1: A token held by two requests
+	key := generateTestRSAKey(t)
+	useTestJWKS(t, key)
+	useFastRetryPolicy(t)
+	originalStore, originalCache := tokenStore, tokenCache
+	t.Cleanup(func() { tokenStore, tokenCache = originalStore, originalCache })
+	tokenStore, tokenCache = NewMemoryTokenStore(), NewTokenCache()
+	var revoked []string
+	mockTokenAppsService(t, func(token string) (*github.Response, error) {
+		revoked = append(revoked, token)
+		return &github.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+	})
+	expiresAt := time.Now().Add(time.Hour)
+	if err := tokenStore.Put(context.Background(), IssuedToken{Fingerprint: TokenFingerprint("ghs_shared"), Token: "ghs_shared", Repository: "owner/repo", RevokeAt: expiresAt, ExpiresAt: expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		_, _ = tokenCache.Issue(context.Background(), "key", time.Minute, func(ctx context.Context) (TokenResponse, error) {
+			return TokenResponse{Token: "ghs_shared", ExpiresAt: expiresAt.Format(time.RFC3339)}, nil
+		})
+	}
+	revoke := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/token/revoke", strings.NewReader(`{"token":"ghs_shared"}`))
+		req.Header.Set("Authorization", "Bearer "+signTestOIDCToken(t, key, nil))
+		w := httptest.NewRecorder()
+		NewTokenHandler(&Config{Apps: []GitHubApp{{Name: "default", AppID: "123"}}})(w, req)
+		return w
+	}
+
+	// Step 2: The first holder only releases it
+	w := revoke()
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"revoked":false,"shared":true}` {
+		t.Errorf("first revoke = %d %s, want 200 {\"revoked\":false,\"shared\":true}", w.Code, w.Body.String())
+	}
+	if len(revoked) != 0 {
+		t.Errorf("revoked tokens after the first holder = %v, want none", revoked)
+	}
+
+	// Step 3: The last holder revokes it
+	if w := revoke(); w.Code != http.StatusNoContent {
+		t.Errorf("last revoke = %d %s, want 204", w.Code, w.Body.String())
+	}
+	if len(revoked) != 1 || revoked[0] != "ghs_shared" {
+		t.Errorf("revoked tokens after the last holder = %v, want [ghs_shared]", revoked)
+	}
+}
+
+// TestRevokeHandler_UntrackedToken tests revoking tokens the token store doesn't know, e.g. issued by
+// another instance: they are revoked only if GitHub reports that they can access just the caller's
+// repository.
+//
+// Test steps:
+//  1. Install a test JWKS, an empty token store, and a GitHub mock listing each token's repositories
+//  2. Revoke each token as owner/repo (repository ID 7001)
+//  3. Verify the status, and that only the token of the caller's repository alone was revoked
+func TestRevokeHandler_UntrackedToken(t *testing.T) {
+	// Step 1: JWKS, token store, and GitHub mock
+	key := generateTestRSAKey(t)
+	useTestJWKS(t, key)
+	useFastRetryPolicy(t)
+	originalStore := tokenStore
+	t.Cleanup(func() { tokenStore = originalStore })
+	tokenStore = NewMemoryTokenStore()
+	repo := func(id int64, fullName string) *github.Repository {
+		return &github.Repository{ID: github.Ptr(id), FullName: github.Ptr(fullName)}
+	}
+	tokenRepositories := map[string][]*github.Repository{
+		"ghs_own":       {repo(7001, "owner/repo")},
+		"ghs_owner":     {repo(7001, "owner/repo"), repo(7002, "owner/other")},
+		"ghs_other":     {repo(7002, "owner/other")},
+		"ghs_recreated": {repo(7003, "owner/repo")},
+	}
+	var revoked []string
+	original := newTokenAppsService
+	t.Cleanup(func() { newTokenAppsService = original })
+	newTokenAppsService = func(baseURL, token string) (GitHubAppsService, error) {
+		return &mockAppsService{
+			listRepos: func(ctx context.Context, opts *github.ListOptions) (*github.ListRepositories, *github.Response, error) {
+				repositories, ok := tokenRepositories[token]
+				if !ok {
+					resp := &http.Response{StatusCode: http.StatusUnauthorized}
+					return nil, &github.Response{Response: resp}, &github.ErrorResponse{Response: resp, Message: "Bad credentials"}
+				}
+				return &github.ListRepositories{TotalCount: github.Ptr(len(repositories)), Repositories: repositories[:min(len(repositories), opts.PerPage)]},
+					&github.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+			},
+			revokeInstallationToken: func(ctx context.Context) (*github.Response, error) {
+				revoked = append(revoked, token)
+				return &github.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+			},
+		}, nil
+	}
+
+	tests := []struct {
+		token      string
+		wantStatus int
+		wantCode   ErrorCode
+	}{
+		{token: "ghs_own", wantStatus: http.StatusNoContent},
+		{token: "ghs_owner", wantStatus: http.StatusForbidden, wantCode: CodeTokenNotIssuedForRepository},
+		{token: "ghs_other", wantStatus: http.StatusForbidden, wantCode: CodeTokenNotIssuedForRepository},
+		{token: "ghs_recreated", wantStatus: http.StatusForbidden, wantCode: CodeTokenNotIssuedForRepository},
+		{token: "ghs_invalid", wantStatus: http.StatusBadRequest, wantCode: CodeTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			// Step 2: Revoke the token as owner/repo
+			req := httptest.NewRequest(http.MethodPost, "/token/revoke", strings.NewReader(`{"token":"`+tt.token+`"}`))
+			req.Header.Set("Authorization", "Bearer "+signTestOIDCToken(t, key, nil))
+			w := httptest.NewRecorder()
+			NewTokenHandler(&Config{Apps: []GitHubApp{{Name: "default", AppID: "123"}}})(w, req)
+
+			// Step 3: Verify the response
+			if w.Code != tt.wantStatus {
+				t.Fatalf("TokenHandler() status = %v, want %v (body: %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantCode != "" {
+				var resp ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != tt.wantCode {
+					t.Errorf("TokenHandler() code = %q (%v), want %q", resp.Code, err, tt.wantCode)
+				}
+			}
+		})
+	}
+
+	if len(revoked) != 1 || revoked[0] != "ghs_own" {
+		t.Errorf("revoked tokens = %v, want only ghs_own", revoked)
+	}
+}
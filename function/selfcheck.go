@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// selfCheckTimeout bounds one self-check of all GitHub Apps.
+const selfCheckTimeout = 30 * time.Second
+
+// permissionScopes maps the GitHub permission names that differ from their scope ID to the scope ID.
+var permissionScopes = map[string]string{
+	"repository_projects":    "projects",
+	"secret_scanning_alerts": "secret_scanning",
+}
+
+// ParseSelfCheckInterval parses the SELF_CHECK_INTERVAL environment variable: how often the GitHub
+// Apps are checked again after the check on startup. Returns 0 (only on startup, retried until it
+// passes) if not set.
+func ParseSelfCheckInterval() (time.Duration, error) {
+	envValue := strings.TrimSpace(configValue("SELF_CHECK_INTERVAL"))
+	if envValue == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(envValue)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SELF_CHECK_INTERVAL %q: %w", envValue, err)
+	}
+	if interval != 0 && interval < time.Minute {
+		return 0, fmt.Errorf("SELF_CHECK_INTERVAL %s must be 0 or at least 1m", interval)
+	}
+	return interval, nil
+}
+
+// AppCheck is the self-check result of a GitHub App.
+type AppCheck struct {
+	AppID string `json:"app_id"`
+	// Error is why the private key, the App JWT, or the app ID failed the check.
+	Error string `json:"error,omitempty"`
+	// UngrantableScopes are the scopes the catalog (AllowedScopes) allows, at the highest allowed
+	// level, that the app's permissions don't cover.
+	UngrantableScopes map[string]string `json:"ungrantable_scopes,omitempty"`
+	// UncataloguedPermissions are the app's permissions the catalog doesn't allow at their level.
+	UncataloguedPermissions map[string]string `json:"uncatalogued_permissions,omitempty"`
+}
+
+// SelfCheckReport is the result of the latest self-check of all GitHub Apps.
+type SelfCheckReport struct {
+	CheckedAt time.Time           `json:"checked_at"`
+	Apps      map[string]AppCheck `json:"apps"`
+}
+
+// selfCheck is the report of the latest self-check of this instance.
+var selfCheck struct {
+	sync.Mutex
+	report *SelfCheckReport
+}
+
+// latestSelfCheck returns the report of the latest self-check, or nil before the first one finished.
+func latestSelfCheck() *SelfCheckReport {
+	selfCheck.Lock()
+	defer selfCheck.Unlock()
+	return selfCheck.report
+}
+
+// Failed self-checks are retried after selfCheckRetryDelay, doubling up to selfCheckMaxRetryDelay until
+// they pass, so an instance recovers from a transient error even if SELF_CHECK_INTERVAL is 0.
+var (
+	selfCheckRetryDelay    = 10 * time.Second
+	selfCheckMaxRetryDelay = 5 * time.Minute
+)
+
+// StartSelfCheck checks the GitHub Apps in the background now and then every interval (if not 0)
+// until ctx is done; failed checks are retried sooner. Problems are written to stderr; the latest
+// report is served by GET /readyz.
+func StartSelfCheck(ctx context.Context, apps []GitHubApp, interval time.Duration) {
+	run := func() SelfCheckReport {
+		checkCtx, cancel := context.WithTimeout(ctx, selfCheckTimeout)
+		defer cancel()
+		report := RunSelfCheck(checkCtx, apps, time.Now())
+		selfCheck.Lock()
+		selfCheck.report = &report
+		selfCheck.Unlock()
+		for _, app := range apps {
+			for _, problem := range report.Apps[app.Name].problems() {
+				fmt.Fprintf(os.Stderr, "self-check: GitHub App '%s' (app ID %s): %s\n", app.Name, app.AppID, problem)
+			}
+		}
+		return report
+	}
+
+	initialRetryDelay, maxRetryDelay := selfCheckRetryDelay, selfCheckMaxRetryDelay
+	go func() {
+		retryDelay := initialRetryDelay
+		for {
+			delay := interval
+			if run().failed() {
+				if interval <= 0 || retryDelay < interval {
+					delay = retryDelay
+				}
+				retryDelay = min(2*retryDelay, maxRetryDelay)
+			} else {
+				retryDelay = initialRetryDelay
+				if interval <= 0 {
+					return
+				}
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// failed reports whether the private key, the App JWT, or the app ID of any GitHub App failed the check.
+func (r SelfCheckReport) failed() bool {
+	for _, check := range r.Apps {
+		if check.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// problems describes the failure and permission mismatches of the check, one line each.
+func (c AppCheck) problems() []string {
+	var problems []string
+	if c.Error != "" {
+		problems = append(problems, c.Error)
+	}
+	if len(c.UngrantableScopes) > 0 {
+		problems = append(problems, "the app can't grant scopes the catalog allows: "+formatScopes(c.UngrantableScopes))
+	}
+	if len(c.UncataloguedPermissions) > 0 {
+		problems = append(problems, "the app has permissions the catalog doesn't allow: "+formatScopes(c.UncataloguedPermissions))
+	}
+	return problems
+}
+
+// formatScopes formats scopes as a sorted list of scope:level.
+func formatScopes(scopes map[string]string) string {
+	formatted := make([]string, 0, len(scopes))
+	for _, scopeID := range sortedScopeIDs(scopes) {
+		formatted = append(formatted, scopeID+":"+scopes[scopeID])
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// RunSelfCheck checks every GitHub App: that its private key loads and parses, that GitHub accepts
+// its App JWT (GET /app), that the app ID (and client ID, if set) matches, and how its permissions
+// compare with the scope catalog.
+func RunSelfCheck(ctx context.Context, apps []GitHubApp, now time.Time) SelfCheckReport {
+	report := SelfCheckReport{CheckedAt: now, Apps: make(map[string]AppCheck, len(apps))}
+	for _, app := range apps {
+		report.Apps[app.Name] = CheckApp(ctx, app)
+	}
+	return report
+}
+
+// CheckApp runs the self-check of a GitHub App.
+func CheckApp(ctx context.Context, app GitHubApp) AppCheck {
+	check := AppCheck{AppID: app.AppID}
+
+	// Load the private key and sign the App JWT
+	apps, err := newJWTAppsService(ctx, app)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	// GitHub rejects JWTs signed by a key not registered on the app, or issued for another app
+	githubApp, _, err := apps.Get(ctx, "")
+	if err != nil {
+		check.Error = fmt.Sprintf("GET /app failed: %v", err)
+		return check
+	}
+	if id := strconv.FormatInt(githubApp.GetID(), 10); id != app.AppID {
+		check.Error = fmt.Sprintf("the App JWT authenticates app ID %s, not %s", id, app.AppID)
+		return check
+	}
+	if app.ClientID != "" && githubApp.GetClientID() != app.ClientID {
+		check.Error = fmt.Sprintf("the App JWT authenticates client ID %s, not %s", githubApp.GetClientID(), app.ClientID)
+		return check
+	}
+
+	check.UngrantableScopes, check.UncataloguedPermissions = comparePermissions(appPermissions(githubApp.GetPermissions()))
+	return check
+}
+
+// appPermissions returns all permissions of a GitHub App by scope ID, including permissions
+// outside the scope catalog (by their GitHub name).
+func appPermissions(permissions *github.InstallationPermissions) map[string]string {
+	scopes := make(map[string]string)
+	if permissions == nil {
+		return scopes
+	}
+	encoded, err := json.Marshal(permissions)
+	if err != nil {
+		return scopes
+	}
+	var byName map[string]interface{}
+	if err := json.Unmarshal(encoded, &byName); err != nil {
+		return scopes
+	}
+	for name, value := range byName {
+		level, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if scopeID, ok := permissionScopes[name]; ok {
+			name = scopeID
+		}
+		scopes[name] = level
+	}
+	return scopes
+}
+
+// comparePermissions compares the app's permissions with the scope catalog. ungrantable are the
+// allowed scopes whose highest allowed level the app's permissions don't cover; uncatalogued are the
+// app's permissions the catalog doesn't allow at their level. Metadata (read), which every app
+// has, is not reported.
+func comparePermissions(permissions map[string]string) (ungrantable, uncatalogued map[string]string) {
+	ungrantable = make(map[string]string)
+	for scopeID, levels := range AllowedScopes {
+		if BlacklistedScopes[scopeID] {
+			continue
+		}
+		if highest := levels[len(levels)-1]; !PermissionCovers(permissions[scopeID], highest) {
+			ungrantable[scopeID] = highest
+		}
+	}
+
+	uncatalogued = make(map[string]string)
+	for scopeID, level := range permissions {
+		if scopeID == "metadata" && level == "read" {
+			continue
+		}
+		levels, allowed := AllowedScopes[scopeID]
+		if !allowed || BlacklistedScopes[scopeID] || !slices.ContainsFunc(levels, func(allowedLevel string) bool {
+			return PermissionCovers(allowedLevel, level)
+		}) {
+			uncatalogued[scopeID] = level
+		}
+	}
+
+	if len(ungrantable) == 0 {
+		ungrantable = nil
+	}
+	if len(uncatalogued) == 0 {
+		uncatalogued = nil
+	}
+	return ungrantable, uncatalogued
+}
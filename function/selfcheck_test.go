@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// mockGetApp replaces newJWTAppsService for the duration of the test with a client whose GET /app
+// returns the given app and error, or fails with keyErr before calling GitHub.
+func mockGetApp(t *testing.T, app *github.App, status int, keyErr error) {
+	t.Helper()
+	original := newJWTAppsService
+	t.Cleanup(func() { newJWTAppsService = original })
+	newJWTAppsService = func(ctx context.Context, _ GitHubApp) (GitHubAppsService, error) {
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		return &mockAppsService{
+			getApp: func(ctx context.Context, appSlug string) (*github.App, *github.Response, error) {
+				resp := &github.Response{Response: &http.Response{StatusCode: status}}
+				if status != http.StatusOK {
+					return nil, resp, &github.ErrorResponse{Response: resp.Response, Message: "Bad credentials"}
+				}
+				return app, resp, nil
+			},
+		}, nil
+	}
+}
+
+// TestParseSelfCheckInterval tests parsing of the SELF_CHECK_INTERVAL environment variable.
+func TestParseSelfCheckInterval(t *testing.T) {
+	tests := []struct {
+		envValue string
+		want     time.Duration
+		wantErr  bool
+	}{
+		{envValue: "", want: 0},
+		{envValue: "0", want: 0},
+		{envValue: "15m", want: 15 * time.Minute},
+		{envValue: "30s", wantErr: true},
+		{envValue: "-1h", wantErr: true},
+		{envValue: "hourly", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.envValue, func(t *testing.T) {
+			t.Setenv("SELF_CHECK_INTERVAL", tt.envValue)
+
+			got, err := ParseSelfCheckInterval()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSelfCheckInterval() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSelfCheckInterval() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCheckApp tests the self-check of a GitHub App against GET /app.
+func TestCheckApp(t *testing.T) {
+	permissions := &github.InstallationPermissions{
+		Contents:           github.Ptr("write"),
+		Issues:             github.Ptr("read"),
+		Metadata:           github.Ptr("read"),
+		RepositoryProjects: github.Ptr("read"),
+		Administration:     github.Ptr("write"),
+		OrganizationHooks:  github.Ptr("read"),
+	}
+	githubApp := &github.App{ID: github.Ptr(int64(123)), ClientID: github.Ptr("Iv23liTest"), Permissions: permissions}
+
+	tests := []struct {
+		name             string
+		app              GitHubApp
+		status           int
+		keyErr           error
+		errContains      string
+		wantUngrantable  map[string]string
+		wantUncatalogued map[string]string
+	}{
+		{
+			name:   "matching app",
+			app:    GitHubApp{Name: "default", AppID: "123", ClientID: "Iv23liTest"},
+			status: http.StatusOK,
+			// Excerpt: read-only grants of read-write scopes and missing scopes are reported
+			wantUngrantable:  map[string]string{"issues": "write", "projects": "write", "actions": "write"},
+			wantUncatalogued: map[string]string{"administration": "write", "organization_hooks": "read"},
+		},
+		{name: "wrong app ID", app: GitHubApp{Name: "default", AppID: "456"}, status: http.StatusOK, errContains: "authenticates app ID 123, not 456"},
+		{name: "wrong client ID", app: GitHubApp{Name: "default", AppID: "123", ClientID: "Iv23liOther"}, status: http.StatusOK, errContains: "client ID Iv23liTest"},
+		{name: "JWT rejected", app: GitHubApp{Name: "default", AppID: "123"}, status: http.StatusUnauthorized, errContains: "GET /app failed"},
+		{name: "key unavailable", app: GitHubApp{Name: "default", AppID: "123"}, keyErr: errors.New("failed to parse private key"), errContains: "failed to parse private key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockGetApp(t, githubApp, tt.status, tt.keyErr)
+
+			got := CheckApp(context.Background(), tt.app)
+
+			if tt.errContains != "" {
+				if !strings.Contains(got.Error, tt.errContains) {
+					t.Errorf("CheckApp() error = %q, want containing %q", got.Error, tt.errContains)
+				}
+				return
+			}
+			if got.Error != "" {
+				t.Fatalf("CheckApp() unexpected error = %s", got.Error)
+			}
+			for scopeID, level := range tt.wantUngrantable {
+				if got.UngrantableScopes[scopeID] != level {
+					t.Errorf("UngrantableScopes[%s] = %q, want %q", scopeID, got.UngrantableScopes[scopeID], level)
+				}
+			}
+			if _, ok := got.UngrantableScopes["contents"]; ok {
+				t.Errorf("UngrantableScopes = %v, want contents granted", got.UngrantableScopes)
+			}
+			if !maps.Equal(got.UncataloguedPermissions, tt.wantUncatalogued) {
+				t.Errorf("UncataloguedPermissions = %v, want %v", got.UncataloguedPermissions, tt.wantUncatalogued)
+			}
+		})
+	}
+}
+
+// TestStartSelfCheck_RetriesFailures tests that a failed self-check is retried until it passes, even
+// without a SELF_CHECK_INTERVAL.
+//
+// Test steps:
+//  1. Mock GET /app to fail twice, then succeed, with short retry delays
+//  2. Start the self-check with interval 0
+//  3. Verify the latest report passes after the retries
+func TestStartSelfCheck_RetriesFailures(t *testing.T) {
+	// Step 1: GET /app fails twice
+	originalService, originalDelay, originalMaxDelay := newJWTAppsService, selfCheckRetryDelay, selfCheckMaxRetryDelay
+	t.Cleanup(func() {
+		newJWTAppsService, selfCheckRetryDelay, selfCheckMaxRetryDelay = originalService, originalDelay, originalMaxDelay
+		selfCheck.Lock()
+		selfCheck.report = nil
+		selfCheck.Unlock()
+	})
+	selfCheckRetryDelay, selfCheckMaxRetryDelay = time.Millisecond, 5*time.Millisecond
+	var calls atomic.Int32
+	newJWTAppsService = func(ctx context.Context, _ GitHubApp) (GitHubAppsService, error) {
+		return &mockAppsService{
+			getApp: func(ctx context.Context, appSlug string) (*github.App, *github.Response, error) {
+				if calls.Add(1) <= 2 {
+					return nil, nil, errors.New("connection reset by peer")
+				}
+				return &github.App{ID: github.Ptr(int64(123))}, &github.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+			},
+		}, nil
+	}
+
+	// Step 2: Self-check only on startup
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartSelfCheck(ctx, []GitHubApp{{Name: "default", AppID: "123"}}, 0)
+
+	// Step 3: The latest report passes
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if report := latestSelfCheck(); report != nil && !report.failed() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("self-check still failing after %d calls", calls.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("GET /app calls = %d, want 3", got)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Server modes of SERVER_MODE.
+const (
+	// serverModeFunctions serves the handler through the Functions Framework.
+	serverModeFunctions = "functions"
+	// serverModeStandalone serves the handler with a net/http server.
+	serverModeStandalone = "standalone"
+)
+
+// ServerSettings controls the HTTP server. Only the port and the shutdown timeout apply to the
+// Functions Framework; the other settings require the standalone server.
+type ServerSettings struct {
+	// Mode is "functions" (Functions Framework) or "standalone" (net/http server).
+	Mode string
+	Port string
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout, and IdleTimeout are the net/http server timeouts.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout bounds draining in-flight requests and revoking pending tokens after SIGTERM.
+	ShutdownTimeout time.Duration
+	MaxHeaderBytes  int
+	// MaxBodyBytes limits request bodies; handlers with a lower limit of their own keep it.
+	MaxBodyBytes int64
+	// TLSCertFile and TLSKeyFile enable TLS. TLSClientCAFile additionally requires client
+	// certificates signed by one of its CAs (mTLS).
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+}
+
+// defaultServerSettings serve the Functions Framework on port 8080. The shutdown timeout fits
+// within the 10 seconds Cloud Run allows after SIGTERM.
+var defaultServerSettings = ServerSettings{
+	Mode:              serverModeFunctions,
+	Port:              "8080",
+	ReadHeaderTimeout: 10 * time.Second,
+	ReadTimeout:       30 * time.Second,
+	WriteTimeout:      60 * time.Second,
+	IdleTimeout:       120 * time.Second,
+	ShutdownTimeout:   8 * time.Second,
+	MaxHeaderBytes:    64 << 10,
+	MaxBodyBytes:      1 << 20,
+}
+
+// ParseServerSettings parses the server settings from the SERVER_MODE, PORT, SERVER_READ_HEADER_TIMEOUT,
+// SERVER_READ_TIMEOUT, SERVER_WRITE_TIMEOUT, SERVER_IDLE_TIMEOUT, SERVER_SHUTDOWN_TIMEOUT,
+// SERVER_MAX_HEADER_BYTES, SERVER_MAX_BODY_BYTES, SERVER_TLS_CERT_FILE, SERVER_TLS_KEY_FILE, and
+// SERVER_TLS_CLIENT_CA_FILE environment variables. Unset variables keep their defaults.
+func ParseServerSettings() (ServerSettings, error) {
+	settings := defaultServerSettings
+
+	if envValue := strings.TrimSpace(configValue("SERVER_MODE")); envValue != "" {
+		if envValue != serverModeFunctions && envValue != serverModeStandalone {
+			return ServerSettings{}, fmt.Errorf("invalid SERVER_MODE %q: must be %q or %q", envValue, serverModeFunctions, serverModeStandalone)
+		}
+		settings.Mode = envValue
+	}
+
+	if envValue := strings.TrimSpace(configValue("PORT")); envValue != "" {
+		if port, err := strconv.Atoi(envValue); err != nil || port < 1 || port > 65535 {
+			return ServerSettings{}, fmt.Errorf("invalid PORT %q: must be a port number", envValue)
+		}
+		settings.Port = envValue
+	}
+
+	durations := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"SERVER_READ_HEADER_TIMEOUT", &settings.ReadHeaderTimeout},
+		{"SERVER_READ_TIMEOUT", &settings.ReadTimeout},
+		{"SERVER_WRITE_TIMEOUT", &settings.WriteTimeout},
+		{"SERVER_IDLE_TIMEOUT", &settings.IdleTimeout},
+		{"SERVER_SHUTDOWN_TIMEOUT", &settings.ShutdownTimeout},
+	}
+	for _, d := range durations {
+		envValue := strings.TrimSpace(configValue(d.name))
+		if envValue == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(envValue)
+		if err != nil || duration <= 0 {
+			return ServerSettings{}, fmt.Errorf("invalid %s %q: must be a positive duration", d.name, envValue)
+		}
+		*d.value = duration
+	}
+
+	if envValue := strings.TrimSpace(configValue("SERVER_MAX_HEADER_BYTES")); envValue != "" {
+		size, err := strconv.Atoi(envValue)
+		if err != nil || size < 1 {
+			return ServerSettings{}, fmt.Errorf("invalid SERVER_MAX_HEADER_BYTES %q: must be a positive integer", envValue)
+		}
+		settings.MaxHeaderBytes = size
+	}
+
+	if envValue := strings.TrimSpace(configValue("SERVER_MAX_BODY_BYTES")); envValue != "" {
+		size, err := strconv.ParseInt(envValue, 10, 64)
+		if err != nil || size < 1 {
+			return ServerSettings{}, fmt.Errorf("invalid SERVER_MAX_BODY_BYTES %q: must be a positive integer", envValue)
+		}
+		settings.MaxBodyBytes = size
+	}
+
+	settings.TLSCertFile = strings.TrimSpace(configValue("SERVER_TLS_CERT_FILE"))
+	settings.TLSKeyFile = strings.TrimSpace(configValue("SERVER_TLS_KEY_FILE"))
+	settings.TLSClientCAFile = strings.TrimSpace(configValue("SERVER_TLS_CLIENT_CA_FILE"))
+	if (settings.TLSCertFile == "") != (settings.TLSKeyFile == "") {
+		return ServerSettings{}, fmt.Errorf("SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE must be set together")
+	}
+	if settings.TLSClientCAFile != "" && settings.TLSCertFile == "" {
+		return ServerSettings{}, fmt.Errorf("SERVER_TLS_CLIENT_CA_FILE requires SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE")
+	}
+	if settings.TLSCertFile != "" && settings.Mode != serverModeStandalone {
+		return ServerSettings{}, fmt.Errorf("SERVER_TLS_CERT_FILE requires SERVER_MODE=%s", serverModeStandalone)
+	}
+
+	return settings, nil
+}
+
+// NewServer returns the standalone HTTP server of the handler, with TLS if configured.
+func NewServer(settings ServerSettings, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:              ":" + settings.Port,
+		Handler:           http.MaxBytesHandler(handler, settings.MaxBodyBytes),
+		ReadHeaderTimeout: settings.ReadHeaderTimeout,
+		ReadTimeout:       settings.ReadTimeout,
+		WriteTimeout:      settings.WriteTimeout,
+		IdleTimeout:       settings.IdleTimeout,
+		MaxHeaderBytes:    settings.MaxHeaderBytes,
+	}
+	if settings.TLSCertFile == "" {
+		return server, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(settings.TLSCertFile, settings.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if settings.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(settings.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SERVER_TLS_CLIENT_CA_FILE: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("SERVER_TLS_CLIENT_CA_FILE %s contains no PEM certificates", settings.TLSClientCAFile)
+		}
+		server.TLSConfig.ClientCAs = clientCAs
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return server, nil
+}
+
+// shutdownContext returns a context that is canceled timeout after ctx is done, so draining requests
+// and revoking tokens after SIGTERM share one shutdown deadline.
+func shutdownContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	shutdownCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(timeout, cancel)
+	})
+	return shutdownCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Serve serves on the listener until ctx is done (on SIGTERM), then stops accepting connections and
+// waits for in-flight requests until shutdownCtx is done. Returns nil after a graceful shutdown.
+func Serve(ctx, shutdownCtx context.Context, server *http.Server, listener net.Listener) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			serveErr <- server.ServeTLS(listener, "", "")
+		} else {
+			serveErr <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to drain in-flight requests: %w", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// RequestTracker counts in-flight requests, so the Functions Framework, which cannot be shut down
+// gracefully, can drain them before the process exits.
+type RequestTracker struct {
+	active atomic.Int64
+}
+
+// Track returns the handler counting its requests as in flight while they are served.
+func (t *RequestTracker) Track(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t.active.Add(1)
+		defer t.active.Add(-1)
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Wait waits until no requests are in flight or ctx is done.
+func (t *RequestTracker) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for t.active.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to drain %d in-flight requests: %w", t.active.Load(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
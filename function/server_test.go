@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate and its key, written as PEM files.
+type testCertificate struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCertificate creates a certificate for localhost signed by parent, or a self-signed CA if
+// parent is nil, and writes it to the test's temp directory.
+func newTestCertificate(t *testing.T, name string, parent *testCertificate) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certificate := &testCertificate{cert: cert, key: key, certFile: filepath.Join(dir, name+".pem"), keyFile: filepath.Join(dir, name+"-key.pem")}
+	if err := os.WriteFile(certificate.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certificate.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+// startTestServer serves the handler with the settings on a local port until the test ends, and
+// returns its address.
+func startTestServer(t *testing.T, settings ServerSettings, handler http.Handler) string {
+	t.Helper()
+	server, err := NewServer(settings, handler)
+	if err != nil {
+		t.Fatalf("NewServer() unexpected error = %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = Serve(ctx, context.Background(), server, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+// TestParseServerSettings tests parsing of the SERVER_* and PORT environment variables.
+func TestParseServerSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		want        func(ServerSettings) bool
+		errContains string
+	}{
+		{
+			name: "defaults",
+			want: func(s ServerSettings) bool { return s == defaultServerSettings },
+		},
+		{
+			name: "standalone with TLS",
+			env: map[string]string{
+				"SERVER_MODE":               "standalone",
+				"PORT":                      "8443",
+				"SERVER_WRITE_TIMEOUT":      "15s",
+				"SERVER_SHUTDOWN_TIMEOUT":   "25s",
+				"SERVER_MAX_BODY_BYTES":     "4096",
+				"SERVER_TLS_CERT_FILE":      "/tls/tls.crt",
+				"SERVER_TLS_KEY_FILE":       "/tls/tls.key",
+				"SERVER_TLS_CLIENT_CA_FILE": "/tls/ca.crt",
+			},
+			want: func(s ServerSettings) bool {
+				return s.Mode == serverModeStandalone && s.Port == "8443" && s.WriteTimeout == 15*time.Second &&
+					s.ShutdownTimeout == 25*time.Second && s.MaxBodyBytes == 4096 && s.TLSClientCAFile == "/tls/ca.crt" &&
+					s.ReadTimeout == defaultServerSettings.ReadTimeout
+			},
+		},
+		{name: "unknown mode", env: map[string]string{"SERVER_MODE": "lambda"}, errContains: "invalid SERVER_MODE"},
+		{name: "invalid port", env: map[string]string{"PORT": "http"}, errContains: "invalid PORT"},
+		{name: "invalid timeout", env: map[string]string{"SERVER_READ_TIMEOUT": "0s"}, errContains: "invalid SERVER_READ_TIMEOUT"},
+		{name: "invalid header limit", env: map[string]string{"SERVER_MAX_HEADER_BYTES": "-1"}, errContains: "invalid SERVER_MAX_HEADER_BYTES"},
+		{
+			name:        "certificate without key",
+			env:         map[string]string{"SERVER_MODE": "standalone", "SERVER_TLS_CERT_FILE": "/tls/tls.crt"},
+			errContains: "must be set together",
+		},
+		{
+			name:        "client CA without certificate",
+			env:         map[string]string{"SERVER_MODE": "standalone", "SERVER_TLS_CLIENT_CA_FILE": "/tls/ca.crt"},
+			errContains: "SERVER_TLS_CLIENT_CA_FILE requires",
+		},
+		{
+			name:        "TLS with the Functions Framework",
+			env:         map[string]string{"SERVER_TLS_CERT_FILE": "/tls/tls.crt", "SERVER_TLS_KEY_FILE": "/tls/tls.key"},
+			errContains: "requires SERVER_MODE=standalone",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			got, err := ParseServerSettings()
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseServerSettings() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseServerSettings() unexpected error = %v", err)
+			}
+			if !tt.want(got) {
+				t.Errorf("ParseServerSettings() = %+v", got)
+			}
+		})
+	}
+}
+
+// TestNewServer_MutualTLS tests that the standalone server requires client certificates signed by
+// SERVER_TLS_CLIENT_CA_FILE.
+//
+// Test steps:
+//  1. Create a CA, a server certificate, and client certificates signed by the CA and by another CA
+//  2. Start the server with TLS and the CA as client CA
+//  3. Verify only the client with the certificate of the CA is served
+func TestNewServer_MutualTLS(t *testing.T) {
+	// Step 1: Certificates
+	ca := newTestCertificate(t, "ca", nil)
+	serverCert := newTestCertificate(t, "server", ca)
+	clientCert := newTestCertificate(t, "client", ca)
+	otherClientCert := newTestCertificate(t, "other-client", newTestCertificate(t, "other-ca", nil))
+
+	// Step 2: Server
+	settings := defaultServerSettings
+	settings.TLSCertFile, settings.TLSKeyFile, settings.TLSClientCAFile = serverCert.certFile, serverCert.keyFile, ca.certFile
+	addr := startTestServer(t, settings, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+
+	// Step 3: Clients
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(client *testCertificate) (string, error) {
+		tlsConfig := &tls.Config{RootCAs: roots}
+		if client != nil {
+			tlsConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{client.cert.Raw}, PrivateKey: client.key}}
+		}
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := httpClient.Get("https://" + addr + "/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	if body, err := get(clientCert); err != nil || body != "client" {
+		t.Errorf("GET with the client certificate = %q, %v, want client", body, err)
+	}
+	if _, err := get(nil); err == nil {
+		t.Error("GET without a client certificate succeeded, want TLS handshake failure")
+	}
+	if _, err := get(otherClientCert); err == nil {
+		t.Error("GET with a client certificate of another CA succeeded, want TLS handshake failure")
+	}
+}
+
+// TestNewServer_InvalidTLS tests that missing certificates and client CAs are reported.
+func TestNewServer_InvalidTLS(t *testing.T) {
+	serverCert := newTestCertificate(t, "server", nil)
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		certFile    string
+		clientCA    string
+		errContains string
+	}{
+		{name: "missing certificate", certFile: filepath.Join(t.TempDir(), "missing.pem"), errContains: "failed to load TLS certificate"},
+		{name: "missing client CA", certFile: serverCert.certFile, clientCA: filepath.Join(t.TempDir(), "missing.pem"), errContains: "failed to read SERVER_TLS_CLIENT_CA_FILE"},
+		{name: "client CA without certificates", certFile: serverCert.certFile, clientCA: notPEM, errContains: "contains no PEM certificates"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := defaultServerSettings
+			settings.TLSCertFile, settings.TLSKeyFile, settings.TLSClientCAFile = tt.certFile, serverCert.keyFile, tt.clientCA
+
+			if _, err := NewServer(settings, http.NotFoundHandler()); err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("NewServer() error = %v, want containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+// TestServe_GracefulShutdown tests that shutting down waits for in-flight requests and refuses new
+// connections.
+//
+// Test steps:
+//  1. Start the server with a handler that blocks until released
+//  2. Send a request and shut down while it is in flight
+//  3. Verify new connections are refused, then release the request and verify it completes
+func TestServe_GracefulShutdown(t *testing.T) {
+	// Step 1: Server with a blocking handler
+	started, release := make(chan struct{}), make(chan struct{})
+	server, err := NewServer(defaultServerSettings, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "issued")
+	}))
+	if err != nil {
+		t.Fatalf("NewServer() unexpected error = %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdownCtx, cancelShutdown := shutdownContext(ctx, 5*time.Second)
+	defer cancelShutdown()
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- Serve(ctx, shutdownCtx, server, listener) }()
+
+	// Step 2: In-flight request, then shutdown
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/token")
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		response <- result{body: string(body), err: err}
+	}()
+	<-started
+	cancel()
+
+	// Step 3: New connections are refused while the request drains
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("server still accepts connections after shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-serveErr:
+		t.Fatalf("Serve() returned %v before the in-flight request completed", err)
+	default:
+	}
+
+	close(release)
+	if got := <-response; got.err != nil || got.body != "issued" {
+		t.Errorf("in-flight request = %q, %v, want issued", got.body, got.err)
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("Serve() error = %v, want nil after a graceful shutdown", err)
+	}
+}
+
+// TestShutdownContext tests that the shutdown deadline starts when the context is done.
+func TestShutdownContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdownCtx, cancelShutdown := shutdownContext(ctx, 20*time.Millisecond)
+	defer cancelShutdown()
+
+	time.Sleep(40 * time.Millisecond)
+	if shutdownCtx.Err() != nil {
+		t.Fatal("shutdown context done before the context")
+	}
+
+	cancel()
+	select {
+	case <-shutdownCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("shutdown context not done after the timeout")
+	}
+}
+
+// TestRequestTracker tests that Wait returns once in-flight requests complete, or when its context is done.
+//
+// Test steps:
+//  1. Track a handler blocking until released and start a request
+//  2. Verify Wait fails while the request is in flight and the context times out
+//  3. Release the request and verify Wait returns nil
+func TestRequestTracker(t *testing.T) {
+	// Step 1: In-flight request
+	var requests RequestTracker
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := requests.Track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/token", nil))
+		close(done)
+	}()
+	<-started
+
+	// Step 2: Wait times out while the request is in flight
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := requests.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Step 3: Wait returns once the request completes
+	close(release)
+	<-done
+	if err := requests.Wait(context.Background()); err != nil {
+		t.Errorf("Wait() unexpected error = %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// cloudKMSScope is the OAuth scope of Cloud KMS API calls.
+const cloudKMSScope = "https://www.googleapis.com/auth/cloudkms"
+
+// kmsKeyVersionPattern matches the resource name of a Cloud KMS key version.
+var kmsKeyVersionPattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+/cryptoKeyVersions/[^/]+$`)
+
+// kmsEndpoint is the Cloud KMS REST API endpoint. It's a variable so tests can use a fake server.
+var kmsEndpoint = "https://cloudkms.googleapis.com"
+
+// kmsTokenSource returns the token source of the service's Google credentials (Application Default
+// Credentials), created once. It's a variable so tests can replace it.
+var kmsTokenSource = sync.OnceValues(func() (oauth2.TokenSource, error) {
+	return google.DefaultTokenSource(context.Background(), cloudKMSScope)
+})
+
+// JWTSigner signs GitHub App JWTs. Like crypto.Signer, it signs a digest, so the private key
+// doesn't have to be in the process.
+type JWTSigner interface {
+	// SignDigest returns the RSASSA-PKCS1-v1_5 signature of the SHA-256 digest (RS256).
+	SignDigest(ctx context.Context, digest []byte) ([]byte, error)
+	// String describes the signer for error messages.
+	String() string
+}
+
+// RotatingSigner is a JWTSigner with several active private key versions. It signs with the newest
+// version GitHub hasn't rejected, so a new key can be added before it is registered on the GitHub App.
+type RotatingSigner interface {
+	JWTSigner
+	// ActiveKey returns the key version in use.
+	ActiveKey(ctx context.Context) (PrivateKeyVersion, error)
+	// RejectKey records that GitHub rejected a JWT signed with the key version, and returns the
+	// older version to fall back to, if any.
+	RejectKey(version string) (PrivateKeyVersion, bool)
+}
+
+// ParseSigner parses the private key source of a GitHub App into its JWT signer. Besides the key
+// sources of ParseKeySource, whose private key is loaded into the process to sign, it supports:
+//
+//	gcpkms://projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>/cryptoKeyVersions/<v>
+//
+// a Cloud KMS asymmetric signing key version (RSA_SIGN_PKCS1_*_SHA256) the JWT is signed with remotely.
+func ParseSigner(source string, settings KeySourceSettings) (JWTSigner, error) {
+	if name, ok := strings.CutPrefix(source, "gcpkms://"); ok {
+		if !kmsKeyVersionPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid private key source %q: expected gcpkms://projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>/cryptoKeyVersions/<version>", source)
+		}
+		return &kmsSigner{keyVersion: name}, nil
+	}
+
+	keySource, err := ParseKeySource(source, settings)
+	if err != nil {
+		return nil, err
+	}
+	return &privateKeySigner{source: keySource}, nil
+}
+
+// keySigner signs with an in-memory private key.
+type keySigner struct {
+	key *rsa.PrivateKey
+}
+
+func (s *keySigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	return rsa.SignPKCS1v15(nil, s.key, crypto.SHA256, digest)
+}
+
+func (s *keySigner) String() string {
+	return "private key"
+}
+
+// privateKeySigner signs in-process with the active private key version of its key source,
+// cached in privateKeyCache.
+type privateKeySigner struct {
+	source KeySource
+}
+
+func (s *privateKeySigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	key, err := s.ActiveKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (&keySigner{key: key.Key}).SignDigest(ctx, digest)
+}
+
+func (s *privateKeySigner) ActiveKey(ctx context.Context) (PrivateKeyVersion, error) {
+	return privateKeyCache.Active(ctx, s.source)
+}
+
+func (s *privateKeySigner) RejectKey(version string) (PrivateKeyVersion, bool) {
+	return privateKeyCache.Reject(s.source, version)
+}
+
+func (s *privateKeySigner) String() string {
+	return s.source.String()
+}
+
+// kmsSigner signs with a Cloud KMS asymmetric signing key version through the Cloud KMS REST API.
+// The private key never leaves Cloud KMS.
+type kmsSigner struct {
+	keyVersion string
+}
+
+// kmsSignRequest is the request body of the asymmetricSign method.
+type kmsSignRequest struct {
+	Digest struct {
+		SHA256 []byte `json:"sha256"`
+	} `json:"digest"`
+	DigestCRC32C int64 `json:"digestCrc32c,string"`
+}
+
+// kmsSignResponse is the response body of the asymmetricSign method.
+type kmsSignResponse struct {
+	Name                 string `json:"name"`
+	Signature            []byte `json:"signature"`
+	SignatureCRC32C      int64  `json:"signatureCrc32c,string"`
+	VerifiedDigestCRC32C bool   `json:"verifiedDigestCrc32c"`
+}
+
+// crc32c returns the CRC32C checksum Cloud KMS uses to verify the integrity of requests and responses.
+func crc32c(data []byte) int64 {
+	return int64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+}
+
+func (s *kmsSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	tokenSource, err := kmsTokenSource()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Google credentials: %w", err)
+	}
+
+	var body kmsSignRequest
+	body.Digest.SHA256 = digest
+	body.DigestCRC32C = crc32c(digest)
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode Cloud KMS request: %w", err)
+	}
+	signURL := fmt.Sprintf("%s/v1/%s:asymmetricSign", strings.TrimRight(kmsEndpoint, "/"), s.keyVersion)
+
+	var result kmsSignResponse
+	err = retryPolicy.Do(ctx, kmsBreaker.Guard(func() (bool, time.Duration, error) {
+		token, err := tokenSource.Token()
+		if err != nil {
+			return true, 0, fmt.Errorf("failed to get Google access token: %w", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, signURL, bytes.NewReader(payload))
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to create Cloud KMS request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		token.SetAuthHeader(req)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return true, 0, fmt.Errorf("failed to call Cloud KMS: %w", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		if resp.StatusCode != http.StatusOK {
+			retryable := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+			return retryable, 0, fmt.Errorf("failed to sign with Cloud KMS: status %d", resp.StatusCode)
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return false, 0, fmt.Errorf("failed to decode Cloud KMS response: %w", err)
+		}
+		// Corrupted in transit: sign again
+		if !result.VerifiedDigestCRC32C || result.Name != s.keyVersion || crc32c(result.Signature) != result.SignatureCRC32C {
+			return true, 0, fmt.Errorf("integrity check of the Cloud KMS response failed")
+		}
+		return false, 0, nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return result.Signature, nil
+}
+
+func (s *kmsSigner) String() string {
+	return fmt.Sprintf("Cloud KMS key '%s'", s.keyVersion)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// testKMSKeyVersion is the Cloud KMS key version of the fake KMS server.
+const testKMSKeyVersion = "projects/p/locations/global/keyRings/github/cryptoKeys/app/cryptoKeyVersions/1"
+
+// fakeKMSServer starts a fake Cloud KMS server signing with the key, and points the KMS client at it.
+// respond can alter the response before it is sent; it returns the HTTP status to respond with.
+func fakeKMSServer(t *testing.T, key *rsa.PrivateKey, respond func(*kmsSignResponse) int) *atomic.Int32 {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/"+testKMSKeyVersion+":asymmetricSign" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req kmsSignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, req.Digest.SHA256)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp := kmsSignResponse{
+			Name:                 testKMSKeyVersion,
+			Signature:            signature,
+			SignatureCRC32C:      crc32c(signature),
+			VerifiedDigestCRC32C: crc32c(req.Digest.SHA256) == req.DigestCRC32C,
+		}
+		status := http.StatusOK
+		if respond != nil {
+			status = respond(&resp)
+		}
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	originalEndpoint, originalTokenSource := kmsEndpoint, kmsTokenSource
+	t.Cleanup(func() { kmsEndpoint, kmsTokenSource = originalEndpoint, originalTokenSource })
+	kmsEndpoint = server.URL
+	kmsTokenSource = func() (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test-token"}), nil
+	}
+	return &calls
+}
+
+// TestParseSigner tests that gcpkms:// sources use the Cloud KMS signer and other key sources sign in-process.
+func TestParseSigner(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		wantSigner  string
+		errContains string
+	}{
+		{name: "cloud kms", source: "gcpkms://" + testKMSKeyVersion, wantSigner: "Cloud KMS key '" + testKMSKeyVersion + "'"},
+		{name: "cloud kms without version", source: "gcpkms://projects/p/locations/global/keyRings/github/cryptoKeys/app", errContains: "expected gcpkms://"},
+		{name: "secret manager", source: "secretmanager://github-app-private-key", wantSigner: "Secret Manager secret 'github-app-private-key' (version latest)"},
+		{name: "invalid key source", source: "s3://bucket/key", errContains: "unsupported scheme"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSigner(tt.source, KeySourceSettings{})
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseSigner() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSigner() unexpected error = %v", err)
+			}
+			if got.String() != tt.wantSigner {
+				t.Errorf("ParseSigner() = %s, want %s", got, tt.wantSigner)
+			}
+		})
+	}
+}
+
+// TestAppTransport_CloudKMS tests signing GitHub App JWTs with a Cloud KMS key version.
+//
+// Test steps:
+//  1. Start a fake Cloud KMS server signing with a test key
+//  2. Sign a JWT with the Cloud KMS signer
+//  3. Verify the JWT with the public key, or the expected error and number of calls
+func TestAppTransport_CloudKMS(t *testing.T) {
+	key := generateTestRSAKey(t)
+
+	tests := []struct {
+		name      string
+		respond   func(*kmsSignResponse) int
+		wantErr   bool
+		wantCalls int32
+	}{
+		{name: "signed", wantCalls: 1},
+		{
+			name:      "permission denied is not retried",
+			respond:   func(*kmsSignResponse) int { return http.StatusForbidden },
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "unavailable is retried",
+			respond:   func(*kmsSignResponse) int { return http.StatusServiceUnavailable },
+			wantErr:   true,
+			wantCalls: 3,
+		},
+		{
+			name: "corrupted signature is retried",
+			respond: func(resp *kmsSignResponse) int {
+				resp.SignatureCRC32C++
+				return http.StatusOK
+			},
+			wantErr:   true,
+			wantCalls: 3,
+		},
+		{
+			name: "unverified digest is retried",
+			respond: func(resp *kmsSignResponse) int {
+				resp.VerifiedDigestCRC32C = false
+				return http.StatusOK
+			},
+			wantErr:   true,
+			wantCalls: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFastRetryPolicy(t)
+
+			// Step 1: Fake Cloud KMS server
+			calls := fakeKMSServer(t, key, tt.respond)
+			signer, err := ParseSigner("gcpkms://"+testKMSKeyVersion, KeySourceSettings{})
+			if err != nil {
+				t.Fatalf("ParseSigner() unexpected error = %v", err)
+			}
+
+			// Step 2: Sign the JWT
+			signed, _, err := NewAppTransport(githubTransport, signer, "12345").Token(context.Background())
+
+			// Step 3: Verify the JWT or the error
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("Cloud KMS calls = %d, want %d", got, tt.wantCalls)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Token() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Token() unexpected error = %v", err)
+			}
+			token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			}, jwt.WithValidMethods([]string{"RS256"}))
+			if err != nil || !token.Valid {
+				t.Fatalf("jwt.Parse() error = %v, want a valid RS256 JWT", err)
+			}
+			if iss, _ := token.Claims.GetIssuer(); iss != "12345" {
+				t.Errorf("iss = %s, want 12345", iss)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// IssuedToken is an installation token tracked by the service after issuance.
+// The token itself is kept because revoking it requires authenticating with it.
+type IssuedToken struct {
+	Fingerprint string
+	Token       string
+	Repository  string
+	// BaseURL is the REST API URL of the GitHub host that issued the token (empty for github.com).
+	BaseURL string
+	// RevokeAt is when the service revokes the token (the effective expiry reported to the caller).
+	RevokeAt time.Time
+	// ExpiresAt is when GitHub expires the token on its own.
+	ExpiresAt time.Time
+	// RunID and RunAttempt bind the token to the workflow run attempt it was issued to
+	// (0 if not bound); the token is revoked when that run attempt completes.
+	RunID      int64
+	RunAttempt int64
+}
+
+// HasMaxLifetime reports whether the token must be revoked before GitHub's expiry
+// regardless of its workflow run.
+func (t IssuedToken) HasMaxLifetime() bool {
+	return t.RevokeAt.Before(t.ExpiresAt)
+}
+
+// TokenStore persists issued tokens pending revocation.
+// Implementations must be safe for concurrent use.
+type TokenStore interface {
+	// Put stores the token, replacing any token with the same fingerprint.
+	Put(ctx context.Context, token IssuedToken) error
+	// Get returns the token with the given fingerprint, if stored.
+	Get(ctx context.Context, fingerprint string) (IssuedToken, bool, error)
+	// Delete removes the token with the given fingerprint. Deleting a missing token is not an error.
+	Delete(ctx context.Context, fingerprint string) error
+	// Due returns the tokens whose RevokeAt is not after now.
+	Due(ctx context.Context, now time.Time) ([]IssuedToken, error)
+	// All returns all stored tokens.
+	All(ctx context.Context) ([]IssuedToken, error)
+	// ForRun returns the tokens bound to the given workflow run attempt.
+	ForRun(ctx context.Context, runID, runAttempt int64) ([]IssuedToken, error)
+}
+
+// tokenStore is the process-wide store of issued tokens.
+var tokenStore TokenStore = NewMemoryTokenStore()
+
+// TokenFingerprint returns the hex-encoded SHA-256 hash of a token, used to identify it without keeping it in plain sight.
+func TokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// runKey identifies a workflow run attempt.
+type runKey struct {
+	runID      int64
+	runAttempt int64
+}
+
+// MemoryTokenStore is an in-memory TokenStore. Stored tokens are lost when the process exits.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]IssuedToken
+	// runs indexes token fingerprints by workflow run attempt.
+	runs map[runKey]map[string]struct{}
+}
+
+// NewMemoryTokenStore creates an empty in-memory token store.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: make(map[string]IssuedToken),
+		runs:   make(map[runKey]map[string]struct{}),
+	}
+}
+
+// Put implements TokenStore.
+func (s *MemoryTokenStore) Put(_ context.Context, token IssuedToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unindex(token.Fingerprint)
+	s.tokens[token.Fingerprint] = token
+	if token.RunID != 0 {
+		key := runKey{token.RunID, token.RunAttempt}
+		if s.runs[key] == nil {
+			s.runs[key] = make(map[string]struct{})
+		}
+		s.runs[key][token.Fingerprint] = struct{}{}
+	}
+	return nil
+}
+
+// Get implements TokenStore.
+func (s *MemoryTokenStore) Get(_ context.Context, fingerprint string) (IssuedToken, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, exists := s.tokens[fingerprint]
+	return token, exists, nil
+}
+
+// Delete implements TokenStore.
+func (s *MemoryTokenStore) Delete(_ context.Context, fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unindex(fingerprint)
+	delete(s.tokens, fingerprint)
+	return nil
+}
+
+// unindex removes the stored token with the given fingerprint from the run index.
+// The caller must hold s.mu.
+func (s *MemoryTokenStore) unindex(fingerprint string) {
+	token, exists := s.tokens[fingerprint]
+	if !exists || token.RunID == 0 {
+		return
+	}
+	key := runKey{token.RunID, token.RunAttempt}
+	delete(s.runs[key], fingerprint)
+	if len(s.runs[key]) == 0 {
+		delete(s.runs, key)
+	}
+}
+
+// Due implements TokenStore.
+func (s *MemoryTokenStore) Due(_ context.Context, now time.Time) ([]IssuedToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []IssuedToken
+	for _, token := range s.tokens {
+		if !token.RevokeAt.After(now) {
+			due = append(due, token)
+		}
+	}
+	return due, nil
+}
+
+// All implements TokenStore.
+func (s *MemoryTokenStore) All(_ context.Context) ([]IssuedToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := make([]IssuedToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		all = append(all, token)
+	}
+	return all, nil
+}
+
+// ForRun implements TokenStore.
+func (s *MemoryTokenStore) ForRun(_ context.Context, runID, runAttempt int64) ([]IssuedToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fingerprints := s.runs[runKey{runID, runAttempt}]
+	tokens := make([]IssuedToken, 0, len(fingerprints))
+	for fingerprint := range fingerprints {
+		tokens = append(tokens, s.tokens[fingerprint])
+	}
+	return tokens, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestTokenFingerprint tests that fingerprints are stable SHA-256 hex digests that don't contain the token.
+func TestTokenFingerprint(t *testing.T) {
+	got := TokenFingerprint("ghs_test")
+	if len(got) != 64 {
+		t.Errorf("TokenFingerprint() length = %d, want 64", len(got))
+	}
+	if got != TokenFingerprint("ghs_test") {
+		t.Error("TokenFingerprint() is not stable")
+	}
+	if got == TokenFingerprint("ghs_other") {
+		t.Error("TokenFingerprint() collides for different tokens")
+	}
+}
+
+// TestMemoryTokenStore tests storing, listing due, and deleting tokens.
+func TestMemoryTokenStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTokenStore()
+	now := time.Now()
+
+	tokens := []IssuedToken{
+		{Fingerprint: "due", Token: "ghs_due", Repository: "owner/repo", RevokeAt: now.Add(-time.Second)},
+		{Fingerprint: "due-now", Token: "ghs_due_now", Repository: "owner/repo", RevokeAt: now},
+		{Fingerprint: "pending", Token: "ghs_pending", Repository: "owner/repo", RevokeAt: now.Add(time.Minute)},
+	}
+	for _, token := range tokens {
+		if err := store.Put(ctx, token); err != nil {
+			t.Fatalf("Put() unexpected error = %v", err)
+		}
+	}
+
+	got, exists, err := store.Get(ctx, "pending")
+	if err != nil || !exists || got.Token != "ghs_pending" {
+		t.Errorf("Get() = %v, %v, %v, want pending token", got, exists, err)
+	}
+
+	due, err := store.Due(ctx, now)
+	if err != nil {
+		t.Fatalf("Due() unexpected error = %v", err)
+	}
+	if len(due) != 2 {
+		t.Errorf("Due() returned %d tokens, want 2", len(due))
+	}
+
+	if err := store.Delete(ctx, "due"); err != nil {
+		t.Fatalf("Delete() unexpected error = %v", err)
+	}
+	if err := store.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete() of missing token error = %v, want nil", err)
+	}
+	if _, exists, _ := store.Get(ctx, "due"); exists {
+		t.Error("Get() found deleted token")
+	}
+
+	all, err := store.All(ctx)
+	if err != nil {
+		t.Fatalf("All() unexpected error = %v", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("All() returned %d tokens, want 2", len(all))
+	}
+}
+
+// TestMemoryTokenStoreForRun tests the workflow run index across Put, replacement, and Delete.
+func TestMemoryTokenStoreForRun(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTokenStore()
+
+	_ = store.Put(ctx, IssuedToken{Fingerprint: "a", RunID: 100, RunAttempt: 1})
+	_ = store.Put(ctx, IssuedToken{Fingerprint: "b", RunID: 100, RunAttempt: 1})
+	_ = store.Put(ctx, IssuedToken{Fingerprint: "retry", RunID: 100, RunAttempt: 2})
+	_ = store.Put(ctx, IssuedToken{Fingerprint: "unbound"})
+
+	if got, _ := store.ForRun(ctx, 100, 1); len(got) != 2 {
+		t.Errorf("ForRun(100, 1) returned %d tokens, want 2", len(got))
+	}
+	if got, _ := store.ForRun(ctx, 100, 2); len(got) != 1 {
+		t.Errorf("ForRun(100, 2) returned %d tokens, want 1", len(got))
+	}
+	if got, _ := store.ForRun(ctx, 0, 0); len(got) != 0 {
+		t.Errorf("ForRun(0, 0) returned %d tokens, want 0 (unbound tokens are not indexed)", len(got))
+	}
+
+	// Replacing a token moves it to its new run attempt
+	_ = store.Put(ctx, IssuedToken{Fingerprint: "b", RunID: 200, RunAttempt: 1})
+	if got, _ := store.ForRun(ctx, 100, 1); len(got) != 1 || got[0].Fingerprint != "a" {
+		t.Errorf("ForRun(100, 1) after replacement = %v, want only 'a'", got)
+	}
+
+	_ = store.Delete(ctx, "a")
+	if got, _ := store.ForRun(ctx, 100, 1); len(got) != 0 {
+		t.Errorf("ForRun(100, 1) after Delete returned %d tokens, want 0", len(got))
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwtLifetime is the lifetime of GitHub App JWTs from their iat claim (GitHub's maximum allowed).
+	jwtLifetime = 10 * time.Minute
+	// jwtClockSkew is how far the iat claim of GitHub App JWTs is backdated, so a GitHub clock
+	// running behind doesn't reject them as issued in the future.
+	jwtClockSkew = time.Minute
+	// jwtRefreshMargin is how long before its expiry a cached GitHub App JWT is signed again.
+	jwtRefreshMargin = time.Minute
+)
+
+// githubTransport is the HTTP transport shared by all GitHub API clients, so connections (HTTP/2 where
+// the GitHub host supports it) are reused across requests.
+var githubTransport = newGitHubTransport()
+
+// newGitHubTransport creates the HTTP transport of GitHub API clients. Unlike http.DefaultTransport,
+// which keeps only 2 idle connections per host, it keeps enough for concurrent requests to one GitHub host.
+func newGitHubTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 100
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return transport
+}
+
+// AppTransport is an http.RoundTripper authenticating requests as a GitHub App. It caches the App JWT
+// and signs a new one shortly before it expires; concurrent requests share one signature, and requests
+// arriving while it is signed keep using the cached JWT until it expires. With several active private
+// key versions (RotatingSigner), requests GitHub rejects (401) are retried with a JWT signed by an
+// older version. It is safe for concurrent use.
+type AppTransport struct {
+	base   http.RoundTripper
+	signer JWTSigner
+	issuer string
+	group  singleflight.Group
+
+	mu sync.Mutex
+	// jwt is the cached JWT, signed with the key version (of a RotatingSigner).
+	jwt       string
+	version   string
+	expiresAt time.Time
+	// refreshing reports whether a new JWT is being signed.
+	refreshing bool
+	now        func() time.Time
+}
+
+// appJWT is a signed App JWT and the key version it is signed with.
+type appJWT struct {
+	jwt       string
+	version   string
+	expiresAt time.Time
+}
+
+// NewAppTransport creates a transport authenticating requests sent through base with JWTs signed by
+// the signer, issued by the app's client ID or app ID.
+func NewAppTransport(base http.RoundTripper, signer JWTSigner, issuer string) *AppTransport {
+	return &AppTransport{base: base, signer: signer, issuer: issuer, now: time.Now}
+}
+
+// Token returns the cached App JWT and the key version it is signed with, signing a new one if it
+// expires within jwtRefreshMargin. The key is loaded and the JWT signed without holding the lock, so
+// while a new JWT is signed, other callers get the cached one if it hasn't expired yet.
+func (t *AppTransport) Token(ctx context.Context) (jwt, version string, err error) {
+	t.mu.Lock()
+	now := t.now()
+	cached := appJWT{jwt: t.jwt, version: t.version, expiresAt: t.expiresAt}
+	valid := cached.jwt != "" && now.Before(cached.expiresAt)
+	if valid && (t.refreshing || now.Add(jwtRefreshMargin).Before(cached.expiresAt)) {
+		t.mu.Unlock()
+		return cached.jwt, cached.version, nil
+	}
+	t.mu.Unlock()
+
+	result, err, _ := t.group.Do("jwt", func() (interface{}, error) {
+		t.mu.Lock()
+		t.refreshing = true
+		t.mu.Unlock()
+		defer func() {
+			t.mu.Lock()
+			t.refreshing = false
+			t.mu.Unlock()
+		}()
+
+		signed, err := t.sign(ctx, now)
+		if err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.jwt, t.version, t.expiresAt = signed.jwt, signed.version, signed.expiresAt
+		t.mu.Unlock()
+		return signed, nil
+	})
+	if err != nil {
+		// Keep using the cached JWT until it expires
+		if valid && t.now().Before(cached.expiresAt) {
+			return cached.jwt, cached.version, nil
+		}
+		return "", "", err
+	}
+	signed := result.(appJWT)
+	return signed.jwt, signed.version, nil
+}
+
+// sign signs a new App JWT issued at now with the signer, or with the active key version of a RotatingSigner.
+func (t *AppTransport) sign(ctx context.Context, now time.Time) (appJWT, error) {
+	signer, version := t.signer, ""
+	if rotating, ok := t.signer.(RotatingSigner); ok {
+		key, err := rotating.ActiveKey(ctx)
+		if err != nil {
+			return appJWT{}, err
+		}
+		signer, version = &keySigner{key: key.Key}, key.Version
+	}
+	jwt, expiresAt, err := signJWTAt(ctx, signer, t.issuer, now)
+	if err != nil {
+		return appJWT{}, err
+	}
+	return appJWT{jwt: jwt, version: version, expiresAt: expiresAt}, nil
+}
+
+// reject drops the cached JWT signed with the key version GitHub rejected and reports whether the
+// request can be retried with a JWT signed by another key version.
+func (t *AppTransport) reject(ctx context.Context, version string) bool {
+	t.mu.Lock()
+	if t.version == version {
+		t.jwt = ""
+	}
+	t.mu.Unlock()
+
+	rotating, ok := t.signer.(RotatingSigner)
+	if !ok {
+		return false
+	}
+	rotating.RejectKey(version)
+	key, err := rotating.ActiveKey(ctx)
+	return err == nil && key.Version != version
+}
+
+func (t *AppTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		jwt, version, err := t.Token(req.Context())
+		if err != nil {
+			return nil, NewError(CodePrivateKeyUnavailable, "%w", err)
+		}
+
+		authenticated := req.Clone(req.Context())
+		if attempt > 0 && req.Body != nil {
+			if authenticated.Body, err = req.GetBody(); err != nil {
+				return nil, fmt.Errorf("failed to reset request body: %w", err)
+			}
+		}
+		authenticated.Header.Set("Authorization", "Bearer "+jwt)
+
+		resp, err := t.base.RoundTrip(authenticated)
+		metrics.RecordGitHubRateLimit(t.issuer, resp)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) || !t.reject(req.Context(), version) {
+			return resp, err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+}
+
+// appClients are the process-wide GitHub API clients authenticated as each GitHub App.
+var appClients = struct {
+	sync.Mutex
+	clients map[string]*appClient
+}{clients: make(map[string]*appClient)}
+
+// appClient is a GitHub API client authenticated as a GitHub App.
+type appClient struct {
+	client    *github.Client
+	transport *AppTransport
+}
+
+// getAppClient returns the GitHub API client authenticated as the app, creating it on first use.
+// Clients are keyed by the app's configuration, so a changed configuration gets a new client.
+func getAppClient(app GitHubApp) (*appClient, error) {
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%s", app.Name, app.Issuer(), app.BaseURL, app.signer)
+
+	appClients.Lock()
+	defer appClients.Unlock()
+	if client, ok := appClients.clients[key]; ok {
+		return client, nil
+	}
+
+	transport := NewAppTransport(githubTransport, app.signer, app.Issuer())
+	opts := []github.ClientOptionsFunc{github.WithTransport(transport)}
+	if app.BaseURL != "" {
+		opts = append(opts, github.WithEnterpriseURLs(app.BaseURL, app.BaseURL))
+	}
+	client, err := github.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	appClients.clients[key] = &appClient{client: client, transport: transport}
+	return appClients.clients[key], nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// countingSigner is a JWTSigner signing with an in-memory key, counting signatures.
+type countingSigner struct {
+	keySigner
+	signatures int
+}
+
+func (s *countingSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	s.signatures++
+	return s.keySigner.SignDigest(ctx, digest)
+}
+
+// TestAppTransport_CachedJWT tests that the App JWT is reused across requests and signed again
+// shortly before it expires.
+//
+// Test steps:
+//  1. Start a fake GitHub API recording the JWTs it receives
+//  2. Send requests within the JWT lifetime and verify a single JWT is signed, with the client ID as issuer and a backdated iat
+//  3. Advance to within the refresh margin of the expiry and verify a new JWT is signed
+func TestAppTransport_CachedJWT(t *testing.T) {
+	key := generateTestRSAKey(t)
+
+	// Step 1: Fake GitHub API
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	}))
+	t.Cleanup(server.Close)
+
+	signer := &countingSigner{keySigner: keySigner{key: key}}
+	transport := NewAppTransport(githubTransport, signer, "Iv23liTest")
+	now := time.Now()
+	transport.now = func() time.Time { return now }
+	client := &http.Client{Transport: transport}
+
+	get := func() {
+		t.Helper()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Get() unexpected error = %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	// Step 2: Requests within the JWT lifetime share one JWT
+	get()
+	now = now.Add(5 * time.Minute)
+	get()
+	if signer.signatures != 1 || received[0] != received[1] {
+		t.Errorf("signatures = %d, want 1 JWT reused", signer.signatures)
+	}
+	token, err := jwt.Parse(received[0], func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil },
+		jwt.WithTimeFunc(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("jwt.Parse() error = %v", err)
+	}
+	if iss, _ := token.Claims.GetIssuer(); iss != "Iv23liTest" {
+		t.Errorf("iss = %s, want the client ID", iss)
+	}
+	if iat, _ := token.Claims.GetIssuedAt(); !iat.Equal(now.Add(-5*time.Minute - jwtClockSkew).Truncate(time.Second)) {
+		t.Errorf("iat = %v, want backdated by %s", iat, jwtClockSkew)
+	}
+
+	// Step 3: A JWT about to expire is signed again
+	now = now.Add(jwtLifetime - jwtClockSkew - 5*time.Minute - jwtRefreshMargin)
+	get()
+	if signer.signatures != 2 || received[2] == received[1] {
+		t.Errorf("signatures = %d, want a new JWT before expiry", signer.signatures)
+	}
+}
+
+// TestAppTransport_Token tests the App JWT signed with the private key of a key source.
+//
+// Test steps:
+//  1. Create transports signing with the key of an environment variable, set or unset
+//  2. Get the App JWT
+//  3. Verify it is signed with RS256 and expires jwtLifetime after its iat, or that a missing key is an error
+func TestAppTransport_Token(t *testing.T) {
+	key := generateTestRSAKey(t)
+	t.Setenv("TEST_TOKEN_PRIVATE_KEY", encodePrivateKey(key))
+	t.Setenv("TEST_TOKEN_PRIVATE_KEY_UNSET", "")
+
+	tests := []struct {
+		name        string
+		source      string
+		errContains string
+	}{
+		{name: "private key", source: "env://TEST_TOKEN_PRIVATE_KEY"},
+		{name: "missing private key", source: "env://TEST_TOKEN_PRIVATE_KEY_UNSET", errContains: "failed to retrieve private key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Transport signing with the key source
+			signer, err := ParseSigner(tt.source, KeySourceSettings{})
+			if err != nil {
+				t.Fatalf("ParseSigner() unexpected error = %v", err)
+			}
+			transport := NewAppTransport(githubTransport, signer, "12345")
+
+			// Step 2: Get the App JWT
+			tokenString, _, err := transport.Token(context.Background())
+
+			// Step 3: Verify the JWT
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("Token() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Token() unexpected error = %v", err)
+			}
+			token, err := jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil })
+			if err != nil {
+				t.Fatalf("jwt.Parse() error = %v", err)
+			}
+			if token.Method.Alg() != "RS256" {
+				t.Errorf("JWT algorithm = %v, want RS256", token.Method.Alg())
+			}
+			if iss, _ := token.Claims.GetIssuer(); iss != "12345" {
+				t.Errorf("iss = %s, want the app ID", iss)
+			}
+			iat, _ := token.Claims.GetIssuedAt()
+			exp, _ := token.Claims.GetExpirationTime()
+			if iat == nil || exp == nil || exp.Sub(iat.Time) != jwtLifetime {
+				t.Errorf("iat = %v, exp = %v, want exp %s after iat", iat, exp, jwtLifetime)
+			}
+		})
+	}
+}
+
+// blockingSigner is a JWTSigner signing with an in-memory key, whose signatures wait for release
+// once block is set.
+type blockingSigner struct {
+	keySigner
+	block   atomic.Bool
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	if s.block.Load() {
+		s.started <- struct{}{}
+		<-s.release
+	}
+	return s.keySigner.SignDigest(ctx, digest)
+}
+
+// TestAppTransport_RefreshOutsideLock tests that requests keep using the cached JWT while a new one is
+// signed, instead of waiting for the signature.
+//
+// Test steps:
+//  1. Sign a JWT and advance to within the refresh margin of its expiry
+//  2. Start a request signing a new JWT, blocked in the signer
+//  3. Verify another request gets the cached JWT meanwhile, and the new JWT is used once signed
+func TestAppTransport_RefreshOutsideLock(t *testing.T) {
+	// Step 1: Cached JWT about to expire
+	signer := &blockingSigner{keySigner: keySigner{key: generateTestRSAKey(t)}, started: make(chan struct{}), release: make(chan struct{})}
+	transport := NewAppTransport(githubTransport, signer, "123")
+	now := time.Now()
+	transport.now = func() time.Time { return now }
+	cached, _, err := transport.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() unexpected error = %v", err)
+	}
+	now = now.Add(jwtLifetime - jwtClockSkew - jwtRefreshMargin/2)
+
+	// Step 2: Refresh blocked in the signer
+	signer.block.Store(true)
+	refreshed := make(chan string)
+	go func() {
+		jwt, _, _ := transport.Token(context.Background())
+		refreshed <- jwt
+	}()
+	<-signer.started
+
+	// Step 3: The cached JWT is served meanwhile, then the new one
+	got, _, err := transport.Token(context.Background())
+	if err != nil || got != cached {
+		t.Errorf("Token() during refresh = %v, want the cached JWT", err)
+	}
+	close(signer.release)
+	newJWT := <-refreshed
+	if newJWT == "" || newJWT == cached {
+		t.Fatal("refreshing Token() returned the cached JWT, want a new one")
+	}
+	if got, _, _ := transport.Token(context.Background()); got != newJWT {
+		t.Error("Token() after refresh didn't return the new JWT")
+	}
+}
+
+// TestAppTransport_KeyFallbackResendsBody tests that a request with a body rejected with 401 is sent
+// again, with the same body, with a JWT signed by the previous private key version.
+func TestAppTransport_KeyFallbackResendsBody(t *testing.T) {
+	registered := generateTestRSAKey(t)
+	source := &fakeKeySource{name: t.Name(), payload: []byte(encodeTestKey(t) + encodePrivateKey(registered))}
+
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		signed := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return &registered.PublicKey, nil }); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: NewAppTransport(githubTransport, &privateKeySigner{source: source}, "123")}
+	resp, err := client.Post(server.URL, "application/json", strings.NewReader(`{"permissions":{}}`))
+	if err != nil {
+		t.Fatalf("Post() unexpected error = %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200 with the previous key version", resp.StatusCode)
+	}
+	if len(bodies) != 2 || bodies[0] != bodies[1] {
+		t.Errorf("request bodies = %q, want the same body sent twice", bodies)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// maxTTLParam is the query parameter with the caller's maximum token lifetime (Go duration, e.g. "10m").
+	maxTTLParam = "max_ttl"
+
+	// minTokenTTL is the shortest allowed token lifetime; revocation runs every revocationSweepInterval.
+	minTokenTTL = 1 * time.Minute
+
+	// maxTokenTTL is GitHub's fixed installation token lifetime.
+	maxTokenTTL = 1 * time.Hour
+
+	revocationSweepInterval = 15 * time.Second
+)
+
+// ParseTokenTTL parses a token lifetime, which must be between minTokenTTL and maxTokenTTL.
+func ParseTokenTTL(value string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid token lifetime %q: %w", value, err)
+	}
+	if ttl < minTokenTTL || ttl > maxTokenTTL {
+		return 0, fmt.Errorf("token lifetime %s must be between %s and %s", ttl, minTokenTTL, maxTokenTTL)
+	}
+	return ttl, nil
+}
+
+// ParseMaxTokenTTL parses the GITHUB_MAX_TOKEN_TTL environment variable, the lifetime policy applied to all tokens.
+// Returns 0 (GitHub's default lifetime) if the variable is not set.
+func ParseMaxTokenTTL() (time.Duration, error) {
+	envValue := strings.TrimSpace(configValue("GITHUB_MAX_TOKEN_TTL"))
+	if envValue == "" {
+		return 0, nil
+	}
+	ttl, err := ParseTokenTTL(envValue)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GITHUB_MAX_TOKEN_TTL: %w", err)
+	}
+	return ttl, nil
+}
+
+// EffectiveTokenTTL returns the shorter of the requested and policy lifetimes, ignoring zero (unset) values.
+func EffectiveTokenTTL(requested, policy time.Duration) time.Duration {
+	switch {
+	case requested == 0:
+		return policy
+	case policy == 0:
+		return requested
+	default:
+		return min(requested, policy)
+	}
+}
+
+// ScheduleRevocation records an issued token for revocation at its RevokeAt time
+// and, if it is bound to a workflow run, when that run completes. Tokens without a maximum
+// lifetime are only removed from the store once GitHub expires them.
+func ScheduleRevocation(ctx context.Context, store TokenStore, token IssuedToken) error {
+	if err := store.Put(ctx, token); err != nil {
+		return fmt.Errorf("failed to schedule token revocation: %w", err)
+	}
+	return nil
+}
+
+// RevokeDueTokens revokes every stored token whose revocation time has passed.
+// Tokens that are revoked, already invalid, or expired by GitHub are removed from the store;
+// tokens that failed to revoke because of transient errors are kept and retried on the next call.
+func RevokeDueTokens(ctx context.Context, store TokenStore, now time.Time) error {
+	due, err := store.Due(ctx, now)
+	if err != nil {
+		return fmt.Errorf("failed to list tokens due for revocation: %w", err)
+	}
+	_, err = revokeStoredTokens(ctx, store, due, now)
+	return err
+}
+
+// RevokeAllTokens revokes every stored token with a maximum lifetime immediately, before the process
+// exits and the in-memory store is lost: no other instance would revoke them, and they would outlive
+// the expires_at reported to the caller until GitHub's expiry. Revoking early is the safe direction for
+// a maximum lifetime. Tokens that are only bound to a workflow run are left alone, since their run may
+// still be using them.
+func RevokeAllTokens(ctx context.Context, store TokenStore, now time.Time) error {
+	all, err := store.All(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list stored tokens: %w", err)
+	}
+	var limited []IssuedToken
+	for _, token := range all {
+		if token.HasMaxLifetime() {
+			limited = append(limited, token)
+		}
+	}
+	_, err = revokeStoredTokens(ctx, store, limited, now)
+	return err
+}
+
+// revokeStoredTokens revokes the given tokens and removes them from the store. It returns the number of
+// tokens that are no longer valid: revoked, or already invalid or expired.
+func revokeStoredTokens(ctx context.Context, store TokenStore, tokens []IssuedToken, now time.Time) (int, error) {
+	var failed int
+	for _, issued := range tokens {
+		if !issued.ExpiresAt.After(now) {
+			// Already expired on GitHub's side
+			_ = store.Delete(ctx, issued.Fingerprint)
+			continue
+		}
+
+		apps, err := newTokenAppsService(issued.BaseURL, issued.Token)
+		if err != nil {
+			failed++
+			continue
+		}
+		if err := RevokeInstallationToken(ctx, apps, issued.BaseURL); err != nil {
+			// Tokens that are already revoked or expired need no retry
+			var typedErr *Error
+			if !errors.As(err, &typedErr) || typedErr.Code != CodeTokenInvalid {
+				failed++
+				continue
+			}
+		}
+		_ = store.Delete(ctx, issued.Fingerprint)
+	}
+
+	if failed > 0 {
+		return len(tokens) - failed, fmt.Errorf("failed to revoke %d of %d tokens", failed, len(tokens))
+	}
+	return len(tokens), nil
+}
+
+// StartRevocationScheduler revokes due tokens every revocationSweepInterval until ctx is done.
+func StartRevocationScheduler(ctx context.Context, store TokenStore) {
+	go func() {
+		ticker := time.NewTicker(revocationSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				sweepCtx, cancel := context.WithTimeout(ctx, revocationSweepInterval)
+				_ = RevokeDueTokens(sweepCtx, store, now)
+				cancel()
+			}
+		}
+	}()
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+// TestParseTokenTTL tests parsing and bounds of token lifetimes.
+func TestParseTokenTTL(t *testing.T) {
+	tests := []struct {
+		value       string
+		want        time.Duration
+		errContains string
+	}{
+		{value: "10m", want: 10 * time.Minute},
+		{value: " 1h ", want: time.Hour},
+		{value: "1m", want: time.Minute},
+		{value: "30s", errContains: "must be between"},
+		{value: "2h", errContains: "must be between"},
+		{value: "-5m", errContains: "must be between"},
+		{value: "ten minutes", errContains: "invalid token lifetime"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTokenTTL(tt.value)
+
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseTokenTTL() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("ParseTokenTTL() unexpected error = %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseTokenTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseMaxTokenTTL tests parsing of the GITHUB_MAX_TOKEN_TTL environment variable.
+func TestParseMaxTokenTTL(t *testing.T) {
+	t.Setenv("GITHUB_MAX_TOKEN_TTL", "")
+	if got, err := ParseMaxTokenTTL(); err != nil || got != 0 {
+		t.Errorf("ParseMaxTokenTTL() = %v, %v, want 0, nil", got, err)
+	}
+
+	t.Setenv("GITHUB_MAX_TOKEN_TTL", "15m")
+	if got, err := ParseMaxTokenTTL(); err != nil || got != 15*time.Minute {
+		t.Errorf("ParseMaxTokenTTL() = %v, %v, want 15m, nil", got, err)
+	}
+
+	t.Setenv("GITHUB_MAX_TOKEN_TTL", "3h")
+	if _, err := ParseMaxTokenTTL(); err == nil || !strings.Contains(err.Error(), "GITHUB_MAX_TOKEN_TTL") {
+		t.Errorf("ParseMaxTokenTTL() error = %v, want containing GITHUB_MAX_TOKEN_TTL", err)
+	}
+}
+
+// TestEffectiveTokenTTL tests combining the caller's and the policy's lifetimes.
+func TestEffectiveTokenTTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested time.Duration
+		policy    time.Duration
+		want      time.Duration
+	}{
+		{"neither set", 0, 0, 0},
+		{"only requested", 10 * time.Minute, 0, 10 * time.Minute},
+		{"only policy", 0, 20 * time.Minute, 20 * time.Minute},
+		{"requested shorter", 5 * time.Minute, 20 * time.Minute, 5 * time.Minute},
+		{"policy shorter", 30 * time.Minute, 20 * time.Minute, 20 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EffectiveTokenTTL(tt.requested, tt.policy); got != tt.want {
+				t.Errorf("EffectiveTokenTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// mockTokenAppsService replaces newTokenAppsService for the duration of the test.
+// The revoke callback receives the installation token the client would be authenticated with.
+func mockTokenAppsService(t *testing.T, revoke func(token string) (*github.Response, error)) {
+	t.Helper()
+	original := newTokenAppsService
+	t.Cleanup(func() { newTokenAppsService = original })
+	newTokenAppsService = func(baseURL, token string) (GitHubAppsService, error) {
+		return &mockAppsService{
+			revokeInstallationToken: func(ctx context.Context) (*github.Response, error) {
+				return revoke(token)
+			},
+		}, nil
+	}
+}
+
+// TestRevokeDueTokens tests that due tokens are revoked and removed, and transient failures are kept for retry.
+//
+// Test steps:
+//  1. Store tokens: due, due but already revoked, due but failing, expired by GitHub, and not yet due
+//  2. Call RevokeDueTokens
+//  3. Verify only the due, non-expired tokens were sent to GitHub
+//  4. Verify only the failing and not-yet-due tokens remain stored
+func TestRevokeDueTokens(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryTokenStore()
+
+	// Step 1: Store tokens
+	for _, token := range []IssuedToken{
+		{Fingerprint: "due", Token: "ghs_due", RevokeAt: now.Add(-time.Second), ExpiresAt: now.Add(time.Hour)},
+		{Fingerprint: "revoked", Token: "ghs_revoked", RevokeAt: now.Add(-time.Second), ExpiresAt: now.Add(time.Hour)},
+		{Fingerprint: "failing", Token: "ghs_failing", RevokeAt: now.Add(-time.Second), ExpiresAt: now.Add(time.Hour)},
+		{Fingerprint: "expired", Token: "ghs_expired", RevokeAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Second)},
+		{Fingerprint: "pending", Token: "ghs_pending", RevokeAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)},
+	} {
+		_ = store.Put(ctx, token)
+	}
+
+	var revoked []string
+	mockTokenAppsService(t, func(token string) (*github.Response, error) {
+		revoked = append(revoked, token)
+		switch token {
+		case "ghs_revoked":
+			return &github.Response{Response: &http.Response{StatusCode: http.StatusUnauthorized}}, fmt.Errorf("bad credentials")
+		case "ghs_failing":
+			return &github.Response{Response: &http.Response{StatusCode: http.StatusBadRequest}}, fmt.Errorf("bad request")
+		default:
+			return &github.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+		}
+	})
+
+	// Step 2: Revoke due tokens
+	err := RevokeDueTokens(ctx, store, now)
+	if err == nil || !strings.Contains(err.Error(), "failed to revoke 1 of 4 tokens") {
+		t.Errorf("RevokeDueTokens() error = %v, want containing 'failed to revoke 1 of 4 tokens'", err)
+	}
+
+	// Step 3: Verify revoked tokens
+	if len(revoked) != 3 {
+		t.Errorf("RevokeDueTokens() revoked %v, want 3 tokens", revoked)
+	}
+	for _, token := range revoked {
+		if token == "ghs_expired" || token == "ghs_pending" {
+			t.Errorf("RevokeDueTokens() revoked %s, which is not due", token)
+		}
+	}
+
+	// Step 4: Verify remaining tokens
+	remaining, _ := store.All(ctx)
+	var fingerprints []string
+	for _, token := range remaining {
+		fingerprints = append(fingerprints, token.Fingerprint)
+	}
+	if len(remaining) != 2 {
+		t.Errorf("remaining tokens = %v, want [failing pending]", fingerprints)
+	}
+}
+
+// TestRevokeAllTokens tests that all tokens with a maximum lifetime are revoked regardless of their
+// revocation time, and tokens only bound to a workflow run are kept.
+//
+// Test steps:
+//  1. Store two tokens with a maximum lifetime, not yet due, and a token only bound to a run
+//  2. Call RevokeAllTokens
+//  3. Verify both tokens with a maximum lifetime were revoked and only the run-bound token remains
+func TestRevokeAllTokens(t *testing.T) {
+	// Step 1: Store tokens
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryTokenStore()
+	_ = store.Put(ctx, IssuedToken{Fingerprint: "a", Token: "ghs_a", RevokeAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)})
+	_ = store.Put(ctx, IssuedToken{Fingerprint: "b", Token: "ghs_b", RevokeAt: now.Add(50 * time.Minute), ExpiresAt: now.Add(time.Hour)})
+	_ = store.Put(ctx, IssuedToken{Fingerprint: "run", Token: "ghs_run", RevokeAt: now.Add(time.Hour), ExpiresAt: now.Add(time.Hour), RunID: 1, RunAttempt: 1})
+
+	revokeCount := 0
+	mockTokenAppsService(t, func(token string) (*github.Response, error) {
+		revokeCount++
+		return &github.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+	})
+
+	// Step 2: Revoke all tokens with a maximum lifetime
+	if err := RevokeAllTokens(ctx, store, now); err != nil {
+		t.Fatalf("RevokeAllTokens() unexpected error = %v", err)
+	}
+
+	// Step 3: Verify revoked and remaining tokens
+	if revokeCount != 2 {
+		t.Errorf("RevokeAllTokens() revoked %d tokens, want 2", revokeCount)
+	}
+	if remaining, _ := store.All(ctx); len(remaining) != 1 || remaining[0].Fingerprint != "run" {
+		t.Errorf("RevokeAllTokens() left %v in store, want only the run-bound token", remaining)
+	}
+}
+
+// TestScheduleRevocation tests that scheduled tokens are stored under their fingerprint.
+func TestScheduleRevocation(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTokenStore()
+	revokeAt := time.Now().Add(10 * time.Minute)
+
+	err := ScheduleRevocation(ctx, store, IssuedToken{
+		Fingerprint: TokenFingerprint("ghs_scheduled"),
+		Token:       "ghs_scheduled",
+		Repository:  "owner/repo",
+		RevokeAt:    revokeAt,
+		ExpiresAt:   revokeAt.Add(50 * time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("ScheduleRevocation() unexpected error = %v", err)
+	}
+
+	got, exists, _ := store.Get(ctx, TokenFingerprint("ghs_scheduled"))
+	if !exists {
+		t.Fatal("ScheduleRevocation() did not store the token")
+	}
+	if got.Repository != "owner/repo" || !got.RevokeAt.Equal(revokeAt) {
+		t.Errorf("ScheduleRevocation() stored %+v", got)
+	}
+}
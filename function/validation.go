@@ -8,7 +8,7 @@ import (
 	"fmt"
 	"math/big"
 	"net/http"
-	"os"
+	"net/url"
 	"slices"
 	"strconv"
 	"strings"
@@ -19,8 +19,8 @@ import (
 )
 
 const (
+	// githubOIDCIssuer is the issuer of the GitHub Actions OIDC tokens of github.com.
 	githubOIDCIssuer  = "https://token.actions.githubusercontent.com"
-	githubJWKSURL     = "https://token.actions.githubusercontent.com/.well-known/jwks"
 	expectedAudience  = "gh-repo-token-issuer"
 	jwksCacheDuration = 1 * time.Hour
 )
@@ -40,52 +40,145 @@ type JWK struct {
 	E   string `json:"e"`
 }
 
-var (
-	jwksCache     *JWKS
-	jwksCacheTime time.Time
-	jwksMutex     sync.RWMutex
-)
-
-// fetchJWKS fetches GitHub's JWKS with caching.
-func fetchJWKS(ctx context.Context) (*JWKS, error) {
-	jwksMutex.RLock()
-	if jwksCache != nil && time.Since(jwksCacheTime) < jwksCacheDuration {
-		defer jwksMutex.RUnlock()
-		return jwksCache, nil
+// OIDCIssuer returns the issuer of the GitHub Actions OIDC tokens of the GitHub host of baseURL (the
+// REST API URL, empty for github.com): https://<host>/_services/token for GitHub Enterprise Server.
+func OIDCIssuer(baseURL string) string {
+	if baseURL == "" {
+		return githubOIDCIssuer
+	}
+	if parsed, err := url.Parse(baseURL); err == nil && parsed.Host != "" {
+		return "https://" + strings.ToLower(parsed.Host) + "/_services/token"
 	}
-	jwksMutex.RUnlock()
+	return baseURL
+}
 
-	jwksMutex.Lock()
-	defer jwksMutex.Unlock()
+// jwksIssuers is the process-wide cache of the JWKS of the trusted OIDC issuers.
+var jwksIssuers = NewJWKSIssuers(defaultBreakerSettings)
 
-	// Double-check after acquiring write lock
-	if jwksCache != nil && time.Since(jwksCacheTime) < jwksCacheDuration {
-		return jwksCache, nil
+// JWKSIssuers caches the JWKS of each OIDC issuer, with a circuit breaker per issuer so an outage of
+// one GitHub host doesn't fail the callers of the others. The breaker of github.com's issuer is named
+// jwks; those of other hosts carry the host, e.g. jwks:github.example.com. It is safe for concurrent use.
+type JWKSIssuers struct {
+	mu       sync.Mutex
+	settings BreakerSettings
+	issuers  map[string]*jwksIssuer
+}
+
+// jwksIssuer is the cached JWKS and the circuit breaker of an OIDC issuer.
+type jwksIssuer struct {
+	url     string
+	breaker *CircuitBreaker
+
+	mu        sync.RWMutex
+	jwks      *JWKS
+	fetchedAt time.Time
+}
+
+// NewJWKSIssuers creates an empty JWKS cache; the entry of an issuer is created on first use.
+func NewJWKSIssuers(settings BreakerSettings) *JWKSIssuers {
+	return &JWKSIssuers{settings: settings, issuers: make(map[string]*jwksIssuer)}
+}
+
+// Configure replaces the settings of the breakers of every issuer and resets them to closed.
+func (c *JWKSIssuers) Configure(settings BreakerSettings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settings = settings
+	for _, issuer := range c.issuers {
+		issuer.breaker.Configure(settings)
 	}
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, githubJWKSURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+// ForApps returns the breakers of the OIDC issuers of the GitHub Apps' hosts, sorted by name.
+func (c *JWKSIssuers) ForApps(apps []GitHubApp) []*CircuitBreaker {
+	var breakers []*CircuitBreaker
+	for _, app := range apps {
+		if breaker := c.issuer(app.OIDCIssuer()).breaker; !slices.Contains(breakers, breaker) {
+			breakers = append(breakers, breaker)
+		}
 	}
+	slices.SortFunc(breakers, func(a, b *CircuitBreaker) int { return strings.Compare(a.name, b.name) })
+	return breakers
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+// issuer returns the entry of the OIDC issuer, creating it on first use.
+func (c *JWKSIssuers) issuer(issuerURL string) *jwksIssuer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	issuer, ok := c.issuers[issuerURL]
+	if !ok {
+		name := "jwks"
+		if issuerURL != githubOIDCIssuer {
+			name += ":" + issuerURL
+			if parsed, err := url.Parse(issuerURL); err == nil && parsed.Host != "" {
+				name = "jwks:" + parsed.Host
+			}
+		}
+		issuer = &jwksIssuer{url: issuerURL, breaker: NewCircuitBreaker(name, c.settings)}
+		c.issuers[issuerURL] = issuer
+	}
+	return issuer
+}
+
+// Fetch returns the JWKS of the OIDC issuer and when it was fetched, fetching it from
+// <issuer>/.well-known/jwks if it isn't cached (or is outdated).
+func (c *JWKSIssuers) Fetch(ctx context.Context, issuerURL string) (*JWKS, time.Time, error) {
+	issuer := c.issuer(issuerURL)
+
+	issuer.mu.RLock()
+	if issuer.jwks != nil && time.Since(issuer.fetchedAt) < jwksCacheDuration {
+		defer issuer.mu.RUnlock()
+		metrics.RecordJWKSCacheLookup(true)
+		return issuer.jwks, issuer.fetchedAt, nil
 	}
-	defer func() { _ = resp.Body.Close() }()
+	issuer.mu.RUnlock()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("JWKS request failed with status %d", resp.StatusCode)
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+
+	// Double-check after acquiring write lock
+	if issuer.jwks != nil && time.Since(issuer.fetchedAt) < jwksCacheDuration {
+		metrics.RecordJWKSCacheLookup(true)
+		return issuer.jwks, issuer.fetchedAt, nil
 	}
+	metrics.RecordJWKSCacheLookup(false)
 
 	var jwks JWKS
-	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
-		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	err := retryPolicy.Do(ctx, issuer.breaker.Guard(func() (bool, time.Duration, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuerURL+"/.well-known/jwks", nil)
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to create JWKS request: %w", err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return true, 0, fmt.Errorf("failed to fetch JWKS: %w", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		if resp.StatusCode != http.StatusOK {
+			retryable := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+			return retryable, 0, fmt.Errorf("JWKS request failed with status %d", resp.StatusCode)
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+			return false, 0, fmt.Errorf("failed to decode JWKS: %w", err)
+		}
+		return false, 0, nil
+	}))
+	if err != nil {
+		return nil, time.Time{}, err
 	}
 
-	jwksCache = &jwks
-	jwksCacheTime = time.Now()
-	return &jwks, nil
+	issuer.jwks = &jwks
+	issuer.fetchedAt = time.Now()
+	return &jwks, issuer.fetchedAt, nil
+}
+
+// fetchJWKS fetches the JWKS of the OIDC issuer with caching.
+func fetchJWKS(ctx context.Context, issuerURL string) (*JWKS, error) {
+	jwks, _, err := jwksIssuers.Fetch(ctx, issuerURL)
+	return jwks, err
 }
 
 // getPublicKey extracts the RSA public key for the given key ID from JWKS.
@@ -119,14 +212,57 @@ func getPublicKey(jwks *JWKS, kid string) (*rsa.PublicKey, error) {
 	return nil, fmt.Errorf("key %s not found in JWKS", kid)
 }
 
-// ValidateAndExtractIdentity validates the GitHub OIDC token and extracts the repository
-// claim and the numeric account ID of the repository owner (repository_owner_id).
-// Validates: signature (against GitHub's JWKS), issuer, audience, and expiration.
-func ValidateAndExtractIdentity(ctx context.Context, tokenString string) (string, int64, error) {
+// Identity is the caller identity extracted from a validated GitHub OIDC token.
+type Identity struct {
+	// Issuer is the OIDC issuer of the token, identifying the GitHub host of the repository.
+	Issuer string
+	// Repository is the repository claim ("owner/repo").
+	Repository string
+	// RepositoryID is the numeric repository ID (repository_id claim, 0 if absent).
+	RepositoryID int64
+	// OwnerID is the numeric account ID of the repository owner (repository_owner_id claim).
+	OwnerID int64
+	// RunID and RunAttempt identify the workflow run attempt that requested the token
+	// (run_id and run_attempt claims, 0 if absent).
+	RunID      int64
+	RunAttempt int64
+	// Ref, Workflow, and Actor are the ref, workflow, and actor claims, recorded in the audit log.
+	Ref      string
+	Workflow string
+	Actor    string
+}
+
+// TrustedIssuers returns the OIDC issuers of the GitHub hosts of the apps: only their workflows can
+// request tokens.
+func TrustedIssuers(apps []GitHubApp) []string {
+	var issuers []string
+	for _, app := range apps {
+		if issuer := app.OIDCIssuer(); !slices.Contains(issuers, issuer) {
+			issuers = append(issuers, issuer)
+		}
+	}
+	return issuers
+}
+
+// ValidateAndExtractIdentity validates the GitHub OIDC token and extracts the caller identity:
+// the issuer, the repository claim, the numeric account ID of the repository owner (repository_owner_id),
+// the workflow run attempt (run_id, run_attempt), and the ref, workflow, and actor claims.
+// Validates: issuer (one of the trusted issuers), signature (against the issuer's JWKS), audience, and expiration.
+func ValidateAndExtractIdentity(ctx context.Context, tokenString string, issuers []string) (Identity, error) {
+	// The issuer selects the JWKS, so it is checked before the signature
+	unverified := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, unverified); err != nil {
+		return Identity{}, fmt.Errorf("token validation failed: %w", err)
+	}
+	issuer, _ := unverified.GetIssuer()
+	if !slices.Contains(issuers, issuer) {
+		return Identity{}, fmt.Errorf("token validation failed: untrusted issuer %q", issuer)
+	}
+
 	// Fetch JWKS
-	jwks, err := fetchJWKS(ctx)
+	jwks, err := fetchJWKS(ctx, issuer)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to fetch JWKS: %w", err)
+		return Identity{}, fmt.Errorf("failed to fetch JWKS: %w", err)
 	}
 
 	// Parse and validate token
@@ -144,48 +280,117 @@ func ValidateAndExtractIdentity(ctx context.Context, tokenString string) (string
 
 		// Get public key from JWKS
 		return getPublicKey(jwks, kid)
-	}, jwt.WithIssuer(githubOIDCIssuer),
+	}, jwt.WithIssuer(issuer),
 		jwt.WithAudience(expectedAudience),
 		jwt.WithExpirationRequired(),
 		jwt.WithValidMethods([]string{"RS256"}))
 
 	if err != nil {
-		return "", 0, fmt.Errorf("token validation failed: %w", err)
+		return Identity{}, fmt.Errorf("token validation failed: %w", err)
 	}
 
 	if !token.Valid {
-		return "", 0, fmt.Errorf("invalid token")
+		return Identity{}, fmt.Errorf("invalid token")
 	}
 
 	// Extract claims
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", 0, fmt.Errorf("failed to extract claims")
+		return Identity{}, fmt.Errorf("failed to extract claims")
 	}
 
+	return identityFromClaims(claims)
+}
+
+// ClaimedIdentity extracts the caller identity from a GitHub OIDC token without verifying its
+// signature. It only lets work that doesn't reveal anything to the caller start ahead of
+// ValidateAndExtractIdentity, whose identity is authoritative. ok is false unless the token has
+// one of the trusted issuers, this service's audience, and hasn't expired.
+func ClaimedIdentity(tokenString string, issuers []string) (identity Identity, ok bool) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return Identity{}, false
+	}
+	if issuer, _ := claims.GetIssuer(); !slices.Contains(issuers, issuer) {
+		return Identity{}, false
+	}
+	validator := jwt.NewValidator(jwt.WithAudience(expectedAudience), jwt.WithExpirationRequired())
+	if err := validator.Validate(claims); err != nil {
+		return Identity{}, false
+	}
+	identity, err := identityFromClaims(claims)
+	return identity, err == nil
+}
+
+// identityFromClaims extracts the caller identity from the claims of a GitHub OIDC token.
+func identityFromClaims(claims jwt.MapClaims) (Identity, error) {
 	// Extract repository claim
 	repository, ok := claims["repository"].(string)
 	if !ok || repository == "" {
-		return "", 0, fmt.Errorf("repository claim not found in OIDC token")
+		return Identity{}, fmt.Errorf("repository claim not found in OIDC token")
 	}
 
 	// Validate format (should be "owner/repo")
 	if !strings.Contains(repository, "/") {
-		return "", 0, fmt.Errorf("invalid repository format: %s", repository)
+		return Identity{}, fmt.Errorf("invalid repository format: %s", repository)
 	}
 
 	// Extract repository owner account ID (GitHub encodes it as a decimal string).
 	// This is stable across owner renames, unlike the owner name.
 	ownerIDStr, ok := claims["repository_owner_id"].(string)
 	if !ok || ownerIDStr == "" {
-		return "", 0, fmt.Errorf("repository_owner_id claim not found in OIDC token")
+		return Identity{}, fmt.Errorf("repository_owner_id claim not found in OIDC token")
 	}
 	ownerID, err := strconv.ParseInt(ownerIDStr, 10, 64)
 	if err != nil {
-		return "", 0, fmt.Errorf("invalid repository_owner_id claim %q: %w", ownerIDStr, err)
+		return Identity{}, fmt.Errorf("invalid repository_owner_id claim %q: %w", ownerIDStr, err)
 	}
 
-	return repository, ownerID, nil
+	// Extract repository ID and workflow run attempt (GitHub encodes them as decimal strings)
+	repositoryID, err := parseNumericClaim(claims, "repository_id")
+	if err != nil {
+		return Identity{}, err
+	}
+	runID, err := parseNumericClaim(claims, "run_id")
+	if err != nil {
+		return Identity{}, err
+	}
+	runAttempt, err := parseNumericClaim(claims, "run_attempt")
+	if err != nil {
+		return Identity{}, err
+	}
+
+	// Optional claims, only recorded in the audit log
+	ref, _ := claims["ref"].(string)
+	workflow, _ := claims["workflow"].(string)
+	actor, _ := claims["actor"].(string)
+
+	issuer, _ := claims.GetIssuer()
+	return Identity{
+		Issuer:       issuer,
+		Repository:   repository,
+		RepositoryID: repositoryID,
+		OwnerID:      ownerID,
+		RunID:        runID,
+		RunAttempt:   runAttempt,
+		Ref:          ref,
+		Workflow:     workflow,
+		Actor:        actor,
+	}, nil
+}
+
+// parseNumericClaim parses an optional claim holding a decimal number encoded as a string.
+// Returns 0 if the claim is absent.
+func parseNumericClaim(claims jwt.MapClaims, name string) (int64, error) {
+	value, ok := claims[name].(string)
+	if !ok || value == "" {
+		return 0, nil
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s claim %q: %w", name, value, err)
+	}
+	return number, nil
 }
 
 // ValidateScopes validates requested scopes against allowlist and blacklist.
@@ -195,22 +400,34 @@ func ValidateAndExtractIdentity(ctx context.Context, tokenString string) (string
 // - Invalid permission levels for each scope
 func ValidateScopes(scopes map[string]string) error {
 	for scopeID, permission := range scopes {
-		// Check blacklist
-		if BlacklistedScopes[scopeID] {
-			return fmt.Errorf("scope '%s' is not allowed", scopeID)
+		if err := ValidateScope(scopeID, permission); err != nil {
+			return err
 		}
+	}
 
-		// Check allowlist
-		allowedLevels, exists := AllowedScopes[scopeID]
-		if !exists {
-			return fmt.Errorf("scope '%s' is not in allowlist", scopeID)
-		}
+	return nil
+}
 
-		// Validate permission level
-		if !slices.Contains(allowedLevels, permission) {
-			return fmt.Errorf("permission '%s' not allowed for scope '%s' (allowed: %v)",
-				permission, scopeID, allowedLevels)
-		}
+// ValidateScope validates a single requested scope and permission level against allowlist and blacklist.
+func ValidateScope(scopeID, permission string) error {
+	// Check blacklist
+	if BlacklistedScopes[scopeID] {
+		return NewError(CodeScopeNotAllowed, "scope '%s' is not allowed", scopeID).
+			WithDetails(map[string]interface{}{"scope": scopeID, "rule": "blacklist"})
+	}
+
+	// Check allowlist
+	allowedLevels, exists := AllowedScopes[scopeID]
+	if !exists {
+		return NewError(CodeScopeNotInAllowlist, "scope '%s' is not in allowlist", scopeID).
+			WithDetails(map[string]interface{}{"scope": scopeID, "rule": "allowlist"})
+	}
+
+	// Validate permission level
+	if !slices.Contains(allowedLevels, permission) {
+		return NewError(CodePermissionNotAllowed, "permission '%s' not allowed for scope '%s' (allowed: %v)",
+			permission, scopeID, allowedLevels).
+			WithDetails(map[string]interface{}{"scope": scopeID, "permission": permission, "allowed_levels": allowedLevels})
 	}
 
 	return nil
@@ -221,7 +438,7 @@ func ValidateScopes(scopes map[string]string) error {
 // Format: comma-separated list of numeric account IDs, whitespace is trimmed.
 func ParseAllowedOwnerIDs() ([]int64, error) {
 	ownerIDs := []int64{}
-	envValue := os.Getenv("GITHUB_ALLOWED_OWNER_IDS")
+	envValue := configValue("GITHUB_ALLOWED_OWNER_IDS")
 	for _, part := range strings.Split(envValue, ",") {
 		trimmed := strings.TrimSpace(part)
 		if trimmed == "" {
@@ -249,5 +466,6 @@ func ValidateOwnerIDAllowed(ownerID int64, allowedOwnerIDs []int64) error {
 		}
 	}
 
-	return fmt.Errorf("repository owner ID %d is not allowed", ownerID)
+	return NewError(CodeOwnerNotAllowed, "repository owner ID %d is not allowed", ownerID).
+		WithDetails(map[string]interface{}{"owner_id": ownerID, "rule": "GITHUB_ALLOWED_OWNER_IDS"})
 }
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
-// Note: OIDC token validation (ValidateAndExtractIdentity) is tested via CI/CD integration
-// as it requires valid GitHub-signed tokens and JWKS fetching.
+// Note: OIDC token validation (ValidateAndExtractIdentity) against GitHub's JWKS is tested via
+// CI/CD integration; unit tests sign their own tokens and install their key with useTestJWKS.
 
 // TestValidateScopes tests the scope validation logic against allowlist and blacklist.
 // It verifies that valid scopes pass validation and invalid scopes are rejected with appropriate errors.
@@ -170,6 +176,36 @@ func TestValidateScopes(t *testing.T) {
 	}
 }
 
+// TestValidateScope tests validation of a single scope and permission level.
+func TestValidateScope(t *testing.T) {
+	tests := []struct {
+		name        string
+		scopeID     string
+		permission  string
+		errContains string
+	}{
+		{"valid scope", "contents", "write", ""},
+		{"unknown scope", "unknown", "read", "not in allowlist"},
+		{"read-only scope with write", "secret_scanning", "write", "not allowed for scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateScope(tt.scopeID, tt.permission)
+
+			if tt.errContains == "" {
+				if err != nil {
+					t.Errorf("ValidateScope() unexpected error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("ValidateScope() error = %v, want containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
 // TestParseAllowedOwnerIDs tests parsing of the GITHUB_ALLOWED_OWNER_IDS environment variable.
 func TestParseAllowedOwnerIDs(t *testing.T) {
 	tests := []struct {
@@ -327,3 +363,171 @@ func TestValidateOwnerIDAllowed(t *testing.T) {
 		})
 	}
 }
+
+// TestParseNumericClaim tests parsing of optional numeric claims such as run_id and run_attempt.
+func TestParseNumericClaim(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		want    int64
+		wantErr bool
+	}{
+		{name: "present", claims: jwt.MapClaims{"run_id": "1234567890"}, want: 1234567890},
+		{name: "absent", claims: jwt.MapClaims{}, want: 0},
+		{name: "empty", claims: jwt.MapClaims{"run_id": ""}, want: 0},
+		{name: "not a number", claims: jwt.MapClaims{"run_id": "abc"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNumericClaim(tt.claims, "run_id")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseNumericClaim() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseNumericClaim() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testOIDCKeyID is the key ID of the test JWKS installed by useTestJWKS.
+const testOIDCKeyID = "test-kid"
+
+// useTestJWKS replaces the cached JWKS of github.com's OIDC issuer for the duration of the test with the public key.
+func useTestJWKS(t *testing.T, key *rsa.PrivateKey) {
+	t.Helper()
+	useTestIssuerJWKS(t, githubOIDCIssuer, key)
+}
+
+// useTestIssuerJWKS replaces the cached JWKS of the OIDC issuer for the duration of the test with the public key.
+func useTestIssuerJWKS(t *testing.T, issuerURL string, key *rsa.PrivateKey) {
+	t.Helper()
+	issuer := jwksIssuers.issuer(issuerURL)
+	issuer.mu.Lock()
+	originalJWKS, originalTime := issuer.jwks, issuer.fetchedAt
+	issuer.jwks = &JWKS{Keys: []JWK{{
+		Kid: testOIDCKeyID,
+		Kty: "RSA",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+	}}}
+	issuer.fetchedAt = time.Now()
+	issuer.mu.Unlock()
+	t.Cleanup(func() {
+		issuer.mu.Lock()
+		issuer.jwks, issuer.fetchedAt = originalJWKS, originalTime
+		issuer.mu.Unlock()
+	})
+}
+
+// signTestOIDCToken returns a GitHub Actions OIDC token of the repository signed with the key,
+// with the claims overriding the defaults.
+func signTestOIDCToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	tokenClaims := jwt.MapClaims{
+		"iss":                 githubOIDCIssuer,
+		"aud":                 expectedAudience,
+		"exp":                 time.Now().Add(5 * time.Minute).Unix(),
+		"repository":          "owner/repo",
+		"repository_id":       "7001",
+		"repository_owner_id": "42",
+		"run_id":              "1001",
+		"run_attempt":         "1",
+		"ref":                 "refs/heads/main",
+		"workflow":            "Release",
+		"actor":               "octocat",
+	}
+	for name, value := range claims {
+		tokenClaims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, tokenClaims)
+	token.Header["kid"] = testOIDCKeyID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign OIDC token: %v", err)
+	}
+	return signed
+}
+
+// TestValidateAndExtractIdentity_Issuers tests that OIDC tokens are only accepted from the trusted
+// issuers, each verified against its own JWKS.
+//
+// Test steps:
+//  1. Install the JWKS of github.com's and a GitHub Enterprise Server's issuer, with different keys
+//  2. Validate tokens of either issuer, of an untrusted issuer, and signed with the other issuer's key
+//  3. Verify the identity carries the token's issuer, and the others are rejected
+func TestValidateAndExtractIdentity_Issuers(t *testing.T) {
+	// Step 1: JWKS of both issuers
+	const enterpriseIssuer = "https://github.example.com/_services/token"
+	githubKey, enterpriseKey := generateTestRSAKey(t), generateTestRSAKey(t)
+	useTestJWKS(t, githubKey)
+	useTestIssuerJWKS(t, enterpriseIssuer, enterpriseKey)
+	issuers := []string{githubOIDCIssuer, enterpriseIssuer}
+
+	tests := []struct {
+		name       string
+		token      string
+		issuers    []string
+		wantIssuer string
+	}{
+		{name: "github.com", token: signTestOIDCToken(t, githubKey, nil), issuers: issuers, wantIssuer: githubOIDCIssuer},
+		{name: "GitHub Enterprise Server", token: signTestOIDCToken(t, enterpriseKey, jwt.MapClaims{"iss": enterpriseIssuer}), issuers: issuers, wantIssuer: enterpriseIssuer},
+		{name: "untrusted issuer", token: signTestOIDCToken(t, enterpriseKey, jwt.MapClaims{"iss": enterpriseIssuer}), issuers: []string{githubOIDCIssuer}},
+		{name: "signed with the other issuer's key", token: signTestOIDCToken(t, githubKey, jwt.MapClaims{"iss": enterpriseIssuer}), issuers: issuers},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Validate the token
+			got, err := ValidateAndExtractIdentity(t.Context(), tt.token, tt.issuers)
+
+			// Step 3: Verify the issuer
+			if tt.wantIssuer == "" {
+				if err == nil {
+					t.Errorf("ValidateAndExtractIdentity() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateAndExtractIdentity() unexpected error = %v", err)
+			}
+			if got.Issuer != tt.wantIssuer || got.Repository != "owner/repo" {
+				t.Errorf("ValidateAndExtractIdentity() = %+v, want issuer %s", got, tt.wantIssuer)
+			}
+		})
+	}
+}
+
+// TestClaimedIdentity tests extracting the caller identity from an unverified OIDC token.
+func TestClaimedIdentity(t *testing.T) {
+	key := generateTestRSAKey(t)
+
+	tests := []struct {
+		name   string
+		token  string
+		wantOK bool
+	}{
+		{name: "well-formed token", token: signTestOIDCToken(t, key, nil), wantOK: true},
+		{name: "expired", token: signTestOIDCToken(t, key, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})},
+		{name: "other audience", token: signTestOIDCToken(t, key, jwt.MapClaims{"aud": "other"})},
+		{name: "other issuer", token: signTestOIDCToken(t, key, jwt.MapClaims{"iss": "https://example.com"})},
+		{name: "missing owner ID", token: signTestOIDCToken(t, key, jwt.MapClaims{"repository_owner_id": ""})},
+		{name: "not a JWT", token: "not-a-jwt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ClaimedIdentity(tt.token, []string{githubOIDCIssuer})
+			if ok != tt.wantOK {
+				t.Fatalf("ClaimedIdentity() ok = %v, want %v", ok, tt.wantOK)
+			}
+			want := Identity{Issuer: githubOIDCIssuer, Repository: "owner/repo", RepositoryID: 7001, OwnerID: 42, RunID: 1001, RunAttempt: 1, Ref: "refs/heads/main", Workflow: "Release", Actor: "octocat"}
+			if ok && got != want {
+				t.Errorf("ClaimedIdentity() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
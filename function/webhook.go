@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+const (
+	// maxWebhookRequestBytes is GitHub's maximum webhook payload size.
+	maxWebhookRequestBytes = 25 * 1024 * 1024
+
+	// webhookTimeout keeps webhook handling within GitHub's 10 second delivery timeout.
+	webhookTimeout = 9 * time.Second
+
+	// webhookSecretID is the Secret Manager secret holding the GitHub App webhook secret.
+	webhookSecretID = "github-webhook-secret"
+)
+
+// getWebhookSecret fetches the GitHub App webhook secret from the project's Secret Manager. It is a variable so tests can replace it.
+var getWebhookSecret = func(ctx context.Context, projectID string) ([]byte, error) {
+	secret, err := accessSecret(ctx, projectID, webhookSecretID, "latest")
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve webhook secret from Secret Manager: %w", err)
+	}
+	return secret, nil
+}
+
+// ParseRunRevocationEnabled parses the GITHUB_REVOKE_ON_RUN_COMPLETION environment variable.
+// When enabled, issued tokens are bound to the requesting workflow run attempt and revoked
+// when GitHub reports its completion through the workflow_run webhook.
+func ParseRunRevocationEnabled() (bool, error) {
+	envValue := strings.TrimSpace(configValue("GITHUB_REVOKE_ON_RUN_COMPLETION"))
+	if envValue == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(envValue)
+	if err != nil {
+		return false, fmt.Errorf("invalid GITHUB_REVOKE_ON_RUN_COMPLETION %q: %w", envValue, err)
+	}
+	return enabled, nil
+}
+
+// ParseWebhookEnabled parses the GITHUB_WEBHOOK_ENABLED environment variable. When enabled, POST /webhook
+// accepts GitHub App webhook deliveries, e.g. to invalidate cached installations, without binding tokens
+// to their workflow run. GITHUB_REVOKE_ON_RUN_COMPLETION enables the webhook too.
+func ParseWebhookEnabled() (bool, error) {
+	envValue := strings.TrimSpace(configValue("GITHUB_WEBHOOK_ENABLED"))
+	if envValue == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(envValue)
+	if err != nil {
+		return false, fmt.Errorf("invalid GITHUB_WEBHOOK_ENABLED %q: %w", envValue, err)
+	}
+	return enabled, nil
+}
+
+// handleWebhook handles POST /webhook requests: GitHub App webhook deliveries.
+// Deliveries are authenticated with the X-Hub-Signature-256 HMAC of the webhook secret.
+// Completed workflow runs revoke their tokens (with run revocation); installation changes invalidate the
+// cached installations.
+func handleWebhook(config *Config, w http.ResponseWriter, r *http.Request) {
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		writeError(w, NewError(CodeMethodNotAllowed, "method not allowed"))
+		return
+	}
+
+	if !config.Webhook {
+		writeError(w, NewError(CodeWebhooksDisabled, "webhooks are not enabled"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), webhookTimeout)
+	defer cancel()
+
+	secret, err := getWebhookSecret(ctx, config.ProjectID)
+	if err != nil {
+		writeError(w, AsError(err, CodeInternalError, "%w"))
+		return
+	}
+
+	// Verify the signature before looking at the payload
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookRequestBytes)
+	payload, err := github.ValidatePayload(r, secret)
+	if err != nil {
+		writeError(w, NewError(CodeInvalidWebhookSignature, "invalid webhook delivery: %w", err))
+		return
+	}
+
+	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	if err != nil {
+		// Events the service has no use for can't be parsed by type either; acknowledge them.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch event := event.(type) {
+	case *github.WorkflowRunEvent:
+		if !config.RunRevocation || event.GetAction() != "completed" {
+			break
+		}
+		run := event.GetWorkflowRun()
+		revoked, err := RevokeRunTokens(ctx, tokenStore, event.GetRepo().GetFullName(), run.GetID(), int64(run.GetRunAttempt()), time.Now())
+		if err != nil {
+			writeError(w, AsError(err, CodeRevocationFailed, "%w").WithDetails(map[string]interface{}{"revoked": revoked}))
+			return
+		}
+		// The count shows in the delivery log: only this instance's tokens are revoked
+		writeJSON(w, http.StatusOK, WebhookResponse{Revoked: revoked})
+		return
+	case *github.InstallationEvent:
+		// Deleted, suspended, or changed permissions
+		installationCache.InvalidateInstallation(event.GetInstallation().GetID())
+	case *github.InstallationRepositoriesEvent:
+		installationCache.InvalidateInstallation(event.GetInstallation().GetID())
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookResponse is the response of POST /webhook to a completed workflow run with run revocation.
+type WebhookResponse struct {
+	// Revoked is the number of tokens of the run attempt revoked by the instance that received the
+	// delivery. Tokens issued by other instances aren't in its token store.
+	Revoked int `json:"revoked"`
+}
+
+// RevokeRunTokens revokes every stored token bound to the completed workflow run attempt and returns the
+// number of tokens revoked. Tokens are first marked as due, so tokens that fail to revoke are retried by
+// the revocation sweep.
+func RevokeRunTokens(ctx context.Context, store TokenStore, repository string, runID, runAttempt int64, now time.Time) (int, error) {
+	tokens, err := store.ForRun(ctx, runID, runAttempt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list tokens for workflow run %d: %w", runID, err)
+	}
+
+	var due []IssuedToken
+	for _, token := range tokens {
+		// Run IDs are unique across repositories; a mismatch means the delivery isn't about this token.
+		if !strings.EqualFold(token.Repository, repository) {
+			continue
+		}
+		if token.RevokeAt.After(now) {
+			token.RevokeAt = now
+			if err := store.Put(ctx, token); err != nil {
+				return 0, fmt.Errorf("failed to schedule token revocation: %w", err)
+			}
+		}
+		due = append(due, token)
+	}
+	return revokeStoredTokens(ctx, store, due, now)
+}
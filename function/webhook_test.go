@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v90/github"
+)
+
+const testWebhookSecret = "test-webhook-secret"
+
+// newWebhookRequest creates a webhook delivery signed with the given secret.
+func newWebhookRequest(event, payload, secret string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", event)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+// setupWebhookTest returns a handler of the configuration with webhooks enabled, a test webhook secret, and
+// an empty token store.
+func setupWebhookTest(t *testing.T, config *Config) (http.HandlerFunc, *MemoryTokenStore) {
+	t.Helper()
+
+	originalSecret := getWebhookSecret
+	originalStore := tokenStore
+	t.Cleanup(func() {
+		getWebhookSecret = originalSecret
+		tokenStore = originalStore
+	})
+	getWebhookSecret = func(ctx context.Context, projectID string) ([]byte, error) {
+		return []byte(testWebhookSecret), nil
+	}
+	store := NewMemoryTokenStore()
+	tokenStore = store
+	config.ProjectID, config.Webhook = "test-project", true
+	return NewTokenHandler(config), store
+}
+
+// TestParseRunRevocationEnabled tests parsing of the GITHUB_REVOKE_ON_RUN_COMPLETION environment variable.
+func TestParseRunRevocationEnabled(t *testing.T) {
+	tests := []struct {
+		envValue string
+		want     bool
+		wantErr  bool
+	}{
+		{envValue: "", want: false},
+		{envValue: "true", want: true},
+		{envValue: " 1 ", want: true},
+		{envValue: "false", want: false},
+		{envValue: "yes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.envValue, func(t *testing.T) {
+			t.Setenv("GITHUB_REVOKE_ON_RUN_COMPLETION", tt.envValue)
+
+			got, err := ParseRunRevocationEnabled()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRunRevocationEnabled() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseRunRevocationEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseWebhookEnabled tests parsing of the GITHUB_WEBHOOK_ENABLED environment variable.
+func TestParseWebhookEnabled(t *testing.T) {
+	tests := []struct {
+		envValue string
+		want     bool
+		wantErr  bool
+	}{
+		{envValue: "", want: false},
+		{envValue: "true", want: true},
+		{envValue: "0", want: false},
+		{envValue: "on", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.envValue, func(t *testing.T) {
+			t.Setenv("GITHUB_WEBHOOK_ENABLED", tt.envValue)
+
+			got, err := ParseWebhookEnabled()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseWebhookEnabled() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseWebhookEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWebhookHandler_Disabled tests that webhook deliveries are rejected unless webhooks are enabled.
+func TestWebhookHandler_Disabled(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	NewTokenHandler(&Config{})(w, newWebhookRequest("ping", `{}`, testWebhookSecret))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("TokenHandler() status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+// TestWebhookHandler_InvalidSignature tests that deliveries not signed with the webhook secret are rejected.
+func TestWebhookHandler_InvalidSignature(t *testing.T) {
+	handler, _ := setupWebhookTest(t, &Config{})
+	w := httptest.NewRecorder()
+
+	handler(w, newWebhookRequest("ping", `{"zen":"hi"}`, "wrong-secret"))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("TokenHandler() status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+// TestWebhookHandler_WorkflowRun tests that completed workflow runs revoke their tokens.
+//
+// Test steps:
+//  1. Store tokens bound to run 100 attempt 1, run 100 attempt 2, and an unbound token
+//  2. Deliver workflow_run events (in_progress, then completed) for run 100 attempt 1, and completed
+//     for run 200
+//  3. Verify the revoked counts, and that only the token of the completed run attempt was revoked and removed
+func TestWebhookHandler_WorkflowRun(t *testing.T) {
+	handler, store := setupWebhookTest(t, &Config{RunRevocation: true})
+	ctx := context.Background()
+	now := time.Now()
+
+	// Step 1: Store tokens
+	for _, token := range []IssuedToken{
+		{Fingerprint: "attempt-1", Token: "ghs_attempt_1", Repository: "owner/repo", RevokeAt: now.Add(time.Hour), ExpiresAt: now.Add(time.Hour), RunID: 100, RunAttempt: 1},
+		{Fingerprint: "attempt-2", Token: "ghs_attempt_2", Repository: "owner/repo", RevokeAt: now.Add(time.Hour), ExpiresAt: now.Add(time.Hour), RunID: 100, RunAttempt: 2},
+		{Fingerprint: "unbound", Token: "ghs_unbound", Repository: "owner/repo", RevokeAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)},
+	} {
+		_ = store.Put(ctx, token)
+	}
+
+	var revoked []string
+	mockTokenAppsService(t, func(token string) (*github.Response, error) {
+		revoked = append(revoked, token)
+		return &github.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+	})
+
+	// Step 2: Deliver workflow_run events
+	deliveries := []struct {
+		action     string
+		runID      string
+		wantStatus int
+		wantBody   string
+	}{
+		{action: "in_progress", runID: "100", wantStatus: http.StatusNoContent},
+		{action: "completed", runID: "100", wantStatus: http.StatusOK, wantBody: `{"revoked":1}`},
+		{action: "completed", runID: "200", wantStatus: http.StatusOK, wantBody: `{"revoked":0}`},
+	}
+	for _, delivery := range deliveries {
+		payload := `{"action":"` + delivery.action + `","workflow_run":{"id":` + delivery.runID + `,"run_attempt":1},"repository":{"full_name":"owner/repo"}}`
+		w := httptest.NewRecorder()
+
+		handler(w, newWebhookRequest("workflow_run", payload, testWebhookSecret))
+
+		if w.Code != delivery.wantStatus || strings.TrimSpace(w.Body.String()) != delivery.wantBody {
+			t.Fatalf("TokenHandler() for %s run %s = %v %s, want %v %s", delivery.action, delivery.runID, w.Code, w.Body.String(), delivery.wantStatus, delivery.wantBody)
+		}
+	}
+
+	// Step 3: Verify revoked tokens
+	if len(revoked) != 1 || revoked[0] != "ghs_attempt_1" {
+		t.Errorf("revoked tokens = %v, want [ghs_attempt_1]", revoked)
+	}
+	if _, exists, _ := store.Get(ctx, "attempt-1"); exists {
+		t.Error("revoked token is still stored")
+	}
+	if remaining, _ := store.All(ctx); len(remaining) != 2 {
+		t.Errorf("remaining tokens = %v, want 2", remaining)
+	}
+}
+
+// TestWebhookHandler_WorkflowRunWithoutRunRevocation tests that completed workflow runs don't revoke
+// tokens when the webhook is enabled without run revocation.
+func TestWebhookHandler_WorkflowRunWithoutRunRevocation(t *testing.T) {
+	handler, store := setupWebhookTest(t, &Config{})
+	now := time.Now()
+	_ = store.Put(context.Background(), IssuedToken{Fingerprint: "run", Token: "ghs_run", Repository: "owner/repo", RevokeAt: now.Add(time.Hour), ExpiresAt: now.Add(time.Hour), RunID: 100, RunAttempt: 1})
+	mockTokenAppsService(t, func(token string) (*github.Response, error) {
+		t.Errorf("revoked %s without run revocation", token)
+		return &github.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+	})
+	w := httptest.NewRecorder()
+
+	handler(w, newWebhookRequest("workflow_run", `{"action":"completed","workflow_run":{"id":100,"run_attempt":1},"repository":{"full_name":"owner/repo"}}`, testWebhookSecret))
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("TokenHandler() status = %v, want %v", w.Code, http.StatusNoContent)
+	}
+}
+
+// TestWebhookHandler_Installation tests that installation events invalidate the cached installations
+// with webhooks enabled, without run revocation.
+func TestWebhookHandler_Installation(t *testing.T) {
+	handler, _ := setupWebhookTest(t, &Config{})
+	app := GitHubApp{Name: "default", AppID: "123"}
+
+	tests := []struct {
+		event   string
+		payload string
+	}{
+		{event: "installation", payload: `{"action":"suspend","installation":{"id":7}}`},
+		{event: "installation_repositories", payload: `{"action":"removed","installation":{"id":7},"repositories_removed":[{"full_name":"owner/repo"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.event, func(t *testing.T) {
+			useEmptyInstallationCache(t)
+			installationCache.Put(app, "owner/repo", &github.Installation{ID: github.Ptr(int64(7))})
+			w := httptest.NewRecorder()
+
+			handler(w, newWebhookRequest(tt.event, tt.payload, testWebhookSecret))
+
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("TokenHandler() status = %v, want %v (body: %s)", w.Code, http.StatusNoContent, w.Body.String())
+			}
+			if _, ok := installationCache.Get(app, "owner/repo"); ok {
+				t.Errorf("installation is still cached after the %s event", tt.event)
+			}
+		})
+	}
+}
+
+// TestRevokeRunTokens tests that tokens of other repositories are skipped and failed revocations are left due.
+func TestRevokeRunTokens(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryTokenStore()
+	_ = store.Put(ctx, IssuedToken{Fingerprint: "failing", Token: "ghs_failing", Repository: "Owner/Repo", RevokeAt: now.Add(time.Hour), ExpiresAt: now.Add(time.Hour), RunID: 100, RunAttempt: 1})
+	_ = store.Put(ctx, IssuedToken{Fingerprint: "other", Token: "ghs_other", Repository: "other/repo", RevokeAt: now.Add(time.Hour), ExpiresAt: now.Add(time.Hour), RunID: 100, RunAttempt: 1})
+
+	mockTokenAppsService(t, func(token string) (*github.Response, error) {
+		if token == "ghs_other" {
+			t.Error("RevokeRunTokens() revoked a token of another repository")
+		}
+		return &github.Response{Response: &http.Response{StatusCode: http.StatusBadRequest}}, http.ErrHandlerTimeout
+	})
+
+	if revoked, err := RevokeRunTokens(ctx, store, "owner/repo", 100, 1, now); err == nil || revoked != 0 {
+		t.Errorf("RevokeRunTokens() = %d, %v, want 0 and an error for failed revocation", revoked, err)
+	}
+
+	// The failed token is retried by the next sweep
+	due, _ := store.Due(ctx, now)
+	if len(due) != 1 || due[0].Fingerprint != "failing" {
+		t.Errorf("Due() after failed revocation = %v, want [failing]", due)
+	}
+}
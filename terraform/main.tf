@@ -35,6 +35,12 @@ resource "google_project_service" "iamcredentials" {
   disable_on_destroy = false
 }
 
+resource "google_project_service" "cloudkms" {
+  count              = length(local.kms_signing_keys) > 0 ? 1 : 0
+  service            = "cloudkms.googleapis.com"
+  disable_on_destroy = false
+}
+
 resource "google_project_service" "artifactregistry" {
   service            = "artifactregistry.googleapis.com"
   disable_on_destroy = false
@@ -137,6 +143,61 @@ resource "google_secret_manager_secret_iam_member" "secret_accessor" {
   member    = "serviceAccount:${google_service_account.cloud_run_sa.email}"
 }
 
+# Secrets for the private keys of additional GitHub Apps (values must be added manually after creation)
+resource "google_secret_manager_secret" "additional_app_private_keys" {
+  for_each  = local.additional_private_key_secrets
+  secret_id = each.value
+
+  replication {
+    auto {}
+  }
+
+  lifecycle {
+    prevent_destroy = true
+  }
+
+  depends_on = [google_project_service.secretmanager]
+}
+
+# Grant access to the additional private keys to service account
+resource "google_secret_manager_secret_iam_member" "additional_app_secret_accessor" {
+  for_each  = local.additional_private_key_secrets
+  secret_id = google_secret_manager_secret.additional_app_private_keys[each.key].secret_id
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${google_service_account.cloud_run_sa.email}"
+}
+
+# Allow the service account to sign GitHub App JWTs with the Cloud KMS keys of gcpkms:// key sources
+# (the keys themselves are imported into Cloud KMS outside Terraform)
+resource "google_kms_crypto_key_iam_member" "jwt_signer" {
+  for_each      = local.kms_signing_keys
+  crypto_key_id = each.value
+  role          = "roles/cloudkms.signer"
+  member        = "serviceAccount:${google_service_account.cloud_run_sa.email}"
+
+  depends_on = [google_project_service.cloudkms]
+}
+
+# Secret for the GitHub App webhook secret (value must be added manually after creation)
+resource "google_secret_manager_secret" "github_webhook_secret" {
+  count     = local.webhook_enabled ? 1 : 0
+  secret_id = "github-webhook-secret"
+
+  replication {
+    auto {}
+  }
+
+  depends_on = [google_project_service.secretmanager]
+}
+
+# Grant access to the webhook secret to service account
+resource "google_secret_manager_secret_iam_member" "webhook_secret_accessor" {
+  count     = local.webhook_enabled ? 1 : 0
+  secret_id = google_secret_manager_secret.github_webhook_secret[0].secret_id
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${google_service_account.cloud_run_sa.email}"
+}
+
 # Cloud Run service
 resource "google_cloud_run_v2_service" "github_token_issuer" {
   name     = "gh-repo-token-issuer"
@@ -168,6 +229,28 @@ resource "google_cloud_run_v2_service" "github_token_issuer" {
         cpu_idle = true # Throttle CPU when idle (allows <512Mi memory, reduces cost)
       }
 
+      # Startup and liveness check no dependencies, so outages of GitHub or Secret Manager don't keep
+      # instances from starting or restart them. GET /readyz reports the dependencies for uptime checks.
+      startup_probe {
+        period_seconds    = 5
+        timeout_seconds   = 5
+        failure_threshold = 12
+
+        http_get {
+          path = "/healthz"
+        }
+      }
+
+      liveness_probe {
+        period_seconds    = 30
+        timeout_seconds   = 5
+        failure_threshold = 3
+
+        http_get {
+          path = "/healthz"
+        }
+      }
+
       env {
         name  = "GITHUB_APP_ID"
         value = var.github_app_id
@@ -185,6 +268,142 @@ resource "google_cloud_run_v2_service" "github_token_issuer" {
           value = join(",", var.github_allowed_owner_ids)
         }
       }
+
+      dynamic "env" {
+        for_each = length(var.github_admin_repository_ids) > 0 ? [1] : []
+        content {
+          name  = "GITHUB_ADMIN_REPOSITORY_IDS"
+          value = join(",", var.github_admin_repository_ids)
+        }
+      }
+
+      dynamic "env" {
+        for_each = length(var.github_scope_profiles) > 0 ? [1] : []
+        content {
+          name  = "GITHUB_SCOPE_PROFILES"
+          value = jsonencode(var.github_scope_profiles)
+        }
+      }
+
+      dynamic "env" {
+        for_each = length(var.github_apps) > 0 ? [1] : []
+        content {
+          name  = "GITHUB_APPS"
+          value = jsonencode(var.github_apps)
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.github_app_client_id != "" ? [1] : []
+        content {
+          name  = "GITHUB_APP_CLIENT_ID"
+          value = var.github_app_client_id
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.github_app_private_key_source != "" ? [1] : []
+        content {
+          name  = "GITHUB_APP_PRIVATE_KEY_SOURCE"
+          value = var.github_app_private_key_source
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.github_app_private_key_cache_ttl != "" ? [1] : []
+        content {
+          name  = "GITHUB_APP_PRIVATE_KEY_CACHE_TTL"
+          value = var.github_app_private_key_cache_ttl
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.github_max_token_ttl != "" ? [1] : []
+        content {
+          name  = "GITHUB_MAX_TOKEN_TTL"
+          value = var.github_max_token_ttl
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.github_token_reuse_min_validity != "" ? [1] : []
+        content {
+          name  = "GITHUB_TOKEN_REUSE_MIN_VALIDITY"
+          value = var.github_token_reuse_min_validity
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.github_installation_cache_ttl != "" ? [1] : []
+        content {
+          name  = "GITHUB_INSTALLATION_CACHE_TTL"
+          value = var.github_installation_cache_ttl
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.revoke_on_run_completion ? [1] : []
+        content {
+          name  = "GITHUB_REVOKE_ON_RUN_COMPLETION"
+          value = "true"
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.webhook_enabled ? [1] : []
+        content {
+          name  = "GITHUB_WEBHOOK_ENABLED"
+          value = "true"
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.debug_stage_timings ? [1] : []
+        content {
+          name  = "DEBUG_STAGE_TIMINGS"
+          value = "true"
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.self_check_interval != "" ? [1] : []
+        content {
+          name  = "SELF_CHECK_INTERVAL"
+          value = var.self_check_interval
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.audit_log != "" ? [1] : []
+        content {
+          name  = "AUDIT_LOG"
+          value = var.audit_log
+        }
+      }
+
+      dynamic "env" {
+        for_each = { for name, value in local.resilience_env_vars : name => value if value != "" }
+        content {
+          name  = env.key
+          value = env.value
+        }
+      }
+
+      dynamic "env" {
+        for_each = { for name, value in local.server_env_vars : name => value if value != "" }
+        content {
+          name  = env.key
+          value = env.value
+        }
+      }
+
+      dynamic "env" {
+        for_each = { for name, value in local.metrics_env_vars : name => value if value != "" }
+        content {
+          name  = env.key
+          value = env.value
+        }
+      }
     }
 
     timeout = "300s"
@@ -202,28 +421,102 @@ resource "google_cloud_run_v2_service" "github_token_issuer" {
   }
 }
 
+# Optional config env vars are only set when configured; the rest are removed from the service.
+locals {
+  # Cloud KMS keys (without the version) of gcpkms:// private key sources
+  kms_signing_keys = toset([
+    for source in concat([var.github_app_private_key_source], [for app in var.github_apps : app.private_key != null ? app.private_key : ""]) :
+    regex("^gcpkms://(projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+)/", source)[0]
+    if startswith(source, "gcpkms://")
+  ])
+
+  # Run revocation receives workflow_run events through the webhook
+  webhook_enabled = var.webhook_enabled || var.revoke_on_run_completion
+
+  # Private key secrets of GitHub Apps other than the default github-app-private-key
+  additional_private_key_secrets = toset([
+    for app in var.github_apps : app.private_key_secret
+    if app.private_key_secret != null && app.private_key_secret != "" && app.private_key_secret != "github-app-private-key"
+  ])
+
+  optional_env_vars = {
+    GITHUB_ALLOWED_OWNER_IDS         = join(",", var.github_allowed_owner_ids)
+    GITHUB_ADMIN_REPOSITORY_IDS      = join(",", var.github_admin_repository_ids)
+    GITHUB_SCOPE_PROFILES            = length(var.github_scope_profiles) > 0 ? jsonencode(var.github_scope_profiles) : ""
+    GITHUB_APPS                      = length(var.github_apps) > 0 ? jsonencode(var.github_apps) : ""
+    GITHUB_APP_CLIENT_ID             = var.github_app_client_id
+    GITHUB_APP_PRIVATE_KEY_SOURCE    = var.github_app_private_key_source
+    GITHUB_APP_PRIVATE_KEY_CACHE_TTL = var.github_app_private_key_cache_ttl
+    GITHUB_MAX_TOKEN_TTL             = var.github_max_token_ttl
+    GITHUB_TOKEN_REUSE_MIN_VALIDITY  = var.github_token_reuse_min_validity
+    GITHUB_INSTALLATION_CACHE_TTL    = var.github_installation_cache_ttl
+    GITHUB_REVOKE_ON_RUN_COMPLETION  = var.revoke_on_run_completion ? "true" : ""
+    GITHUB_WEBHOOK_ENABLED           = var.webhook_enabled ? "true" : ""
+    DEBUG_STAGE_TIMINGS              = var.debug_stage_timings ? "true" : ""
+    SELF_CHECK_INTERVAL              = var.self_check_interval
+    AUDIT_LOG                        = var.audit_log
+  }
+
+  # Retry policy and circuit breaker settings
+  resilience_env_vars = {
+    RETRY_MAX_ATTEMPTS = var.retry_policy.max_attempts != null ? tostring(var.retry_policy.max_attempts) : ""
+    RETRY_BASE_DELAY   = var.retry_policy.base_delay != null ? var.retry_policy.base_delay : ""
+    RETRY_MAX_DELAY    = var.retry_policy.max_delay != null ? var.retry_policy.max_delay : ""
+    RETRY_BUDGET       = var.retry_policy.budget != null ? var.retry_policy.budget : ""
+
+    CIRCUIT_BREAKER_FAILURE_RATIO = var.circuit_breaker.failure_ratio != null ? tostring(var.circuit_breaker.failure_ratio) : ""
+    CIRCUIT_BREAKER_MIN_REQUESTS  = var.circuit_breaker.min_requests != null ? tostring(var.circuit_breaker.min_requests) : ""
+    CIRCUIT_BREAKER_WINDOW        = var.circuit_breaker.window != null ? var.circuit_breaker.window : ""
+    CIRCUIT_BREAKER_OPEN_DURATION = var.circuit_breaker.open_duration != null ? var.circuit_breaker.open_duration : ""
+  }
+
+  # HTTP server settings (TLS is terminated by Cloud Run)
+  server_env_vars = {
+    SERVER_MODE                = var.server.mode != null ? var.server.mode : ""
+    SERVER_READ_HEADER_TIMEOUT = var.server.read_header_timeout != null ? var.server.read_header_timeout : ""
+    SERVER_READ_TIMEOUT        = var.server.read_timeout != null ? var.server.read_timeout : ""
+    SERVER_WRITE_TIMEOUT       = var.server.write_timeout != null ? var.server.write_timeout : ""
+    SERVER_IDLE_TIMEOUT        = var.server.idle_timeout != null ? var.server.idle_timeout : ""
+    SERVER_SHUTDOWN_TIMEOUT    = var.server.shutdown_timeout != null ? var.server.shutdown_timeout : ""
+    SERVER_MAX_HEADER_BYTES    = var.server.max_header_bytes != null ? tostring(var.server.max_header_bytes) : ""
+    SERVER_MAX_BODY_BYTES      = var.server.max_body_bytes != null ? tostring(var.server.max_body_bytes) : ""
+  }
+
+  # Metrics exporters
+  metrics_env_vars = {
+    METRICS_PROMETHEUS      = var.metrics.prometheus == true ? "true" : ""
+    METRICS_PROMETHEUS_PORT = var.metrics.prometheus_port != null ? var.metrics.prometheus_port : ""
+    METRICS_OTLP_ENDPOINT   = var.metrics.otlp_endpoint != null ? var.metrics.otlp_endpoint : ""
+    METRICS_OTLP_INTERVAL   = var.metrics.otlp_interval != null ? var.metrics.otlp_interval : ""
+  }
+
+  env_vars = merge(
+    {
+      GITHUB_APP_ID        = var.github_app_id
+      GOOGLE_CLOUD_PROJECT = var.project_id
+    },
+    { for name, value in merge(local.optional_env_vars, local.resilience_env_vars, local.server_env_vars, local.metrics_env_vars) : name => value if value != "" },
+  )
+
+  removed_env_vars = [for name, value in merge(local.optional_env_vars, local.resilience_env_vars, local.server_env_vars, local.metrics_env_vars) : name if value == ""]
+}
+
 # Cloud Run env vars aren't managed through the service resource above: its template is
 # ignored (deployments go through gcloud), so this syncs the config env vars to the
-# running service with gcloud whenever any of their values change.
+# running service with gcloud whenever any of their values change. Values are joined
+# with "@" (gcloud's ^@^ delimiter syntax), as they can contain commas.
 resource "terraform_data" "env_vars" {
   triggers_replace = {
-    app_id     = var.github_app_id
-    project_id = var.project_id
-    owner_ids  = join(",", var.github_allowed_owner_ids)
+    env_vars         = local.env_vars
+    removed_env_vars = local.removed_env_vars
   }
 
   provisioner "local-exec" {
     command = <<-EOT
-      if [ -n "${join(",", var.github_allowed_owner_ids)}" ]; then
-        gcloud run services update ${google_cloud_run_v2_service.github_token_issuer.name} \
-          --region=${var.region} \
-          --update-env-vars=^@^GITHUB_APP_ID=${var.github_app_id}@GOOGLE_CLOUD_PROJECT=${var.project_id}@GITHUB_ALLOWED_OWNER_IDS=${join(",", var.github_allowed_owner_ids)}
-      else
-        gcloud run services update ${google_cloud_run_v2_service.github_token_issuer.name} \
-          --region=${var.region} \
-          --update-env-vars=^@^GITHUB_APP_ID=${var.github_app_id}@GOOGLE_CLOUD_PROJECT=${var.project_id} \
-          --remove-env-vars=GITHUB_ALLOWED_OWNER_IDS
-      fi
+      gcloud run services update ${google_cloud_run_v2_service.github_token_issuer.name} \
+        --region=${var.region} \
+        '--update-env-vars=^@^${join("@", [for name, value in local.env_vars : "${name}=${value}"])}' \
+        ${length(local.removed_env_vars) > 0 ? "--remove-env-vars=${join(",", local.removed_env_vars)}" : ""}
     EOT
   }
 }
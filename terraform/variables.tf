@@ -14,8 +14,155 @@ variable "github_app_id" {
   type        = string
 }
 
+variable "github_app_client_id" {
+  description = "Client ID of the GitHub App (e.g. \"Iv23li...\"), used as the issuer of its JWTs as GitHub recommends. If empty, the app ID is used."
+  type        = string
+  default     = ""
+}
+
 variable "github_allowed_owner_ids" {
   description = "List of GitHub account IDs (organizations or users) allowed to request tokens. Account IDs are stable across renames, unlike owner names. If empty, all owners are allowed."
   type        = list(string)
   default     = []
 }
+
+variable "github_admin_repository_ids" {
+  description = "List of GitHub repository IDs whose workflows may call GET /keys and read the detailed GET /readyz report. Repository IDs are stable across renames; prefix repositories of a GitHub Enterprise Server with its host (\"github.example.com/123\"). If empty, both are disabled."
+  type        = list(string)
+  default     = []
+}
+
+variable "github_scope_profiles" {
+  description = "Named scope bundles that callers can request with ?profile=<name>. Each profile lists its scopes and can optionally be restricted to specific owner account IDs and/or repositories (owner/repo). Profile scopes are validated against the allowlist at request time."
+  type = map(object({
+    scopes       = map(string)
+    owner_ids    = optional(list(number), [])
+    repositories = optional(list(string), [])
+  }))
+  default = {}
+}
+
+variable "github_apps" {
+  description = "GitHub Apps to issue tokens with, in routing order: the first app whose rule matches the request is used. Each app has its own app ID (and optional client ID), Secret Manager secret holding its private key (default github-app-private-key) or other private key source (private_key, see github_app_private_key_source), and GitHub API base URL (empty for github.com; apps of a GitHub Enterprise Server only issue tokens to workflows of that server, verified with its OIDC issuer). Rules match on owner account IDs and/or requested scopes; an app without rules matches every request. If empty, github_app_id is the only app."
+  type = list(object({
+    name               = string
+    app_id             = string
+    client_id          = optional(string)
+    private_key_secret = optional(string)
+    private_key        = optional(string)
+    base_url           = optional(string)
+    when = optional(object({
+      owner_ids = optional(list(number))
+      scopes    = optional(list(string))
+    }))
+  }))
+  default = []
+}
+
+variable "github_app_private_key_source" {
+  description = "Source of the GitHub App private key: secretmanager://<secret>[?version=<n>] (pin a Secret Manager secret version), file://<path>, env://<variable>, vault://<mount>/<path>[?field=<field>&version=<n>] (HashiCorp Vault KV version 2, configured with VAULT_* env vars), or gcpkms://projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>/cryptoKeyVersions/<v> (sign JWTs with a Cloud KMS key the private key was imported into; it never enters the service). If empty, the latest version of the github-app-private-key secret is used."
+  type        = string
+  default     = ""
+}
+
+variable "github_app_private_key_cache_ttl" {
+  description = "How long each instance caches the GitHub App private keys as a Go duration (e.g. \"10m\"); \"0\" loads the key on every request. New key versions are picked up within this time. If empty, the default of 5m is used."
+  type        = string
+  default     = ""
+}
+
+variable "github_max_token_ttl" {
+  description = "Maximum lifetime of issued tokens as a Go duration between 1m and 1h (e.g. \"15m\"). Tokens are revoked once it has passed. If empty, tokens live for GitHub's full hour unless callers request less with ?max_ttl=."
+  type        = string
+  default     = ""
+}
+
+variable "revoke_on_run_completion" {
+  description = "Bind issued tokens to the requesting workflow run and revoke them when GitHub reports the run as completed. Requires the GitHub App webhook (workflow_run events) to point at <service URL>/webhook, with its secret stored in the github-webhook-secret Secret Manager secret. Each delivery reaches one instance, which only revokes the tokens it issued, so this is only complete with a single instance or a shared token store."
+  type        = bool
+  default     = false
+}
+
+variable "webhook_enabled" {
+  description = "Accept GitHub App webhook deliveries on <service URL>/webhook, so installation and installation_repositories events invalidate the cached installations. Requires the webhook secret in the github-webhook-secret Secret Manager secret. Implied by revoke_on_run_completion."
+  type        = bool
+  default     = false
+}
+
+variable "debug_stage_timings" {
+  description = "Report the duration of each stage of POST /token (OIDC validation, app JWT, installation lookup, token creation) in a Server-Timing response header, for latency debugging."
+  type        = bool
+  default     = false
+}
+
+variable "self_check_interval" {
+  description = "How often each instance checks the GitHub Apps again after its startup self-check (private key, App JWT, app ID, and permissions against the scope catalog), as a Go duration of at least 1m. If empty, the apps are only checked on startup, retrying until the check passes."
+  type        = string
+  default     = ""
+}
+
+variable "audit_log" {
+  description = "Where the audit event of every POST /token decision (claims, scopes, decision, installation ID, and the token's SHA-256 fingerprint) is delivered: \"stdout\" for Cloud Logging, written before the response is sent (a token whose issuance can't be recorded is withheld), or an https:// CloudEvents endpoint, delivered at most once from an in-memory queue. If empty, no audit log is written."
+  type        = string
+  default     = ""
+}
+
+variable "github_token_reuse_min_validity" {
+  description = "Enables token reuse when set (Go duration, e.g. \"10m\"): identical token requests of the same workflow run attempt (e.g. matrix legs) share one token while it has at least this much lifetime left. Concurrent identical requests are combined into one GitHub call. If empty, every request gets a fresh token."
+  type        = string
+  default     = ""
+}
+
+variable "github_installation_cache_ttl" {
+  description = "How long each instance caches the GitHub App installation of a repository (Go duration, e.g. \"5m\"). \"0\" looks it up on every token request. If empty, the service default (10m) is used."
+  type        = string
+  default     = ""
+}
+
+variable "retry_policy" {
+  description = "Retries of GitHub API, JWKS, and Secret Manager calls: total attempts, base and max backoff delay, and total retry budget per call (Go durations). Unset fields keep the service defaults (3 attempts, 500ms, 5s, 15s)."
+  type = object({
+    max_attempts = optional(number)
+    base_delay   = optional(string)
+    max_delay    = optional(string)
+    budget       = optional(string)
+  })
+  default = {}
+}
+
+variable "circuit_breaker" {
+  description = "Circuit breakers of GitHub, Secret Manager, and JWKS calls: share of failed calls that opens a breaker, minimum calls per window, counting window, and how long an open breaker fails fast before a probe (Go durations). Unset fields keep the service defaults (0.5, 5, 30s, 30s)."
+  type = object({
+    failure_ratio = optional(number)
+    min_requests  = optional(number)
+    window        = optional(string)
+    open_duration = optional(string)
+  })
+  default = {}
+}
+
+variable "server" {
+  description = "HTTP server: mode (\"functions\" for the Functions Framework or \"standalone\" for a net/http server), the standalone server's timeouts and header and body size limits (Go durations, bytes), and how long shutdown drains requests and revokes pending tokens. Unset fields keep the service defaults (functions, 10s, 30s, 60s, 120s, 8s, 65536, 1048576)."
+  type = object({
+    mode                = optional(string)
+    read_header_timeout = optional(string)
+    read_timeout        = optional(string)
+    write_timeout       = optional(string)
+    idle_timeout        = optional(string)
+    shutdown_timeout    = optional(string)
+    max_header_bytes    = optional(number)
+    max_body_bytes      = optional(number)
+  })
+  default = {}
+}
+
+variable "metrics" {
+  description = "OpenTelemetry metrics (token requests by decision and error code, issued tokens and scopes by owner, dependency latency and retries, JWKS cache lookups, GitHub rate limit headroom): prometheus serves them on GET /metrics of a port of their own (prometheus_port, default \"9464\"), which Cloud Run doesn't expose, for a collector sidecar to scrape; otlp_endpoint pushes them to an OTLP/HTTP metrics URL (e.g. \"https://collector.example.com/v1/metrics\") every otlp_interval (Go duration, default 1m). Unset fields leave the exporter disabled."
+  type = object({
+    prometheus      = optional(bool)
+    prometheus_port = optional(string)
+    otlp_endpoint   = optional(string)
+    otlp_interval   = optional(string)
+  })
+  default = {}
+}